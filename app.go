@@ -0,0 +1,119 @@
+package baaah
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/leader"
+	"github.com/obot-platform/nah/pkg/log"
+	"github.com/obot-platform/nah/pkg/merr"
+	"github.com/obot-platform/nah/pkg/router"
+)
+
+// defaultShutdownTimeout bounds how long an App waits for running handlers when it stops.
+const defaultShutdownTimeout = 30 * time.Second
+
+// App runs a router with its leader election. It is a composition of the router and leader election pieces for
+// binaries that don't need to control them separately.
+type App struct {
+	name            string
+	router          *router.Router
+	election        *leader.ElectionConfig
+	ShutdownTimeout time.Duration
+}
+
+// NewApp creates an App with the same options as New. Register the routes on App.Router before calling Run.
+func NewApp(name string, opts ...Option) (*App, error) {
+	o, err := newOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The App runs the election itself so that it controls the shutdown order.
+	election := o.ElectionConfig
+	o.ElectionConfig = nil
+	o.configureElection(election)
+
+	r, err := NewRouter(name, &o.Options)
+	if err != nil {
+		return nil, err
+	}
+	router.WithLeaderElection(election)(r)
+
+	app := &App{
+		name:            name,
+		router:          r,
+		election:        election,
+		ShutdownTimeout: defaultShutdownTimeout,
+	}
+	if o.ShutdownTimeout > 0 {
+		app.ShutdownTimeout = o.ShutdownTimeout
+	}
+	if election != nil {
+		election.BeforeRelease(app.drain)
+		election.OnLeadershipLost(func() {
+			r.AbortHandlers(leader.ErrLeadershipLost)
+		})
+	}
+	return app, nil
+}
+
+// Router returns the router of the App for registering routes.
+func (a *App) Router() *router.Router {
+	return a.router
+}
+
+// Run starts the router once this process is the leader, or immediately without leader election, and blocks until
+// ctx is done, the leadership is lost, or the router fails to start.
+//
+// When ctx is done, the App stops in this order: the router stops dispatching keys and waits up to ShutdownTimeout
+// for the running handlers, then the lease is released, then the caches are stopped. This way a new leader doesn't
+// start while this one is still writing. When the lease is lost instead, the running handlers are aborted at once,
+// because another process may already be the leader. Either way, the OnStop hooks of the router run last and their
+// errors are returned. Nil is returned if the App stopped because ctx is done and the hooks succeeded.
+func (a *App) Run(ctx context.Context) error {
+	id, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	// The router context is not a child of ctx so that the caches are only stopped after the lease is released.
+	routerCtx, stopRouter := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopRouter()
+
+	if a.election != nil {
+		go func() {
+			if err := a.router.Standby(routerCtx); err != nil {
+				log.Errorf("failed to preload caches of %s: %v", a.name, err)
+			}
+		}()
+	}
+
+	err = a.election.RunAndWait(ctx, id, func(context.Context) error {
+		return a.router.Start(routerCtx)
+	}, func(identity string) {
+		if identity != id {
+			log.Infof("%s is the leader for %s", identity, a.name)
+		}
+	})
+	// Without an election there is no lease to hold, but the handlers are still drained before the caches are
+	// stopped. Then the OnStop hooks of the router run.
+	return merr.NewErrors(err, a.stop())
+}
+
+// stop is called after the election returned.
+func (a *App) stop() error {
+	stopCtx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+	defer cancel()
+	return a.router.Stop(stopCtx)
+}
+
+// drain is called before the lease is released.
+func (a *App) drain() {
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+	defer cancel()
+	if err := a.router.Drain(drainCtx); err != nil {
+		log.Warnf("timed out waiting for handlers of %s to stop: %v", a.name, err)
+	}
+}
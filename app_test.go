@@ -0,0 +1,362 @@
+package baaah
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/leader"
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var appConfigMapGVK = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+
+// appBackend is a backend over the controller-runtime fake client whose keys are reconciled by calling dispatch.
+type appBackend struct {
+	kclient.WithWatch
+
+	lock     sync.Mutex
+	watchers map[schema.GroupVersionKind]backend.Callback
+	startErr error
+}
+
+func newAppBackend(t *testing.T) *appBackend {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(appConfigMapGVK, meta.RESTScopeNamespace)
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+	return &appBackend{
+		WithWatch: fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(cm).Build(),
+		watchers:  map[schema.GroupVersionKind]backend.Callback{},
+	}
+}
+
+func (b *appBackend) dispatch(key string) error {
+	b.lock.Lock()
+	cb, ok := b.watchers[appConfigMapGVK]
+	b.lock.Unlock()
+	if !ok {
+		return errors.New("type is not watched")
+	}
+	_, err := cb(appConfigMapGVK, router.ReplayPrefix+key, nil)
+	return err
+}
+
+func (b *appBackend) Trigger(schema.GroupVersionKind, string, time.Duration) error {
+	return nil
+}
+
+func (b *appBackend) Watcher(_ context.Context, gvk schema.GroupVersionKind, _ string, cb backend.Callback) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.watchers[gvk] = cb
+	return nil
+}
+
+func (b *appBackend) GetInformerForKind(_ context.Context, gvk schema.GroupVersionKind) (cache.SharedIndexInformer, error) {
+	obj, err := b.Scheme().New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return cache.NewSharedIndexInformer(&cache.ListWatch{}, obj, 0, cache.Indexers{}), nil
+}
+
+func (b *appBackend) IndexField(context.Context, kclient.Object, string, kclient.IndexerFunc) error {
+	return nil
+}
+
+func (b *appBackend) Preload(context.Context) error {
+	return nil
+}
+
+func (b *appBackend) Start(context.Context) error {
+	return b.startErr
+}
+
+func (b *appBackend) GVKForObject(obj runtime.Object, scheme *runtime.Scheme) (schema.GroupVersionKind, error) {
+	return apiutil.GVKForObject(obj, scheme)
+}
+
+// appLease is a lease in memory shared by the locks of several apps. It records the events of the apps in order.
+type appLease struct {
+	lock    sync.Mutex
+	record  *resourcelock.LeaderElectionRecord
+	failing map[string]bool
+	events  *eventLog
+}
+
+type appLock struct {
+	lease    *appLease
+	identity string
+}
+
+func (l appLock) Get(context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	l.lease.lock.Lock()
+	defer l.lease.lock.Unlock()
+	if l.lease.record == nil {
+		return nil, nil, apierrors.NewNotFound(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "test")
+	}
+	record := *l.lease.record
+	raw, err := json.Marshal(record)
+	return &record, raw, err
+}
+
+func (l appLock) Create(_ context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.lease.lock.Lock()
+	defer l.lease.lock.Unlock()
+	if l.lease.record != nil {
+		return apierrors.NewAlreadyExists(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "test")
+	}
+	l.lease.record = &ler
+	return nil
+}
+
+func (l appLock) Update(_ context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.lease.lock.Lock()
+	defer l.lease.lock.Unlock()
+	if l.lease.failing[l.identity] {
+		return errors.New("lease is unreachable")
+	}
+	if ler.HolderIdentity == "" && l.lease.record != nil && l.lease.record.HolderIdentity == l.identity {
+		l.lease.events.add(l.identity + " released the lease")
+	}
+	l.lease.record = &ler
+	return nil
+}
+
+func (l appLock) RecordEvent(string) {}
+
+func (l appLock) Identity() string {
+	return l.identity
+}
+
+func (l appLock) Describe() string {
+	return "default/test"
+}
+
+type eventLog struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (e *eventLog) add(event string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *eventLog) get() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+// newTestApp returns an app over a fake backend whose election uses a lock of the lease with the identity, or no
+// election if the lease is nil.
+func newTestApp(t *testing.T, lease *appLease, identity string) (*App, *appBackend) {
+	t.Helper()
+	b := newAppBackend(t)
+	opts := []Option{WithBackend(b), WithHealthzPort(0), WithShutdownTimeout(5 * time.Second)}
+	if lease == nil {
+		opts = append(opts, WithoutLeaderElection())
+	} else {
+		ec := leader.NewElectionConfig(5*time.Second, "default", "test", "", nil)
+		ec.NewLock = func(string) (resourcelock.Interface, error) {
+			return appLock{lease: lease, identity: identity}, nil
+		}
+		opts = append(opts, WithElectionConfig(ec))
+	}
+	app, err := NewApp(t.Name()+"-"+identity, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, b
+}
+
+// runApp runs the app and returns the channel of the error returned by Run.
+func runApp(ctx context.Context, app *App) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- app.Run(ctx)
+	}()
+	return errs
+}
+
+func waitAppReady(t *testing.T, app *App, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-app.Router().Ready():
+	case <-time.After(timeout):
+		t.Fatal("app did not start its router")
+	}
+}
+
+func waitRun(t *testing.T, errs <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(20 * time.Second):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+func TestAppWithoutElection(t *testing.T) {
+	events := &eventLog{}
+	app, b := newTestApp(t, nil, "app")
+
+	running := make(chan struct{})
+	app.Router().Type(&corev1.ConfigMap{}).HandlerFunc(func(req router.Request, resp router.Response) error {
+		close(running)
+		time.Sleep(200 * time.Millisecond)
+		events.add("handler returned")
+		return nil
+	})
+	if err := app.Router().OnStop(func(context.Context) error {
+		events.add("stop hook")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := runApp(ctx, app)
+	waitAppReady(t, app, 10*time.Second)
+
+	reconciled := make(chan error, 1)
+	go func() {
+		reconciled <- b.dispatch("default/a")
+	}()
+	<-running
+	cancel()
+
+	if err := waitRun(t, errs); err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if err := <-reconciled; err != nil {
+		t.Fatalf("expected the running handler to finish, got %v", err)
+	}
+	if got := events.get(); len(got) != 2 || got[0] != "handler returned" || got[1] != "stop hook" {
+		t.Fatalf("expected the handler to return before the stop hooks, got %v", got)
+	}
+}
+
+// TestAppLeaderAndStandby checks that only the leader starts its router, and that the lease is released after the
+// running handlers return and before the stop hooks, so that the standby takes over afterwards.
+func TestAppLeaderAndStandby(t *testing.T) {
+	events := &eventLog{}
+	lease := &appLease{events: events}
+
+	first, b := newTestApp(t, lease, "first")
+	running := make(chan struct{})
+	first.Router().Type(&corev1.ConfigMap{}).HandlerFunc(func(req router.Request, resp router.Response) error {
+		close(running)
+		time.Sleep(200 * time.Millisecond)
+		events.add("first handler returned")
+		return nil
+	})
+	if err := first.Router().OnStop(func(context.Context) error {
+		events.add("first stop hook")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	firstErrs := runApp(firstCtx, first)
+	waitAppReady(t, first, 10*time.Second)
+
+	second, _ := newTestApp(t, lease, "second")
+	second.Router().Type(&corev1.ConfigMap{}).HandlerFunc(func(req router.Request, resp router.Response) error {
+		return nil
+	})
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	secondErrs := runApp(secondCtx, second)
+
+	select {
+	case <-second.Router().Ready():
+		t.Fatal("expected the standby not to start its router while the other app leads")
+	case <-time.After(time.Second):
+	}
+
+	reconciled := make(chan error, 1)
+	go func() {
+		reconciled <- b.dispatch("default/a")
+	}()
+	<-running
+	cancelFirst()
+
+	if err := waitRun(t, firstErrs); err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if err := <-reconciled; err != nil {
+		t.Fatalf("expected the running handler to finish, got %v", err)
+	}
+	expected := []string{"first handler returned", "first released the lease", "first stop hook"}
+	if got := events.get(); len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] || got[2] != expected[2] {
+		t.Fatalf("expected the shutdown order %v, got %v", expected, got)
+	}
+
+	waitAppReady(t, second, 20*time.Second)
+	cancelSecond()
+	if err := waitRun(t, secondErrs); err != nil {
+		t.Fatalf("expected a clean shutdown of the new leader, got %v", err)
+	}
+}
+
+func TestAppReturnsStartError(t *testing.T) {
+	app, b := newTestApp(t, &appLease{events: &eventLog{}}, "app")
+	b.startErr = errors.New("start failed")
+	app.Router().Type(&corev1.ConfigMap{}).HandlerFunc(func(req router.Request, resp router.Response) error {
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := waitRun(t, runApp(ctx, app)); !errors.Is(err, b.startErr) {
+		t.Fatalf("expected the start error to be returned, got %v", err)
+	}
+}
+
+func TestAppReturnsLostLeadership(t *testing.T) {
+	lease := &appLease{events: &eventLog{}}
+	app, _ := newTestApp(t, lease, "app")
+	app.Router().Type(&corev1.ConfigMap{}).HandlerFunc(func(req router.Request, resp router.Response) error {
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := runApp(ctx, app)
+	waitAppReady(t, app, 10*time.Second)
+
+	lease.lock.Lock()
+	lease.failing = map[string]bool{"app": true}
+	lease.lock.Unlock()
+	if err := waitRun(t, errs); !errors.Is(err, leader.ErrLeadershipLost) {
+		t.Fatalf("expected the lost leadership to be returned, got %v", err)
+	}
+}
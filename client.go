@@ -0,0 +1,131 @@
+package baaah
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/obot-platform/nah/pkg/mapper"
+	"github.com/obot-platform/nah/pkg/restconfig"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClientOption configures the clients created by NewClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	scheme    *runtime.Scheme
+	userAgent string
+	qps       float32
+	burst     int
+}
+
+// WithClientScheme sets the scheme of the client. Defaults to the client-go scheme.
+func WithClientScheme(scheme *runtime.Scheme) ClientOption {
+	return func(o *clientOptions) {
+		o.scheme = scheme
+	}
+}
+
+// WithUserAgent overrides the user agent of the client.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithQPS sets the client side rate limit of the client. By default, the rate limit of the config is used.
+func WithQPS(qps float32, burst int) ClientOption {
+	return func(o *clientOptions) {
+		o.qps = qps
+		o.burst = burst
+	}
+}
+
+func (o *clientOptions) restConfig(cfg *rest.Config) *rest.Config {
+	cfg = restconfig.SetScheme(rest.CopyConfig(cfg), o.scheme)
+	if o.userAgent != "" {
+		cfg.UserAgent = o.userAgent
+	}
+	if o.qps > 0 {
+		cfg.RateLimiter = nil
+		cfg.QPS = o.qps
+		cfg.Burst = o.burst
+	}
+	return cfg
+}
+
+// NewClient returns an uncached client configured the same way as the clients the router gives to handlers. The
+// given config is not modified.
+func NewClient(cfg *rest.Config, opts ...ClientOption) (kclient.WithWatch, error) {
+	o := clientOptions{scheme: clientgoscheme.Scheme}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cfg = o.restConfig(cfg)
+
+	m, err := mapper.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return kclient.NewWithWatch(cfg, kclient.Options{
+		Scheme: o.scheme,
+		Mapper: m,
+	})
+}
+
+// NewCachedClient returns a client that reads from a started cache and writes directly to the API server, the same as
+// the client the router gives to handlers. If namespaces are given, the cache only holds objects in those namespaces.
+// The returned function stops the cache.
+func NewCachedClient(ctx context.Context, cfg *rest.Config, scheme *runtime.Scheme, namespaces ...string) (kclient.Client, func(), error) {
+	o := clientOptions{scheme: scheme}
+	cfg = o.restConfig(cfg)
+
+	m, err := mapper.New(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var defaultNamespaces map[string]cache.Config
+	if len(namespaces) > 0 {
+		defaultNamespaces = make(map[string]cache.Config, len(namespaces))
+		for _, ns := range namespaces {
+			defaultNamespaces[ns] = cache.Config{}
+		}
+	}
+
+	theCache, err := cache.New(cfg, cache.Options{
+		Mapper:            m,
+		Scheme:            scheme,
+		DefaultNamespaces: defaultNamespaces,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := kclient.New(cfg, kclient.Options{
+		Scheme: scheme,
+		Mapper: m,
+		Cache: &kclient.CacheOptions{
+			Reader: theCache,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		_ = theCache.Start(ctx)
+	}()
+	if !theCache.WaitForCacheSync(ctx) {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to sync cache")
+	}
+
+	return c, cancel, nil
+}
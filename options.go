@@ -0,0 +1,364 @@
+package baaah
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/apply"
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/leader"
+	"github.com/obot-platform/nah/pkg/merr"
+	"github.com/obot-platform/nah/pkg/restconfig"
+	"github.com/obot-platform/nah/pkg/router"
+	bruntime "github.com/obot-platform/nah/pkg/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Option configures the router created by New.
+type Option func(*options)
+
+type options struct {
+	Options
+
+	set        []string
+	noElection bool
+}
+
+func (o *options) mark(name string) {
+	for _, s := range o.set {
+		if s == name {
+			return
+		}
+	}
+	o.set = append(o.set, name)
+}
+
+func (o *options) isSet(name string) bool {
+	for _, s := range o.set {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func WithScheme(scheme *runtime.Scheme) Option {
+	return func(o *options) {
+		o.mark("WithScheme")
+		o.Scheme = scheme
+	}
+}
+
+// WithRESTConfig sets the config used to create the backend. When used with WithBackend, the config is only used
+// for leader election and tenant impersonation.
+func WithRESTConfig(cfg *rest.Config) Option {
+	return func(o *options) {
+		o.mark("WithRESTConfig")
+		o.DefaultRESTConfig = cfg
+	}
+}
+
+// WithBackend uses a pre-built backend instead of creating one. The backend's scheme is used for the router.
+func WithBackend(b backend.Backend) Option {
+	return func(o *options) {
+		o.mark("WithBackend")
+		o.Backend = b
+	}
+}
+
+// WithNamespace restricts the caches of the created backend to the namespace.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.mark("WithNamespace")
+		o.DefaultNamespace = namespace
+	}
+}
+
+// WithNamespaces restricts the caches of the created backend to the namespaces, with informers per namespace. Reads
+// of other namespaces fail with a *backend.NamespaceNotCachedError, and lists of all namespaces are merged from the
+// namespaces.
+func WithNamespaces(namespaces ...string) Option {
+	return func(o *options) {
+		o.mark("WithNamespaces")
+		o.Namespaces = append(o.Namespaces, namespaces...)
+	}
+}
+
+// WithAPIGroupConfig indicates that all actions on the API group should use the given config.
+func WithAPIGroupConfig(group string, cfg bruntime.Config) Option {
+	return func(o *options) {
+		o.mark("WithAPIGroupConfig")
+		if o.APIGroupConfigs == nil {
+			o.APIGroupConfigs = map[string]bruntime.Config{}
+		}
+		o.APIGroupConfigs[group] = cfg
+	}
+}
+
+// WithDefaultConcurrency sets the default number of workers per GVK.
+func WithDefaultConcurrency(workers int) Option {
+	return func(o *options) {
+		o.mark("WithDefaultConcurrency")
+		o.Workers = workers
+	}
+}
+
+// WithElectionConfig sets the leader election config of the router. If neither this nor WithoutLeaderElection is
+// given, then the default lease based election is used.
+func WithElectionConfig(ec *leader.ElectionConfig) Option {
+	return func(o *options) {
+		o.mark("WithElectionConfig")
+		o.ElectionConfig = ec
+	}
+}
+
+// WithoutLeaderElection disables leader election for the router.
+func WithoutLeaderElection() Option {
+	return func(o *options) {
+		o.mark("WithoutLeaderElection")
+		o.noElection = true
+	}
+}
+
+// WithHealthzPort sets the port for the healthz endpoint. A port <= 0 disables the endpoint, without this option the
+// endpoint is served on port 8888.
+func WithHealthzPort(port int) Option {
+	return func(o *options) {
+		o.mark("WithHealthzPort")
+		if port <= 0 {
+			// Options.HealthzPort 0 is the default port, so a disabled endpoint is negative.
+			port = -1
+		}
+		o.HealthzPort = port
+	}
+}
+
+// WithClock sets the clock used by the router and the created backend.
+func WithClock(c clock.WithTicker) Option {
+	return func(o *options) {
+		o.mark("WithClock")
+		o.Clock = c
+	}
+}
+
+// WithTenantImpersonation makes the writes of each request impersonate the tenant of the request's namespace. Writes
+// while handling cluster scoped objects return router.ErrClusterScopedTenant.
+func WithTenantImpersonation(impersonate func(namespace string) rest.ImpersonationConfig) Option {
+	return func(o *options) {
+		o.mark("WithTenantImpersonation")
+		o.TenantImpersonation = &router.TenantImpersonation{
+			Impersonate: impersonate,
+		}
+	}
+}
+
+// WithFairness dispatches keys round-robin across the buckets returned by keyFn, such as the namespace of the key
+// with bruntime.NamespaceBucket, and limits each bucket to maxPerBucket concurrent reconciles while other buckets
+// have keys waiting. A maxPerBucket of zero only enables the round-robin dispatch.
+func WithFairness(keyFn func(key string) string, maxPerBucket int) Option {
+	return func(o *options) {
+		o.mark("WithFairness")
+		o.Fairness = &bruntime.Fairness{
+			Key:          keyFn,
+			MaxPerBucket: maxPerBucket,
+		}
+	}
+}
+
+// WithObjectElision caches large objects of the types in the policies with some fields elided.
+func WithObjectElision(policies ...bruntime.ElidePolicy) Option {
+	return func(o *options) {
+		o.mark("WithObjectElision")
+		o.Elide = append(o.Elide, policies...)
+	}
+}
+
+// WithWarmStandby makes replicas that are not the leader sync the caches of the router's types, and of the extra types
+// that handlers read, so that a failover doesn't wait for the caches to be listed.
+func WithWarmStandby(extra ...kclient.Object) Option {
+	return func(o *options) {
+		o.mark("WithWarmStandby")
+		o.WarmStandby = true
+		o.WarmStandbyTypes = append(o.WarmStandbyTypes, extra...)
+	}
+}
+
+// WithRequeueStore persists the pending requeues to the store every interval and on shutdown, and restores them on
+// start.
+func WithRequeueStore(store router.RequeueStore, interval time.Duration) Option {
+	return func(o *options) {
+		o.mark("WithRequeueStore")
+		o.RequeueStore = store
+		o.RequeueStoreInterval = interval
+	}
+}
+
+// WithMetrics registers the Prometheus metrics of the handlers of the router, such as their invocations, errors and
+// durations, with the registerer. NewRouter, and NewApp, return an error if they can't be registered.
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(o *options) {
+		o.mark("WithMetrics")
+		o.MetricsRegisterer = registerer
+	}
+}
+
+// WithShutdownTimeout sets how long the router waits for its running handlers to return when it stops, before the
+// lease is released. The handlers still running after the timeout are aborted.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.mark("WithShutdownTimeout")
+		o.ShutdownTimeout = timeout
+	}
+}
+
+// WithLogger sets the structured logger of the router and of its leader election. Defaults to log.Slog, which writes
+// to the log package.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.mark("WithLogger")
+		o.Logger = logger
+	}
+}
+
+// WithFatalHandler sets the function called when the router or its leader election can't go on, such as when the
+// leader callback fails or the lease is lost, instead of exiting the process with log.Fatalf.
+func WithFatalHandler(f func(err error)) Option {
+	return func(o *options) {
+		o.mark("WithFatalHandler")
+		o.FatalHandler = f
+	}
+}
+
+// WithStallThreshold sets how long a key can wait in the queue without any reconcile succeeding before the router is
+// reported as unhealthy on /healthz. Zero uses router.DefaultStallThreshold, and a negative threshold disables the
+// check.
+func WithStallThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.mark("WithStallThreshold")
+		o.StallThreshold = threshold
+	}
+}
+
+// WithRefreshingToken makes the clients, caches, leader election and tenant clients of the router authenticate with
+// the bearer token returned by load, which is reloaded when it expires or after the API server responds with a 401.
+// Tokens without an expiry are reloaded after the TTL. It applies to the configs of WithRESTConfig,
+// WithAPIGroupConfig and WithTenantImpersonation, and to the default config when none is given.
+func WithRefreshingToken(load restconfig.TokenFunc, ttl time.Duration) Option {
+	return func(o *options) {
+		o.mark("WithRefreshingToken")
+		o.RefreshToken = load
+		o.RefreshTokenTTL = ttl
+	}
+}
+
+// WithEventRecorder sets the recorder of the "Adopted" events of the existing objects adopted for the routes with
+// router.RouteBuilder.AdoptExisting, such as a record.EventRecorder of client-go.
+func WithEventRecorder(recorder apply.EventRecorder) Option {
+	return func(o *options) {
+		o.mark("WithEventRecorder")
+		o.EventRecorder = recorder
+	}
+}
+
+func (o *options) validate() error {
+	var errs []error
+
+	conflicts := func(option string, others ...string) {
+		if !o.isSet(option) {
+			return
+		}
+		var found []string
+		for _, other := range others {
+			if o.isSet(other) {
+				found = append(found, other)
+			}
+		}
+		if len(found) > 0 {
+			errs = append(errs, fmt.Errorf("%s cannot be used with %s", option, strings.Join(found, ", ")))
+		}
+	}
+
+	conflicts("WithBackend", "WithNamespace", "WithNamespaces", "WithAPIGroupConfig", "WithDefaultConcurrency", "WithObjectElision", "WithFairness")
+	conflicts("WithoutLeaderElection", "WithElectionConfig", "WithWarmStandby")
+	if o.isSet("WithTenantImpersonation") && o.isSet("WithBackend") && !o.isSet("WithRESTConfig") {
+		errs = append(errs, fmt.Errorf("WithTenantImpersonation requires WithRESTConfig when used with WithBackend"))
+	}
+
+	if o.Backend == nil && o.Scheme == nil {
+		errs = append(errs, fmt.Errorf("WithScheme or WithBackend is required"))
+	}
+	if o.Backend != nil && o.Scheme != nil && o.Backend.Scheme() != o.Scheme {
+		errs = append(errs, fmt.Errorf("WithScheme conflicts with the scheme of WithBackend"))
+	}
+	if o.Workers < 0 {
+		errs = append(errs, fmt.Errorf("WithDefaultConcurrency must be positive, got %d", o.Workers))
+	}
+	if o.Fairness != nil && o.Fairness.MaxPerBucket < 0 {
+		errs = append(errs, fmt.Errorf("WithFairness maxPerBucket must not be negative, got %d", o.Fairness.MaxPerBucket))
+	}
+	if o.isSet("WithRequeueStore") && o.RequeueStore == nil {
+		errs = append(errs, fmt.Errorf("WithRequeueStore requires a non-nil store"))
+	}
+	if o.isSet("WithMetrics") && o.MetricsRegisterer == nil {
+		errs = append(errs, fmt.Errorf("WithMetrics requires a non-nil registerer"))
+	}
+	if o.isSet("WithEventRecorder") && o.EventRecorder == nil {
+		errs = append(errs, fmt.Errorf("WithEventRecorder requires a non-nil recorder"))
+	}
+	if o.isSet("WithRefreshingToken") && o.RefreshToken == nil {
+		errs = append(errs, fmt.Errorf("WithRefreshingToken requires a non-nil token func"))
+	}
+	if o.isSet("WithElectionConfig") && o.ElectionConfig == nil {
+		errs = append(errs, fmt.Errorf("WithElectionConfig requires a non-nil config, use WithoutLeaderElection to disable leader election"))
+	}
+
+	return merr.NewErrors(errs...)
+}
+
+// New creates a router with the given options. All options are validated together and the returned error names the
+// options that conflict. Unless WithoutLeaderElection or WithElectionConfig is given, the default lease based leader
+// election is used when a REST config is available.
+func New(routerName string, opts ...Option) (*router.Router, error) {
+	o, err := newOptions(routerName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewRouter(routerName, &o.Options)
+}
+
+func newOptions(routerName string, opts []Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	if o.Backend != nil && o.Scheme == nil {
+		o.Scheme = o.Backend.Scheme()
+	}
+
+	if o.Backend == nil && o.DefaultRESTConfig == nil {
+		cfg, err := restconfig.New(o.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		o.DefaultRESTConfig = cfg
+	}
+	// The configs are wrapped before the default election config is created from the default config.
+	o.refreshCredentials()
+
+	if !o.noElection && o.ElectionConfig == nil && o.DefaultRESTConfig != nil {
+		o.ElectionConfig = leader.NewDefaultElectionConfig("", routerName, o.DefaultRESTConfig)
+	}
+
+	return o, nil
+}
@@ -0,0 +1,143 @@
+package baaah
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bruntime "github.com/obot-platform/nah/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+)
+
+func TestWithHealthzPort(t *testing.T) {
+	for name, test := range map[string]struct {
+		opts     []Option
+		expected int
+	}{
+		// Options.HealthzPort 0 is completed to the default port.
+		"unset":    {expected: 0},
+		"port":     {opts: []Option{WithHealthzPort(9999)}, expected: 9999},
+		"zero":     {opts: []Option{WithHealthzPort(0)}, expected: -1},
+		"negative": {opts: []Option{WithHealthzPort(-5)}, expected: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := append([]Option{WithScheme(runtime.NewScheme()), WithRESTConfig(&rest.Config{}), WithoutLeaderElection()}, test.opts...)
+			o, err := newOptions("test", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.HealthzPort != test.expected {
+				t.Fatalf("expected port %d, got %d", test.expected, o.HealthzPort)
+			}
+		})
+	}
+}
+
+func TestWithRefreshingToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer refreshed" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &rest.Config{Host: server.URL, BearerToken: "static"}
+	o, err := newOptions("test", []Option{
+		WithScheme(runtime.NewScheme()),
+		WithRESTConfig(cfg),
+		WithAPIGroupConfig("example.com", bruntime.Config{Rest: cfg}),
+		WithRefreshingToken(func() (string, time.Time, error) { return "refreshed", time.Time{}, nil }, time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed, err := o.complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cfg := range map[string]*rest.Config{
+		"default": completed.DefaultRESTConfig,
+		"group":   completed.APIGroupConfigs["example.com"].Rest,
+		// The default election config is created from this config.
+		"election": o.DefaultRESTConfig,
+	} {
+		httpClient, err := rest.HTTPClientFor(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := httpClient.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected the refreshed token to be used, got %s", name, resp.Status)
+		}
+	}
+	if cfg.BearerToken != "static" || cfg.WrapTransport != nil {
+		t.Fatal("expected the given config not to be modified")
+	}
+}
+
+func TestWithRefreshingTokenOfDefaultConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer refreshed" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	// Without WithRESTConfig, the default config is loaded from the kubeconfig.
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+users:
+- name: test
+  user:
+    token: static
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`, server.URL)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", kubeconfig)
+	t.Setenv("CONTEXT", "")
+
+	// The Options of NewRouter are completed without the functional options, which create the config themselves.
+	o := &Options{
+		Scheme:          runtime.NewScheme(),
+		RefreshToken:    func() (string, time.Time, error) { return "refreshed", time.Time{}, nil },
+		RefreshTokenTTL: time.Hour,
+	}
+	completed, err := o.complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient, err := rest.HTTPClientFor(completed.DefaultRESTConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the refreshed token to be used by the default config, got %s", resp.Status)
+	}
+}
@@ -33,6 +33,9 @@ type Apply interface {
 	WithPruneGVKs(gvks ...schema.GroupVersionKind) Apply
 	WithPruneTypes(gvks ...kclient.Object) Apply
 	WithNoPrune() Apply
+	WithChildNameTemplate(tmpl string) Apply
+	WithValidation() Apply
+	WithEventRecorder(recorder EventRecorder) Apply
 
 	FindOwner(ctx context.Context, obj kclient.Object) (kclient.Object, error)
 	PurgeOrphan(ctx context.Context, obj kclient.Object) error
@@ -2,8 +2,12 @@ package apply
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"text/template"
 
 	"github.com/obot-platform/nah/pkg/apply/objectset"
+	"github.com/obot-platform/nah/pkg/name"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
@@ -25,6 +29,9 @@ type apply struct {
 	ownerGVK         schema.GroupVersionKind
 	ensure           bool
 	noPrune          bool
+	nameTemplate     string
+	validate         bool
+	recorder         EventRecorder
 }
 
 func (a apply) Ensure(ctx context.Context, objs ...kclient.Object) error {
@@ -54,6 +61,15 @@ func (a apply) Apply(ctx context.Context, owner kclient.Object, objs ...kclient.
 		}
 		a.ownerGVK = gvk
 	}
+	objs, err := a.nameChildren(objs)
+	if err != nil {
+		return err
+	}
+	if a.validate {
+		if err := a.validateObjects(objs); err != nil {
+			return err
+		}
+	}
 	os, err := objectset.NewObjectSet(a.client.Scheme(), objs...)
 	if err != nil {
 		return err
@@ -61,6 +77,63 @@ func (a apply) Apply(ctx context.Context, owner kclient.Object, objs ...kclient.
 	return a.apply(os)
 }
 
+// WithChildNameTemplate names the applied objects that have neither a name nor a generate name with the Go template.
+// The template is executed with .Owner (the owner object, nil for Ensure), .Kind (the lower cased kind of the object),
+// .Index (the position of the object in the applied objects), and .Hash (an 8 character hash of the object's content).
+// The result is made a valid DNS-1123 label with name.SafeConcat, and applying fails if it is empty. Copies of the
+// objects are named, so the objects passed to Apply are left unnamed and can be applied again.
+func (a apply) WithChildNameTemplate(tmpl string) Apply {
+	a.nameTemplate = tmpl
+	return a
+}
+
+type childNameData struct {
+	Owner kclient.Object
+	Kind  string
+	Index int
+	Hash  string
+}
+
+// nameChildren returns the objects with the unnamed ones replaced by named copies.
+func (a apply) nameChildren(objs []kclient.Object) ([]kclient.Object, error) {
+	if a.nameTemplate == "" {
+		return objs, nil
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(a.nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid child name template: %w", err)
+	}
+
+	result := make([]kclient.Object, 0, len(objs))
+	for i, obj := range objs {
+		if obj.GetName() != "" || obj.GetGenerateName() != "" {
+			result = append(result, obj)
+			continue
+		}
+		gvk, err := apiutil.GVKForObject(obj, a.client.Scheme())
+		if err != nil {
+			return nil, err
+		}
+		out := &strings.Builder{}
+		if err := tmpl.Execute(out, childNameData{
+			Owner: a.owner,
+			Kind:  strings.ToLower(gvk.Kind),
+			Index: i,
+			Hash:  name.HashFor(obj, 8),
+		}); err != nil {
+			return nil, fmt.Errorf("failed to execute child name template: %w", err)
+		}
+		if strings.TrimSpace(out.String()) == "" {
+			return nil, fmt.Errorf("child name template gave an empty name for %s %d", gvk.Kind, i)
+		}
+		obj = obj.DeepCopyObject().(kclient.Object)
+		obj.SetName(name.SafeConcat(out.String()))
+		result = append(result, obj)
+	}
+	return result, nil
+}
+
 func (a apply) WithNoPrune() Apply {
 	a.noPrune = true
 	return a
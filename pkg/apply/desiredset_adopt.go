@@ -0,0 +1,61 @@
+package apply
+
+import (
+	"fmt"
+
+	"github.com/obot-platform/nah/pkg/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationAdopt set to "true" on a desired object adopts the existing object of its name if it is not managed by any
+// owner, such as an object created by hand before the controller was deployed. An adopted object is updated to the
+// desired object, which gives it the owner reference and the annotations of the owner, and a Normal "Adopted" event
+// is recorded on it with the recorder of WithEventRecorder. An existing object with a controller owner reference to
+// another object is not adopted and a conditions.ErrTerminal is returned for it.
+//
+// Without the annotation, an unmanaged existing object is still updated to the desired object, but the update fails if
+// the object has another controller.
+const AnnotationAdopt = LabelPrefix + "adopt"
+
+// EventRecorder records the events of the adopted objects. It is implemented by record.EventRecorder of client-go.
+type EventRecorder interface {
+	Event(object runtime.Object, eventtype, reason, message string)
+	Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{})
+}
+
+// WithEventRecorder records an event on each object adopted because of AnnotationAdopt. No events are recorded
+// without a recorder.
+func (a apply) WithEventRecorder(recorder EventRecorder) Apply {
+	a.recorder = recorder
+	return a
+}
+
+// checkAdoption returns an error if the existing object can't be adopted because it is controlled by another object.
+func (a *apply) checkAdoption(gvk schema.GroupVersionKind, existing kclient.Object) error {
+	controller := metav1.GetControllerOf(existing)
+	if controller == nil || a.owner != nil && controller.UID == a.owner.GetUID() {
+		return nil
+	}
+	owner := "ensure"
+	if a.owner != nil {
+		owner = fmt.Sprintf("%s %s/%s", a.ownerGVK.Kind, a.owner.GetNamespace(), a.owner.GetName())
+	}
+	return conditions.NewErrTerminalf("can not adopt %s %s/%s for %s: it is controlled by %s %s", gvk.Kind,
+		existing.GetNamespace(), existing.GetName(), owner, controller.Kind, controller.Name)
+}
+
+func (a *apply) recordAdoption(existing kclient.Object) {
+	if a.recorder == nil {
+		return
+	}
+	if a.owner == nil {
+		a.recorder.Event(existing, corev1.EventTypeNormal, "Adopted", "Adopted by ensure")
+		return
+	}
+	a.recorder.Eventf(existing, corev1.EventTypeNormal, "Adopted", "Adopted by %s %s/%s", a.ownerGVK.Kind,
+		a.owner.GetNamespace(), a.owner.GetName())
+}
@@ -67,7 +67,12 @@ func (a *apply) apply(objs *objectset.ObjectSet) error {
 		}
 	}
 
-	return merr.NewErrors(errs...)
+	// Len is the number of types, the total is the number of objects.
+	total := 0
+	for _, byKey := range objs.ObjectsByGVK() {
+		total += len(byKey)
+	}
+	return newApplyError(total, merr.NewErrors(errs...))
 }
 
 func (a *apply) knownGVK() (ret []schema.GroupVersionKind) {
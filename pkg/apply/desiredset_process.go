@@ -203,6 +203,7 @@ func (a *apply) process(debugID string, set labels.Selector, gvk schema.GroupVer
 	}
 
 	var toReplace []objectset.ObjectKey
+	adopted := map[objectset.ObjectKey]bool{}
 	toCreate, toDelete, toUpdate := compareSets(existing, objs)
 
 	// check for resources in the objectset but under a different version of the same group/kind
@@ -211,21 +212,30 @@ func (a *apply) process(debugID string, set labels.Selector, gvk schema.GroupVer
 	createF := func(k objectset.ObjectKey) error {
 		obj, err := prepareObjectForCreate(gvk, objs[k], !a.ensure)
 		if err != nil {
-			return fmt.Errorf("failed to prepare create %s %s for %s: %w", k, gvk, debugID, err)
+			return objectError(gvk, k, ActionCreate, debugID, fmt.Errorf("failed to prepare: %w", err))
 		}
 
 		_, err = a.create(gvk, obj)
 		if apierrors.IsAlreadyExists(err) {
 			// Taking over an object that wasn't previously managed by us
 			existingObj, getErr := a.get(gvk, objs[k], k.Namespace, k.Name)
+			if getErr == nil && objs[k].GetAnnotations()[AnnotationAdopt] == "true" && existingObj.GetLabels()[LabelHash] == "" {
+				if err := a.checkAdoption(gvk, existingObj); err != nil {
+					return objectError(gvk, k, ActionCreate, debugID, err)
+				}
+				adopted[k] = true
+				toUpdate = append(toUpdate, k)
+				existing[k] = existingObj
+				return nil
+			}
 			if getErr == nil {
 				if !annotationsMatch(existingObj, obj) {
 					if existingObj.GetLabels()[LabelHash] != "" && !isAssigningSubContext(existingObj, obj) && !isAllowOwnerTransition(existingObj, obj) {
-						return fmt.Errorf("failed to update existing owned object %s %s for %s, old subcontext [%s] gvk [%s] namespace [%s] name [%s]: %w", k, gvk, debugID,
+						return objectError(gvk, k, ActionUpdate, debugID, fmt.Errorf("existing owned object has old subcontext [%s] gvk [%s] namespace [%s] name [%s]: %w",
 							existingObj.GetAnnotations()[LabelSubContext],
 							existingObj.GetAnnotations()[LabelGVK],
 							existingObj.GetAnnotations()[LabelNamespace],
-							existingObj.GetAnnotations()[LabelName], err)
+							existingObj.GetAnnotations()[LabelName], err))
 					}
 				}
 				if should(obj, AnnotationUpdate) {
@@ -236,7 +246,7 @@ func (a *apply) process(debugID string, set labels.Selector, gvk schema.GroupVer
 			}
 		}
 		if err != nil {
-			return fmt.Errorf("failed to create %s %s for %s: %w", k, gvk, debugID, err)
+			return objectError(gvk, k, ActionCreate, debugID, err)
 		}
 
 		log.Debugf("DesiredSet - Created %s %s for %s", gvk, k, debugID)
@@ -245,7 +255,7 @@ func (a *apply) process(debugID string, set labels.Selector, gvk schema.GroupVer
 
 	deleteF := func(k objectset.ObjectKey, force bool) error {
 		if err := a.delete(gvk, k.Namespace, k.Name); err != nil {
-			return fmt.Errorf("failed to delete %s %s for %s: %w", k, gvk, debugID, err)
+			return objectError(gvk, k, ActionDelete, debugID, err)
 		}
 		log.Debugf("DesiredSet - DeleteStrategy %s %s for %s", gvk, k, debugID)
 		return nil
@@ -258,7 +268,10 @@ func (a *apply) process(debugID string, set labels.Selector, gvk schema.GroupVer
 				toReplace = append(toReplace, k)
 			}
 		} else if err != nil {
-			return fmt.Errorf("failed to update %s %s for %s: %w", k, gvk, debugID, err)
+			return objectError(gvk, k, ActionUpdate, debugID, err)
+		} else if adopted[k] {
+			log.Debugf("DesiredSet - Adopted %s %s for %s", gvk, k, debugID)
+			a.recordAdoption(existing[k])
 		}
 		return nil
 	}
@@ -320,6 +333,8 @@ func isAssigningSubContext(existingObj, newObj kclient.Object) bool {
 
 func (a *apply) list(gvk schema.GroupVersionKind, selector labels.Selector, objs map[objectset.ObjectKey]kclient.Object) (map[objectset.ObjectKey]kclient.Object, error) {
 	if selector != nil {
+		// The set is listed with its labels rather than with the owner UID index, because its objects whose owner
+		// reference was removed or never written must be pruned too.
 		return a.listBySelector(gvk, selector)
 	}
 
@@ -0,0 +1,109 @@
+package apply
+
+import (
+	"context"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newPruneTestClient returns a fake client, with the owner UID index of the cache of a backend if indexed is true.
+func newPruneTestClient(t *testing.T, indexed bool) kclient.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper)
+	if indexed {
+		builder = builder.WithIndex(&corev1.ConfigMap{}, backend.OwnerUIDIndexField, func(obj kclient.Object) []string {
+			var uids []string
+			for _, ref := range obj.GetOwnerReferences() {
+				uids = append(uids, string(ref.UID))
+			}
+			return uids
+		})
+	}
+	return builder.Build()
+}
+
+func child(name string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+}
+
+func assertPruned(t *testing.T, c kclient.Client, name string) {
+	t.Helper()
+	if err := c.Get(context.Background(), kclient.ObjectKey{Namespace: "default", Name: name}, &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected %s to be pruned, got %v", name, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	for name, test := range map[string]struct {
+		indexed         bool
+		owner           kclient.Object
+		listerNamespace bool
+	}{
+		"namespaced owner in its namespace": {
+			indexed:         true,
+			owner:           &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}},
+			listerNamespace: true,
+		},
+		"cluster scoped owner": {
+			indexed: true,
+			owner:   &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "owner", UID: "owner-uid"}},
+		},
+		"namespaced owner in all namespaces": {
+			indexed: true,
+			owner:   &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}},
+		},
+		"index not registered": {
+			owner:           &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}},
+			listerNamespace: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := newPruneTestClient(t, test.indexed)
+			a := New(c)
+			if test.listerNamespace {
+				a = a.WithNamespace(test.owner.GetNamespace())
+			}
+			ctx := context.Background()
+
+			if err := a.Apply(ctx, test.owner, child("a"), child("b"), child("c")); err != nil {
+				t.Fatal(err)
+			}
+
+			// The owner reference of c is removed, but its labels still make it a member of the set.
+			var stripped corev1.ConfigMap
+			if err := c.Get(ctx, kclient.ObjectKey{Namespace: "default", Name: "c"}, &stripped); err != nil {
+				t.Fatal(err)
+			}
+			stripped.OwnerReferences = nil
+			if err := c.Update(ctx, &stripped); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.Apply(ctx, test.owner, child("a")); err != nil {
+				t.Fatal(err)
+			}
+			assertPruned(t, c, "b")
+			assertPruned(t, c, "c")
+			if err := c.Get(ctx, kclient.ObjectKey{Namespace: "default", Name: "a"}, &corev1.ConfigMap{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package apply
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestChildNameTemplate(t *testing.T) {
+	c := newPruneTestClient(t, false)
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: strings.Repeat("owner", 20), UID: "owner-uid"}}
+	ctx := context.Background()
+
+	named := child("named")
+	unnamed := []*corev1.ConfigMap{child(""), child("")}
+	unnamed[1].Data = map[string]string{"key": "value"}
+	a := New(c).WithChildNameTemplate("{{.Owner.GetName}}-{{.Kind}}-{{.Index}}-{{.Hash}}")
+	if err := a.Apply(ctx, owner, named, unnamed[0], unnamed[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if named.Name != "named" {
+		t.Fatalf("expected a named object to keep its name, got %q", named.Name)
+	}
+	// The objects of the caller are not renamed, so they can be applied again.
+	for _, obj := range unnamed {
+		if obj.Name != "" {
+			t.Fatalf("expected the object of the caller to be left unnamed, got %q", obj.Name)
+		}
+	}
+
+	var list corev1.ConfigMapList
+	if err := c.List(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 3 {
+		t.Fatalf("expected three objects, got %d", len(list.Items))
+	}
+	var children []string
+	for _, obj := range list.Items {
+		if obj.Name == "named" {
+			continue
+		}
+		if len(obj.Name) > 63 || !strings.HasPrefix(obj.Name, "owner") {
+			t.Fatalf("expected the long name to be shortened, got %q", obj.Name)
+		}
+		children = append(children, obj.Name)
+	}
+	if len(children) != 2 || children[0] == children[1] {
+		t.Fatalf("expected the children to get different names, got %v", children)
+	}
+
+	// Applying the same objects again keeps the children.
+	if err := a.Apply(ctx, owner, named, unnamed[0], unnamed[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.List(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 3 {
+		t.Fatalf("expected the children to be kept, got %d objects", len(list.Items))
+	}
+}
+
+func TestChildNameTemplateEmptyName(t *testing.T) {
+	c := newPruneTestClient(t, false)
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}}
+
+	err := New(c).WithChildNameTemplate("{{if .Hash}}{{end}}").Apply(context.Background(), owner, child(""))
+	if err == nil || !strings.Contains(err.Error(), "empty name") {
+		t.Fatalf("expected an error for an empty name, got %v", err)
+	}
+}
+
+func TestApplyLongNames(t *testing.T) {
+	c := newPruneTestClient(t, false)
+	namespace := strings.Repeat("n", 63)
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: strings.Repeat("o", 253), UID: "owner-uid"}}
+	ctx := context.Background()
+
+	children := func(n int) []kclient.Object {
+		var result []kclient.Object
+		for i := 0; i < n; i++ {
+			result = append(result, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}})
+		}
+		return result
+	}
+	apply := func(objs ...kclient.Object) *corev1.ConfigMapList {
+		t.Helper()
+		// A new apply is used each time, as by a new reconcile or after a restart.
+		if err := New(c).WithChildNameTemplate("{{.Owner.GetName}}-{{.Kind}}-{{.Index}}").Apply(ctx, owner, objs...); err != nil {
+			t.Fatal(err)
+		}
+		var list corev1.ConfigMapList
+		if err := c.List(ctx, &list, kclient.InNamespace(namespace)); err != nil {
+			t.Fatal(err)
+		}
+		return &list
+	}
+
+	list := apply(children(3)...)
+	if len(list.Items) != 3 {
+		t.Fatalf("expected the three children to be created, got %d", len(list.Items))
+	}
+	// The names and the owner hash label are the same for every run, which is what pruning relies on.
+	names := map[string]bool{}
+	for _, obj := range list.Items {
+		if len(obj.Name) > 63 {
+			t.Fatalf("expected the name to be at most 63 characters, got %q", obj.Name)
+		}
+		if hash := obj.Labels[LabelHash]; hash != "6a50aa22c144a99da08775a0dc26464f6c4ae970" {
+			t.Fatalf("expected the owner hash label to be stable, got %q", hash)
+		}
+		if obj.Annotations[LabelName] != owner.Name || obj.Annotations[LabelNamespace] != owner.Namespace {
+			t.Fatalf("expected the owner annotations to have the full owner name and namespace, got %v", obj.Annotations)
+		}
+		names[obj.Name] = true
+	}
+	first := strings.Repeat("o", 63-len("-5ed30107")) + "-5ed30107"
+	if !names[first] {
+		t.Fatalf("expected the first child to be named %s, got %v", first, names)
+	}
+
+	list = apply(children(3)...)
+	if len(list.Items) != 3 {
+		t.Fatalf("expected applying the same children again to keep them, got %d", len(list.Items))
+	}
+	for _, obj := range list.Items {
+		if !names[obj.Name] {
+			t.Fatalf("expected the children to keep their names, got %q", obj.Name)
+		}
+	}
+
+	list = apply(children(1)...)
+	if len(list.Items) != 1 || list.Items[0].Name != first {
+		t.Fatalf("expected all but the first child to be pruned, got %v", list.Items)
+	}
+}
@@ -0,0 +1,136 @@
+package apply
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/obot-platform/nah/pkg/apply/objectset"
+	"github.com/obot-platform/nah/pkg/merr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Action is the change that an apply attempted on an object.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// ObjectError is the failure to apply one object.
+type ObjectError struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Action    Action
+	// Err is the underlying error, usually an error of the API server.
+	Err error
+
+	debugID string
+}
+
+func objectError(gvk schema.GroupVersionKind, key objectset.ObjectKey, action Action, debugID string, err error) *ObjectError {
+	return &ObjectError{
+		GVK:       gvk,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+		Action:    action,
+		Err:       err,
+		debugID:   debugID,
+	}
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("failed to %s %s %s for %s: %v", e.Action, e.key(), e.GVK, e.debugID, e.Err)
+}
+
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ObjectError) key() string {
+	if e.Namespace == "" {
+		return e.Name
+	}
+	return e.Namespace + "/" + e.Name
+}
+
+// ApplyError is returned by Apply and Ensure when any of the objects failed to apply. An apply is not transactional:
+// the objects that applied successfully stay applied and are not rolled back, and the objects that failed are retried
+// by the next apply of the same set.
+//
+// Use errors.As to extract it, or a single ObjectError. Checks of the underlying errors, such as
+// apierrors.IsConflict, see through it.
+type ApplyError struct {
+	// Objects are the failures of the individual objects.
+	Objects []*ObjectError
+	// Errors are the failures that are not specific to an object, such as failing to list the existing objects of a
+	// type.
+	Errors []error
+	// Total is the number of desired objects of the apply.
+	Total int
+}
+
+func newApplyError(total int, err error) error {
+	if err == nil {
+		return nil
+	}
+	result := &ApplyError{
+		Total: total,
+	}
+	result.add(err)
+	return result
+}
+
+func (e *ApplyError) add(err error) {
+	switch err := err.(type) {
+	case merr.Errors:
+		for _, err := range err {
+			e.add(err)
+		}
+	case *ObjectError:
+		e.Objects = append(e.Objects, err)
+	default:
+		e.Errors = append(e.Errors, err)
+	}
+}
+
+func (e *ApplyError) Error() string {
+	return merr.NewErrors(e.Unwrap()...).Error()
+}
+
+func (e *ApplyError) Unwrap() []error {
+	result := make([]error, 0, len(e.Objects)+len(e.Errors))
+	for _, err := range e.Objects {
+		result = append(result, err)
+	}
+	return append(result, e.Errors...)
+}
+
+// ConditionMessage returns a short message listing each failed object, for the message of a status condition.
+func (e *ApplyError) ConditionMessage() string {
+	buf := &strings.Builder{}
+	if len(e.Objects) > 0 {
+		fmt.Fprintf(buf, "failed to apply %d of %d objects: ", len(e.Objects), e.Total)
+	}
+	for i, err := range e.Objects {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		fmt.Fprintf(buf, "%s %s %s: ", err.Action, err.GVK.Kind, err.key())
+		if reason := apierrors.ReasonForError(err.Err); reason != "" {
+			buf.WriteString(string(reason))
+		} else {
+			buf.WriteString(err.Err.Error())
+		}
+	}
+	for i, err := range e.Errors {
+		if i > 0 || len(e.Objects) > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(err.Error())
+	}
+	return buf.String()
+}
@@ -0,0 +1,64 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestApplyError(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "b", errors.New("denied"))
+	c := interceptor.NewClient(newPruneTestClient(t, false).(kclient.WithWatch), interceptor.Funcs{
+		Create: func(ctx context.Context, c kclient.WithWatch, obj kclient.Object, opts ...kclient.CreateOption) error {
+			if obj.GetName() == "b" {
+				return forbidden
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	})
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}}
+
+	err := New(c).Apply(context.Background(), owner, child("a"), child("b"))
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected an ApplyError, got %v", err)
+	}
+	if applyErr.Total != 2 || len(applyErr.Objects) != 1 || len(applyErr.Errors) != 0 {
+		t.Fatalf("expected one of two objects to fail, got %d of %d and %v", len(applyErr.Objects), applyErr.Total, applyErr.Errors)
+	}
+	objErr := applyErr.Objects[0]
+	if objErr.GVK != corev1.SchemeGroupVersion.WithKind("ConfigMap") || objErr.Namespace != "default" || objErr.Name != "b" || objErr.Action != ActionCreate {
+		t.Fatalf("expected the failure to name the object, got %+v", objErr)
+	}
+	for _, s := range []string{"failed to create default/b", "/v1, Kind=ConfigMap", "denied"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected the error to contain %q, got %v", s, err)
+		}
+	}
+
+	// The original error is still found through the wrapping.
+	if !errors.Is(err, forbidden) || !apierrors.IsForbidden(err) {
+		t.Fatalf("expected the error to unwrap to the error of the API server, got %v", err)
+	}
+	var single *ObjectError
+	if !errors.As(err, &single) || single != objErr {
+		t.Fatalf("expected the ObjectError to be extracted, got %v", single)
+	}
+	if msg := applyErr.ConditionMessage(); msg != "failed to apply 1 of 2 objects: create ConfigMap default/b: Forbidden" {
+		t.Fatalf("unexpected condition message %q", msg)
+	}
+
+	// The object that applied successfully stays applied.
+	if err := c.Get(context.Background(), kclient.ObjectKey{Namespace: "default", Name: "a"}, &corev1.ConfigMap{}); err != nil {
+		t.Fatal(err)
+	}
+}
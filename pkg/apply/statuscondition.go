@@ -0,0 +1,131 @@
+package apply
+
+import (
+	"fmt"
+
+	"github.com/obot-platform/nah/pkg/conditions"
+	"github.com/obot-platform/nah/pkg/router"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StatusConditionReason is the reason of the condition set by StatusConditionErrorHandler.
+const StatusConditionReason = "ReconcileError"
+
+// StatusConditionErrorHandler returns a router.ErrorHandler that records the error of a failed reconcile in a status
+// condition of the object, with the type conditionType, status False, and the error as its message. A reconcile
+// without error removes the condition. The error is always returned, so that the key is retried and the handler can be
+// chained with router.ErrorHandlers.
+//
+// The object must implement conditions.Conditions or be unstructured with a status. The status is patched with
+// optimistic concurrency and retried on conflicts, and objects without a status subresource are patched as a whole.
+// Conflicts of the reconcile are not recorded, because they are retried right away and are usually resolved then.
+func StatusConditionErrorHandler(conditionType string) router.ErrorHandler {
+	return func(req router.Request, resp router.Response, err error) error {
+		if req.Object == nil || !req.Object.GetDeletionTimestamp().IsZero() || apierrors.IsConflict(err) {
+			return err
+		}
+
+		var cond *metav1.Condition
+		if err != nil {
+			cond = &metav1.Condition{
+				Type:               conditionType,
+				Status:             metav1.ConditionFalse,
+				ObservedGeneration: req.Object.GetGeneration(),
+				Reason:             StatusConditionReason,
+				Message:            conditions.ErrorMessage(err),
+			}
+		}
+
+		if patchErr := patchCondition(req, conditionType, cond); patchErr != nil {
+			if err != nil {
+				return fmt.Errorf("%w, and failed to set condition %s: %v", err, conditionType, patchErr)
+			}
+			return fmt.Errorf("failed to clear condition %s: %w", conditionType, patchErr)
+		}
+		return err
+	}
+}
+
+// patchCondition sets the condition on a copy of the request's object, or removes the condition of the type if cond is
+// nil, and patches the status if it changed.
+func patchCondition(req router.Request, conditionType string, cond *metav1.Condition) error {
+	obj := req.Object.DeepCopyObject().(kclient.Object)
+	first := true
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !first {
+			if err := req.Client.Get(req.Ctx, kclient.ObjectKeyFromObject(obj), obj); err != nil {
+				return kclient.IgnoreNotFound(err)
+			}
+		}
+		first = false
+
+		orig := obj.DeepCopyObject().(kclient.Object)
+		changed, err := setCondition(obj, conditionType, cond)
+		if err != nil || !changed {
+			return err
+		}
+
+		patch := kclient.MergeFromWithOptions(orig, kclient.MergeFromWithOptimisticLock{})
+		err = req.Client.Status().Patch(req.Ctx, obj, patch)
+		if apierrors.IsNotFound(err) {
+			// Either the object is gone, or its type has no status subresource and the status is part of the object.
+			err = req.Client.Patch(req.Ctx, obj, patch)
+		}
+		return kclient.IgnoreNotFound(err)
+	})
+}
+
+// setCondition sets or removes the condition on an object that implements conditions.Conditions or is unstructured
+// with a status. It returns whether the conditions changed, which is false for objects without conditions.
+func setCondition(obj kclient.Object, conditionType string, cond *metav1.Condition) (bool, error) {
+	if t, ok := obj.(conditions.Conditions); ok {
+		conds := t.GetConditions()
+		if conds == nil {
+			return false, nil
+		}
+		return updateConditions(conds, conditionType, cond), nil
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false, nil
+	}
+	status, ok := u.Object["status"].(map[string]any)
+	if !ok {
+		return false, nil
+	}
+
+	var parsed unstructuredConditions
+	if existing, ok := status["conditions"].([]any); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(map[string]any{"conditions": existing}, &parsed); err != nil {
+			return false, fmt.Errorf("invalid status.conditions of %s %s: %w", u.GetKind(), u.GetName(), err)
+		}
+	}
+	if !updateConditions(&parsed.Conditions, conditionType, cond) {
+		return false, nil
+	}
+
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&parsed)
+	if err != nil {
+		return false, err
+	}
+	status["conditions"] = data["conditions"]
+	return true, nil
+}
+
+type unstructuredConditions struct {
+	Conditions []metav1.Condition `json:"conditions"`
+}
+
+func updateConditions(conds *[]metav1.Condition, conditionType string, cond *metav1.Condition) bool {
+	if cond == nil {
+		return meta.RemoveStatusCondition(conds, conditionType)
+	}
+	return meta.SetStatusCondition(conds, *cond)
+}
@@ -0,0 +1,92 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/router"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStatusConditionErrorHandler(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+	}).Build()
+	ctx := context.Background()
+
+	get := func() *unstructured.Unstructured {
+		t.Helper()
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+		if err := c.Get(ctx, kclient.ObjectKey{Namespace: "default", Name: "a"}, obj); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := obj.Object["status"]; !ok {
+			obj.Object["status"] = map[string]any{}
+		}
+		return obj
+	}
+	condition := func() *metav1.Condition {
+		t.Helper()
+		conds, _, err := unstructured.NestedSlice(get().Object, "status", "conditions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed unstructuredConditions
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(map[string]any{"conditions": conds}, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		return meta.FindStatusCondition(parsed.Conditions, "Reconciled")
+	}
+
+	// The next error handler gets the error and handles it, and is called for a success too.
+	var seen []error
+	onError := router.ErrorHandlers(StatusConditionErrorHandler("Reconciled"), func(req router.Request, resp router.Response, err error) error {
+		seen = append(seen, err)
+		return nil
+	})
+
+	handlerErr := errors.New("failed to reconcile")
+	req := router.Request{Client: c, Ctx: ctx, Object: get()}
+	if err := onError(req, &router.ResponseWrapper{}, handlerErr); err != nil {
+		t.Fatalf("expected the error to be handled by the next handler, got %v", err)
+	}
+	cond := condition()
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != StatusConditionReason ||
+		cond.Message != "failed to reconcile" || cond.ObservedGeneration != req.Object.GetGeneration() {
+		t.Fatalf("expected the error to be recorded in the condition, got %+v", cond)
+	}
+
+	// A later success clears the condition.
+	req.Object = get()
+	if err := onError(req, &router.ResponseWrapper{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cond := condition(); cond != nil {
+		t.Fatalf("expected the success to clear the condition, got %+v", cond)
+	}
+	if len(seen) != 2 || seen[0] != handlerErr || seen[1] != nil {
+		t.Fatalf("expected the next handler to get the error and then the success, got %v", seen)
+	}
+
+	// Alone, the error handler returns the error so that the key is retried.
+	req.Object = get()
+	if err := StatusConditionErrorHandler("Reconciled")(req, &router.ResponseWrapper{}, handlerErr); err != handlerErr {
+		t.Fatalf("expected the error to be returned, got %v", err)
+	}
+	if cond := condition(); cond == nil {
+		t.Fatal("expected the error to be recorded in the condition")
+	}
+}
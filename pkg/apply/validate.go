@@ -0,0 +1,333 @@
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/conditions"
+	"github.com/obot-platform/nah/pkg/log"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// AnnotationSkipValidation on a desired object skips the validation enabled with WithValidation.
+const AnnotationSkipValidation = LabelPrefix + "skip-validation"
+
+var crdGVK = apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition")
+
+// SkipValidation marks the object so that it is applied without validation, for objects whose required fields are
+// filled in by admission webhooks. The object is returned for convenience.
+func SkipValidation(obj kclient.Object) kclient.Object {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationSkipValidation] = "true"
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+// ValidationError is returned by an Apply with validation for a desired object that the API server would reject.
+// It is returned wrapped in a conditions.ErrTerminal, because retrying won't fix the object.
+type ValidationError struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Errors    field.ErrorList
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid desired %s %s/%s: %v", e.GVK.Kind, e.Namespace, e.Name, e.Errors.ToAggregate())
+}
+
+// WithValidation defaults and validates the desired objects before they are applied. Typed objects are defaulted
+// with the defaulting functions of the scheme. Objects of custom resources are defaulted and validated against the
+// structural schema of their CustomResourceDefinition, which is parsed again when the CRD changes. Only the schema
+// itself is checked (types, required fields and enums), not CEL rules or admission webhooks. Objects are not validated
+// against a CRD that the client isn't allowed to read, until it can be read.
+func (a apply) WithValidation() Apply {
+	a.validate = true
+	return a
+}
+
+func (a apply) validateObjects(objs []kclient.Object) error {
+	for _, obj := range objs {
+		if obj.GetAnnotations()[AnnotationSkipValidation] == "true" {
+			continue
+		}
+		if err := a.validateObject(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a apply) validateObject(obj kclient.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, a.client.Scheme())
+	if err != nil {
+		return err
+	}
+
+	ustr, isUnstructured := obj.(*unstructured.Unstructured)
+	if !isUnstructured {
+		a.client.Scheme().Default(obj)
+	}
+
+	props, status, err := a.crdSchema(gvk)
+	if err != nil || props == nil {
+		return err
+	}
+
+	var content map[string]any
+	if isUnstructured {
+		content = ustr.Object
+	} else if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+		return err
+	}
+
+	// Defaults are only written back to unstructured objects, typed objects are defaulted by the scheme.
+	applyDefaults(content, props)
+	var errs field.ErrorList
+	for name, value := range content {
+		if prop, ok := props.Properties[name]; ok && !serverField(name, status) {
+			errs = append(errs, validateValue(field.NewPath(name), value, &prop)...)
+		}
+	}
+	for _, name := range props.Required {
+		if _, ok := content[name]; !ok && !serverField(name, status) {
+			errs = append(errs, field.Required(field.NewPath(name), ""))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return conditions.NewErrTerminal(&ValidationError{
+		GVK:       gvk,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Errors:    errs,
+	})
+}
+
+// serverField returns true for the top level fields that the API server validates or ignores itself. The status of a
+// new object is ignored if the CRD has a status subresource.
+func serverField(name string, status bool) bool {
+	switch name {
+	case "apiVersion", "kind", "metadata":
+		return true
+	case "status":
+		return status
+	}
+	return false
+}
+
+type crdVersionSchema struct {
+	props  *apiextensionsv1.JSONSchemaProps
+	status bool
+	// resourceVersion is the resource version of the CRD that the schema was read from, empty if the CRD could not be
+	// read.
+	resourceVersion string
+	// expires is when the CRD of a type whose CRD could not be read is looked up again.
+	expires time.Time
+}
+
+// crdSchemaRetry is how long the objects of a type whose CRD could not be read are not validated before the CRD is
+// looked up again, so that a CRD installed later is used.
+const crdSchemaRetry = time.Minute
+
+// crdSchemas caches the schema of each GVK, with the resource version of its CRD. The CRD is read for every
+// validation, which is cheap with a cached client, and its schema is only parsed again when the CRD changed. GVKs
+// that are not custom resources, or whose CRD can't be read, are cached with a nil schema for crdSchemaRetry.
+var crdSchemas sync.Map
+
+func (a apply) crdSchema(gvk schema.GroupVersionKind) (*apiextensionsv1.JSONSchemaProps, bool, error) {
+	var cached crdVersionSchema
+	if value, ok := crdSchemas.Load(gvk); ok {
+		cached = value.(crdVersionSchema)
+		if cached.resourceVersion == "" && time.Now().Before(cached.expires) {
+			return nil, false, nil
+		}
+	}
+
+	mapping, err := a.client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, false, err
+	}
+
+	crdObj := &unstructured.Unstructured{}
+	crdObj.SetGroupVersionKind(crdGVK)
+	err = a.client.Get(a.ctx, kclient.ObjectKey{Name: mapping.Resource.Resource + "." + gvk.Group}, crdObj)
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) || meta.IsNoMatchError(err) {
+		log.Debugf("Not validating [%s] against a CRD schema: %v", gvk, err)
+		crdSchemas.Store(gvk, crdVersionSchema{expires: time.Now().Add(crdSchemaRetry)})
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if cached.resourceVersion != "" && cached.resourceVersion == crdObj.GetResourceVersion() {
+		return cached.props, cached.status, nil
+	}
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(crdObj.Object, crd); err != nil {
+		return nil, false, err
+	}
+
+	s := crdVersionSchema{resourceVersion: crd.ResourceVersion}
+	for _, version := range crd.Spec.Versions {
+		if version.Name == gvk.Version && version.Schema != nil {
+			s.props = version.Schema.OpenAPIV3Schema
+			s.status = version.Subresources != nil && version.Subresources.Status != nil
+		}
+	}
+	crdSchemas.Store(gvk, s)
+	return s.props, s.status, nil
+}
+
+// applyDefaults sets the defaults of the schema on the missing fields of the value, recursively.
+func applyDefaults(value any, props *apiextensionsv1.JSONSchemaProps) {
+	switch v := value.(type) {
+	case map[string]any:
+		for name, prop := range props.Properties {
+			if existing, ok := v[name]; ok {
+				applyDefaults(existing, &prop)
+				continue
+			}
+			if prop.Default == nil {
+				continue
+			}
+			var def any
+			if err := json.Unmarshal(prop.Default.Raw, &def); err == nil {
+				v[name] = def
+			}
+		}
+		if props.AdditionalProperties != nil && props.AdditionalProperties.Schema != nil {
+			for name, existing := range v {
+				if _, ok := props.Properties[name]; !ok {
+					applyDefaults(existing, props.AdditionalProperties.Schema)
+				}
+			}
+		}
+	case []any:
+		if props.Items != nil && props.Items.Schema != nil {
+			for _, item := range v {
+				applyDefaults(item, props.Items.Schema)
+			}
+		}
+	}
+}
+
+func validateValue(path *field.Path, value any, props *apiextensionsv1.JSONSchemaProps) field.ErrorList {
+	if value == nil {
+		if props.Nullable {
+			return nil
+		}
+		return field.ErrorList{field.Required(path, "must not be null")}
+	}
+	if props.XIntOrString {
+		switch value.(type) {
+		case string, int64, float64:
+			return nil
+		}
+		return field.ErrorList{field.Invalid(path, value, "must be an integer or a string")}
+	}
+	if props.XEmbeddedResource || props.XPreserveUnknownFields != nil && *props.XPreserveUnknownFields && len(props.Properties) == 0 {
+		return nil
+	}
+
+	if !hasType(value, props.Type) {
+		return field.ErrorList{field.Invalid(path, value, "must be of type "+props.Type)}
+	}
+	if len(props.Enum) > 0 && !inEnum(value, props.Enum) {
+		var allowed []string
+		for _, e := range props.Enum {
+			allowed = append(allowed, string(e.Raw))
+		}
+		return field.ErrorList{field.NotSupported(path, value, allowed)}
+	}
+
+	var errs field.ErrorList
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range props.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, field.Required(path.Child(name), ""))
+			}
+		}
+		for name, child := range v {
+			if prop, ok := props.Properties[name]; ok {
+				errs = append(errs, validateValue(path.Child(name), child, &prop)...)
+			} else if props.AdditionalProperties != nil && props.AdditionalProperties.Schema != nil {
+				errs = append(errs, validateValue(path.Key(name), child, props.AdditionalProperties.Schema)...)
+			}
+		}
+	case []any:
+		if props.Items != nil && props.Items.Schema != nil {
+			for i, item := range v {
+				errs = append(errs, validateValue(path.Index(i), item, props.Items.Schema)...)
+			}
+		}
+	}
+	return errs
+}
+
+func hasType(value any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		switch n := value.(type) {
+		case int64, int32, int:
+			return true
+		case float64:
+			return n == float64(int64(n))
+		}
+		return false
+	case "number":
+		switch value.(type) {
+		case int64, int32, int, float64:
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func inEnum(value any, enum []apiextensionsv1.JSON) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return false
+	}
+	for _, e := range enum {
+		var allowed any
+		if err := json.Unmarshal(e.Raw, &allowed); err == nil && reflect.DeepEqual(normalized, allowed) {
+			return true
+		}
+	}
+	return false
+}
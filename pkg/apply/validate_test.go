@@ -0,0 +1,82 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func widgetCRD(required ...string) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "widgets.validate.example.com"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "validate.example.com",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Plural: "widgets", Kind: "Widget"},
+			Scope: apiextensionsv1.NamespaceScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name: "v1",
+				Schema: &apiextensionsv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+						Type:     "object",
+						Required: required,
+					},
+				},
+			}},
+		},
+	}
+}
+
+func TestCRDSchemaFollowsCRDChanges(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "validate.example.com", Version: "v1", Kind: "Widget"}
+	scheme := runtime.NewScheme()
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	mapper.Add(crdGVK, meta.RESTScopeRoot)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).Build()
+	a := apply{ctx: context.Background(), client: c}
+
+	// The CRD is not installed yet, so the type isn't validated.
+	props, _, err := a.crdSchema(gvk)
+	if err != nil || props != nil {
+		t.Fatalf("expected no schema, got %v, %v", props, err)
+	}
+
+	crd := widgetCRD("spec")
+	if err := c.Create(context.Background(), crd); err != nil {
+		t.Fatal(err)
+	}
+	// The missing CRD is looked up again once the retry delay expired.
+	if props, _, _ = a.crdSchema(gvk); props != nil {
+		t.Fatal("expected the missing CRD to be cached until the retry delay")
+	}
+	value, _ := crdSchemas.Load(gvk)
+	missing := value.(crdVersionSchema)
+	missing.expires = time.Now().Add(-time.Second)
+	crdSchemas.Store(gvk, missing)
+
+	props, _, err = a.crdSchema(gvk)
+	if err != nil || props == nil || len(props.Required) != 1 || props.Required[0] != "spec" {
+		t.Fatalf("expected the schema of the installed CRD, got %v, %v", props, err)
+	}
+
+	// An upgrade of the CRD is seen by the next validation.
+	upgraded := widgetCRD("spec", "data")
+	upgraded.ResourceVersion = crd.ResourceVersion
+	if err := c.Update(context.Background(), upgraded); err != nil {
+		t.Fatal(err)
+	}
+	props, _, err = a.crdSchema(gvk)
+	if err != nil || props == nil || len(props.Required) != 2 {
+		t.Fatalf("expected the schema of the upgraded CRD, got %v, %v", props, err)
+	}
+}
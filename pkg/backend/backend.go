@@ -2,6 +2,8 @@ package backend
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -10,6 +12,10 @@ import (
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// OwnerUIDIndexField is the field selector that the cache of a backend indexes by the UIDs in the owner references of
+// objects. Backends that don't index it return an error when it is used.
+const OwnerUIDIndexField = "metadata.ownerReferences.uid"
+
 type Callback func(gvk schema.GroupVersionKind, key string, obj runtime.Object) (runtime.Object, error)
 
 type Trigger interface {
@@ -35,3 +41,151 @@ type Backend interface {
 type CacheFactory interface {
 	GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.SharedIndexInformer, error)
 }
+
+// EnqueueInfo describes when a key being processed was enqueued.
+type EnqueueInfo struct {
+	// EnqueuedAt is when the key was added to the queue. For requeues, this is when the requeue was due. When
+	// enqueues are coalesced, the earliest is kept.
+	EnqueuedAt time.Time
+	// EventObservedAt is when the watch event that enqueued the key was observed. This is zero for keys that were
+	// not enqueued by a watch event of the object, such as triggers and requeues.
+	EventObservedAt time.Time
+	// Requeued is true if the key was only enqueued by a requeue, either after an error or a delay.
+	Requeued bool
+	// OldObject is a copy of the cached version of the object before the update event that enqueued the key. When
+	// events are coalesced, it is the version before the earliest of them, and it is nil if that event was not an
+	// update. It is only set for types whose old objects are tracked, see OldObjectTracker.
+	OldObject runtime.Object
+}
+
+// EnqueueInfoGetter is implemented by backends that track when the keys being processed were enqueued.
+type EnqueueInfoGetter interface {
+	EnqueueInfo(gvk schema.GroupVersionKind, key string) (EnqueueInfo, bool)
+}
+
+// OldObjectTracker is implemented by backends that can keep the old object of updates in the EnqueueInfo. Old objects
+// are only kept for the types that have been tracked, because they double the memory used by the pending keys.
+type OldObjectTracker interface {
+	TrackOldObjects(gvk schema.GroupVersionKind) error
+}
+
+// EnqueueObserver is implemented by backends that can report the keys that the events of the objects of a type
+// enqueue. The observers are called for every event, with the object of the event, so they must be cheap.
+type EnqueueObserver interface {
+	ObserveEnqueues(gvk schema.GroupVersionKind, observe func(key string, obj runtime.Object)) error
+}
+
+// WorkerScaler is implemented by backends that can change the number of workers of a type while they are running.
+type WorkerScaler interface {
+	SetWorkers(gvk schema.GroupVersionKind, workers int) error
+}
+
+// PendingEnqueueLister is implemented by backends that can list the keys waiting in their queues, including the
+// delayed requeues.
+type PendingEnqueueLister interface {
+	PendingEnqueues(gvk schema.GroupVersionKind) map[string]EnqueueInfo
+}
+
+// QueuedKindLister is implemented by backends that can list the types that have a queue, including the types that are
+// only enqueued by triggers.
+type QueuedKindLister interface {
+	QueuedKinds() []schema.GroupVersionKind
+}
+
+// CachedNamespaceLister is implemented by backends whose caches can be restricted to a set of namespaces.
+type CachedNamespaceLister interface {
+	// CachedNamespaces returns the namespaces that the caches of the type are restricted to, or nil if they are
+	// cluster wide. The API groups of a backend can be restricted to different namespaces.
+	CachedNamespaces(gvk schema.GroupVersionKind) []string
+}
+
+// NamespaceNotCachedError is returned for reads of a namespace that the caches of a backend are not restricted to.
+type NamespaceNotCachedError struct {
+	Namespace  string
+	Namespaces []string
+}
+
+func (e *NamespaceNotCachedError) Error() string {
+	return fmt.Sprintf("namespace %s is not cached, the caches are restricted to the namespaces %v", e.Namespace, e.Namespaces)
+}
+
+// CheckNamespace returns a *NamespaceNotCachedError if the namespace is not one of the cached namespaces. Every
+// namespace is cached if namespaces is empty, and the empty namespace, which is for cluster scoped objects or all
+// the namespaces, is always allowed.
+func CheckNamespace(namespace string, namespaces []string) error {
+	if namespace == "" || len(namespaces) == 0 || slices.Contains(namespaces, namespace) {
+		return nil
+	}
+	return &NamespaceNotCachedError{Namespace: namespace, Namespaces: namespaces}
+}
+
+// RequeuedError wraps the error of a Callback when the caller of Watcher already requeued the key, such as with the
+// back off of a router. The backend doesn't requeue the key again, and doesn't reset the back off of its rate limiter
+// for the key, as a successful callback would.
+type RequeuedError struct {
+	Err error
+}
+
+func (e *RequeuedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequeuedError) Unwrap() error {
+	return e.Err
+}
+
+// MinDelayError wraps the error of a Callback for a key that must not be retried before Delay. The backend requeues the
+// key after the longer of Delay and the back off of its rate limiter.
+type MinDelayError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *MinDelayError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MinDelayError) Unwrap() error {
+	return e.Err
+}
+
+// IsRequeued returns true if err is a RequeuedError, or if every error joined in err is, so that a key is only left to
+// the callers of Watcher when all of them requeued it.
+func IsRequeued(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *RequeuedError:
+		return true
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		for _, err := range errs {
+			if !IsRequeued(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	case interface{ Unwrap() error }:
+		return IsRequeued(e.Unwrap())
+	}
+	return false
+}
+
+// MinDelay returns the longest Delay of the MinDelayErrors in err, zero if there are none.
+func MinDelay(err error) time.Duration {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *MinDelayError:
+		return max(e.Delay, MinDelay(e.Err))
+	case interface{ Unwrap() []error }:
+		var result time.Duration
+		for _, err := range e.Unwrap() {
+			result = max(result, MinDelay(err))
+		}
+		return result
+	case interface{ Unwrap() error }:
+		return MinDelay(e.Unwrap())
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsRequeued(t *testing.T) {
+	requeued := &RequeuedError{Err: errors.New("failed")}
+	for _, tt := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil},
+		{name: "plain", err: errors.New("failed")},
+		{name: "requeued", err: requeued, expected: true},
+		{name: "wrapped", err: fmt.Errorf("handler: %w", requeued), expected: true},
+		{name: "all joined", err: errors.Join(requeued, requeued), expected: true},
+		{name: "some joined", err: errors.Join(requeued, errors.New("failed"))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := IsRequeued(tt.err); actual != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMinDelay(t *testing.T) {
+	err := errors.Join(
+		&MinDelayError{Err: errors.New("a"), Delay: time.Minute},
+		fmt.Errorf("b: %w", &MinDelayError{Err: errors.New("b"), Delay: time.Hour}),
+		errors.New("c"),
+	)
+	if delay := MinDelay(err); delay != time.Hour {
+		t.Fatalf("expected an hour, got %s", delay)
+	}
+	if delay := MinDelay(errors.New("failed")); delay != 0 {
+		t.Fatalf("expected no delay, got %s", delay)
+	}
+}
@@ -42,6 +42,32 @@ func (e *ErrTerminal) Unwrap() error {
 	return e.Cause
 }
 
+// Terminal returns true, so that the router treats the error as terminal, such as in Router.ReconcileAll.
+func (e *ErrTerminal) Terminal() bool {
+	return true
+}
+
+// isTerminal returns true for errors from other packages that declare themselves terminal, such as a
+// router.WrongKindError, which can't return an ErrTerminal because this package imports them.
+func isTerminal(err error) bool {
+	var terminal interface {
+		Terminal() bool
+	}
+	return errors.As(err, &terminal) && terminal.Terminal()
+}
+
+// ErrorMessage returns the message of the error for a status condition. Errors that aggregate the failures of several
+// objects, such as an apply.ApplyError, are rendered as a short list of each failed object.
+func ErrorMessage(err error) string {
+	var messager interface {
+		ConditionMessage() string
+	}
+	if errors.As(err, &messager) {
+		return messager.ConditionMessage()
+	}
+	return err.Error()
+}
+
 func ErrorMiddleware() router.Middleware {
 	return func(h router.Handler) router.Handler {
 		return router.HandlerFunc(func(req router.Request, resp router.Response) error {
@@ -58,6 +84,8 @@ func ErrorMiddleware() router.Middleware {
 			err := h.Handle(req, resp)
 			if errors.As(err, &uErr) {
 				logErr = uErr
+			} else if isTerminal(err) {
+				logErr = err
 			} else if apierrors.IsNotFound(err) {
 				logErr = err
 			}
@@ -71,7 +99,7 @@ func ErrorMiddleware() router.Middleware {
 				}
 			}
 
-			errored, _ := resp.Attributes()["_errormiddleware:errored"].(bool)
+			errored, _ := resp.Attributes()[router.ErroredAttribute].(bool)
 			if errored {
 				if logErr != nil {
 					return nil
@@ -93,9 +121,9 @@ func ErrorMiddleware() router.Middleware {
 					Status:             metav1.ConditionFalse,
 					ObservedGeneration: req.Object.GetGeneration(),
 					Reason:             reflect.TypeOf(logErr).Name(),
-					Message:            logErr.Error(),
+					Message:            ErrorMessage(logErr),
 				})
-				resp.Attributes()["_errormiddleware:errored"] = true
+				resp.Attributes()[router.ErroredAttribute] = true
 				return nil
 			}
 
@@ -0,0 +1,206 @@
+// Package flags registers a standard set of command line flags, with environment variable fallbacks, for the knobs
+// that every binary embedding a router needs.
+package flags
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	nah "github.com/obot-platform/nah"
+	"github.com/obot-platform/nah/pkg/leader"
+	"github.com/obot-platform/nah/pkg/ratelimit"
+	"github.com/obot-platform/nah/pkg/restconfig"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// EnvPrefix is prepended to the upper-cased flag name, with dashes replaced by underscores, to get the environment
+// variable used when a flag is not given on the command line. For example, --kube-api-qps falls back to
+// NAH_KUBE_API_QPS.
+var EnvPrefix = "NAH_"
+
+type Options struct {
+	Kubeconfig           string
+	KubeContext          string
+	KubeAPIQPS           float32
+	KubeAPIBurst         int
+	Namespace            string
+	Concurrency          int
+	HealthzPort          int
+	LogLevel             string
+	LeaderElect          bool
+	LeaderElectNamespace string
+	LeaseDuration        time.Duration
+	ResourceLock         string
+
+	fs *pflag.FlagSet
+}
+
+// AddFlags registers the standard flags on the flag set and returns the options they populate. Call Complete after
+// the flag set is parsed to apply the environment variable fallbacks and validate the values.
+func AddFlags(fs *pflag.FlagSet) *Options {
+	o := &Options{fs: fs}
+	fs.StringVar(&o.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, defaults to the in-cluster config or $KUBECONFIG")
+	fs.StringVar(&o.KubeContext, "kube-context", "", "The kubeconfig context to use")
+	fs.Float32Var(&o.KubeAPIQPS, "kube-api-qps", 0, "QPS to use when talking to the API server, 0 disables client side rate limiting")
+	fs.IntVar(&o.KubeAPIBurst, "kube-api-burst", 0, "Burst to use when talking to the API server, defaults to twice the QPS, ignored when the QPS is 0")
+	fs.StringVar(&o.Namespace, "namespace", "", "Restrict the caches to this namespace, or a comma separated list of namespaces, empty watches all namespaces")
+	fs.IntVar(&o.Concurrency, "concurrency", 5, "The number of workers per type")
+	fs.IntVar(&o.HealthzPort, "healthz-port", 8888, "The port for the healthz endpoint, 0 or less disables it")
+	fs.StringVar(&o.LogLevel, "log-level", "info", "The log level, one of debug, info, warn, error")
+	fs.BoolVar(&o.LeaderElect, "leader-elect", true, "Enable leader election")
+	fs.StringVar(&o.LeaderElectNamespace, "leader-elect-namespace", "kube-system", "The namespace of the leader election lock")
+	fs.DurationVar(&o.LeaseDuration, "leader-elect-lease-duration", time.Minute, "The duration of the leader election lease")
+	fs.StringVar(&o.ResourceLock, "leader-elect-resource-lock", resourcelock.LeasesResourceLock, "The type of the leader election lock")
+	return o
+}
+
+// Parse registers the standard flags on a new flag set, parses the arguments, and completes the options.
+func Parse(name string, args []string) (*Options, error) {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	o := AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return o, o.Complete()
+}
+
+// EnvName returns the environment variable used as the fallback for the flag.
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Complete applies the environment variables for the flags that were not set on the command line and validates all
+// the values. Errors name the offending flag.
+func (o *Options) Complete() error {
+	var err error
+	o.fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		env := EnvName(f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if setErr := o.fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid value %q for --%s from $%s: %w", v, f.Name, env, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return o.Validate()
+}
+
+func (o *Options) Validate() error {
+	if o.KubeAPIQPS < 0 {
+		return fmt.Errorf("invalid value %v for --kube-api-qps: must not be negative", o.KubeAPIQPS)
+	}
+	if o.KubeAPIBurst < 0 {
+		return fmt.Errorf("invalid value %v for --kube-api-burst: must not be negative", o.KubeAPIBurst)
+	}
+	if o.Concurrency <= 0 {
+		return fmt.Errorf("invalid value %v for --concurrency: must be positive", o.Concurrency)
+	}
+	if _, err := logrus.ParseLevel(o.LogLevel); err != nil {
+		return fmt.Errorf("invalid value %q for --log-level: %w", o.LogLevel, err)
+	}
+	if o.LeaderElect {
+		if o.LeaseDuration <= 0 {
+			return fmt.Errorf("invalid value %v for --leader-elect-lease-duration: must be positive", o.LeaseDuration)
+		}
+		if o.ResourceLock != resourcelock.LeasesResourceLock {
+			return fmt.Errorf("invalid value %q for --leader-elect-resource-lock: only %s is supported", o.ResourceLock, resourcelock.LeasesResourceLock)
+		}
+	}
+	return nil
+}
+
+// SetLogLevel sets the logrus level from --log-level.
+func (o *Options) SetLogLevel() {
+	if level, err := logrus.ParseLevel(o.LogLevel); err == nil {
+		logrus.SetLevel(level)
+	}
+}
+
+// RESTConfig returns the config from --kubeconfig and --kube-context with --kube-api-qps and --kube-api-burst
+// applied. A QPS of 0 disables client side rate limiting, with or without a kubeconfig file.
+func (o *Options) RESTConfig(scheme *runtime.Scheme) (*rest.Config, error) {
+	var (
+		cfg *rest.Config
+		err error
+	)
+	if o.Kubeconfig != "" || o.KubeContext != "" {
+		cfg, err = restconfig.FromFile(o.Kubeconfig, o.KubeContext)
+		if err == nil {
+			cfg = restconfig.SetScheme(cfg, scheme)
+		}
+	} else {
+		cfg, err = restconfig.New(scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.KubeAPIQPS == 0 {
+		// The config of a kubeconfig file has the client-go default rate limits.
+		cfg.RateLimiter = ratelimit.None
+	} else {
+		cfg.RateLimiter = nil
+		cfg.QPS = o.KubeAPIQPS
+		cfg.Burst = o.KubeAPIBurst
+		if cfg.Burst == 0 {
+			cfg.Burst = int(2 * o.KubeAPIQPS)
+		}
+	}
+	return cfg, nil
+}
+
+// ElectionConfig returns the leader election config for the lock name, or nil if --leader-elect is false.
+func (o *Options) ElectionConfig(name string, cfg *rest.Config) *leader.ElectionConfig {
+	if !o.LeaderElect {
+		return nil
+	}
+	return leader.NewElectionConfig(o.LeaseDuration, o.LeaderElectNamespace, name, o.ResourceLock, cfg)
+}
+
+// namespaces returns the namespaces of the comma separated --namespace flag, without spaces and empty entries.
+func (o *Options) namespaces() []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(o.Namespace, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// RouterOptions returns the options for nah.New built from the flags.
+func (o *Options) RouterOptions(name string, scheme *runtime.Scheme) ([]nah.Option, error) {
+	cfg, err := o.RESTConfig(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []nah.Option{
+		nah.WithScheme(scheme),
+		nah.WithRESTConfig(cfg),
+		nah.WithDefaultConcurrency(o.Concurrency),
+		nah.WithHealthzPort(o.HealthzPort),
+	}
+	if namespaces := o.namespaces(); len(namespaces) > 1 {
+		opts = append(opts, nah.WithNamespaces(namespaces...))
+	} else if len(namespaces) == 1 {
+		opts = append(opts, nah.WithNamespace(namespaces[0]))
+	}
+	if ec := o.ElectionConfig(name, cfg); ec != nil {
+		opts = append(opts, nah.WithElectionConfig(ec))
+	} else {
+		opts = append(opts, nah.WithoutLeaderElection())
+	}
+	return opts, nil
+}
@@ -0,0 +1,62 @@
+package flags
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/ratelimit"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNamespaces(t *testing.T) {
+	for flag, expected := range map[string][]string{
+		"":         nil,
+		"a":        {"a"},
+		" a , b,,": {"a", "b"},
+		" , ":      nil,
+		"a,b,c":    {"a", "b", "c"},
+	} {
+		o := Options{Namespace: flag}
+		if namespaces := o.namespaces(); !slices.Equal(namespaces, expected) {
+			t.Errorf("--namespace %q: expected %v, got %v", flag, expected, namespaces)
+		}
+	}
+}
+
+func TestRESTConfigRateLimits(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := Options{Kubeconfig: kubeconfig}
+	cfg, err := o.RESTConfig(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimiter != ratelimit.None {
+		t.Fatalf("expected a QPS of 0 to disable client side rate limiting, got %v", cfg.RateLimiter)
+	}
+
+	o.KubeAPIQPS = 10
+	cfg, err = o.RESTConfig(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimiter != nil || cfg.QPS != 10 || cfg.Burst != 20 {
+		t.Fatalf("expected a QPS of 10 and a burst of 20, got %v and %d", cfg.QPS, cfg.Burst)
+	}
+}
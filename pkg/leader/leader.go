@@ -2,9 +2,13 @@ package leader
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,10 +26,30 @@ const (
 type OnLeader func(context.Context) error
 type OnNewLeader func(string)
 
+// OnFollower is called on every replica, whether or not it becomes the leader.
+type OnFollower func(context.Context) error
+
 type ElectionConfig struct {
 	TTL                               time.Duration
 	Name, Namespace, ResourceLockType string
-	restCfg                           *rest.Config
+	// Logger receives the logs of the election. Defaults to the log package.
+	Logger *slog.Logger
+	// OnFatal is called by Run when the leader callback fails or the lease is lost, after which the process can't go
+	// on leading. It defaults to exiting the process with log.Fatalf. It can be overridden to trigger a controlled
+	// shutdown instead, such as by canceling the context given to Run. RunAndWait returns these errors instead.
+	OnFatal func(err error)
+	// NewLock creates the lock of the election for the identity given to Run. Defaults to a lock of ResourceLockType
+	// created from the rest config. It is for locks other than the ones of client-go, such as fake locks in tests.
+	NewLock func(identity string) (resourcelock.Interface, error)
+	restCfg *rest.Config
+
+	stateLock     sync.RWMutex
+	leader        string
+	leading       bool
+	lastRenew     time.Time
+	renewErr      error
+	beforeRelease []func()
+	onLost        []func()
 }
 
 func NewDefaultElectionConfig(namespace, name string, cfg *rest.Config) *ElectionConfig {
@@ -52,25 +76,81 @@ func NewElectionConfig(ttl time.Duration, namespace, name, lockType string, cfg
 	}
 }
 
-func (ec *ElectionConfig) Run(ctx context.Context, id string, onLeader OnLeader, onSwitchLeader OnNewLeader, signalDone chan struct{}) error {
+// Leader returns the identity of the current leader and whether this process holds the lease. Without an election
+// config, there is no identity and this process is always the leader.
+func (ec *ElectionConfig) Leader() (identity string, isLeader bool) {
 	if ec == nil {
-		// Don't start leader election if there is no config.
-		return onLeader(ctx)
+		return "", true
 	}
+	ec.stateLock.RLock()
+	defer ec.stateLock.RUnlock()
+	return ec.leader, ec.leading
+}
 
-	if ec.Namespace == "" {
-		ec.Namespace = "kube-system"
+// LastRenew returns when this process last acquired or renewed the lease, which is zero if it never held it.
+func (ec *ElectionConfig) LastRenew() time.Time {
+	if ec == nil {
+		return time.Time{}
 	}
+	ec.stateLock.RLock()
+	defer ec.stateLock.RUnlock()
+	return ec.lastRenew
+}
 
-	if err := ec.run(ctx, id, onLeader, onSwitchLeader, signalDone); err != nil {
-		return fmt.Errorf("failed to start leader election for %s: %v", ec.Name, err)
+// Healthz returns an error if this process is the leader and has not renewed the lease for longer than the TTL, at
+// which point other processes may consider the lease expired. Followers, and processes without an election config,
+// are always healthy.
+func (ec *ElectionConfig) Healthz() error {
+	if ec == nil {
+		return nil
+	}
+	ec.stateLock.RLock()
+	defer ec.stateLock.RUnlock()
+	if !ec.leading || ec.lastRenew.IsZero() {
+		return nil
+	}
+	if stale := time.Since(ec.lastRenew); stale > ec.TTL {
+		if ec.renewErr != nil {
+			return fmt.Errorf("lease %s/%s was last renewed %s ago: %w", ec.Namespace, ec.Name, stale.Round(time.Second), ec.renewErr)
+		}
+		return fmt.Errorf("lease %s/%s was last renewed %s ago", ec.Namespace, ec.Name, stale.Round(time.Second))
 	}
-
 	return nil
 }
 
-func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwitchLeader OnNewLeader, signalDone chan struct{}) error {
-	rl, err := resourcelock.NewFromKubeconfig(
+func (ec *ElectionConfig) recordRenew(err error) {
+	ec.stateLock.Lock()
+	defer ec.stateLock.Unlock()
+	ec.renewErr = err
+	if err == nil {
+		ec.lastRenew = time.Now()
+	}
+}
+
+// renewRecorder records the acquires and renewals of the lease by this process, which are the only writes to the
+// lock that the leader elector makes, so that Healthz can report a lease that is not being renewed.
+type renewRecorder struct {
+	resourcelock.Interface
+	ec *ElectionConfig
+}
+
+func (r renewRecorder) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	err := r.Interface.Create(ctx, ler)
+	r.ec.recordRenew(err)
+	return err
+}
+
+func (r renewRecorder) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	err := r.Interface.Update(ctx, ler)
+	r.ec.recordRenew(err)
+	return err
+}
+
+func (ec *ElectionConfig) newLock(id string) (resourcelock.Interface, error) {
+	if ec.NewLock != nil {
+		return ec.NewLock(id)
+	}
+	return resourcelock.NewFromKubeconfig(
 		ec.ResourceLockType,
 		ec.Namespace,
 		ec.Name,
@@ -80,9 +160,122 @@ func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwi
 		ec.restCfg,
 		ec.TTL/2,
 	)
+}
+
+func (ec *ElectionConfig) logger() *slog.Logger {
+	if ec.Logger == nil {
+		return log.Slog()
+	}
+	return ec.Logger
+}
+
+func (ec *ElectionConfig) fatal(err error) {
+	if ec.OnFatal != nil {
+		ec.OnFatal(err)
+		return
+	}
+	log.Fatalf("%v", err)
+}
+
+func (ec *ElectionConfig) setLeading(leading bool) {
+	ec.stateLock.Lock()
+	defer ec.stateLock.Unlock()
+	ec.leading = leading
+}
+
+// BeforeRelease registers a function that is called when the election is asked to stop, before the lease is released.
+// The lease is held until the function returns, so it should be bounded. It is for draining the work of the leader so
+// that the next leader doesn't race with it.
+func (ec *ElectionConfig) BeforeRelease(f func()) {
+	ec.stateLock.Lock()
+	defer ec.stateLock.Unlock()
+	ec.beforeRelease = append(ec.beforeRelease, f)
+}
+
+// OnLeadershipLost registers a function that is called when the lease is lost without the election being asked to
+// stop, at which point another process may already be the leader.
+func (ec *ElectionConfig) OnLeadershipLost(f func()) {
+	ec.stateLock.Lock()
+	defer ec.stateLock.Unlock()
+	ec.onLost = append(ec.onLost, f)
+}
+
+func (ec *ElectionConfig) runHooks(hooks *[]func()) {
+	ec.stateLock.RLock()
+	fs := *hooks
+	ec.stateLock.RUnlock()
+	for _, f := range fs {
+		f()
+	}
+}
+
+// releaseAfter returns a context for running the leader elector that is canceled once ctx is done and the
+// BeforeRelease functions have returned, along with a function that reports whether that has started.
+func (ec *ElectionConfig) releaseAfter(ctx context.Context) (context.Context, context.CancelFunc, func() bool) {
+	electionCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var releasing atomic.Bool
+	stop := context.AfterFunc(ctx, func() {
+		releasing.Store(true)
+		ec.runHooks(&ec.beforeRelease)
+		cancel()
+	})
+	return electionCtx, func() {
+		stop()
+		releasing.Store(true)
+		cancel()
+	}, releasing.Load
+}
+
+// onNewLeader records the new leader before calling onSwitchLeader.
+func (ec *ElectionConfig) onNewLeader(id string, onSwitchLeader OnNewLeader) OnNewLeader {
+	return func(identity string) {
+		ec.stateLock.Lock()
+		ec.leader = identity
+		ec.leading = identity == id
+		ec.stateLock.Unlock()
+		if onSwitchLeader != nil {
+			onSwitchLeader(identity)
+		}
+	}
+}
+
+func (ec *ElectionConfig) Run(ctx context.Context, id string, onLeader OnLeader, onSwitchLeader OnNewLeader, signalDone chan struct{}) error {
+	if ec == nil {
+		// Don't start leader election if there is no config.
+		return onLeader(ctx)
+	}
+
+	if ec.Namespace == "" {
+		ec.Namespace = "kube-system"
+	}
+
+	if err := ec.run(ctx, id, onLeader, onSwitchLeader, signalDone); err != nil {
+		return fmt.Errorf("failed to start leader election for %s: %v", ec.Name, err)
+	}
+
+	return nil
+}
+
+// RunWithFollower is like Run, but also calls onFollower on every replica, for work that doesn't need the lease such
+// as serving webhooks or keeping read-only caches. onFollower is called with ctx before the election starts, so it
+// should start its work and return, and its context is not canceled by leadership changes. onLeader is additionally
+// called when the lease is acquired, with a context that is canceled when the lease is lost, and losing the lease
+// exits the process as with Run. Without an election config, both callbacks are called.
+func (ec *ElectionConfig) RunWithFollower(ctx context.Context, id string, onLeader OnLeader, onFollower OnFollower, onSwitchLeader OnNewLeader, signalDone chan struct{}) error {
+	if onFollower != nil {
+		if err := onFollower(ctx); err != nil {
+			return fmt.Errorf("follower callback error: %w", err)
+		}
+	}
+	return ec.Run(ctx, id, onLeader, onSwitchLeader, signalDone)
+}
+
+func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwitchLeader OnNewLeader, signalDone chan struct{}) error {
+	rl, err := ec.newLock(id)
 	if err != nil {
 		return fmt.Errorf("error creating leader lock for %s: %v", ec.Name, err)
 	}
+	rl = renewRecorder{Interface: rl, ec: ec}
 
 	// Catch these signals to ensure a graceful shutdown and leader election release.
 	sigCtx, cancel := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
@@ -100,12 +293,14 @@ func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwi
 		RetryPeriod:   2 * time.Second,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
+				ec.setLeading(true)
 				if err := cb(ctx); err != nil {
-					log.Fatalf("leader callback error: %v", err)
+					ec.fatal(fmt.Errorf("leader callback error: %w", err))
 				}
 			},
-			OnNewLeader: onSwitchLeader,
+			OnNewLeader: ec.onNewLeader(id, onSwitchLeader),
 			OnStoppedLeading: func() {
+				ec.setLeading(false)
 				select {
 				case <-sigCtx.Done():
 					// Must cancel so that the registered signals are no longer caught.
@@ -122,10 +317,11 @@ func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwi
 					// complete so that everything comes back up correctly after
 					// a restart.
 					// The pattern found here can be found inside the kube-scheduler.
-					log.Infof("requested to terminate, exiting")
+					ec.logger().Info("requested to terminate, exiting", "lease", ec.Name)
 					close(signalDone)
 				default:
-					log.Fatalf("leader election lost for %s", ec.Name)
+					ec.runHooks(&ec.onLost)
+					ec.fatal(fmt.Errorf("%w for %s", ErrLeadershipLost, ec.Name))
 				}
 			},
 		},
@@ -136,7 +332,81 @@ func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwi
 	}
 
 	go func() {
-		le.Run(sigCtx)
+		electionCtx, release, _ := ec.releaseAfter(sigCtx)
+		defer release()
+		le.Run(electionCtx)
 	}()
 	return nil
 }
+
+// ErrLeadershipLost is returned by RunAndWait when the lease is lost while the context is not done.
+var ErrLeadershipLost = errors.New("leader election lost")
+
+// RunAndWait is like Run, but blocks until the context is done, the leadership is lost, or onLeader returns an error,
+// and returns errors instead of exiting the process. Nil is returned when the context is done. The lease is
+// released when RunAndWait returns.
+func (ec *ElectionConfig) RunAndWait(ctx context.Context, id string, onLeader OnLeader, onSwitchLeader OnNewLeader) error {
+	if ec == nil {
+		if err := onLeader(ctx); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	if ec.Namespace == "" {
+		ec.Namespace = "kube-system"
+	}
+
+	rl, err := ec.newLock(id)
+	if err != nil {
+		return fmt.Errorf("error creating leader lock for %s: %v", ec.Name, err)
+	}
+	rl = renewRecorder{Interface: rl, ec: ec}
+
+	electionCtx, cancel, releasing := ec.releaseAfter(ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          rl,
+		LeaseDuration: ec.TTL,
+		RenewDeadline: ec.TTL / 2,
+		RetryPeriod:   2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				ec.setLeading(true)
+				if err := onLeader(ctx); err != nil {
+					select {
+					case errs <- fmt.Errorf("leader callback error: %w", err):
+					default:
+					}
+					cancel()
+				}
+			},
+			OnNewLeader: ec.onNewLeader(id, onSwitchLeader),
+			OnStoppedLeading: func() {
+				ec.setLeading(false)
+				if !releasing() {
+					ec.runHooks(&ec.onLost)
+				}
+			},
+		},
+		ReleaseOnCancel: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	le.Run(electionCtx)
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("%w for %s", ErrLeadershipLost, ec.Name)
+}
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+// SlogLevel, if set, is the minimum level of the records of the loggers returned by Slog, the records below it are
+// dropped before they are formatted. If it is nil, the debug records are written once Debugf is replaced, such as by
+// SetLogger, and dropped while Debugf is the default that discards them.
+var SlogLevel slog.Leveler
+
+// discardDebugf is the code of the default Debugf, to tell whether it was replaced.
+var discardDebugf = reflect.ValueOf(Debugf).Pointer()
+
+// slogLevel returns the minimum level of the records of the loggers returned by Slog.
+func slogLevel() slog.Level {
+	if SlogLevel != nil {
+		return SlogLevel.Level()
+	}
+	if reflect.ValueOf(Debugf).Pointer() != discardDebugf {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// Slog returns a structured logger that writes to the functions of this package, so that structured logs go to the
+// same sink as the printf style logs, including after SetLogger. The attributes are appended to the message as
+// key=value pairs. Only the records at SlogLevel or above are written, see SlogLevel.
+func Slog() *slog.Logger {
+	return slog.New(handler{})
+}
+
+type handler struct {
+	attrs []slog.Attr
+	group string
+}
+
+func (h handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slogLevel()
+}
+
+func (h handler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	for _, a := range h.attrs {
+		writeAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.group, a)
+		return true
+	})
+
+	switch {
+	case r.Level >= slog.LevelError:
+		Errorf("%s", b.String())
+	case r.Level >= slog.LevelWarn:
+		Warnf("%s", b.String())
+	case r.Level >= slog.LevelInfo:
+		Infof("%s", b.String())
+	default:
+		Debugf("%s", b.String())
+	}
+	return nil
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := handler{group: h.group, attrs: make([]slog.Attr, 0, len(h.attrs)+len(attrs))}
+	result.attrs = append(result.attrs, h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		result.attrs = append(result.attrs, a)
+	}
+	return result
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	if h.group != "" {
+		name = h.group + "." + name
+	}
+	return handler{attrs: h.attrs, group: name}
+}
+
+func writeAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	key := a.Key
+	if group != "" && key != "" {
+		key = group + "." + key
+	} else if key == "" {
+		key = group
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			writeAttr(b, key, ga)
+		}
+		return
+	}
+	fmt.Fprintf(b, " %s=%v", key, a.Value.Any())
+}
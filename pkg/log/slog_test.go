@@ -0,0 +1,42 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLevel(t *testing.T) {
+	infof, debugf := Infof, Debugf
+	t.Cleanup(func() {
+		Infof, Debugf = infof, debugf
+		SlogLevel = nil
+	})
+
+	logger := Slog().With("a", 1).WithGroup("g")
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("expected debug records to be disabled while Debugf discards them")
+	}
+
+	var logged []string
+	Infof = func(message string, obj ...interface{}) {
+		logged = append(logged, "INFO "+fmt.Sprintf(message, obj...))
+	}
+	Debugf = func(message string, obj ...interface{}) {
+		logged = append(logged, "DEBUG "+fmt.Sprintf(message, obj...))
+	}
+
+	// Once Debugf is replaced, debug records reach it.
+	logger.Debug("debug", "b", 2)
+	logger.Info("written", "c", 3)
+
+	// SlogLevel takes precedence over Debugf.
+	SlogLevel = slog.LevelInfo
+	logger.Debug("dropped")
+
+	expected := []string{"DEBUG debug a=1 g.b=2", "INFO written a=1 g.c=3"}
+	if fmt.Sprint(logged) != fmt.Sprint(expected) {
+		t.Fatalf("expected %q to be logged, got %q", expected, logged)
+	}
+}
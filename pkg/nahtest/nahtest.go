@@ -0,0 +1,170 @@
+// Package nahtest boots a real API server with envtest and builds a router against it. It is intended for the tests
+// that need server side semantics that the in memory tester package can't provide, such as server-side apply or CRD
+// validation.
+package nahtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	nah "github.com/obot-platform/nah"
+	"github.com/obot-platform/nah/pkg/router"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/rest"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const assetsEnv = "KUBEBUILDER_ASSETS"
+
+type Options struct {
+	// Name is the name of the router. Defaults to "nahtest".
+	Name string
+	// Scheme is required and is used for both the router and the returned client.
+	Scheme *kruntime.Scheme
+	// CRDDirectoryPaths are the directories, or files, that contain CRDs to install. Every CRD is waited on until
+	// it is established before New returns.
+	CRDDirectoryPaths []string
+	// BinaryAssetsDirectory is the directory containing the etcd and kube-apiserver binaries. If not set, then the
+	// KUBEBUILDER_ASSETS environment variable is used followed by the default setup-envtest install locations.
+	BinaryAssetsDirectory string
+	// StrictInvariantChecks makes the router panic when one of its internal invariants is violated, see
+	// router.WithStrictInvariantChecks.
+	StrictInvariantChecks bool
+}
+
+// New starts an API server and returns a router configured against it with leader election and healthz disabled,
+// an uncached client, and a function to stop the API server. The router is not started so that routes can be
+// registered first.
+func New(opts Options) (*router.Router, kclient.WithWatch, func() error, error) {
+	if opts.Scheme == nil {
+		return nil, nil, nil, fmt.Errorf("scheme is required to be set")
+	}
+	if opts.Name == "" {
+		opts.Name = "nahtest"
+	}
+
+	assets := opts.BinaryAssetsDirectory
+	if assets == "" {
+		assets = findAssets()
+	}
+	if assets == "" {
+		return nil, nil, nil, fmt.Errorf("failed to find envtest binaries, set %s or install them with setup-envtest", assetsEnv)
+	}
+
+	env := &envtest.Environment{
+		Scheme:                opts.Scheme,
+		BinaryAssetsDirectory: assets,
+		CRDInstallOptions: envtest.CRDInstallOptions{
+			Scheme:             opts.Scheme,
+			Paths:              opts.CRDDirectoryPaths,
+			ErrorIfPathMissing: true,
+		},
+	}
+
+	// envtest allocates free ports for etcd and the API server, so parallel test packages do not collide.
+	cfg, err := env.Start()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to start envtest: %w", err)
+	}
+
+	r, c, err := build(opts, cfg)
+	if err != nil {
+		return nil, nil, nil, errors.Join(err, env.Stop())
+	}
+
+	return r, c, env.Stop, nil
+}
+
+func build(opts Options, cfg *rest.Config) (*router.Router, kclient.WithWatch, error) {
+	c, err := kclient.NewWithWatch(cfg, kclient.Options{
+		Scheme: opts.Scheme,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := nah.NewRouter(opts.Name, &nah.Options{
+		Scheme:            opts.Scheme,
+		DefaultRESTConfig: cfg,
+		HealthzPort:       -1,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.StrictInvariantChecks {
+		router.WithStrictInvariantChecks()(r)
+	}
+
+	return r, c, nil
+}
+
+// findAssets looks for the envtest binaries in the environment and in the locations that setup-envtest installs
+// them to by default, preferring the newest version.
+func findAssets() string {
+	if dir := os.Getenv(assetsEnv); dir != "" {
+		return dir
+	}
+
+	var roots []string
+	if dataDir := os.Getenv("XDG_DATA_HOME"); dataDir != "" {
+		roots = append(roots, filepath.Join(dataDir, "kubebuilder-envtest", "k8s"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if runtime.GOOS == "darwin" {
+			roots = append(roots, filepath.Join(home, "Library", "Application Support", "io.kubebuilder.envtest", "k8s"))
+		}
+		roots = append(roots, filepath.Join(home, ".local", "share", "kubebuilder-envtest", "k8s"))
+	}
+
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+
+		var dirs []string
+		for _, entry := range entries {
+			if entry.IsDir() && hasAPIServer(filepath.Join(root, entry.Name())) {
+				dirs = append(dirs, filepath.Join(root, entry.Name()))
+			}
+		}
+		if len(dirs) > 0 {
+			return newestVersion(dirs)
+		}
+	}
+
+	if hasAPIServer("/usr/local/kubebuilder/bin") {
+		return "/usr/local/kubebuilder/bin"
+	}
+	return ""
+}
+
+// newestVersion returns the directory whose name has the highest version, such as 1.30.0-linux-amd64 over
+// 1.9.0-linux-amd64. Directories whose name is not a version sort before the others, by name.
+func newestVersion(dirs []string) string {
+	sort.Slice(dirs, func(i, j int) bool {
+		vi, errI := version.ParseGeneric(filepath.Base(dirs[i]))
+		vj, errJ := version.ParseGeneric(filepath.Base(dirs[j]))
+		switch {
+		case errI != nil && errJ != nil:
+			return dirs[i] < dirs[j]
+		case errI != nil || errJ != nil:
+			return errI != nil
+		case vi.EqualTo(vj):
+			return dirs[i] < dirs[j]
+		}
+		return vi.LessThan(vj)
+	})
+	return dirs[len(dirs)-1]
+}
+
+func hasAPIServer(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "kube-apiserver"))
+	return err == nil
+}
@@ -0,0 +1,117 @@
+package nahtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFindAssetsPrefersNewestVersion(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv(assetsEnv, "")
+	t.Setenv("XDG_DATA_HOME", dataDir)
+
+	root := filepath.Join(dataDir, "kubebuilder-envtest", "k8s")
+	for _, name := range []string{"1.9.0-linux-amd64", "1.30.0-linux-amd64", "1.29.3-linux-amd64", "latest", "1.31.0-linux-amd64"} {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// 1.31.0 is incomplete, it has no API server.
+		if name == "1.31.0-linux-amd64" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, "kube-apiserver"), nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dir := findAssets(); dir != filepath.Join(root, "1.30.0-linux-amd64") {
+		t.Fatalf("expected the newest version with an API server, got %s", dir)
+	}
+}
+
+// TestFinalizerFlow runs a finalizer end to end against a real API server. It is skipped unless the envtest binaries
+// are installed.
+func TestFinalizerFlow(t *testing.T) {
+	if findAssets() == "" {
+		t.Skipf("envtest binaries not found, set %s to run", assetsEnv)
+	}
+
+	scheme := kruntime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	r, c, stop, err := New(Options{Scheme: scheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := stop(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	const finalizer = "nahtest.obot.ai/finalizer"
+	finalized := make(chan struct{})
+	r.Type(&corev1.ConfigMap{}).Namespace("default").FinalizeFunc(finalizer, func(req router.Request, resp router.Response) error {
+		close(finalized)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Start(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-r.Ready():
+	case <-time.After(30 * time.Second):
+		t.Fatal("router did not start")
+	}
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "finalized"}}
+	if err := c.Create(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, func() bool {
+		err := c.Get(ctx, kclient.ObjectKeyFromObject(cm), cm)
+		return err == nil && slices.Contains(cm.Finalizers, finalizer)
+	})
+
+	if err := c.Delete(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-finalized:
+	case <-time.After(30 * time.Second):
+		t.Fatal("the finalizer was not called")
+	}
+	waitUntil(t, func() bool {
+		return apierrors.IsNotFound(c.Get(ctx, kclient.ObjectKeyFromObject(cm), &corev1.ConfigMap{}))
+	})
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
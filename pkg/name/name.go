@@ -3,6 +3,8 @@ package name
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"strings"
 )
 
@@ -36,3 +38,76 @@ func SafeHashConcatName(name ...string) string {
 	suffix := hex.EncodeToString(hash[:])[:8]
 	return SafeConcatName(append(name, suffix)...)
 }
+
+// DNS1123LabelMaxLength is the maximum length of a DNS-1123 label, which is the limit for most object names.
+const DNS1123LabelMaxLength = 63
+
+// SafeConcat joins the parts with "-" into a valid DNS-1123 label. The result is lower cased, characters that are not
+// allowed are replaced with "-", and if the result is longer than 63 characters, the overflow is replaced with a hash
+// of the whole joined input so that different inputs with a common prefix still get different names. The result is
+// never empty: if no allowed character is left, it is only the hash.
+func SafeConcat(parts ...string) string {
+	full := strings.Join(parts, "-")
+	result := strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '-':
+			return r
+		case 'A' <= r && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, full), "-")
+
+	digest := sha256.Sum256([]byte(full))
+	suffix := hex.EncodeToString(digest[:])[:8]
+	if result == "" {
+		return suffix
+	}
+	if len(result) <= DNS1123LabelMaxLength {
+		return result
+	}
+	return strings.TrimRight(result[:DNS1123LabelMaxLength-len(suffix)-1], "-") + "-" + suffix
+}
+
+// HashFor returns the first length hex characters of the SHA-256 of the JSON form of obj. This is suitable for
+// content derived name suffixes: the same content always gives the same hash. The length is limited to 64.
+func HashFor(obj any, length int) string {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%#v", obj))
+	}
+	digest := sha256.Sum256(data)
+	hash := hex.EncodeToString(digest[:])
+	if length <= 0 || length > len(hash) {
+		return hash
+	}
+	return hash[:length]
+}
+
+// MetricLabelMaxLength is the length above which MetricLabel shortens metric label values.
+const MetricLabelMaxLength = 128
+
+// Limit returns s if it is at most max bytes long. Otherwise it returns a prefix of s followed by "-" and a hash of the
+// whole of s, max bytes long in total. The same s always gives the same result, so limited values can still be
+// compared, and different values with a long common prefix get different results. If max leaves no room for a
+// prefix, the result is only the hash.
+func Limit(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	if max <= 0 {
+		return ""
+	}
+	suffix := HashFor(s, 8)
+	if max <= len(suffix)+1 {
+		// There is no room for a prefix, the hash alone keeps different values apart.
+		return HashFor(s, max)
+	}
+	return strings.ToValidUTF8(s[:max-len(suffix)-1], "") + "-" + suffix
+}
+
+// MetricLabel limits a value that is used as a metric label, such as a name or key, to MetricLabelMaxLength.
+func MetricLabel(value string) string {
+	return Limit(value, MetricLabelMaxLength)
+}
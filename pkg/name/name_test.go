@@ -0,0 +1,133 @@
+package name
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestLimit(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	for _, max := range []int{1, 5, 9, 10, 20, 63, 128, 199} {
+		result := Limit(long, max)
+		if len(result) != max {
+			t.Errorf("expected a result of %d bytes, got %d: %q", max, len(result), result)
+		}
+		if result != Limit(long, max) {
+			t.Errorf("expected the same result for the same input at %d bytes", max)
+		}
+	}
+
+	if result := Limit(long, 0); result != "" {
+		t.Fatalf("expected an empty result for a limit of 0, got %q", result)
+	}
+	if result := Limit("short", 10); result != "short" {
+		t.Fatalf("expected a value within the limit to be unchanged, got %q", result)
+	}
+	if result := Limit(long, 200); result != long {
+		t.Fatal("expected a value of exactly the limit to be unchanged")
+	}
+	if Limit(long+"x", 20) == Limit(long+"y", 20) {
+		t.Fatal("expected values with a long common prefix to get different results")
+	}
+}
+
+func TestLimitMultiByte(t *testing.T) {
+	// The cut falls in the middle of a multi byte character, which must not be split.
+	s := strings.Repeat("é", 100)
+	result := Limit(s, 50)
+	if !utf8.ValidString(result) {
+		t.Fatalf("expected a valid UTF-8 result, got %q", result)
+	}
+	if len(result) > 50 {
+		t.Fatalf("expected at most 50 bytes, got %d", len(result))
+	}
+}
+
+func TestMetricLabel(t *testing.T) {
+	name := "router.go:42"
+	if result := MetricLabel(name); result != name {
+		t.Fatalf("expected a short label to be unchanged, got %q", result)
+	}
+	long := strings.Repeat("route/", 50)
+	if result := MetricLabel(long); len(result) != MetricLabelMaxLength || !strings.HasPrefix(result, "route/") {
+		t.Fatalf("expected a label of %d bytes with the prefix of the value, got %q", MetricLabelMaxLength, result)
+	}
+}
+
+func TestSafeConcat(t *testing.T) {
+	for name, test := range map[string]struct {
+		parts    []string
+		expected string
+	}{
+		"joined":                {parts: []string{"parent", "role"}, expected: "parent-role"},
+		"sanitized":             {parts: []string{"My_App", "Web.Server"}, expected: "my-app-web-server"},
+		"trimmed":               {parts: []string{"-parent", "role-"}, expected: "parent-role"},
+		"at the limit":          {parts: []string{strings.Repeat("a", 63)}, expected: strings.Repeat("a", 63)},
+		"one over the limit":    {parts: []string{strings.Repeat("a", 64)}},
+		"overflow across parts": {parts: []string{strings.Repeat("a", 40), strings.Repeat("b", 40)}},
+		// The cut falls right after a "-", which is trimmed before the hash.
+		"cut at a separator":   {parts: []string{strings.Repeat("a", 53), strings.Repeat("b", 20)}},
+		"no allowed character": {parts: []string{"__", "."}},
+		"empty":                {parts: []string{""}},
+		"no parts":             {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := SafeConcat(test.parts...)
+			if errs := validation.IsDNS1123Label(result); len(errs) > 0 {
+				t.Fatalf("expected a valid DNS-1123 label, got %q: %v", result, errs)
+			}
+			if test.expected != "" && result != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, result)
+			}
+			if result != SafeConcat(test.parts...) {
+				t.Fatal("expected the same result for the same parts")
+			}
+		})
+	}
+}
+
+func TestSafeConcatHashesWholeInput(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	if result := SafeConcat(long); len(result) != DNS1123LabelMaxLength {
+		t.Fatalf("expected a result of %d characters, got %d: %q", DNS1123LabelMaxLength, len(result), result)
+	}
+	// The inputs only differ past the limit.
+	if SafeConcat(long, "x") == SafeConcat(long, "y") {
+		t.Fatal("expected inputs with a long common prefix to get different names")
+	}
+	// The inputs only differ by characters that are replaced.
+	if SafeConcat(long, "a_b") == SafeConcat(long, "a.b") {
+		t.Fatal("expected inputs that differ by replaced characters to get different names")
+	}
+	if SafeConcat("__") == SafeConcat("..") {
+		t.Fatal("expected inputs without allowed characters to get different names")
+	}
+	if SafeConcat(strings.Repeat("a", 64)) == strings.Repeat("a", 63) {
+		t.Fatal("expected an input over the limit to differ from its truncation")
+	}
+}
+
+func TestHashFor(t *testing.T) {
+	type spec struct {
+		Role     string
+		Replicas int
+	}
+	a, b := spec{Role: "web", Replicas: 1}, spec{Role: "web", Replicas: 2}
+	if HashFor(a, 8) != HashFor(spec{Role: "web", Replicas: 1}, 8) {
+		t.Fatal("expected the same content to give the same hash")
+	}
+	if HashFor(a, 8) == HashFor(b, 8) {
+		t.Fatal("expected different content to give different hashes")
+	}
+	for _, length := range []int{1, 8, 64} {
+		if hash := HashFor(a, length); len(hash) != length {
+			t.Fatalf("expected a hash of %d characters, got %q", length, hash)
+		}
+	}
+	if hash := HashFor(a, 100); len(hash) != 64 {
+		t.Fatalf("expected the hash to be limited to 64 characters, got %d", len(hash))
+	}
+}
@@ -0,0 +1,54 @@
+package restconfig
+
+import (
+	"time"
+
+	"golang.org/x/oauth2"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+const defaultTokenTTL = time.Minute
+
+// TokenFunc returns the current bearer token and, optionally, when it expires. A zero expiry means the token is
+// reloaded after the TTL given to WithRefreshingToken.
+type TokenFunc func() (token string, expiry time.Time, err error)
+
+// WithRefreshingToken returns a copy of the config that authenticates with the token returned by load. The token is
+// cached until it expires, and the cache is reset whenever the API server responds with a 401 so that the next
+// request, including the re-established watches of the informers, uses freshly loaded credentials. The transport
+// wrapper is carried by every copy of the config, so every client, cache, and leader lock built from it is covered.
+func WithRefreshingToken(cfg *rest.Config, load TokenFunc, ttl time.Duration) *rest.Config {
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+
+	cfg = rest.CopyConfig(cfg)
+	// Static credentials would otherwise be sent when the token source fails.
+	cfg.BearerToken = ""
+	cfg.BearerTokenFile = ""
+
+	ts := transport.NewCachedTokenSource(&tokenSource{load: load, ttl: ttl})
+	cfg.Wrap(transport.ResettableTokenSourceWrapTransport(ts))
+	return cfg
+}
+
+type tokenSource struct {
+	load TokenFunc
+	ttl  time.Duration
+}
+
+func (t *tokenSource) Token() (*oauth2.Token, error) {
+	token, expiry, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	if expiry.IsZero() {
+		expiry = time.Now().Add(t.ttl)
+	}
+	return &oauth2.Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package restconfig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// rotatingServer is an API server that only accepts the current token.
+type rotatingServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	valid    string
+	rejected int
+}
+
+func newRotatingServer(t *testing.T, valid string) *rotatingServer {
+	s := &rotatingServer{valid: valid}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if req.Header.Get("Authorization") != "Bearer "+s.valid {
+			s.rejected++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major":"1","minor":"30","gitVersion":"v1.30.0"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *rotatingServer) rotate(valid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.valid = valid
+}
+
+func TestRefreshingTokenRecoversFromUnauthorized(t *testing.T) {
+	server := newRotatingServer(t, "first")
+
+	var (
+		lock  sync.Mutex
+		token = "first"
+		loads int
+	)
+	load := func() (string, time.Time, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		loads++
+		return token, time.Time{}, nil
+	}
+
+	cfg := WithRefreshingToken(&rest.Config{Host: server.URL, BearerToken: "static"}, load, time.Hour)
+	clients, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverVersion := func() error {
+		_, err := clients.Discovery().ServerVersion()
+		return err
+	}
+
+	if err := serverVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The credentials rotate on the server before the new token can be loaded, so the cached token is rejected.
+	server.rotate("second")
+	if err := serverVersion(); err == nil {
+		t.Fatal("expected the stale token to be rejected")
+	}
+
+	lock.Lock()
+	token = "second"
+	lock.Unlock()
+	if err := serverVersion(); err != nil {
+		t.Fatalf("expected the new token to be loaded after a 401, got %v", err)
+	}
+	if err := serverVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	// The token is loaded by the first request and after the 401, it is cached otherwise.
+	if loads != 2 {
+		t.Fatalf("expected the token to be loaded twice, got %d", loads)
+	}
+	if server.rejected != 1 {
+		t.Fatalf("expected one rejected request, got %d", server.rejected)
+	}
+}
+
+func TestRefreshingTokenExpiry(t *testing.T) {
+	server := newRotatingServer(t, "first")
+
+	var loads int
+	load := func() (string, time.Time, error) {
+		loads++
+		// An expired token is reloaded before each request.
+		return "first", time.Now().Add(-time.Minute), nil
+	}
+
+	clients, err := kubernetes.NewForConfig(WithRefreshingToken(&rest.Config{Host: server.URL}, load, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := clients.Discovery().ServerVersion(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads < 3 {
+		t.Fatalf("expected an expired token to be reloaded, got %d loads", loads)
+	}
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrRequestAborted is returned by the Request's client for writes attempted after the request's context is done.
+// Once the router has abandoned a reconcile, no further mutations from it should reach the API server.
+var ErrRequestAborted = errors.New("request aborted")
+
+// WithBlockReadsAfterAbort makes the Request's client also fail reads with ErrRequestAborted once the request's
+// context is done. By default, only writes are blocked.
+func WithBlockReadsAfterAbort() Option {
+	return func(r *Router) {
+		r.handlers.blockReadsAfterAbort = true
+	}
+}
+
+// AbortedWrites returns the number of writes that were rejected because the request's context was done.
+func (r *Router) AbortedWrites() int64 {
+	return r.handlers.abortedWrites.Load()
+}
+
+type abortGuard struct {
+	ctx        context.Context
+	blockReads bool
+	aborted    *atomic.Int64
+	// writes, if set, counts the writes that are let through.
+	writes *atomic.Int64
+	// trace, if set, logs the writes that are let through, with their result.
+	trace *writeTracer
+}
+
+func (a *abortGuard) checkWrite() error {
+	if a == nil {
+		return nil
+	}
+	if a.ctx.Err() == nil {
+		if a.writes != nil {
+			a.writes.Add(1)
+		}
+		return nil
+	}
+	a.aborted.Add(1)
+	return fmt.Errorf("%w: %v", ErrRequestAborted, context.Cause(a.ctx))
+}
+
+func (a *abortGuard) traceWrite(verb string, obj kclient.Object, err error) {
+	if a != nil {
+		a.trace.write(verb, obj, err)
+	}
+}
+
+func (a *abortGuard) checkRead() error {
+	if a == nil || !a.blockReads || a.ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrRequestAborted, context.Cause(a.ctx))
+}
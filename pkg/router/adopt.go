@@ -0,0 +1,28 @@
+package router
+
+// AdoptExisting makes the router adopt the existing objects that have the name of an object declared with Objects by
+// the handler of this route but are not managed by any owner, such as objects created by hand before the controller
+// was deployed, instead of failing to apply them. The objects declared by the other routes of the type are not
+// adopted. An existing object that is controlled by another object is not adopted and fails the reconcile with a
+// terminal error. See Applier.
+func (r RouteBuilder) AdoptExisting() RouteBuilder {
+	r.adopt = true
+	return r
+}
+
+// AdoptHandler marks the objects declared with Objects by its handler to be adopted by the Applier. Only the objects
+// declared with the response of the router are marked.
+type AdoptHandler struct {
+	Next Handler
+}
+
+func (a AdoptHandler) Handle(req Request, resp Response) error {
+	r, ok := resp.(*response)
+	if !ok {
+		return a.Next.Handle(req, resp)
+	}
+	declared := len(r.objects)
+	err := a.Next.Handle(req, resp)
+	r.adopt = append(r.adopt, r.objects[declared:]...)
+	return err
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAdoptExisting(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	var applied, adopted []string
+	WithApplier(func(_ context.Context, _ kclient.Client, _ kclient.Object, _ []schema.GroupVersionKind, adopt []kclient.Object, objs ...kclient.Object) error {
+		for _, obj := range objs {
+			applied = append(applied, obj.GetName())
+		}
+		for _, obj := range adopt {
+			adopted = append(adopted, obj.GetName())
+		}
+		return nil
+	})(r)
+
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return Objects(resp, configMap("default", "plain"))
+	})
+	r.Type(configMap("", "")).AdoptExisting().HandlerFunc(func(req Request, resp Response) error {
+		return Objects(resp, configMap("default", "adopted"), configMap("default", "other"))
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 {
+		t.Fatalf("expected the objects of both routes to be applied, got %v", applied)
+	}
+	if len(adopted) != 2 || adopted[0] != "adopted" || adopted[1] != "other" {
+		t.Fatalf("expected only the objects of the route with AdoptExisting to be adopted, got %v", adopted)
+	}
+}
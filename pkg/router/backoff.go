@@ -0,0 +1,150 @@
+package router
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+)
+
+// DefaultBackoffJitter is the jitter of the back off of WithBackoff, unless it is set with WithBackoffJitter.
+const DefaultBackoffJitter = 0.1
+
+// WithBackoff requeues the keys whose reconcile failed, or whose handlers called RetryAfter with a zero delay, after
+// an exponential back off computed by the router: base for the first retry, doubling with each consecutive retry up to
+// maxDelay, with jitter. The retries are counted per key and reset once a reconcile of the key succeeds, its error is
+// dropped by the ErrorHandler, or the object is deleted. The count is in Request.Retries, so that an ErrorHandler can
+// give up after a number of retries. A RetryAfter with a non-zero delay takes precedence over the back off, and keys
+// that failed in a quarantined namespace are retried after no less than the FailedDelay of the quarantine.
+//
+// Without WithBackoff, failed keys are requeued with the rate limiter of the backend and RetryAfter with a zero delay
+// doesn't requeue. External routes keep the back off of their own queue.
+func WithBackoff(base, maxDelay time.Duration) Option {
+	return func(r *Router) {
+		jitter := DefaultBackoffJitter
+		if r.handlers.backoff != nil {
+			jitter = r.handlers.backoff.jitter
+		}
+		r.handlers.backoff = &backoff{
+			base:    base,
+			max:     max(base, maxDelay),
+			jitter:  jitter,
+			retries: map[limiterKey]int{},
+		}
+	}
+}
+
+// WithBackoffJitter sets the jitter of the back off of WithBackoff, as the fraction of the delay that is randomly
+// added or removed. Jitter spreads the retries of keys that failed together, such as when the API server was down.
+// It is ignored without WithBackoff.
+func WithBackoffJitter(jitter float64) Option {
+	return func(r *Router) {
+		if r.handlers.backoff == nil {
+			r.handlers.backoff = &backoff{retries: map[limiterKey]int{}}
+		}
+		r.handlers.backoff.jitter = min(max(jitter, 0), 1)
+	}
+}
+
+type backoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+
+	lock    sync.Mutex
+	retries map[limiterKey]int
+}
+
+func (b *backoff) enabled() bool {
+	return b != nil && b.base > 0
+}
+
+// retriesOf returns the number of consecutive retries of the key, zero if the back off is disabled.
+func (b *backoff) retriesOf(lKey limiterKey) int {
+	if !b.enabled() {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.retries[lKey]
+}
+
+// next counts a retry of the key and returns its delay.
+func (b *backoff) next(lKey limiterKey) time.Duration {
+	b.lock.Lock()
+	retries := b.retries[lKey]
+	b.retries[lKey] = retries + 1
+	b.lock.Unlock()
+
+	delay := b.base
+	for i := 0; i < retries && delay < b.max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.max)
+	if b.jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + b.jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+func (b *backoff) reset(lKey limiterKey) {
+	if !b.enabled() {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.retries, lKey)
+}
+
+// applyBackoff requeues the key after its back off if the reconcile failed or asked for a retry. The error of a failed
+// reconcile is logged and returned as a backend.RequeuedError. The errors that are already requeued are returned as is.
+func (m *HandlerSet) applyBackoff(req Request, resp *response, err error) error {
+	if !m.backoff.enabled() {
+		return err
+	}
+
+	if backend.IsRequeued(err) {
+		// The key was requeued by the handlers, such as the keys parked by a RetryBudget.
+		return err
+	}
+
+	lKey := limiterKey{key: req.Key, gvk: req.GVK}
+	if err == nil && !resp.retry {
+		// The reconcile succeeded, or its error was dropped by the ErrorHandler.
+		m.backoff.reset(lKey)
+		return nil
+	}
+	delay := m.backoff.next(lKey)
+	if resp.delay > 0 {
+		if err == nil {
+			// The explicit delay was already scheduled.
+			return nil
+		}
+		delay = resp.delay
+	}
+	if err != nil {
+		// Keys in a quarantined namespace are not retried before the FailedDelay of the quarantine.
+		delay = max(delay, m.quarantine.failedDelay(req.Namespace))
+		req.Logger().Error("error syncing", "err", err, "retryIn", delay)
+	}
+	if triggerErr := m.backend.Trigger(req.GVK, req.Key, delay); triggerErr != nil {
+		if err == nil {
+			return triggerErr
+		}
+		return err
+	}
+	if err != nil {
+		m.traceEnqueue(req.GVK, req.Key, delay, "the back off of the error")
+	} else {
+		m.traceEnqueue(req.GVK, req.Key, delay, "the back off of the retry")
+	}
+	if err == nil {
+		// Requeues after an error are listed by the backend as error back offs.
+		m.requeues.track(req.GVK, req.Key, m.clock.Now().Add(delay))
+		return nil
+	}
+	// The error is still returned so that the reconcile counts as failed, for the passes of ReconcileAll, Healthz and
+	// the metrics, but it is marked as requeued so that the backend doesn't requeue it again with its rate limiter.
+	return &backend.RequeuedError{Err: err}
+}
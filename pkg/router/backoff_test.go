@@ -0,0 +1,111 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+)
+
+func TestBackoffJitterBounds(t *testing.T) {
+	b := &backoff{
+		base:    time.Second,
+		max:     time.Minute,
+		jitter:  0.2,
+		retries: map[limiterKey]int{},
+	}
+	lKey := limiterKey{key: "default/a", gvk: configMapGVK}
+
+	expected := time.Second
+	for i := 0; i < 10; i++ {
+		delay := b.next(lKey)
+		low, high := time.Duration(float64(expected)*0.8), time.Duration(float64(expected)*1.2)
+		if delay < low || delay > high {
+			t.Errorf("retry %d: delay %s is not within [%s, %s]", i, delay, low, high)
+		}
+		expected = min(expected*2, time.Minute)
+	}
+}
+
+func TestBackoffResetOnSuccess(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithBackoff(time.Second, time.Minute)(r)
+	WithBackoffJitter(0)(r)
+
+	fail := true
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	lKey := limiterKey{key: "default/a", gvk: configMapGVK}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		err := b.dispatch(configMapGVK, ReplayPrefix+"default/a")
+		if !backend.IsRequeued(err) {
+			t.Fatalf("reconcile %d: expected a requeued error, got %v", i, err)
+		}
+		triggers := b.triggered()
+		if len(triggers) != 1 || triggers[0].delay != expected {
+			t.Fatalf("reconcile %d: expected a requeue after %s, got %v", i, expected, triggers)
+		}
+	}
+	if retries := r.handlers.backoff.retriesOf(lKey); retries != 3 {
+		t.Fatalf("expected 3 retries, got %d", retries)
+	}
+
+	fail = false
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if retries := r.handlers.backoff.retriesOf(lKey); retries != 0 {
+		t.Fatalf("expected the retries to be reset, got %d", retries)
+	}
+	if triggers := b.triggered(); len(triggers) != 0 {
+		t.Fatalf("expected no requeue after a success, got %v", triggers)
+	}
+}
+
+func TestBackoffExplicitDelay(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithBackoff(time.Second, time.Minute)(r)
+	WithBackoffJitter(0)(r)
+
+	var handlerErr error
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		resp.RetryAfter(0)
+		resp.RetryAfter(time.Hour)
+		resp.RetryAfter(0)
+		return handlerErr
+	})
+	startTestRouter(t, r)
+
+	// A zero delay doesn't clear the explicit delay, which takes precedence over the back off.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if triggers := b.triggered(); len(triggers) != 1 || triggers[0].delay != time.Hour {
+		t.Fatalf("expected one requeue after an hour, got %v", triggers)
+	}
+
+	handlerErr = errors.New("failed")
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); !backend.IsRequeued(err) {
+		t.Fatalf("expected a requeued error, got %v", err)
+	}
+	if triggers := b.triggered(); len(triggers) != 1 || triggers[0].delay != time.Hour {
+		t.Fatalf("expected the failed reconcile to be requeued after an hour, got %v", triggers)
+	}
+}
+
+func TestResponseWrapperRetryAfter(t *testing.T) {
+	resp := &ResponseWrapper{}
+	resp.RetryAfter(time.Minute)
+	resp.RetryAfter(0)
+	resp.RetryAfter(time.Hour)
+	if resp.Delay != time.Minute || !resp.Retry {
+		t.Fatalf("expected a delay of a minute and a retry, got %s and %v", resp.Delay, resp.Retry)
+	}
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"golang.org/x/time/rate"
+)
+
+// RetryBudget limits the error driven retries of this route to a token bucket shared by all the keys of the route,
+// refilled at limit tokens per second up to burst. When the budget is exhausted, keys that fail are not requeued
+// with the usual back off, but are parked and released one at a time as tokens refill. Successful reconciles and
+// RetryAfter without an error don't consume the budget. The errors of parked keys are returned as a
+// backend.RequeuedError, so that the reconcile still fails, without saving or applying objects or running the OnCommit
+// functions, but is neither retried with the back off of the router nor by the backend.
+func (r RouteBuilder) RetryBudget(limit rate.Limit, burst int) RouteBuilder {
+	r.retryBudget = &retryBudget{
+		limiter: rate.NewLimiter(limit, burst),
+		parked:  map[limiterKey]struct{}{},
+		paid:    map[limiterKey]struct{}{},
+	}
+	return r
+}
+
+type retryBudget struct {
+	limiter *rate.Limiter
+
+	lock      sync.Mutex
+	parked    map[limiterKey]struct{}
+	order     []limiterKey
+	paid      map[limiterKey]struct{}
+	releasing bool
+}
+
+type RetryBudgetHandler struct {
+	Next Handler
+	Name string
+
+	budget   *retryBudget
+	handlers *HandlerSet
+}
+
+func (b RetryBudgetHandler) Handle(req Request, resp Response) error {
+	err := b.Next.Handle(req, resp)
+	lKey := limiterKey{key: req.Key, gvk: req.GVK}
+	now := b.handlers.clock.Now()
+
+	b.budget.lock.Lock()
+	defer b.budget.lock.Unlock()
+
+	if err == nil {
+		// The retry of a released key succeeded, so its next failure has to pay for its retry again.
+		delete(b.budget.paid, lKey)
+		return nil
+	}
+
+	if _, ok := b.budget.paid[lKey]; ok {
+		// This key was released by the budget, so the token for its retry has already been taken.
+		delete(b.budget.paid, lKey)
+		return err
+	}
+	if _, ok := b.budget.parked[lKey]; ok {
+		return &backend.RequeuedError{Err: err}
+	}
+	if b.budget.limiter.AllowN(now, 1) {
+		return err
+	}
+
+	log.Debugf("Retry budget of [%s] exhausted, parking [%s] [%v]: %v", b.Name, req.Key, req.GVK, err)
+	b.budget.parked[lKey] = struct{}{}
+	b.budget.order = append(b.budget.order, lKey)
+	parkedKeys.WithLabelValues(nahname.MetricLabel(b.Name)).Set(float64(len(b.budget.parked)))
+	if !b.budget.releasing {
+		b.budget.releasing = true
+		go b.release()
+	}
+	return &backend.RequeuedError{Err: err}
+}
+
+// release triggers the parked keys one at a time as the budget refills, and exits once no keys are parked.
+func (b RetryBudgetHandler) release() {
+	for {
+		// The parked keys are checked before a token is reserved, so that no token is taken once none are left.
+		b.budget.lock.Lock()
+		if len(b.budget.order) == 0 {
+			b.budget.releasing = false
+			b.budget.lock.Unlock()
+			return
+		}
+		b.budget.lock.Unlock()
+
+		now := b.handlers.clock.Now()
+		delay := b.budget.limiter.ReserveN(now, 1).DelayFrom(now)
+		if delay > 0 {
+			select {
+			case <-b.handlers.clock.After(delay):
+			case <-b.handlers.ctx.Done():
+				return
+			}
+		}
+
+		// Only release removes parked keys, so the key checked above is still parked.
+		b.budget.lock.Lock()
+		lKey := b.budget.order[0]
+		b.budget.order = b.budget.order[1:]
+		delete(b.budget.parked, lKey)
+		b.budget.paid[lKey] = struct{}{}
+		parkedKeys.WithLabelValues(nahname.MetricLabel(b.Name)).Set(float64(len(b.budget.parked)))
+		b.budget.lock.Unlock()
+
+		if err := b.handlers.backend.Trigger(lKey.gvk, ReplayPrefix+lKey.key, 0); err != nil {
+			log.Errorf("failed to release [%s] [%v] from the retry budget of [%s]: %v", lKey.key, lKey.gvk, b.Name, err)
+		} else {
+			b.handlers.traceEnqueue(lKey.gvk, lKey.key, 0, "the release from the retry budget of [%s]", b.Name)
+		}
+	}
+}
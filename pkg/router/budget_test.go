@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRetryBudgetClearsPaidOnSuccess(t *testing.T) {
+	r, _ := newTestRouter(t)
+	budget := r.RetryBudget(rate.Limit(0), 1).retryBudget
+	// The parked keys are released until the router stops.
+	startTestRouter(t, r)
+
+	var handlerErr error
+	h := RetryBudgetHandler{
+		Next: HandlerFunc(func(req Request, resp Response) error {
+			return handlerErr
+		}),
+		Name:     "test",
+		budget:   budget,
+		handlers: r.handlers,
+	}
+	req := Request{GVK: configMapGVK, Key: "default/a"}
+	lKey := limiterKey{key: req.Key, gvk: req.GVK}
+
+	// The key was released by the budget and its retry succeeds.
+	budget.paid[lKey] = struct{}{}
+	if err := h.Handle(req, &ResponseWrapper{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := budget.paid[lKey]; ok {
+		t.Fatal("expected the paid retry to be cleared by the success")
+	}
+
+	// The next failure takes the only token, and the one after that is parked.
+	handlerErr = errors.New("failed")
+	if err := h.Handle(req, &ResponseWrapper{}); err == nil {
+		t.Fatal("expected the first failure to be retried with the error")
+	}
+	if err := h.Handle(req, &ResponseWrapper{}); !backend.IsRequeued(err) {
+		t.Fatalf("expected the second failure to be parked as requeued, got %v", err)
+	}
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	if _, ok := budget.parked[lKey]; !ok {
+		t.Fatal("expected the key to be parked")
+	}
+}
+
+func TestRetryBudgetParkedFailure(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithBackoff(time.Second, time.Minute)(r)
+	var applied int
+	WithApplier(func(context.Context, kclient.Client, kclient.Object, []schema.GroupVersionKind, []kclient.Object, ...kclient.Object) error {
+		applied++
+		return nil
+	})(r)
+
+	var commits int
+	r.Type(configMap("", "")).RetryBudget(rate.Limit(0), 1).HandlerFunc(func(req Request, resp Response) error {
+		if err := OnCommit(resp, func(context.Context) error {
+			commits++
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := Objects(resp, configMap("default", "child")); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	startTestRouter(t, r)
+
+	// The first failure takes the only token and is retried with the back off.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); !backend.IsRequeued(err) {
+		t.Fatalf("expected a requeued error, got %v", err)
+	}
+	if triggers := b.triggered(); len(triggers) != 1 {
+		t.Fatalf("expected the failure to be retried with the back off, got %v", triggers)
+	}
+
+	// The second failure is parked: it still fails, but it is not retried until the budget releases it.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); !backend.IsRequeued(err) {
+		t.Fatalf("expected the parked failure to be returned as requeued, got %v", err)
+	}
+	if triggers := b.triggered(); len(triggers) != 0 {
+		t.Fatalf("expected the parked key not to be retried, got %v", triggers)
+	}
+	if applied != 0 || commits != 0 {
+		t.Fatalf("expected the objects of the failed reconciles not to be applied or committed, got %d applies and %d commits", applied, commits)
+	}
+}
+
+func TestRetryBudgetReleaseKeepsTokens(t *testing.T) {
+	r, _ := newTestRouter(t)
+	budget := r.RetryBudget(rate.Every(time.Hour), 1).retryBudget
+	startTestRouter(t, r)
+
+	// Without parked keys, release exits without reserving the token.
+	RetryBudgetHandler{Name: "test", budget: budget, handlers: r.handlers}.release()
+	if tokens := budget.limiter.TokensAt(r.handlers.clock.Now()); tokens < 1 {
+		t.Fatalf("expected the token to be left, got %v", tokens)
+	}
+}
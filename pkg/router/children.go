@@ -0,0 +1,222 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// ChildrenAnnotation is set on an object whose handlers declared objects with Objects. It lists the types of
+// the applied objects, so that the objects of a type that is no longer declared are pruned, also after a restart.
+const ChildrenAnnotation = "nah.obot.ai/children"
+
+// ErroredAttribute is set to true in the Attributes of a response by middleware that turns the error of a handler
+// into a success, such as conditions.ErrorMiddleware. The objects declared with Objects are then neither
+// applied nor pruned, because the handler that failed may not have declared all of its objects.
+const ErroredAttribute = "_errormiddleware:errored"
+
+// Applier applies the objects declared with Objects with owner references to the owner, and deletes the
+// objects of the prune types that it applied for the owner before and that are not in objs. The router calls it with
+// the request's client, so that changes to the applied objects trigger the owner. The objects of objs that were
+// declared by the routes with RouteBuilder.AdoptExisting are also in adopt, the applier adopts their existing objects
+// that are not managed by any owner.
+type Applier func(ctx context.Context, c kclient.Client, owner kclient.Object, pruneGVKs []schema.GroupVersionKind, adopt []kclient.Object, objs ...kclient.Object) error
+
+// WithApplier sets the Applier of the objects declared with Objects. The routers created by nah.NewRouter
+// apply them with the apply package. Without an applier, reconciles that declare objects fail.
+func WithApplier(applier Applier) Option {
+	return func(r *Router) {
+		r.handlers.applier = applier
+	}
+}
+
+// errored returns true if middleware turned the error of a handler into a success, see ErroredAttribute.
+func (r *response) errored() bool {
+	errored, _ := r.attr[ErroredAttribute].(bool)
+	return errored
+}
+
+func (r *response) Objects(objs ...kclient.Object) {
+	r.objectsDeclared = true
+	r.objects = append(r.objects, objs...)
+}
+
+// applyObjects applies the objects declared by the handlers of the reconcile with the request's object as their owner,
+// and prunes the objects that it applied before that were not declared again. The objects of all handlers are applied
+// together as one set, so handlers of the same type can declare different objects.
+//
+// Nothing is applied or pruned if the reconcile failed, including when the error of a handler was turned into a success
+// by middleware that set the ErroredAttribute, because the handlers that failed may not have declared all of their
+// objects. Nothing is applied either if the object is deleted or being deleted, because the objects it owns are
+// garbage collected with it.
+func (m *HandlerSet) applyObjects(req Request, resp *response) error {
+	if req.Object == nil {
+		if resp.objectsDeclared && req.External != "" {
+			return fmt.Errorf("handlers of external route [%s] can't declare objects, there is no object to own them", req.External)
+		}
+		return nil
+	}
+
+	previous, err := childGVKs(req.Object)
+	if err != nil {
+		return err
+	}
+	if (!resp.objectsDeclared && len(previous) == 0) || resp.failed || resp.errored() || !req.Object.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+
+	if m.applier == nil {
+		return fmt.Errorf("handlers of %s %s declared objects, but the router has no Applier", req.GVK.Kind, req.Key)
+	}
+
+	current, err := declaredGVKs(req, resp.objects)
+	if err != nil {
+		return err
+	}
+
+	pruneGVKs := append(slices.Clone(current), previous...)
+	if err := m.applier(req.Ctx, req.Client, req.Object, pruneGVKs, resp.adopt, resp.objects...); err != nil {
+		// The annotation is not updated, so that the types that were not applied this time are pruned by the retry.
+		return err
+	}
+
+	if slices.Equal(previous, current) {
+		return nil
+	}
+	return setChildGVKs(req, current)
+}
+
+// declaredGVKs returns the sorted types of the declared objects. Objects in another namespace than a namespaced owner,
+// including cluster scoped objects, are rejected, because their owner reference would not be valid.
+func declaredGVKs(req Request, objs []kclient.Object) ([]schema.GroupVersionKind, error) {
+	ownerNamespace := req.Object.GetNamespace()
+
+	var result []schema.GroupVersionKind
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, req.Client.Scheme())
+		if err != nil {
+			return nil, err
+		}
+		if ownerNamespace != "" {
+			namespaced, err := req.Client.IsObjectNamespaced(obj)
+			if err != nil {
+				return nil, err
+			}
+			if !namespaced {
+				return nil, fmt.Errorf("cluster scoped %s %s can't be declared by namespaced %s %s", gvk.Kind,
+					obj.GetName(), req.GVK.Kind, req.Key)
+			}
+			if ns := obj.GetNamespace(); ns != "" && ns != ownerNamespace {
+				return nil, fmt.Errorf("%s %s/%s can't be declared by %s %s in another namespace", gvk.Kind, ns,
+					obj.GetName(), req.GVK.Kind, req.Key)
+			}
+		}
+		if !slices.Contains(result, gvk) {
+			result = append(result, gvk)
+		}
+	}
+
+	slices.SortFunc(result, compareGVKs)
+	return result, nil
+}
+
+func compareGVKs(a, b schema.GroupVersionKind) int {
+	if a.String() < b.String() {
+		return -1
+	} else if a.String() > b.String() {
+		return 1
+	}
+	return 0
+}
+
+func childGVKs(obj kclient.Object) ([]schema.GroupVersionKind, error) {
+	value := obj.GetAnnotations()[ChildrenAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+
+	var gvks []string
+	if err := json.Unmarshal([]byte(value), &gvks); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", ChildrenAnnotation, err)
+	}
+
+	result := make([]schema.GroupVersionKind, 0, len(gvks))
+	for _, gvk := range gvks {
+		gv, kind, ok := splitGVK(gvk)
+		if !ok {
+			return nil, fmt.Errorf("invalid type [%s] in %s annotation", gvk, ChildrenAnnotation)
+		}
+		result = append(result, gv.WithKind(kind))
+	}
+	slices.SortFunc(result, compareGVKs)
+	return result, nil
+}
+
+// splitGVK parses the apiVersion/Kind format of the annotation, such as apps/v1/Deployment or v1/ConfigMap.
+func splitGVK(s string) (schema.GroupVersion, string, bool) {
+	i := strings.LastIndex(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return schema.GroupVersion{}, "", false
+	}
+	gv, err := schema.ParseGroupVersion(s[:i])
+	if err != nil {
+		return schema.GroupVersion{}, "", false
+	}
+	return gv, s[i+1:], true
+}
+
+// setChildGVKs records the types of the applied objects on the owner, or removes the annotation if there are none. The
+// annotation of an elided owner is patched on the live object, because the patch of the elided object is refused.
+func setChildGVKs(req Request, gvks []schema.GroupVersionKind) error {
+	var value string
+	if len(gvks) > 0 {
+		values := make([]string, 0, len(gvks))
+		for _, gvk := range gvks {
+			values = append(values, gvk.GroupVersion().String()+"/"+gvk.Kind)
+		}
+		data, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+
+	target := req.Object
+	if req.IsElided() {
+		live, err := getLive(req, req.Object)
+		if err != nil {
+			return err
+		}
+		target = live
+	}
+	orig := target.DeepCopyObject().(kclient.Object)
+	setChildrenAnnotation(target, value)
+	if err := req.Client.Patch(req.Ctx, target, kclient.MergeFrom(orig)); err != nil {
+		return err
+	}
+	if target != req.Object {
+		setChildrenAnnotation(req.Object, value)
+		req.Object.SetResourceVersion(target.GetResourceVersion())
+	}
+	return nil
+}
+
+// setChildrenAnnotation sets the ChildrenAnnotation of the object to the value, or removes it if the value is empty.
+func setChildrenAnnotation(obj kclient.Object, value string) {
+	annotations := obj.GetAnnotations()
+	if value == "" {
+		delete(annotations, ChildrenAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[ChildrenAnnotation] = value
+	}
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyObjectsSkippedWhenErrored(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	var applied [][]kclient.Object
+	WithApplier(func(_ context.Context, _ kclient.Client, _ kclient.Object, _ []schema.GroupVersionKind, _ []kclient.Object, objs ...kclient.Object) error {
+		applied = append(applied, objs)
+		return nil
+	})(r)
+
+	errored := false
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return Objects(resp, configMap("default", "child"))
+	})
+	// The middleware turns the error of the second handler into a success, as conditions.ErrorMiddleware does.
+	r.Type(configMap("", "")).Middleware(func(h Handler) Handler {
+		return HandlerFunc(func(req Request, resp Response) error {
+			if err := h.Handle(req, resp); err != nil {
+				resp.Attributes()[ErroredAttribute] = true
+			}
+			return nil
+		})
+	}).HandlerFunc(func(req Request, resp Response) error {
+		if errored {
+			return errors.New("failed")
+		}
+		return Objects(resp, configMap("default", "other"))
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || len(applied[0]) != 2 {
+		t.Fatalf("expected the objects of both handlers to be applied, got %v", applied)
+	}
+
+	applied = nil
+	errored = true
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("expected nothing to be applied or pruned after a swallowed error, got %v", applied)
+	}
+}
+
+func TestObjectsUnsupported(t *testing.T) {
+	if err := Objects(&plainResponse{}, configMap("default", "child")); err == nil {
+		t.Fatal("expected an error for a response without Objects")
+	}
+	resp := &ResponseWrapper{}
+	if err := Objects(resp, configMap("default", "child")); err != nil || len(resp.Objs) != 1 {
+		t.Fatalf("expected the object to be declared with the ResponseWrapper, got %v", err)
+	}
+}
@@ -2,13 +2,16 @@ package router
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/untriggered"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -40,6 +43,7 @@ func (c *client) RESTMapper() meta.RESTMapper {
 type writer struct {
 	client   kclient.Client
 	registry TriggerRegistry
+	guard    *abortGuard
 }
 
 func (w *writer) DeleteAllOf(ctx context.Context, obj kclient.Object, opts ...kclient.DeleteAllOfOption) error {
@@ -47,34 +51,66 @@ func (w *writer) DeleteAllOf(ctx context.Context, obj kclient.Object, opts ...kc
 	for _, opt := range opts {
 		opt.ApplyToDeleteAllOf(delOpts)
 	}
+	if err := w.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := w.registry.Watch(obj, delOpts.Namespace, "", delOpts.LabelSelector, delOpts.FieldSelector); err != nil {
 		return err
 	}
-	return w.client.DeleteAllOf(ctx, obj, opts...)
+	err := w.client.DeleteAllOf(ctx, obj, opts...)
+	w.guard.traceWrite("delete all of", obj, err)
+	return err
 }
 
 func (w *writer) Delete(ctx context.Context, obj kclient.Object, opts ...kclient.DeleteOption) error {
+	if err := w.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := w.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
-	return w.client.Delete(ctx, obj, opts...)
+	err := w.client.Delete(ctx, obj, opts...)
+	w.guard.traceWrite("delete", obj, err)
+	return err
 }
 
 func (w *writer) Patch(ctx context.Context, obj kclient.Object, patch kclient.Patch, opts ...kclient.PatchOption) error {
+	// A JSON patch only changes the paths of its operations, the other patches are built from the whole object.
+	if patch.Type() != types.JSONPatchType {
+		if err := checkElided("patch", obj); err != nil {
+			return err
+		}
+	}
+	if err := w.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := w.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
-	return w.client.Patch(ctx, obj, patch, opts...)
+	err := w.client.Patch(ctx, obj, patch, opts...)
+	w.guard.traceWrite("patch", obj, err)
+	return err
 }
 
 func (w *writer) Update(ctx context.Context, obj kclient.Object, opts ...kclient.UpdateOption) error {
+	if err := checkElided("update", obj); err != nil {
+		return err
+	}
+	if err := w.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := w.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
-	return w.client.Update(ctx, obj, opts...)
+	err := w.client.Update(ctx, obj, opts...)
+	w.guard.traceWrite("update", obj, err)
+	return err
 }
 
 func (w *writer) Create(ctx context.Context, obj kclient.Object, opts ...kclient.CreateOption) (err error) {
+	if err := w.guard.checkWrite(); err != nil {
+		return err
+	}
 	if obj.GetName() == "" {
 		defer func() {
 			if err != nil {
@@ -86,28 +122,45 @@ func (w *writer) Create(ctx context.Context, obj kclient.Object, opts ...kclient
 			return err
 		}
 	}
-	return w.client.Create(ctx, obj, opts...)
+	err = w.client.Create(ctx, obj, opts...)
+	w.guard.traceWrite("create", obj, err)
+	return err
+}
+
+// checkElided returns an error for an object with elided fields, whose write would drop the elided fields on the
+// server.
+func checkElided(verb string, obj kclient.Object) error {
+	if isElided(obj) {
+		return fmt.Errorf("cannot %s %s/%s because it has elided fields, use Request.GetFull to get the full object", verb, obj.GetNamespace(), obj.GetName())
+	}
+	return nil
 }
 
 type subResourceClient struct {
 	writer   kclient.SubResourceWriter
 	reader   kclient.SubResourceReader
 	registry TriggerRegistry
+	guard    *abortGuard
 }
 
 type status struct {
 	client   kclient.Client
 	registry TriggerRegistry
+	guard    *abortGuard
 }
 
 func (s *status) Status() kclient.StatusWriter {
 	return &subResourceClient{
 		writer:   s.client.Status(),
 		registry: s.registry,
+		guard:    s.guard,
 	}
 }
 
 func (s *subResourceClient) Get(ctx context.Context, obj kclient.Object, subResource kclient.Object, opts ...kclient.SubResourceGetOption) error {
+	if err := s.guard.checkRead(); err != nil {
+		return err
+	}
 	if err := s.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
@@ -116,30 +169,47 @@ func (s *subResourceClient) Get(ctx context.Context, obj kclient.Object, subReso
 }
 
 func (s *subResourceClient) Update(ctx context.Context, obj kclient.Object, opts ...kclient.SubResourceUpdateOption) error {
+	if err := s.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := s.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
-	return s.writer.Update(ctx, obj, opts...)
+	err := s.writer.Update(ctx, obj, opts...)
+	s.guard.traceWrite("update subresource", obj, err)
+	return err
 }
 
 func (s *subResourceClient) Patch(ctx context.Context, obj kclient.Object, patch kclient.Patch, opts ...kclient.SubResourcePatchOption) error {
+	if err := s.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := s.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
-	return s.writer.Patch(ctx, obj, patch, opts...)
+	err := s.writer.Patch(ctx, obj, patch, opts...)
+	s.guard.traceWrite("patch subresource", obj, err)
+	return err
 }
 
 func (s *subResourceClient) Create(ctx context.Context, obj kclient.Object, subResource kclient.Object, opts ...kclient.SubResourceCreateOption) error {
+	if err := s.guard.checkWrite(); err != nil {
+		return err
+	}
 	if err := s.registry.Watch(obj, obj.GetNamespace(), obj.GetName(), nil, nil); err != nil {
 		return err
 	}
-	return s.writer.Create(ctx, obj, subResource, opts...)
+	err := s.writer.Create(ctx, obj, subResource, opts...)
+	s.guard.traceWrite("create subresource", obj, err)
+	return err
 }
 
 type reader struct {
 	scheme   *runtime.Scheme
 	client   kclient.Client
 	registry TriggerRegistry
+	guard    *abortGuard
+	indexes  *indexes
 }
 
 func (a *reader) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
@@ -156,10 +226,18 @@ func (a *reader) SubResource(subResource string) kclient.SubResourceClient {
 		writer:   c,
 		reader:   c,
 		registry: a.registry,
+		guard:    a.guard,
 	}
 }
 
 func (a *reader) Get(ctx context.Context, key kclient.ObjectKey, obj kclient.Object, opts ...kclient.GetOption) error {
+	if err := a.guard.checkRead(); err != nil {
+		return err
+	}
+	// Fail before registering the trigger, so that namespaces that aren't cached are not watched.
+	if err := a.checkNamespace(obj, key.Namespace); err != nil {
+		return err
+	}
 	if err := a.registry.Watch(obj, key.Namespace, key.Name, nil, nil); err != nil {
 		return err
 	}
@@ -168,14 +246,37 @@ func (a *reader) Get(ctx context.Context, key kclient.ObjectKey, obj kclient.Obj
 }
 
 func (a *reader) List(ctx context.Context, list kclient.ObjectList, opts ...kclient.ListOption) error {
+	if err := a.guard.checkRead(); err != nil {
+		return err
+	}
 	listOpt := &kclient.ListOptions{}
 	for _, opt := range opts {
 		opt.ApplyToList(listOpt)
 	}
 
+	if err := a.checkNamespace(list, listOpt.Namespace); err != nil {
+		return err
+	}
+	if err := a.indexes.checkList(a.scheme, list, listOpt.FieldSelector); err != nil {
+		return err
+	}
 	if err := a.registry.Watch(list, listOpt.Namespace, "", listOpt.LabelSelector, listOpt.FieldSelector); err != nil {
 		return err
 	}
 
 	return a.client.List(ctx, list, listOpt)
 }
+
+// checkNamespace returns a *backend.NamespaceNotCachedError if the caches of the backend for the type of the object or
+// list are restricted to other namespaces.
+func (a *reader) checkNamespace(obj runtime.Object, namespace string) error {
+	lister, ok := a.client.(backend.CachedNamespaceLister)
+	if !ok {
+		return nil
+	}
+	gvk, err := a.client.GroupVersionKindFor(untriggered.Unwrap(obj))
+	if err != nil {
+		return err
+	}
+	return backend.CheckNamespace(namespace, lister.CachedNamespaces(gvk))
+}
@@ -0,0 +1,46 @@
+package router
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReadOfUncachedNamespaceDoesNotTrigger(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	b.namespaces = []string{"default"}
+	secretGVK := corev1.SchemeGroupVersion.WithKind("Secret")
+
+	namespace := "other"
+	var getErr error
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		getErr = req.Client.Get(req.Ctx, kclient.ObjectKey{Namespace: namespace, Name: "s"}, &corev1.Secret{})
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if nsErr := (*backend.NamespaceNotCachedError)(nil); !errors.As(getErr, &nsErr) || nsErr.Namespace != "other" {
+		t.Fatalf("expected a NamespaceNotCachedError for namespace other, got %v", getErr)
+	}
+	if b.watching(secretGVK) {
+		t.Fatal("expected the read of a namespace that is not cached not to watch its type")
+	}
+
+	namespace = "default"
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if !apierrors.IsNotFound(getErr) {
+		t.Fatalf("expected the read of a cached namespace to reach the client, got %v", getErr)
+	}
+	if !b.watching(secretGVK) {
+		t.Fatal("expected the read of a cached namespace to watch its type")
+	}
+}
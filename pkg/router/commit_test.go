@@ -0,0 +1,59 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// plainResponse is a Response of another implementation that doesn't support the optional interfaces.
+type plainResponse struct {
+	ResponseAttributes
+}
+
+func (plainResponse) RetryAfter(time.Duration) {}
+
+func TestOnCommit(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+
+	var (
+		handlerErr error
+		commits    int
+	)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if err := OnCommit(resp, func(context.Context) error {
+			commits++
+			return nil
+		}); err != nil {
+			return err
+		}
+		return handlerErr
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if commits != 1 {
+		t.Fatalf("expected the callback to run once after a success, ran %d times", commits)
+	}
+
+	handlerErr = errors.New("failed")
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err == nil {
+		t.Fatal("expected the reconcile to fail")
+	}
+	if commits != 1 {
+		t.Fatalf("expected the callback not to run after a failure, ran %d times", commits)
+	}
+}
+
+func TestOnCommitUnsupported(t *testing.T) {
+	if err := OnCommit(&plainResponse{}, func(context.Context) error { return nil }); err == nil {
+		t.Fatal("expected an error for a response without OnCommit")
+	}
+	resp := &ResponseWrapper{}
+	if err := OnCommit(resp, func(context.Context) error { return nil }); err != nil || len(resp.Commits) != 1 {
+		t.Fatalf("expected the callback to be registered with the ResponseWrapper, got %v", err)
+	}
+}
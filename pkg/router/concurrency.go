@@ -0,0 +1,318 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	adaptiveWorkers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nah_adaptive_workers",
+		Help: "Number of workers chosen by the adaptive concurrency of a type.",
+	}, []string{"router", "gvk"})
+	adaptiveDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nah_adaptive_reconcile_duration_seconds",
+		Help: "Moving average of the reconcile duration of a type with adaptive concurrency.",
+	}, []string{"router", "gvk"})
+	adaptiveErrorRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nah_adaptive_error_rate",
+		Help: "Moving average of the ratio of failed reconciles of a type with adaptive concurrency.",
+	}, []string{"router", "gvk"})
+	adaptiveDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nah_adaptive_decisions_total",
+		Help: "Number of adjustments of the adaptive concurrency of a type, by decision.",
+	}, []string{"router", "gvk", "decision"})
+)
+
+const (
+	decisionGrow    = "grow"
+	decisionShrink  = "shrink"
+	decisionBackoff = "backoff"
+	decisionHold    = "hold"
+)
+
+// ConcurrencyPolicy bounds and tunes the number of workers of a type with adaptive concurrency.
+type ConcurrencyPolicy struct {
+	// Min is the number of workers the type starts with and never goes below. Defaults to 1.
+	Min int
+	// Max is the number of workers the type never goes above. Defaults to Min.
+	Max int
+	// TargetDuration is the average reconcile duration above which a worker is removed, and below which a worker is
+	// added when keys are waiting. Defaults to 1 second.
+	TargetDuration time.Duration
+	// MaxErrorRate is the average ratio of failed reconciles above which the workers are halved while it is rising,
+	// and no worker is added. Defaults to 0.1.
+	MaxErrorRate float64
+	// Interval is how often the number of workers is adjusted. Defaults to 15 seconds.
+	Interval time.Duration
+	// Smoothing is the weight of each reconcile in the moving averages, between 0 and 1. Defaults to 0.1.
+	Smoothing float64
+}
+
+// Adaptive returns the policy of an adaptive concurrency between min and max workers, with the default tuning.
+func Adaptive(min, max int) ConcurrencyPolicy {
+	return ConcurrencyPolicy{
+		Min: min,
+		Max: max,
+	}
+}
+
+func (p ConcurrencyPolicy) complete() ConcurrencyPolicy {
+	if p.Min < 1 {
+		p.Min = 1
+	}
+	if p.Max < p.Min {
+		p.Max = p.Min
+	}
+	if p.TargetDuration <= 0 {
+		p.TargetDuration = time.Second
+	}
+	if p.MaxErrorRate <= 0 {
+		p.MaxErrorRate = 0.1
+	}
+	if p.Interval <= 0 {
+		p.Interval = 15 * time.Second
+	}
+	if p.Smoothing <= 0 || p.Smoothing > 1 {
+		p.Smoothing = 0.1
+	}
+	return p
+}
+
+// Concurrency makes the number of workers of the type of this route adapt to the cost of its reconciles, within the
+// bounds of the policy. The router keeps a moving average of the duration and error rate of the reconciles of the type
+// and, every interval, halves the workers while the error rate is above the maximum and rising, which is usually
+// backpressure from the API server, removes a worker while reconciles are slower than the target, and adds one while
+// keys are waiting and reconciles are fast. Decisions are logged at debug level and exposed as metrics.
+//
+// Workers are shared by all the routes of a type, so the averages are of the whole reconcile of a key. If several
+// routes of a type set a concurrency, the bounds are widened to cover all of them and the tuning of the first is used.
+// External routes adapt their own workers the same way, with the keys waiting in the queue of the route.
+func (r RouteBuilder) Concurrency(policy ConcurrencyPolicy) RouteBuilder {
+	policy = policy.complete()
+	r.concurrency = &policy
+	return r
+}
+
+// Workers sets the number of workers of the type of this route, instead of the default number of workers of the
+// backend, such as Options.Workers. Types with their own workers are reconciled by their own pool, so a type with slow
+// reconciles doesn't starve the others.
+//
+// Whatever the number of workers, the reconciles of a key never run concurrently, and a key that is enqueued again
+// while it is being reconciled is reconciled once more after the running reconcile, however many times it was
+// enqueued. Workers are shared by all the routes of a type: if several routes set a number, the largest is used. It is
+// ignored for types with Concurrency, which choose their own number of workers. On an external route, it sets the
+// workers of the route instead of DefaultExternalWorkers.
+func (r RouteBuilder) Workers(workers int) RouteBuilder {
+	r.workers = workers
+	return r
+}
+
+// setWorkers sets the fixed number of workers of the type, keeping the largest of the routes of the type.
+func (m *HandlerSet) setWorkers(gvk schema.GroupVersionKind, workers int) {
+	scaler, ok := m.backend.(backend.WorkerScaler)
+	if !ok {
+		log.Warnf("The backend of router [%s] can't change its workers, ignoring the workers of [%s]", m.name, gvk)
+		return
+	}
+
+	m.concurrencyLock.Lock()
+	defer m.concurrencyLock.Unlock()
+	if _, ok := m.concurrency[gvk]; ok {
+		log.Warnf("Ignoring the workers of [%s] in router [%s], it has an adaptive concurrency", gvk, m.name)
+		return
+	}
+	if workers <= m.workers[gvk] {
+		return
+	}
+	if m.workers == nil {
+		m.workers = map[schema.GroupVersionKind]int{}
+	}
+	m.workers[gvk] = workers
+	if err := scaler.SetWorkers(gvk, workers); err != nil {
+		log.Errorf("Failed to set the workers of [%s] in router [%s]: %v", gvk, m.name, err)
+	}
+}
+
+type adaptiveConcurrency struct {
+	policy ConcurrencyPolicy
+
+	lock          sync.Mutex
+	workers       int
+	observed      bool
+	duration      float64
+	errorRate     float64
+	lastErrorRate float64
+}
+
+// observe adds the reconcile to the moving averages.
+func (a *adaptiveConcurrency) observe(d time.Duration, failed bool) {
+	var failure float64
+	if failed {
+		failure = 1
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.observed {
+		a.duration, a.errorRate, a.observed = d.Seconds(), failure, true
+		return
+	}
+	a.duration += a.policy.Smoothing * (d.Seconds() - a.duration)
+	a.errorRate += a.policy.Smoothing * (failure - a.errorRate)
+}
+
+// decide returns the number of workers for the number of keys waiting, and the decision that led to it.
+func (a *adaptiveConcurrency) decide(waiting int) (int, string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.observed {
+		return a.workers, decisionHold
+	}
+
+	rising := a.errorRate > a.lastErrorRate
+	a.lastErrorRate = a.errorRate
+
+	workers, decision := a.workers, decisionHold
+	switch {
+	case a.errorRate > a.policy.MaxErrorRate:
+		if rising {
+			workers, decision = max(a.policy.Min, a.workers/2), decisionBackoff
+		}
+	case a.duration > a.policy.TargetDuration.Seconds():
+		workers, decision = max(a.policy.Min, a.workers-1), decisionShrink
+	case waiting > a.workers:
+		workers, decision = min(a.policy.Max, a.workers+1), decisionGrow
+	}
+	if workers == a.workers {
+		return workers, decisionHold
+	}
+	a.workers = workers
+	return workers, decision
+}
+
+// addConcurrency enables the adaptive concurrency of the type, with the minimum workers until it is first adjusted.
+func (m *HandlerSet) addConcurrency(gvk schema.GroupVersionKind, policy ConcurrencyPolicy) {
+	scaler, ok := m.backend.(backend.WorkerScaler)
+	if !ok {
+		log.Warnf("The backend of router [%s] can't change its workers, ignoring the concurrency of [%s]", m.name, gvk)
+		return
+	}
+
+	m.concurrencyLock.Lock()
+	defer m.concurrencyLock.Unlock()
+	if a, ok := m.concurrency[gvk]; ok {
+		a.lock.Lock()
+		a.policy.Min = min(a.policy.Min, policy.Min)
+		a.policy.Max = max(a.policy.Max, policy.Max)
+		a.workers = min(max(a.workers, a.policy.Min), a.policy.Max)
+		workers := a.workers
+		a.lock.Unlock()
+		if err := scaler.SetWorkers(gvk, workers); err != nil {
+			log.Errorf("Failed to set the workers of [%s] in router [%s]: %v", gvk, m.name, err)
+		}
+		return
+	}
+
+	if m.concurrency == nil {
+		m.concurrency = map[schema.GroupVersionKind]*adaptiveConcurrency{}
+	}
+	a := &adaptiveConcurrency{
+		policy:  policy,
+		workers: policy.Min,
+	}
+	m.concurrency[gvk] = a
+	if err := scaler.SetWorkers(gvk, a.workers); err != nil {
+		log.Errorf("Failed to set the workers of [%s] in router [%s]: %v", gvk, m.name, err)
+	}
+	adaptiveWorkers.WithLabelValues(nahname.MetricLabel(m.name), gvk.String()).Set(float64(a.workers))
+	if m.concurrencyCtx != nil {
+		go m.adaptConcurrency(m.concurrencyCtx, gvk, a)
+	}
+}
+
+// startConcurrency starts adjusting the workers of the types with adaptive concurrency.
+func (m *HandlerSet) startConcurrency(ctx context.Context) {
+	m.concurrencyLock.Lock()
+	defer m.concurrencyLock.Unlock()
+	m.concurrencyCtx = ctx
+	for gvk, a := range m.concurrency {
+		go m.adaptConcurrency(ctx, gvk, a)
+	}
+}
+
+// observeConcurrency records the duration and outcome of a reconcile of a type with adaptive concurrency.
+func (m *HandlerSet) observeConcurrency(gvk schema.GroupVersionKind, d time.Duration, failed bool) {
+	m.concurrencyLock.Lock()
+	a, ok := m.concurrency[gvk]
+	m.concurrencyLock.Unlock()
+	if ok {
+		a.observe(d, failed)
+	}
+}
+
+func (m *HandlerSet) adaptConcurrency(ctx context.Context, gvk schema.GroupVersionKind, a *adaptiveConcurrency) {
+	ticker := m.clock.NewTicker(a.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			m.adjustConcurrency(gvk, a)
+		}
+	}
+}
+
+func (m *HandlerSet) adjustConcurrency(gvk schema.GroupVersionKind, a *adaptiveConcurrency) {
+	m.adjustWorkers(gvk.String(), a, m.waitingKeys(gvk), func(workers int) error {
+		return m.backend.(backend.WorkerScaler).SetWorkers(gvk, workers)
+	})
+}
+
+// adjustWorkers decides the number of workers of the type or external route with adaptive concurrency for the number
+// of keys waiting, and sets them with setWorkers if they changed.
+func (m *HandlerSet) adjustWorkers(kind string, a *adaptiveConcurrency, waiting int, setWorkers func(int) error) {
+	workers, decision := a.decide(waiting)
+
+	a.lock.Lock()
+	duration, errorRate := a.duration, a.errorRate
+	a.lock.Unlock()
+
+	routerLabel := nahname.MetricLabel(m.name)
+	adaptiveDuration.WithLabelValues(routerLabel, kind).Set(duration)
+	adaptiveErrorRate.WithLabelValues(routerLabel, kind).Set(errorRate)
+	adaptiveDecisions.WithLabelValues(routerLabel, kind, decision).Inc()
+	if decision == decisionHold {
+		return
+	}
+
+	log.Debugf("Adaptive concurrency of [%s] in router [%s]: %s to %d workers (average duration %s, error rate %.2f, %d keys waiting)",
+		kind, m.name, decision, workers, time.Duration(duration*float64(time.Second)), errorRate, waiting)
+	adaptiveWorkers.WithLabelValues(routerLabel, kind).Set(float64(workers))
+	if err := setWorkers(workers); err != nil {
+		log.Errorf("Failed to set the workers of [%s] in router [%s]: %v", kind, m.name, err)
+	}
+}
+
+// waitingKeys returns the number of keys of the type that are due and waiting for a worker.
+func (m *HandlerSet) waitingKeys(gvk schema.GroupVersionKind) int {
+	lister, ok := m.backend.(backend.PendingEnqueueLister)
+	if !ok {
+		return 0
+	}
+	now := m.clock.Now()
+	var waiting int
+	for _, info := range lister.PendingEnqueues(gvk) {
+		if !info.EnqueuedAt.After(now) {
+			waiting++
+		}
+	}
+	return waiting
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RequeueReason is why a key is waiting in the delayed queue.
+type RequeueReason string
+
+const (
+	// RequeueRetryAfter is a requeue from Response.RetryAfter.
+	RequeueRetryAfter RequeueReason = "retry-after"
+	// RequeueErrorBackoff is the back off of a key whose reconcile failed.
+	RequeueErrorBackoff RequeueReason = "error-backoff"
+)
+
+// PendingKey is a key waiting in the delayed queue of a router.
+type PendingKey struct {
+	Key    string        `json:"key"`
+	Due    time.Time     `json:"due"`
+	Reason RequeueReason `json:"reason"`
+}
+
+// WithCancelRequeuesOnDelete cancels the pending requeues of an object when it is deleted. Requeues are per key and
+// not per route, so this applies to every route of the router.
+func WithCancelRequeuesOnDelete() Option {
+	return func(r *Router) {
+		r.handlers.cancelRequeuesOnDelete = true
+	}
+}
+
+// PendingRequeues returns the keys of the GVK that are waiting in the delayed queue, ordered by when they are due. Error
+// back offs are only listed if the backend can list its pending keys.
+func (r *Router) PendingRequeues(gvk schema.GroupVersionKind) []PendingKey {
+	return r.handlers.pendingRequeues(gvk)
+}
+
+// CancelRequeue cancels the pending requeue of the key, whether it is from RetryAfter or an error back off. If the key
+// is being reconciled, then CancelRequeue waits for the reconcile to finish and cancels the requeue it scheduled, if
+// any. Changes to the object and triggers still enqueue the key. It returns false if no requeue of the key is pending.
+func (r *Router) CancelRequeue(gvk schema.GroupVersionKind, key string) bool {
+	unlock := r.handlers.lockKey(gvk, key)
+	defer unlock()
+	return r.handlers.cancelRequeueLocked(gvk, key)
+}
+
+// cancelRequeueLocked must be called with the lock of the key held, so that it doesn't race with the reconcile of the
+// key. The delayed queue of the backend can't remove entries, so the canceled requeue is skipped when it is due.
+func (m *HandlerSet) cancelRequeueLocked(gvk schema.GroupVersionKind, key string) bool {
+	now := m.clock.Now()
+	lKey := limiterKey{key: key, gvk: gvk}
+
+	m.requeues.lock.Lock()
+	due, pending := m.requeues.pending[lKey]
+	_, canceled := m.requeues.canceled[lKey]
+	m.requeues.lock.Unlock()
+	if canceled {
+		return false
+	}
+	if !pending || !due.After(now) {
+		if !m.errorBackoffPending(gvk, key, now) {
+			return false
+		}
+	}
+
+	m.requeues.lock.Lock()
+	defer m.requeues.lock.Unlock()
+	delete(m.requeues.pending, lKey)
+	m.requeues.canceled[lKey] = struct{}{}
+	return true
+}
+
+// errorBackoffPending returns true if the backend lists a pending error back off of the key.
+func (m *HandlerSet) errorBackoffPending(gvk schema.GroupVersionKind, key string, now time.Time) bool {
+	lister, ok := m.backend.(backend.PendingEnqueueLister)
+	if !ok {
+		return false
+	}
+	enqueues := lister.PendingEnqueues(gvk)
+	for _, queued := range []string{key, TriggerPrefix + key, ReplayPrefix + key} {
+		if info, ok := enqueues[queued]; ok && info.Requeued && info.EnqueuedAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// takeCanceled returns whether the requeue of the key was canceled, and forgets the cancellation because the key has
+// now been dequeued.
+func (r *requeueTracker) takeCanceled(gvk schema.GroupVersionKind, key string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	lKey := limiterKey{key: key, gvk: gvk}
+	if _, ok := r.canceled[lKey]; !ok {
+		return false
+	}
+	delete(r.canceled, lKey)
+	return true
+}
+
+func (m *HandlerSet) pendingRequeues(gvk schema.GroupVersionKind) []PendingKey {
+	now := m.clock.Now()
+
+	m.requeues.lock.Lock()
+	seen := map[string]bool{}
+	var result []PendingKey
+	for k, due := range m.requeues.pending {
+		if k.gvk == gvk && due.After(now) {
+			seen[k.key] = true
+			result = append(result, PendingKey{Key: k.key, Due: due, Reason: RequeueRetryAfter})
+		}
+	}
+	canceled := map[string]bool{}
+	for k := range m.requeues.canceled {
+		if k.gvk == gvk {
+			canceled[k.key] = true
+		}
+	}
+	m.requeues.lock.Unlock()
+
+	if lister, ok := m.backend.(backend.PendingEnqueueLister); ok {
+		for key, info := range lister.PendingEnqueues(gvk) {
+			key = strings.TrimPrefix(strings.TrimPrefix(key, TriggerPrefix), ReplayPrefix)
+			if !info.Requeued || !info.EnqueuedAt.After(now) || seen[key] || canceled[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, PendingKey{Key: key, Due: info.EnqueuedAt, Reason: RequeueErrorBackoff})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Due.Before(result[j].Due)
+	})
+	return result
+}
+
+var delayedRequeuesDesc = prometheus.NewDesc("nah_delayed_requeues", "Number of keys waiting in the delayed queue of a router.", []string{"router", "gvk", "reason"}, nil)
+
+// delayedCollector reports the pending requeues of the started routers when the metrics are scraped, rather than
+// keeping a gauge up to date on every requeue.
+type delayedCollector struct {
+	lock    sync.RWMutex
+	routers map[string]*HandlerSet
+}
+
+var delayed = &delayedCollector{
+	routers: map[string]*HandlerSet{},
+}
+
+func registerDelayed(name string, m *HandlerSet) {
+	delayed.lock.Lock()
+	defer delayed.lock.Unlock()
+	delayed.routers[name] = m
+}
+
+func (d *delayedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- delayedRequeuesDesc
+}
+
+func (d *delayedCollector) Collect(ch chan<- prometheus.Metric) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for name, m := range d.routers {
+		for _, gvk := range m.handlers.GVKs() {
+			counts := map[RequeueReason]int{}
+			for _, p := range m.pendingRequeues(gvk) {
+				counts[p.Reason]++
+			}
+			for _, reason := range []RequeueReason{RequeueRetryAfter, RequeueErrorBackoff} {
+				ch <- prometheus.MustNewConstMetric(delayedRequeuesDesc, prometheus.GaugeValue, float64(counts[reason]), nahname.MetricLabel(name), gvk.String(), string(reason))
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCancelRequeue(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"), configMap("default", "b"))
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if req.Name == "a" {
+			resp.RetryAfter(time.Hour)
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	for _, key := range []string{"default/a", "default/b"} {
+		if err := b.dispatch(configMapGVK, ReplayPrefix+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.CancelRequeue(configMapGVK, "default/b") {
+		t.Fatal("expected no requeue of a key that was not requeued")
+	}
+	if !r.CancelRequeue(configMapGVK, "default/a") {
+		t.Fatal("expected the requeue of the key to be canceled")
+	}
+	if r.CancelRequeue(configMapGVK, "default/a") {
+		t.Fatal("expected no requeue of a key whose requeue was canceled")
+	}
+	if pending := r.PendingRequeues(configMapGVK); len(pending) != 0 {
+		t.Fatalf("expected no pending requeues, got %v", pending)
+	}
+}
+
+// enqueueInfoBackend is a fake backend that reports the EnqueueInfo of the next dispatch.
+type enqueueInfoBackend struct {
+	*fakeBackend
+	info backend.EnqueueInfo
+}
+
+func (e *enqueueInfoBackend) EnqueueInfo(schema.GroupVersionKind, string) (backend.EnqueueInfo, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.info, true
+}
+
+// dispatchWith reconciles the key as the queue of the type would, with the EnqueueInfo.
+func (e *enqueueInfoBackend) dispatchWith(key string, info backend.EnqueueInfo) error {
+	e.lock.Lock()
+	e.info = info
+	e.lock.Unlock()
+	return e.dispatch(configMapGVK, key)
+}
+
+func TestCancelRequeueSurvivesEvents(t *testing.T) {
+	scheme := testScheme(t)
+	b := &enqueueInfoBackend{fakeBackend: newFakeBackend(scheme, configMap("default", "a"))}
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0)
+	var reconciles int
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		reconciles++
+		if reconciles == 1 {
+			resp.RetryAfter(time.Hour)
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatchWith(ReplayPrefix+"default/a", backend.EnqueueInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if !r.CancelRequeue(configMapGVK, "default/a") {
+		t.Fatal("expected the requeue of the key to be canceled")
+	}
+
+	// A change of the object is reconciled, and doesn't consume the cancellation of the requeue.
+	if err := b.dispatchWith(ReplayPrefix+"default/a", backend.EnqueueInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if reconciles != 2 {
+		t.Fatalf("expected the change to be reconciled, got %d reconciles", reconciles)
+	}
+
+	// The canceled requeue is skipped when it is due.
+	if err := b.dispatchWith(ReplayPrefix+"default/a", backend.EnqueueInfo{Requeued: true}); err != nil {
+		t.Fatal(err)
+	}
+	if reconciles != 2 {
+		t.Fatalf("expected the canceled requeue to be skipped, got %d reconciles", reconciles)
+	}
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"encoding/json"
+
+	"github.com/obot-platform/nah/pkg/log"
+	"gomodules.xyz/jsonpatch/v2"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const diffAttribute = "_objectdiff"
+
+// Diff snapshots the object before the handler of this route runs and computes a JSON patch from the snapshot to the
+// object after the handler returns, including any writes of the object. The patch is available to the middleware of
+// this route with ObjectDiff and is logged at debug level. The resourceVersion and managedFields are excluded from the
+// patch. This deep copies the object on every reconcile, so it is off by default.
+func (r RouteBuilder) Diff() RouteBuilder {
+	r.diff = true
+	return r
+}
+
+// ObjectDiff returns the JSON patch computed for the route's object by a route with Diff, if any.
+func ObjectDiff(resp Response) ([]jsonpatch.Operation, bool) {
+	ops, ok := resp.Attributes()[diffAttribute].([]jsonpatch.Operation)
+	return ops, ok
+}
+
+type DiffRecorder struct {
+	Next Handler
+}
+
+func (d DiffRecorder) Handle(req Request, resp Response) error {
+	if req.Object == nil {
+		return d.Next.Handle(req, resp)
+	}
+
+	before, err := diffJSON(req.Object)
+	if err != nil {
+		return err
+	}
+
+	handlerErr := d.Next.Handle(req, resp)
+
+	after, err := diffJSON(req.Object)
+	if err != nil {
+		return err
+	}
+	ops, err := jsonpatch.CreatePatch(before, after)
+	if err != nil {
+		return err
+	}
+
+	resp.Attributes()[diffAttribute] = ops
+	if len(ops) > 0 {
+		if patch, err := json.Marshal(ops); err == nil {
+			log.Debugf("Diff of [%s/%s] [%v]: %s", req.Namespace, req.Name, req.GVK, patch)
+			req.LogSummary("diff", string(patch))
+		}
+	}
+	return handlerErr
+}
+
+func diffJSON(obj kclient.Object) ([]byte, error) {
+	obj = obj.DeepCopyObject().(kclient.Object)
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	return json.Marshal(obj)
+}
@@ -0,0 +1,259 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/leader"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// fakeLease is a lease in memory shared by the fake locks of several routers.
+type fakeLease struct {
+	lock   sync.Mutex
+	record *resourcelock.LeaderElectionRecord
+	// failing are the identities whose renewals fail, as if they lost the connection to the API server.
+	failing map[string]bool
+}
+
+func (l *fakeLease) fail(identity string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.failing == nil {
+		l.failing = map[string]bool{}
+	}
+	l.failing[identity] = true
+}
+
+type fakeLock struct {
+	lease    *fakeLease
+	identity string
+}
+
+func (f fakeLock) Get(context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	f.lease.lock.Lock()
+	defer f.lease.lock.Unlock()
+	if f.lease.record == nil {
+		return nil, nil, apierrors.NewNotFound(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "test")
+	}
+	record := *f.lease.record
+	raw, err := json.Marshal(record)
+	return &record, raw, err
+}
+
+func (f fakeLock) Create(_ context.Context, ler resourcelock.LeaderElectionRecord) error {
+	f.lease.lock.Lock()
+	defer f.lease.lock.Unlock()
+	if f.lease.record != nil {
+		return apierrors.NewAlreadyExists(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "test")
+	}
+	f.lease.record = &ler
+	return nil
+}
+
+func (f fakeLock) Update(_ context.Context, ler resourcelock.LeaderElectionRecord) error {
+	f.lease.lock.Lock()
+	defer f.lease.lock.Unlock()
+	if f.lease.failing[f.identity] {
+		return errors.New("lease is unreachable")
+	}
+	f.lease.record = &ler
+	return nil
+}
+
+func (f fakeLock) RecordEvent(string) {}
+
+func (f fakeLock) Identity() string {
+	return f.identity
+}
+
+func (f fakeLock) Describe() string {
+	return "default/test"
+}
+
+// newTestElectionRouter returns a router whose election uses a fake lock of the lease with the identity.
+func newTestElectionRouter(t *testing.T, lease *fakeLease, identity string, onFatal func(error), opts ...Option) (*Router, *fakeBackend) {
+	t.Helper()
+	scheme := testScheme(t)
+	b := newFakeBackend(scheme, configMap("default", "a"))
+	ec := leader.NewElectionConfig(5*time.Second, "default", "test", "", nil)
+	ec.NewLock = func(string) (resourcelock.Interface, error) {
+		return fakeLock{lease: lease, identity: identity}, nil
+	}
+	ec.OnFatal = onFatal
+	r := New(NewHandlerSet(t.Name()+"-"+identity, scheme, b), ec, 0, append([]Option{WithShutdownTimeout(5 * time.Second)}, opts...)...)
+	return r, b
+}
+
+func waitReady(t *testing.T, r *Router, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-r.Ready():
+	case <-time.After(timeout):
+		t.Fatal("router did not become the leader")
+	}
+}
+
+// TestLeaseHandoffAfterDrain checks that the next leader starts only once the handlers of the last leader have
+// returned, because the lease is released after the drain.
+func TestLeaseHandoffAfterDrain(t *testing.T) {
+	lease := &fakeLease{}
+	first, firstBackend := newTestElectionRouter(t, lease, "first", func(err error) { t.Errorf("unexpected fatal error: %v", err) })
+
+	running := make(chan struct{})
+	var (
+		lock     sync.Mutex
+		returned time.Time
+	)
+	first.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		close(running)
+		time.Sleep(500 * time.Millisecond)
+		lock.Lock()
+		returned = time.Now()
+		lock.Unlock()
+		return req.Ctx.Err()
+	})
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	go func() {
+		_ = first.Start(firstCtx)
+	}()
+	waitReady(t, first, 10*time.Second)
+
+	second, _ := newTestElectionRouter(t, lease, "second", func(error) {})
+	second.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	go func() {
+		_ = second.Start(secondCtx)
+	}()
+
+	reconciled := make(chan error, 1)
+	go func() {
+		reconciled <- firstBackend.dispatch(configMapGVK, ReplayPrefix+"default/a")
+	}()
+	<-running
+	cancelFirst()
+
+	waitReady(t, second, 20*time.Second)
+	leading := time.Now()
+	if err := <-reconciled; err != nil {
+		t.Fatalf("expected the handler of the last leader to finish without being aborted, got %v", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !returned.Before(leading) {
+		t.Fatalf("expected the next leader to start after the handler returned at %s, started at %s", returned, leading)
+	}
+	<-first.Stopped()
+}
+
+// TestLeaseLostAbortsHandlers checks that the handlers are aborted as soon as the lease is lost.
+func TestLeaseLostAbortsHandlers(t *testing.T) {
+	lease := &fakeLease{}
+	fatal := make(chan error, 1)
+	r, b := newTestElectionRouter(t, lease, "first", func(err error) { fatal <- err })
+
+	running := make(chan struct{})
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		close(running)
+		select {
+		case <-req.Ctx.Done():
+			return req.Ctx.Err()
+		case <-time.After(20 * time.Second):
+			return nil
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = r.Start(ctx)
+	}()
+	waitReady(t, r, 10*time.Second)
+
+	reconciled := make(chan error, 1)
+	go func() {
+		reconciled <- b.dispatch(configMapGVK, ReplayPrefix+"default/a")
+	}()
+	<-running
+	lease.fail("first")
+
+	select {
+	case err := <-reconciled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the handler to be aborted when the lease is lost, got %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("the handler was not aborted when the lease was lost")
+	}
+	select {
+	case err := <-fatal:
+		if !errors.Is(err, leader.ErrLeadershipLost) {
+			t.Fatalf("expected a fatal error for the lost lease, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a fatal error for the lost lease")
+	}
+}
+
+// TestWarmStandbyFailover checks that a warm standby syncs its caches while the other router leads, without running
+// its handlers, and only starts its workers once it takes over.
+func TestWarmStandbyFailover(t *testing.T) {
+	lease := &fakeLease{}
+	first, _ := newTestElectionRouter(t, lease, "first", func(err error) { t.Errorf("unexpected fatal error: %v", err) })
+	first.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	go func() {
+		_ = first.Start(firstCtx)
+	}()
+	waitReady(t, first, 10*time.Second)
+
+	standby, b := newTestElectionRouter(t, lease, "standby", func(error) {}, WithWarmStandby(&corev1.Secret{}))
+	var reconciled atomic.Bool
+	standby.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		reconciled.Store(true)
+		return nil
+	})
+	standbyCtx, cancelStandby := context.WithCancel(context.Background())
+	defer cancelStandby()
+	go func() {
+		_ = standby.Start(standbyCtx)
+	}()
+
+	waitFor(t, 10*time.Second, func() bool {
+		preloads, _ := b.calls()
+		return preloads > 0
+	})
+	if !b.watching(configMapGVK) || !b.watching(corev1.SchemeGroupVersion.WithKind("Secret")) {
+		t.Fatal("expected the standby to watch its types and the extra types")
+	}
+	if _, starts := b.calls(); starts != 0 || standby.Phase() != PhaseStarting {
+		t.Fatalf("expected the standby not to start its workers, got %d starts in phase %s", starts, standby.Phase())
+	}
+
+	cancelFirst()
+	<-first.Stopped()
+	waitReady(t, standby, 20*time.Second)
+	if _, starts := b.calls(); starts != 1 {
+		t.Fatalf("expected the workers to start once on the failover, got %d starts", starts)
+	}
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if !reconciled.Load() {
+		t.Fatal("expected the new leader to reconcile")
+	}
+}
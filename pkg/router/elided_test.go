@@ -0,0 +1,138 @@
+package router
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/untriggered"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// elidingBackend is a fake backend whose cache elides the data of the config maps, as an ElidePolicy of .data would.
+// Uncached reads return the full objects.
+type elidingBackend struct {
+	*fakeBackend
+}
+
+func (e *elidingBackend) Get(ctx context.Context, key kclient.ObjectKey, obj kclient.Object, opts ...kclient.GetOption) error {
+	if holder, ok := obj.(*untriggered.Holder); ok {
+		if holder.IsUncached() {
+			return e.fakeBackend.Get(ctx, key, holder.Object, opts...)
+		}
+		obj = holder.Object
+	}
+	if err := e.fakeBackend.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	if cm, ok := obj.(*corev1.ConfigMap); ok {
+		cm.Data = nil
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Annotations[ElidedAnnotation] = ".data"
+	}
+	return nil
+}
+
+func newElidingRouter(t *testing.T, objs ...kclient.Object) (*Router, *elidingBackend) {
+	t.Helper()
+	scheme := testScheme(t)
+	b := &elidingBackend{fakeBackend: newFakeBackend(scheme, objs...)}
+	return New(NewHandlerSet(t.Name(), scheme, b), nil, 0), b
+}
+
+func TestElidedObjectWrites(t *testing.T) {
+	obj := configMap("default", "a")
+	obj.Data = map[string]string{"large": "value"}
+	r, b := newElidingRouter(t, obj)
+
+	var updateErr, mergeErr, jsonErr error
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if !req.IsElided() {
+			t.Error("expected the object of the request to be elided")
+		}
+		updateErr = req.Client.Update(req.Ctx, req.Object)
+		mergeErr = req.Client.Patch(req.Ctx, req.Object, kclient.MergeFrom(req.Object.DeepCopyObject().(kclient.Object)))
+		jsonErr = req.Client.Patch(req.Ctx, req.Object, kclient.RawPatch(types.JSONPatchType,
+			[]byte(`[{"op":"add","path":"/metadata/labels","value":{"patched":"true"}}]`)))
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	for verb, err := range map[string]error{"update": updateErr, "patch": mergeErr} {
+		if err == nil || !strings.Contains(err.Error(), "cannot "+verb+" default/a because it has elided fields") {
+			t.Errorf("expected the %s of the elided object to be refused, got %v", verb, err)
+		}
+	}
+	if jsonErr != nil {
+		t.Fatalf("expected the JSON patch of the elided object to be allowed, got %v", jsonErr)
+	}
+
+	var live corev1.ConfigMap
+	if err := b.fakeBackend.Get(context.Background(), kclient.ObjectKeyFromObject(obj), &live); err != nil {
+		t.Fatal(err)
+	}
+	if live.Data["large"] != "value" || live.Labels["patched"] != "true" {
+		t.Fatalf("expected only the JSON patch to change the object, got %v and %v", live.Data, live.Labels)
+	}
+}
+
+func TestElidedObjectFinalizer(t *testing.T) {
+	obj := configMap("default", "a")
+	obj.Data = map[string]string{"large": "value"}
+	r, b := newElidingRouter(t, obj)
+
+	r.Type(configMap("", "")).FinalizeFunc("nah.io/a", func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	// The finalizer is added to the live object, which keeps the fields that the cache elided.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	var live corev1.ConfigMap
+	if err := b.fakeBackend.Get(context.Background(), kclient.ObjectKeyFromObject(obj), &live); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(live.Finalizers, "nah.io/a") || live.Data["large"] != "value" {
+		t.Fatalf("expected the finalizer to be added without losing the data, got %v and %v", live.Finalizers, live.Data)
+	}
+	if _, ok := live.Annotations[ElidedAnnotation]; ok {
+		t.Fatal("expected the elided annotation of the cache not to be written")
+	}
+}
+
+func TestElidedOwnerChildren(t *testing.T) {
+	obj := configMap("default", "a")
+	obj.Data = map[string]string{"large": "value"}
+	r, b := newElidingRouter(t, obj)
+	WithApplier(func(context.Context, kclient.Client, kclient.Object, []schema.GroupVersionKind, []kclient.Object, ...kclient.Object) error {
+		return nil
+	})(r)
+
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return Objects(resp, configMap("default", "child"))
+	})
+	startTestRouter(t, r)
+
+	// The types of the children are recorded on the live object of the elided owner.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	var live corev1.ConfigMap
+	if err := b.fakeBackend.Get(context.Background(), kclient.ObjectKeyFromObject(obj), &live); err != nil {
+		t.Fatal(err)
+	}
+	if live.Annotations[ChildrenAnnotation] != `["v1/ConfigMap"]` || live.Data["large"] != "value" {
+		t.Fatalf("expected the children to be recorded without losing the data, got %v and %v", live.Annotations, live.Data)
+	}
+}
@@ -0,0 +1,443 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultExternalWorkers is the number of keys of an external route that are reconciled concurrently, unless its routes
+// set their own with RouteBuilder.Workers or RouteBuilder.Concurrency.
+const DefaultExternalWorkers = 5
+
+// External returns a route for keys that are not Kubernetes objects, such as the IDs of rows in a database. The keys of
+// the route are enqueued with EnqueueExternal or ExternalSource and are reconciled with the same retries, back off,
+// RetryAfter and middleware as objects, one key at a time. The Request of an external key has a nil Object, the key as
+// both its Key and Name, and the name of the route as its External. A failed reconcile, or RetryAfter with a zero
+// delay, requeues the key with the rate limited back off of the route.
+//
+// DefaultExternalWorkers keys of the route are reconciled concurrently, unless its routes set Workers, in which case
+// the largest is used, or Concurrency, which adapts the workers to the cost of the reconciles of the route.
+//
+// Features that depend on the type of an object, such as selectors, finalizers and triggers, cannot be used with an
+// external route and registering a handler on such a route panics.
+func (r *Router) External(name string) RouteBuilder {
+	rb := r.RouteBuilder
+	rb.external = name
+	return rb
+}
+
+// EnqueueExternal enqueues the key for the external route with the name. An error is returned if no handler has been
+// registered for the route.
+func (r *Router) EnqueueExternal(name, key string) error {
+	return r.EnqueueExternalAfter(name, key, 0)
+}
+
+// EnqueueExternalAfter enqueues the key for the external route with the name after the delay.
+func (r *Router) EnqueueExternalAfter(name, key string, delay time.Duration) error {
+	route, err := r.handlers.externalRoute(name)
+	if err != nil {
+		return err
+	}
+	route.enqueue(key, delay)
+	return nil
+}
+
+// ExternalSource enqueues every key received from the channel for the external route with the name, once the router
+// has started and until the channel is closed or the router stops. An error is returned if no handler has been
+// registered for the route.
+func (r *Router) ExternalSource(name string, keys <-chan string) error {
+	route, err := r.handlers.externalRoute(name)
+	if err != nil {
+		return err
+	}
+	route.lock.Lock()
+	defer route.lock.Unlock()
+	if route.queue != nil {
+		go route.consume(route.ctx, keys)
+	} else {
+		route.sources = append(route.sources, keys)
+	}
+	return nil
+}
+
+type externalRoute struct {
+	name     string
+	handlers handlers
+
+	lock    sync.Mutex
+	ctx     context.Context
+	queue   workqueue.TypedRateLimitingInterface[string]
+	pending []pendingKey
+	sources []<-chan string
+	// workers is the number of workers of the route and running the number of workers that are started, which is
+	// above workers until the extra workers finish their current key. workers is 0 until it is set by a route.
+	workers int
+	running int
+	// concurrency is the adaptive concurrency of the route, if a route set one.
+	concurrency *adaptiveConcurrency
+}
+
+type pendingKey struct {
+	key   string
+	after time.Duration
+}
+
+func (e *externalRoute) enqueue(key string, delay time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	switch {
+	case e.queue == nil:
+		e.pending = append(e.pending, pendingKey{key: key, after: delay})
+	case delay > 0:
+		e.queue.AddAfter(key, delay)
+	default:
+		e.queue.Add(key)
+	}
+}
+
+func (e *externalRoute) consume(ctx context.Context, keys <-chan string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			e.enqueue(key, 0)
+		}
+	}
+}
+
+// validateExternal returns an error if the route uses a feature that needs the type of an object.
+func (r RouteBuilder) validateExternal() error {
+	var unsupported string
+	switch {
+	case r.objType != nil:
+		unsupported = "Type"
+	case r.sel != nil:
+		unsupported = "Selector"
+	case r.fieldSelector != nil:
+		unsupported = "FieldSelector"
+	case r.name != "" || r.namespace != "":
+		unsupported = "Name and Namespace"
+	case r.finalizeID != "":
+		unsupported = "Finalize"
+	case r.minAge > 0:
+		unsupported = "MinAge"
+	case r.diff:
+		unsupported = "Diff"
+	case r.retryBudget != nil:
+		unsupported = "RetryBudget"
+	case r.includeRemove || r.includeFinalizing:
+		unsupported = "IncludeRemoved and IncludeFinalizing"
+	case len(r.gauges) > 0:
+		unsupported = "Gauge"
+	case r.oldObject:
+		unsupported = "WithOldObject"
+	case len(r.watches) > 0:
+		unsupported = "Watches"
+	default:
+		return nil
+	}
+	return fmt.Errorf("external route %q cannot use %s because its keys are not Kubernetes objects", r.external, unsupported)
+}
+
+func (m *HandlerSet) addExternal(name, routeName string, handler Handler, workers int, concurrency *ConcurrencyPolicy) *Registration {
+	m.externalLock.Lock()
+	defer m.externalLock.Unlock()
+	if m.external == nil {
+		m.external = map[string]*externalRoute{}
+	}
+	route, ok := m.external[name]
+	if !ok {
+		route = &externalRoute{
+			name: name,
+			handlers: handlers{
+				handlers: map[schema.GroupVersionKind][]*registration{},
+			},
+		}
+		m.external[name] = route
+	}
+	reg, _ := route.handlers.addHandler(schema.GroupVersionKind{}, routeName, handler)
+	if concurrency != nil {
+		m.addExternalConcurrency(route, *concurrency)
+	} else if workers > 0 {
+		route.setWorkers(m, workers, true)
+	}
+	if !ok && m.externalStarted {
+		m.startExternalRoute(m.ctx, route)
+	}
+	return &Registration{
+		handlers: &route.handlers,
+		reg:      reg,
+	}
+}
+
+func (m *HandlerSet) externalRoute(name string) (*externalRoute, error) {
+	m.externalLock.Lock()
+	defer m.externalLock.Unlock()
+	route, ok := m.external[name]
+	if !ok {
+		return nil, fmt.Errorf("no handler is registered for external route %q", name)
+	}
+	return route, nil
+}
+
+// startExternal starts the workers of the external routes. It is called once the caches are synced, so that handlers
+// of external keys can read objects from them.
+func (m *HandlerSet) startExternal(ctx context.Context) {
+	m.externalLock.Lock()
+	defer m.externalLock.Unlock()
+	m.externalStarted = true
+	for _, route := range m.external {
+		m.startExternalRoute(ctx, route)
+	}
+}
+
+func (m *HandlerSet) startExternalRoute(ctx context.Context, route *externalRoute) {
+	route.handlers.start()
+
+	route.lock.Lock()
+	defer route.lock.Unlock()
+	if route.queue != nil {
+		return
+	}
+
+	route.ctx = ctx
+	if route.workers == 0 {
+		route.workers = DefaultExternalWorkers
+	}
+	route.queue = workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "external " + route.name, Clock: m.clock})
+	for _, start := range route.pending {
+		if start.after > 0 {
+			route.queue.AddAfter(start.key, start.after)
+		} else {
+			route.queue.Add(start.key)
+		}
+	}
+	route.pending = nil
+	for _, keys := range route.sources {
+		go route.consume(ctx, keys)
+	}
+	route.sources = nil
+
+	route.startWorkersLocked(m)
+	if route.concurrency != nil {
+		go m.adaptExternalConcurrency(ctx, route)
+	}
+	go func() {
+		<-ctx.Done()
+		route.queue.ShutDown()
+	}()
+}
+
+// setWorkers changes the number of workers of the route. If largest is true, the number is only raised, so that the
+// largest of the routes is used.
+func (e *externalRoute) setWorkers(m *HandlerSet, workers int, largest bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.concurrency != nil && largest {
+		log.Warnf("Ignoring the workers of external route [%s] in router [%s], it has an adaptive concurrency", e.name, m.name)
+		return
+	}
+	if largest && workers <= e.workers {
+		return
+	}
+	e.workers = workers
+	if e.queue != nil {
+		e.startWorkersLocked(m)
+	}
+}
+
+// startWorkersLocked starts workers until the number of workers of the route are running. Extra workers stop once they
+// finish their current key.
+func (e *externalRoute) startWorkersLocked(m *HandlerSet) {
+	for ; e.running < e.workers; e.running++ {
+		go func() {
+			for m.processExternal(e) && !e.stopExtraWorker() {
+			}
+		}()
+	}
+}
+
+// stopExtraWorker returns true, and counts the worker as stopped, if more workers are running than the route has.
+func (e *externalRoute) stopExtraWorker() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.running > e.workers {
+		e.running--
+		return true
+	}
+	return false
+}
+
+// addExternalConcurrency enables the adaptive concurrency of the external route, with the minimum workers until it is
+// first adjusted. If several routes set a concurrency, the bounds are widened to cover all of them.
+func (m *HandlerSet) addExternalConcurrency(route *externalRoute, policy ConcurrencyPolicy) {
+	route.lock.Lock()
+	a := route.concurrency
+	if a == nil {
+		a = &adaptiveConcurrency{
+			policy:  policy,
+			workers: policy.Min,
+		}
+		route.concurrency = a
+		if route.queue != nil {
+			go m.adaptExternalConcurrency(route.ctx, route)
+		}
+	} else {
+		a.lock.Lock()
+		a.policy.Min = min(a.policy.Min, policy.Min)
+		a.policy.Max = max(a.policy.Max, policy.Max)
+		a.workers = min(max(a.workers, a.policy.Min), a.policy.Max)
+		a.lock.Unlock()
+	}
+	route.lock.Unlock()
+
+	a.lock.Lock()
+	workers := a.workers
+	a.lock.Unlock()
+	route.setWorkers(m, workers, false)
+	adaptiveWorkers.WithLabelValues(nahname.MetricLabel(m.name), route.kind()).Set(float64(workers))
+}
+
+func (m *HandlerSet) adaptExternalConcurrency(ctx context.Context, route *externalRoute) {
+	ticker := m.clock.NewTicker(route.concurrency.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			m.adjustWorkers(route.kind(), route.concurrency, route.queue.Len(), func(workers int) error {
+				route.setWorkers(m, workers, false)
+				return nil
+			})
+		}
+	}
+}
+
+func (e *externalRoute) adaptiveConcurrency() *adaptiveConcurrency {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.concurrency
+}
+
+// kind is the name of the route in logs and metrics.
+func (e *externalRoute) kind() string {
+	return "external " + e.name
+}
+
+func (m *HandlerSet) processExternal(route *externalRoute) bool {
+	key, shutdown := route.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer route.queue.Done(key)
+
+	if !m.freezer.enter(route.ctx) {
+		return false
+	}
+	defer m.freezer.exit()
+
+	start := m.clock.Now()
+	resp, err := m.handleExternal(route, key)
+	if a := route.adaptiveConcurrency(); a != nil {
+		a.observe(m.clock.Since(start), err != nil)
+	}
+	switch {
+	case err != nil:
+		log.Errorf("error syncing external key [%s] of [%s]: %v, requeuing", key, route.name, err)
+		route.queue.AddRateLimited(key)
+	case resp.delay > 0:
+		// An explicit delay takes precedence over the back off.
+		route.queue.Forget(key)
+		route.queue.AddAfter(key, resp.delay)
+	case resp.retry:
+		route.queue.AddRateLimited(key)
+	default:
+		route.queue.Forget(key)
+	}
+	return true
+}
+
+func (m *HandlerSet) handleExternal(route *externalRoute, key string) (*response, error) {
+	guard := &abortGuard{
+		ctx:        route.ctx,
+		blockReads: m.blockReadsAfterAbort,
+		aborted:    &m.abortedWrites,
+	}
+	registry := externalRegistry{}
+	resp := &response{
+		registry: registry,
+	}
+	req := Request{
+		Client: &client{
+			backend: m.backend,
+			reader: reader{
+				scheme:   m.scheme,
+				client:   m.backend,
+				registry: registry,
+				guard:    guard,
+				indexes:  &m.indexes,
+			},
+			writer: writer{
+				client:   m.backend,
+				registry: registry,
+				guard:    guard,
+			},
+			status: status{
+				client:   m.backend,
+				registry: registry,
+				guard:    guard,
+			},
+		},
+		Ctx:        route.ctx,
+		Name:       key,
+		Key:        key,
+		External:   route.name,
+		election:   m.election,
+		attributes: &resp.ResponseAttributes,
+	}
+
+	req.logger = m.baseLogger()
+	req.debug("Handling external")
+	if err := route.handlers.Handle(req, resp, m.clock.Now, m.observe); err != nil {
+		resp.failed = true
+		err := m.handleError(req, resp, err)
+		m.recordHandlerFailures(req, resp, err != nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := m.applyObjects(req, resp); err != nil {
+		return nil, err
+	}
+	if err := m.runOnCommit(req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// externalRegistry doesn't register triggers, because an external key can't be enqueued by changes to objects.
+type externalRegistry struct{}
+
+func (externalRegistry) Watch(runtime.Object, string, string, labels.Selector, fields.Selector) error {
+	return nil
+}
+
+func (externalRegistry) WatchingGVKs() []schema.GroupVersionKind {
+	return nil
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls the condition until it is true or the timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestExternalWorkers(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	var (
+		active, peak atomic.Int32
+		release      = make(chan struct{})
+	)
+	r.External("rows").Workers(3).HandlerFunc(func(req Request, resp Response) error {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		<-release
+		return nil
+	})
+	startTestRouter(t, r)
+
+	for i := 0; i < 6; i++ {
+		if err := r.EnqueueExternal("rows", fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 10*time.Second, func() bool { return active.Load() == 3 })
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if p := peak.Load(); p != 3 {
+		t.Fatalf("expected 3 keys to be reconciled concurrently, got %d", p)
+	}
+}
+
+func TestExternalConcurrency(t *testing.T) {
+	r, _ := newTestRouter(t)
+	r.External("rows").Concurrency(ConcurrencyPolicy{Min: 2, Max: 4}).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	route, err := r.handlers.externalRoute("rows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.lock.Lock()
+	workers, running := route.workers, route.running
+	route.lock.Unlock()
+	if workers != 2 || running != 2 {
+		t.Fatalf("expected the route to start with the minimum of 2 workers, got %d workers and %d running", workers, running)
+	}
+}
+
+func TestExternalRetryAfterZero(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	var (
+		lock  sync.Mutex
+		calls int
+	)
+	r.External("rows").HandlerFunc(func(req Request, resp Response) error {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		if calls == 1 {
+			resp.RetryAfter(0)
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := r.EnqueueExternal("rows", "a"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10*time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return calls == 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if calls != 2 {
+		t.Fatalf("expected the key to be retried once, got %d reconciles", calls)
+	}
+}
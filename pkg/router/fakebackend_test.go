@@ -0,0 +1,212 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var configMapGVK = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+
+// fakeBackend is a backend over the controller-runtime fake client. It has no queues: the keys are reconciled by
+// calling dispatch, and the triggers and requeues are recorded.
+type fakeBackend struct {
+	kclient.WithWatch
+
+	lock     sync.Mutex
+	triggers []fakeTrigger
+	watchers map[schema.GroupVersionKind]backend.Callback
+	// namespaces are the namespaces that the caches are restricted to, nil if they are cluster wide.
+	namespaces []string
+	// preloads and starts count the calls to Preload and Start.
+	preloads, starts int
+}
+
+type fakeTrigger struct {
+	gvk   schema.GroupVersionKind
+	key   string
+	delay time.Duration
+}
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newFakeBackend(scheme *runtime.Scheme, objs ...kclient.Object) *fakeBackend {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+	mapper.Add(autoscalingv1.SchemeGroupVersion.WithKind("HorizontalPodAutoscaler"), meta.RESTScopeNamespace)
+	mapper.Add(autoscalingv2.SchemeGroupVersion.WithKind("HorizontalPodAutoscaler"), meta.RESTScopeNamespace)
+	return &fakeBackend{
+		WithWatch: fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(objs...).Build(),
+		watchers:  map[schema.GroupVersionKind]backend.Callback{},
+	}
+}
+
+// newTestRouter returns a router without leader election over a fake backend with the objects.
+func newTestRouter(t *testing.T, objs ...kclient.Object) (*Router, *fakeBackend) {
+	t.Helper()
+	scheme := testScheme(t)
+	b := newFakeBackend(scheme, objs...)
+	return New(NewHandlerSet(t.Name(), scheme, b), nil, 0), b
+}
+
+// startTestRouter starts the router and waits for it to run. The router is stopped when the test ends.
+func startTestRouter(t *testing.T, r *Router) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Start(ctx)
+	}()
+	select {
+	case <-r.Ready():
+	case err := <-errs:
+		cancel()
+		t.Fatalf("router failed to start: %v", err)
+	case <-time.After(10 * time.Second):
+		cancel()
+		t.Fatal("router did not start")
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-r.Stopped()
+	})
+	return cancel
+}
+
+func (f *fakeBackend) Trigger(gvk schema.GroupVersionKind, key string, delay time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.triggers = append(f.triggers, fakeTrigger{gvk: gvk, key: key, delay: delay})
+	return nil
+}
+
+// triggered returns the triggers and requeues recorded since the last call.
+func (f *fakeBackend) triggered() []fakeTrigger {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	result := f.triggers
+	f.triggers = nil
+	return result
+}
+
+func (f *fakeBackend) Watcher(_ context.Context, gvk schema.GroupVersionKind, _ string, cb backend.Callback) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.watchers[gvk] = cb
+	return nil
+}
+
+// dispatch reconciles the key as the queue of the type would.
+func (f *fakeBackend) dispatch(gvk schema.GroupVersionKind, key string) error {
+	f.lock.Lock()
+	cb, ok := f.watchers[gvk]
+	f.lock.Unlock()
+	if !ok {
+		return errors.New("type is not watched")
+	}
+	_, err := cb(gvk, key, nil)
+	return err
+}
+
+// watching returns true if the type is watched.
+func (f *fakeBackend) watching(gvk schema.GroupVersionKind) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.watchers[gvk]
+	return ok
+}
+
+func (f *fakeBackend) CachedNamespaces(schema.GroupVersionKind) []string {
+	return f.namespaces
+}
+
+// GetInformerForKind returns an informer that is never started, whose store has the objects of the type in the client
+// when it is called.
+func (f *fakeBackend) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.SharedIndexInformer, error) {
+	obj, err := f.Scheme().New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	listGVK := gvk
+	listGVK.Kind += "List"
+	list, err := f.Scheme().New(listGVK)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.List(ctx, list.(kclient.ObjectList)); err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, obj, 0, cache.Indexers{})
+	for _, item := range items {
+		if err := informer.GetStore().Add(item); err != nil {
+			return nil, err
+		}
+	}
+	return informer, nil
+}
+
+func (f *fakeBackend) IndexField(context.Context, kclient.Object, string, kclient.IndexerFunc) error {
+	return nil
+}
+
+func (f *fakeBackend) Preload(context.Context) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.preloads++
+	return nil
+}
+
+func (f *fakeBackend) Start(context.Context) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.starts++
+	return nil
+}
+
+// calls returns the number of calls to Preload and Start.
+func (f *fakeBackend) calls() (preloads, starts int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.preloads, f.starts
+}
+
+func (f *fakeBackend) GVKForObject(obj runtime.Object, scheme *runtime.Scheme) (schema.GroupVersionKind, error) {
+	return apiutil.GVKForObject(obj, scheme)
+}
+
+func configMap(namespace, name string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+}
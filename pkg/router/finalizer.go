@@ -1,13 +1,72 @@
 package router
 
 import (
-	"k8s.io/utils/strings/slices"
+	"slices"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// FinalizerHandler adds the finalizer to live objects and, once they are being deleted, calls Next and removes the
+// finalizer when Next succeeds. Objects that were already being deleted without the finalizer are left alone, because
+// finalizers can't be added to them. Only this finalizer is removed.
+//
+// The finalizers registered with Finalize by the same router are handled in the order they are in the finalizers of
+// the object: Next isn't called while one of them is before this finalizer. The finalizers of other controllers don't
+// affect when Next is called.
+//
+// Next is called again until it succeeds, so it must be idempotent. The finalizer is kept while Next returns an error,
+// which is retried as any reconcile error, or while it calls RetryAfter. Conflicts when adding or removing the
+// finalizer are retried with the latest version of the object.
 type FinalizerHandler struct {
 	FinalizerID string
 	Next        Handler
+
+	// own are the finalizers of the router, nil if the handler isn't registered with Finalize.
+	own *finalizers
+}
+
+// finalizers are the finalizer IDs registered with Finalize, by GVK.
+type finalizers struct {
+	lock sync.RWMutex
+	ids  map[schema.GroupVersionKind]map[string]struct{}
+}
+
+func (f *finalizers) add(gvk schema.GroupVersionKind, finalizerID string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.ids == nil {
+		f.ids = map[schema.GroupVersionKind]map[string]struct{}{}
+	}
+	if f.ids[gvk] == nil {
+		f.ids[gvk] = map[string]struct{}{}
+	}
+	f.ids[gvk][finalizerID] = struct{}{}
+}
+
+func (f *finalizers) has(gvk schema.GroupVersionKind, finalizerID string) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	_, ok := f.ids[gvk][finalizerID]
+	return ok
+}
+
+// blocked returns true if one of the finalizers of the router is before this one in the finalizers of the object.
+func (f FinalizerHandler) blocked(gvk schema.GroupVersionKind, objFinalizers []string) bool {
+	if f.own == nil {
+		return false
+	}
+	for _, finalizer := range objFinalizers {
+		if finalizer == f.FinalizerID {
+			return false
+		}
+		if f.own.has(gvk, finalizer) {
+			return true
+		}
+	}
+	return false
 }
 
 func (f FinalizerHandler) Handle(req Request, resp Response) error {
@@ -18,15 +77,17 @@ func (f FinalizerHandler) Handle(req Request, resp Response) error {
 
 	if obj.GetDeletionTimestamp().IsZero() {
 		if !slices.Contains(obj.GetFinalizers(), f.FinalizerID) {
-			obj.SetFinalizers(append(obj.GetFinalizers(), f.FinalizerID))
-			if err := req.Client.Update(req.Ctx, obj); err != nil {
-				return err
-			}
+			return f.updateFinalizers(req, obj, func(finalizers []string) ([]string, bool) {
+				if slices.Contains(finalizers, f.FinalizerID) {
+					return nil, false
+				}
+				return append(finalizers, f.FinalizerID), true
+			})
 		}
 		return nil
 	}
 
-	if len(obj.GetFinalizers()) == 0 || obj.GetFinalizers()[0] != f.FinalizerID {
+	if !slices.Contains(obj.GetFinalizers(), f.FinalizerID) || f.blocked(req.GVK, obj.GetFinalizers()) {
 		return nil
 	}
 
@@ -41,9 +102,17 @@ func (f FinalizerHandler) Handle(req Request, resp Response) error {
 	if newResp.Delay != 0 {
 		resp.RetryAfter(newResp.Delay)
 	}
+	if newResp.Retry {
+		resp.RetryAfter(0)
+	}
 	for k, v := range newResp.Attr {
 		resp.Attributes()[k] = v
 	}
+	for _, f := range newResp.Commits {
+		if err := OnCommit(resp, f); err != nil {
+			return err
+		}
+	}
 
 	if StatusChanged(obj, newObj) {
 		if err := req.Client.Status().Update(req.Ctx, newObj); err != nil {
@@ -51,12 +120,46 @@ func (f FinalizerHandler) Handle(req Request, resp Response) error {
 		}
 	}
 
-	if newResp.Delay == 0 && len(newObj.GetFinalizers()) > 0 && newObj.GetFinalizers()[0] == f.FinalizerID {
-		newObj.SetFinalizers(obj.GetFinalizers()[1:])
-		if err := req.Client.Update(req.Ctx, newObj); err != nil {
-			return err
-		}
+	if newResp.Delay == 0 && !newResp.Retry && slices.Contains(newObj.GetFinalizers(), f.FinalizerID) {
+		return f.updateFinalizers(req, newObj, func(finalizers []string) ([]string, bool) {
+			if !slices.Contains(finalizers, f.FinalizerID) {
+				return nil, false
+			}
+			return slices.DeleteFunc(finalizers, func(finalizer string) bool {
+				return finalizer == f.FinalizerID
+			}), true
+		})
 	}
 
 	return nil
 }
+
+// updateFinalizers updates the finalizers of obj to the result of update, which returns false if there is nothing to
+// change. On a conflict, the latest version of the object is read from the API server and updated instead, and obj is
+// given its finalizers and resource version so that the rest of the reconcile doesn't conflict.
+func (f FinalizerHandler) updateFinalizers(req Request, obj kclient.Object, update func(finalizers []string) ([]string, bool)) error {
+	latest := obj
+	first := true
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// The update of an elided object is refused, so its finalizers are updated on the live object.
+		if !first || isElided(obj) {
+			var err error
+			if latest, err = getLive(req, obj); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		finalizers, ok := update(slices.Clone(latest.GetFinalizers()))
+		if !ok {
+			return nil
+		}
+		latest.SetFinalizers(finalizers)
+		return req.Client.Update(req.Ctx, latest)
+	})
+	if err == nil && latest != obj {
+		obj.SetFinalizers(latest.GetFinalizers())
+		obj.SetResourceVersion(latest.GetResourceVersion())
+	}
+	return kclient.IgnoreNotFound(err)
+}
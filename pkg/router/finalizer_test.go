@@ -0,0 +1,66 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFinalizerOrder(t *testing.T) {
+	obj := configMap("default", "a")
+	obj.Finalizers = []string{"other.io/foreign", "nah.io/a", "nah.io/b"}
+	now := metav1.NewTime(time.Now())
+	obj.DeletionTimestamp = &now
+	r, b := newTestRouter(t, obj)
+
+	var called []string
+	for _, id := range []string{"nah.io/b", "nah.io/a"} {
+		r.Type(configMap("", "")).FinalizeFunc(id, func(req Request, resp Response) error {
+			called = append(called, id)
+			return nil
+		})
+	}
+	startTestRouter(t, r)
+
+	finalizers := func() string {
+		t.Helper()
+		var cm corev1.ConfigMap
+		if err := b.Get(context.Background(), kclient.ObjectKeyFromObject(obj), &cm); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(cm.Finalizers)
+	}
+
+	// The foreign finalizer doesn't block nah.io/a, which blocks nah.io/b.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(called) != "[nah.io/a]" {
+		t.Fatalf("expected only nah.io/a to be called, got %v", called)
+	}
+	if f := finalizers(); f != "[other.io/foreign nah.io/b]" {
+		t.Fatalf("expected the finalizers [other.io/foreign nah.io/b], got %s", f)
+	}
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(called) != "[nah.io/a nah.io/b]" {
+		t.Fatalf("expected nah.io/b to be called once nah.io/a is removed, got %v", called)
+	}
+	if f := finalizers(); f != "[other.io/foreign]" {
+		t.Fatalf("expected only the foreign finalizer to be left, got %s", f)
+	}
+}
+
+func TestFinalizerHandlerWithoutRouter(t *testing.T) {
+	f := FinalizerHandler{FinalizerID: "nah.io/b"}
+	if f.blocked(configMapGVK, []string{"nah.io/a", "nah.io/b"}) {
+		t.Fatal("expected a finalizer handler without a router not to be blocked")
+	}
+}
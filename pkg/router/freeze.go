@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/log"
+)
+
+// Freeze stops the router from dispatching keys to handlers for all types and waits for the handlers that are
+// running to return. Keys enqueued while frozen, including triggers and requeues, are held in the queues and
+// processed after Unfreeze. If ctx is done before the running handlers return then its error is returned and the
+// router stays frozen. While frozen, the router is reported as not ready on /readyz.
+func (r *Router) Freeze(ctx context.Context) error {
+	r.handlers.freezer.freeze()
+	setFrozen(r.handlers.name, true)
+	log.Infof("Router [%s] frozen, waiting for running handlers", r.handlers.name)
+	return r.handlers.freezer.wait(ctx)
+}
+
+// Unfreeze resumes dispatching keys after Freeze.
+func (r *Router) Unfreeze() {
+	r.handlers.freezer.unfreeze()
+	setFrozen(r.handlers.name, false)
+	log.Infof("Router [%s] unfrozen", r.handlers.name)
+}
+
+// Frozen returns true if the router is frozen.
+func (r *Router) Frozen() bool {
+	return r.handlers.freezer.isFrozen()
+}
+
+type freezer struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	frozen   bool
+	closed   bool
+	inflight int
+}
+
+func (f *freezer) init() {
+	if f.cond == nil {
+		f.cond = sync.NewCond(&f.lock)
+	}
+}
+
+// enter blocks while frozen and then counts the caller as running until exit is called. False is returned, without
+// counting the caller, if ctx is done while waiting or if the freezer is closed.
+func (f *freezer) enter(ctx context.Context) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.init()
+	if f.closed {
+		return false
+	}
+	if f.frozen {
+		stop := context.AfterFunc(ctx, func() {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			f.cond.Broadcast()
+		})
+		defer stop()
+		for f.frozen && !f.closed && ctx.Err() == nil {
+			f.cond.Wait()
+		}
+		if ctx.Err() != nil || f.closed {
+			return false
+		}
+	}
+	f.inflight++
+	return true
+}
+
+func (f *freezer) exit() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.inflight--
+	f.cond.Broadcast()
+}
+
+func (f *freezer) freeze() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.init()
+	f.frozen = true
+}
+
+func (f *freezer) unfreeze() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.init()
+	f.frozen = false
+	f.cond.Broadcast()
+}
+
+// close makes enter return false from now on, so that wait returns once the running callers have exited.
+func (f *freezer) close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.init()
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+func (f *freezer) isFrozen() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.frozen
+}
+
+func (f *freezer) wait(ctx context.Context) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.init()
+	stop := context.AfterFunc(ctx, func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.cond.Broadcast()
+	})
+	defer stop()
+	for f.inflight > 0 && ctx.Err() == nil {
+		f.cond.Wait()
+	}
+	if f.inflight > 0 {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFreeze(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"), configMap("default", "b"))
+
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		started <- req.Name
+		if req.Name == "a" {
+			<-release
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	dispatch := func(key string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- b.dispatch(configMapGVK, key)
+		}()
+		return done
+	}
+
+	aDone := dispatch("default/a")
+	if name := <-started; name != "a" {
+		t.Fatalf("expected a to be handled, got %s", name)
+	}
+
+	// Freeze waits for the running handler, and the router stays frozen if it gives up.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Freeze(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the freeze to wait for the running handler, got %v", err)
+	}
+	if !r.Frozen() {
+		t.Fatal("expected the router to stay frozen")
+	}
+
+	frozen := make(chan error, 1)
+	go func() {
+		frozen <- r.Freeze(context.Background())
+	}()
+	close(release)
+	if err := <-aDone; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-frozen; err != nil {
+		t.Fatalf("expected the freeze to return once the handler returned, got %v", err)
+	}
+
+	// Keys are held while frozen.
+	bDone := dispatch("default/b")
+	select {
+	case name := <-started:
+		t.Fatalf("expected no handler to run while frozen, got %s", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.Unfreeze()
+	if r.Frozen() {
+		t.Fatal("expected the router to be unfrozen")
+	}
+	if name := <-started; name != "b" {
+		t.Fatalf("expected b to be handled after the unfreeze, got %s", name)
+	}
+	if err := <-bDone; err != nil {
+		t.Fatal(err)
+	}
+}
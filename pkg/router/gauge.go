@@ -0,0 +1,322 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/log"
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultGaugeSeriesLimit is the number of label sets that a gauge registered with RouteBuilder.Gauge can have.
+const DefaultGaugeSeriesLimit = 1000
+
+var gaugeDroppedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nah_gauge_dropped_objects_total",
+	Help: "Number of times an object was left out of a route gauge because its labels would exceed the series limit.",
+}, []string{"gauge"})
+
+func init() {
+	metrics.Registry.MustRegister(gaugeDroppedObjects)
+}
+
+// WithGaugeSeriesLimit sets the number of label sets that each gauge registered with RouteBuilder.Gauge can have. An
+// object whose labels would add a label set beyond the limit is left out of the gauge. Defaults to
+// DefaultGaugeSeriesLimit.
+func WithGaugeSeriesLimit(limit int) Option {
+	return func(r *Router) {
+		r.handlers.gaugeSeriesLimit = limit
+	}
+}
+
+// Gauge adds a gauge with the name to the controller-runtime metrics registry that counts the objects of the route's
+// type by the labels that labelFn returns for each of them. Objects for which labelFn returns nil are not counted. The
+// gauge is updated from the informer of the type as objects are added, updated, and deleted, not from reconciles, and
+// only counts the objects that match the Namespace, Name and Selector of the route. The label names of the gauge are
+// the union of the label names returned for all objects, with empty values where an object doesn't have a label.
+//
+// Routes that register a gauge with the same name share it, and the objects counted for each of them are added up.
+// A route of a router that is registered with the same type and filters as another reuses its count, so that reusing
+// a RouteBuilder for several handlers doesn't count the objects more than once.
+func (r RouteBuilder) Gauge(name string, labelFn func(obj kclient.Object) map[string]string) RouteBuilder {
+	r.gauges = append(slices.Clip(r.gauges), routeGauge{name: name, labelFn: labelFn})
+	return r
+}
+
+type routeGauge struct {
+	name    string
+	labelFn func(obj kclient.Object) map[string]string
+}
+
+// gaugeCollectors are the collectors registered for the gauges, by name.
+var gaugeCollectors = struct {
+	lock   sync.Mutex
+	byName map[string]*gaugeCollector
+}{
+	byName: map[string]*gaugeCollector{},
+}
+
+func (r RouteBuilder) addGauges(gvk schema.GroupVersionKind) {
+	for _, g := range r.gauges {
+		og := &objectGauge{
+			name:      g.name,
+			gvk:       gvk,
+			labelFn:   g.labelFn,
+			namespace: r.namespace,
+			objName:   r.name,
+			sel:       r.sel,
+			limit:     r.router.handlers.gaugeSeriesLimit,
+			objects:   map[string]map[string]string{},
+			series:    map[string]int{},
+		}
+		if og.limit <= 0 {
+			og.limit = DefaultGaugeSeriesLimit
+		}
+		desc := fmt.Sprintf("Number of %s objects by the labels of route %s.", gvk.Kind, r.routeName)
+		if added, err := addGaugeSource(r.router.handlers.name, desc, og); err != nil {
+			panic(fmt.Sprintf("failed to register gauge %q: %v", g.name, err))
+		} else if added {
+			r.router.handlers.addGauge(og)
+		}
+	}
+}
+
+// addGaugeSource adds the gauge to the collector of its name, which is registered the first time. It returns false if
+// the router already counts the same objects for the gauge.
+func addGaugeSource(routerName, desc string, og *objectGauge) (bool, error) {
+	gaugeCollectors.lock.Lock()
+	defer gaugeCollectors.lock.Unlock()
+
+	c, ok := gaugeCollectors.byName[og.name]
+	if !ok {
+		c = &gaugeCollector{
+			name:    og.name,
+			desc:    desc,
+			sources: map[string]*objectGauge{},
+		}
+		if err := metrics.Registry.Register(c); err != nil {
+			return false, err
+		}
+		gaugeCollectors.byName[og.name] = c
+	}
+
+	sel := ""
+	if og.sel != nil {
+		sel = og.sel.String()
+	}
+	source := fmt.Sprintf("%s %v %s/%s %s", routerName, og.gvk, og.namespace, og.objName, sel)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.sources[source]; ok {
+		return false, nil
+	}
+	c.sources[source] = og
+	return true, nil
+}
+
+func (m *HandlerSet) addGauge(g *objectGauge) {
+	m.gaugeLock.Lock()
+	defer m.gaugeLock.Unlock()
+	m.gauges = append(m.gauges, g)
+	if m.gaugesStarted {
+		if err := m.startGauge(m.ctx, g); err != nil {
+			log.Errorf("failed to start gauge %q added after start: %v", g.name, err)
+		}
+	}
+}
+
+// startGauges adds the event handlers of the gauges to the informers. It is called before the backend is started so
+// that the initial list of each type is counted.
+func (m *HandlerSet) startGauges(ctx context.Context) error {
+	m.gaugeLock.Lock()
+	defer m.gaugeLock.Unlock()
+	m.gaugesStarted = true
+	for _, g := range m.gauges {
+		if err := m.startGauge(ctx, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *HandlerSet) startGauge(ctx context.Context, g *objectGauge) error {
+	informer, err := m.backend.GetInformerForKind(ctx, g.gvk)
+	if err != nil {
+		return err
+	}
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: g.set,
+		UpdateFunc: func(_, obj any) {
+			g.set(obj)
+		},
+		DeleteFunc: g.delete,
+	})
+	return err
+}
+
+// objectGauge counts the objects of a type that match the filters of a route for a gauge.
+type objectGauge struct {
+	name      string
+	gvk       schema.GroupVersionKind
+	labelFn   func(obj kclient.Object) map[string]string
+	namespace string
+	objName   string
+	sel       labels.Selector
+	limit     int
+
+	lock    sync.Mutex
+	objects map[string]map[string]string
+	series  map[string]int
+	warned  bool
+}
+
+func (g *objectGauge) set(obj any) {
+	kobj, ok := obj.(kclient.Object)
+	if !ok {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(kobj)
+	if err != nil {
+		return
+	}
+
+	var objLabels map[string]string
+	if (g.namespace == "" || kobj.GetNamespace() == g.namespace) && (g.objName == "" || kobj.GetName() == g.objName) &&
+		(g.sel == nil || g.sel.Matches(labels.Set(kobj.GetLabels()))) {
+		objLabels = g.labelFn(kobj)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.remove(key)
+	if objLabels == nil {
+		return
+	}
+
+	series := seriesKey(objLabels)
+	if g.series[series] == 0 && len(g.series) >= g.limit {
+		gaugeDroppedObjects.WithLabelValues(nahname.MetricLabel(g.name)).Inc()
+		if !g.warned {
+			g.warned = true
+			log.Warnf("Gauge [%s] reached its limit of %d label sets, objects with new label sets are not counted", g.name, g.limit)
+		}
+		return
+	}
+	g.objects[key] = objLabels
+	g.series[series]++
+}
+
+func (g *objectGauge) delete(obj any) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.remove(key)
+}
+
+func (g *objectGauge) remove(key string) {
+	existing, ok := g.objects[key]
+	if !ok {
+		return
+	}
+	delete(g.objects, key)
+	series := seriesKey(existing)
+	if g.series[series]--; g.series[series] <= 0 {
+		delete(g.series, series)
+	}
+}
+
+func seriesKey(l map[string]string) string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := &strings.Builder{}
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(l[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// gaugeCollector is a collector rather than a GaugeVec because the label names come from the objects. It collects the
+// objects counted by every source of the gauge.
+type gaugeCollector struct {
+	name string
+	desc string
+
+	lock    sync.Mutex
+	sources map[string]*objectGauge
+}
+
+// Describe sends nothing, which makes the gauge an unchecked collector, because its label names are not known until
+// the objects are seen.
+func (c *gaugeCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *gaugeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	sources := make([]*objectGauge, 0, len(c.sources))
+	for _, g := range c.sources {
+		sources = append(sources, g)
+	}
+	c.lock.Unlock()
+
+	var objects []map[string]string
+	for _, g := range sources {
+		g.lock.Lock()
+		for _, l := range g.objects {
+			objects = append(objects, l)
+		}
+		g.lock.Unlock()
+	}
+
+	names := map[string]struct{}{}
+	for _, l := range objects {
+		for k := range l {
+			names[k] = struct{}{}
+		}
+	}
+	labelNames := make([]string, 0, len(names))
+	for k := range names {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+
+	counts := map[string]float64{}
+	values := map[string][]string{}
+	for _, l := range objects {
+		v := make([]string, len(labelNames))
+		for i, k := range labelNames {
+			v[i] = l[k]
+		}
+		series := strings.Join(v, "\x00")
+		counts[series]++
+		values[series] = v
+	}
+
+	desc := prometheus.NewDesc(c.name, c.desc, labelNames, nil)
+	for series, count := range counts {
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, count, values[series]...)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			continue
+		}
+		ch <- metric
+	}
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func byLabel(obj kclient.Object) map[string]string {
+	return map[string]string{"app": obj.GetLabels()["app"]}
+}
+
+// total returns the sum of the values collected from the collector.
+func total(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	var result float64
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		result += m.GetGauge().GetValue()
+	}
+	return result
+}
+
+func gaugeCollectorNamed(t *testing.T, name string) *gaugeCollector {
+	t.Helper()
+	gaugeCollectors.lock.Lock()
+	defer gaugeCollectors.lock.Unlock()
+	c, ok := gaugeCollectors.byName[name]
+	if !ok {
+		t.Fatalf("gauge %s is not registered", name)
+	}
+	return c
+}
+
+func TestGaugeReusedRouteBuilder(t *testing.T) {
+	r, _ := newTestRouter(t)
+	rb := r.Type(configMap("", "")).Name("a").Gauge("nah_test_reused_gauge", byLabel)
+	rb.HandlerFunc(func(req Request, resp Response) error { return nil })
+	rb.HandlerFunc(func(req Request, resp Response) error { return nil })
+
+	if len(r.handlers.gauges) != 1 {
+		t.Fatalf("expected the gauge to be counted once, got %d sources", len(r.handlers.gauges))
+	}
+	g := r.handlers.gauges[0]
+	a, b := configMap("default", "a"), configMap("default", "b")
+	a.Labels = map[string]string{"app": "x"}
+	b.Labels = map[string]string{"app": "x"}
+	g.set(a)
+	g.set(b)
+
+	// Only the object with the name of the route is counted.
+	c := gaugeCollectorNamed(t, "nah_test_reused_gauge")
+	if value := total(t, c); value != 1 {
+		t.Fatalf("expected a count of 1, got %v", value)
+	}
+}
+
+func TestGaugeSharedByRouters(t *testing.T) {
+	var sources []*objectGauge
+	for _, name := range []string{"one", "two"} {
+		r, _ := newTestRouter(t)
+		r.handlers.name = name
+		r.Type(configMap("", "")).Gauge("nah_test_shared_gauge", byLabel).
+			HandlerFunc(func(req Request, resp Response) error { return nil })
+		sources = append(sources, r.handlers.gauges...)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("expected a source per router, got %d", len(sources))
+	}
+	for _, g := range sources {
+		obj := configMap("default", "a")
+		obj.Labels = map[string]string{"app": "x"}
+		g.set(obj)
+	}
+
+	c := gaugeCollectorNamed(t, "nah_test_shared_gauge")
+	if value := total(t, c); value != 2 {
+		t.Fatalf("expected the counts of both routers to be added up, got %v", value)
+	}
+}
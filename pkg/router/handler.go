@@ -3,14 +3,18 @@ package router
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/moby/locker"
 	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/leader"
 	"github.com/obot-platform/nah/pkg/log"
 	"github.com/obot-platform/nah/pkg/merr"
+	nahname "github.com/obot-platform/nah/pkg/name"
 	"golang.org/x/exp/maps"
 	"golang.org/x/time/rate"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
@@ -18,6 +22,7 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/clock"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -35,14 +40,68 @@ type HandlerSet struct {
 	triggers triggers
 	save     save
 	onError  ErrorHandler
+	clock    clock.WithTicker
+	tenants  *tenantClients
+	requeues *requeueTracker
+
+	blockReadsAfterAbort bool
+	summaryLogging       bool
+	freezer              freezer
+	// handlerCtx is the context of requests. It is canceled when the context given to Start is, or by abort.
+	handlerCtx             context.Context
+	aborted                context.Context
+	abort                  context.CancelCauseFunc
+	tracer                 tracer
+	election               *leader.ElectionConfig
+	quarantine             *quarantine
+	cancelRequeuesOnDelete bool
+
+	externalLock    sync.Mutex
+	external        map[string]*externalRoute
+	externalStarted bool
+	history         *history
+	abortedWrites   atomic.Int64
 
 	watchingLock sync.Mutex
 	watching     map[schema.GroupVersionKind]bool
 	locker       locker.Locker
 
+	backoff     *backoff
 	limiterLock sync.Mutex
 	limiters    map[limiterKey]*rate.Limiter
+	limiterLRU  *keyLRU
 	waiting     map[limiterKey]struct{}
+	limits      *stateLimits
+
+	passes reconcilePasses
+
+	gaugeLock        sync.Mutex
+	gauges           []*objectGauge
+	gaugesStarted    bool
+	gaugeSeriesLimit int
+
+	watchesLock    sync.Mutex
+	watches        map[schema.GroupVersionKind]*secondaryWatch
+	watchesStarted bool
+
+	concurrencyLock sync.Mutex
+	concurrency     map[schema.GroupVersionKind]*adaptiveConcurrency
+	workers         map[schema.GroupVersionKind]int
+	concurrencyCtx  context.Context
+
+	metrics    MetricsRecorder
+	applier    Applier
+	invariants *invariants
+	paused     pausedKeys
+	finalizers finalizers
+	logger     *slog.Logger
+	indexes    indexes
+
+	// synced is true once the caches of the handled types have synced, by Start or Preload.
+	synced         atomic.Bool
+	stallThreshold time.Duration
+	// lastSuccess is the unix nano time of the last successful reconcile, or of the start of the handlers.
+	lastSuccess atomic.Int64
 }
 
 type limiterKey struct {
@@ -56,7 +115,7 @@ func NewHandlerSet(name string, scheme *runtime.Scheme, backend backend.Backend)
 		scheme:  scheme,
 		backend: backend,
 		handlers: handlers{
-			handlers: map[schema.GroupVersionKind][]Handler{},
+			handlers: map[schema.GroupVersionKind][]*registration{},
 		},
 		triggers: triggers{
 			matchers:  map[schema.GroupVersionKind]map[enqueueTarget]map[string]objectMatcher{},
@@ -69,8 +128,16 @@ func NewHandlerSet(name string, scheme *runtime.Scheme, backend backend.Backend)
 			client: backend,
 		},
 		watching: map[schema.GroupVersionKind]bool{},
+		clock:    clock.RealClock{},
+		history:  newHistory(DefaultHistorySize),
+		requeues: newRequeueTracker(),
+
+		stallThreshold: DefaultStallThreshold,
 	}
+	hs.aborted, hs.abort = context.WithCancelCause(context.Background())
 	hs.triggers.watcher = hs
+	hs.triggers.traced = hs.traced
+	hs.triggers.indexes = &hs.indexes
 	return hs
 }
 
@@ -78,20 +145,63 @@ func (m *HandlerSet) Start(ctx context.Context) error {
 	if m.ctx == nil {
 		m.ctx = ctx
 	}
+	// Handlers get their own context, derived from this start rather than from an earlier Preload, so that they can
+	// be aborted without stopping the caches.
+	handlerCtx, cancel := context.WithCancelCause(ctx)
+	context.AfterFunc(m.aborted, func() {
+		cancel(context.Cause(m.aborted))
+	})
+	m.handlerCtx = handlerCtx
+	m.handlers.start()
 	if err := m.WatchGVK(m.handlers.GVKs()...); err != nil {
 		return err
 	}
-	return m.backend.Start(ctx)
+	if m.tenants != nil {
+		if err := m.watchNamespaces(ctx); err != nil {
+			return err
+		}
+	}
+	if err := m.startGauges(ctx); err != nil {
+		return err
+	}
+	if err := m.startWatches(ctx); err != nil {
+		return err
+	}
+	if err := m.backend.Start(ctx); err != nil {
+		return err
+	}
+	m.synced.Store(true)
+	m.lastSuccess.Store(m.clock.Now().UnixNano())
+	m.startConcurrency(ctx)
+	if m.metrics != nil {
+		go m.reportQueueDepths(ctx)
+	}
+	if m.invariants != nil {
+		go m.checkInvariantsPeriodically(ctx)
+	}
+	if m.requeues.store != nil {
+		if err := m.restoreRequeues(ctx); err != nil {
+			return err
+		}
+		go m.persistRequeues(ctx)
+	}
+	m.startExternal(m.handlerCtx)
+	return nil
 }
 
-func (m *HandlerSet) Preload(ctx context.Context) error {
+// Preload syncs the caches of the handled types and the extra types without starting the workers.
+func (m *HandlerSet) Preload(ctx context.Context, extra ...schema.GroupVersionKind) error {
 	if m.ctx == nil {
 		m.ctx = ctx
 	}
-	if err := m.WatchGVK(m.handlers.GVKs()...); err != nil {
+	if err := m.WatchGVK(append(m.handlers.GVKs(), extra...)...); err != nil {
+		return err
+	}
+	if err := m.backend.Preload(ctx); err != nil {
 		return err
 	}
-	return m.backend.Preload(ctx)
+	m.synced.Store(true)
+	return nil
 }
 
 func toObject(obj runtime.Object) kclient.Object {
@@ -146,6 +256,40 @@ func (m *HandlerSet) newRequestResponse(gvk schema.GroupVersionKind, key string,
 		registry: triggerRegistry,
 	}
 
+	var writeClient kclient.Client = m.backend
+	if m.tenants != nil {
+		c, err := m.tenants.clientFor(m.backend, ns)
+		if err != nil {
+			return Request{}, nil, err
+		}
+		writeClient = c
+	}
+
+	guard := &abortGuard{
+		ctx:        m.handlerCtx,
+		blockReads: m.blockReadsAfterAbort,
+		aborted:    &m.abortedWrites,
+	}
+
+	traced := m.traced(gvk, key)
+	if traced {
+		guard.trace = &writeTracer{gvk: gvk, key: key}
+	}
+
+	var summary *reconcileSummary
+	if m.summaryLogging {
+		summary = &reconcileSummary{
+			gvk:    gvk,
+			key:    key,
+			source: "change",
+			start:  m.clock.Now(),
+		}
+		if trigger {
+			summary.source = "trigger"
+		}
+		guard.writes = &summary.writes
+	}
+
 	req := Request{
 		FromTrigger: trigger,
 		Client: &client{
@@ -154,33 +298,56 @@ func (m *HandlerSet) newRequestResponse(gvk schema.GroupVersionKind, key string,
 				scheme:   m.scheme,
 				client:   m.backend,
 				registry: triggerRegistry,
+				guard:    guard,
+				indexes:  &m.indexes,
 			},
 			writer: writer{
-				client:   m.backend,
+				client:   writeClient,
 				registry: triggerRegistry,
+				guard:    guard,
 			},
 			status: status{
-				client:   m.backend,
+				client:   writeClient,
 				registry: triggerRegistry,
+				guard:    guard,
 			},
 		},
-		Ctx:       m.ctx,
-		GVK:       gvk,
-		Object:    obj,
-		Namespace: ns,
-		Name:      name,
-		Key:       key,
+		Ctx:        m.handlerCtx,
+		GVK:        gvk,
+		Object:     obj,
+		Namespace:  ns,
+		Name:       name,
+		Key:        key,
+		summary:    summary,
+		traced:     traced,
+		election:   m.election,
+		attributes: &resp.ResponseAttributes,
 	}
 
 	return req, &resp, nil
 }
 
 func (m *HandlerSet) AddHandler(objType kclient.Object, handler Handler) {
+	m.addHandler(objType, "", handler)
+}
+
+func (m *HandlerSet) addHandler(objType kclient.Object, name string, handler Handler) *Registration {
 	gvk, err := m.backend.GVKForObject(objType, m.scheme)
 	if err != nil {
 		panic(fmt.Sprintf("scheme does not know gvk for %T", objType))
 	}
-	m.handlers.AddHandler(gvk, handler)
+	reg, started := m.handlers.addHandler(gvk, name, handler)
+	if started {
+		// Start has already watched the GVKs it knew about, so watch this one now.
+		if err := m.WatchGVK(gvk); err != nil {
+			log.Errorf("failed to watch %v for handler %q added after start: %v", gvk, name, err)
+		}
+	}
+	return &Registration{
+		handlers: &m.handlers,
+		gvk:      gvk,
+		reg:      reg,
+	}
 }
 
 func (m *HandlerSet) WatchGVK(gvks ...schema.GroupVersionKind) error {
@@ -192,6 +359,7 @@ func (m *HandlerSet) WatchGVK(gvks ...schema.GroupVersionKind) error {
 		}
 		if err := m.backend.Watcher(m.ctx, gvk, m.name, m.onChange); err == nil {
 			m.watching[gvk] = true
+			m.observeEnqueues(gvk)
 		} else {
 			watchErrs = append(watchErrs, err)
 		}
@@ -220,8 +388,16 @@ func (m *HandlerSet) checkDelay(gvk schema.GroupVersionKind, key string) bool {
 		}
 		m.limiters[lKey] = limit
 	}
+	m.limiterLRU.touch(lKey)
+	if m.limits != nil && m.limits.MaxKeys > 0 {
+		for _, evicted := range m.limiterLRU.evict(m.limits.MaxKeys) {
+			delete(m.limiters, evicted)
+			stateEvictions.WithLabelValues(nahname.MetricLabel(m.name), "limiters").Inc()
+		}
+	}
 
-	delay := limit.Reserve().Delay()
+	now := m.clock.Now()
+	delay := limit.ReserveN(now, 1).DelayFrom(now)
 	if delay > 0 {
 		if m.waiting == nil {
 			m.waiting = map[limiterKey]struct{}{}
@@ -229,11 +405,13 @@ func (m *HandlerSet) checkDelay(gvk schema.GroupVersionKind, key string) bool {
 		m.waiting[lKey] = struct{}{}
 		go func() {
 			log.Debugf("Backing off [%s] [%s] for %s", key, gvk, delay)
-			time.Sleep(delay)
+			<-m.clock.After(delay)
 			m.limiterLock.Lock()
 			defer m.limiterLock.Unlock()
 			delete(m.waiting, lKey)
-			_ = m.backend.Trigger(gvk, ReplayPrefix+key, 0)
+			if err := m.backend.Trigger(gvk, ReplayPrefix+key, 0); err == nil {
+				m.traceEnqueue(gvk, key, 0, "the end of the back off after %s", delay)
+			}
 		}()
 		return false
 	}
@@ -245,9 +423,16 @@ func (m *HandlerSet) forgetBackoff(gvk schema.GroupVersionKind, key string) {
 	m.limiterLock.Lock()
 	defer m.limiterLock.Unlock()
 	delete(m.limiters, limiterKey{key: key, gvk: gvk})
+	m.limiterLRU.remove(limiterKey{key: key, gvk: gvk})
+	m.backoff.reset(limiterKey{key: key, gvk: gvk})
 }
 
 func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeObject runtime.Object) (runtime.Object, error) {
+	var info backend.EnqueueInfo
+	if getter, ok := m.backend.(backend.EnqueueInfoGetter); ok {
+		info, _ = getter.EnqueueInfo(gvk, key)
+	}
+
 	fromTrigger := false
 	fromReplay := false
 	if strings.HasPrefix(key, TriggerPrefix) {
@@ -260,9 +445,15 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 		key = strings.TrimPrefix(key, ReplayPrefix)
 	}
 
+	if !m.freezer.enter(m.ctx) {
+		return nil, m.ctx.Err()
+	}
+	defer m.freezer.exit()
+
 	if !fromReplay && !fromTrigger {
 		// Process delay have key has been reassigned from the TriggerPrefix
 		if !m.checkDelay(gvk, key) {
+			m.traceDequeue(gvk, key, fromTrigger, fromReplay, info, "skipped while the key backs off")
 			return runtimeObject, nil
 		}
 	}
@@ -278,9 +469,15 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 		ns = ""
 	}
 
-	lockKey := gvk.Kind + " " + key
-	m.locker.Lock(lockKey)
-	defer func() { _ = m.locker.Unlock(lockKey) }()
+	unlock := m.lockKey(gvk, key)
+	defer unlock()
+
+	if info.Requeued && m.requeues.takeCanceled(gvk, key) {
+		// The requeue was canceled with CancelRequeue. Only the requeue takes the cancellation, so that the changes
+		// reconciled before it is due don't revive it.
+		m.traceDequeue(gvk, key, fromTrigger, fromReplay, info, "skipped because the requeue was canceled")
+		return runtimeObject, nil
+	}
 
 	err = m.backend.Get(m.ctx, kclient.ObjectKey{Name: name, Namespace: ns}, obj.(kclient.Object))
 	if err == nil {
@@ -291,9 +488,47 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 
 	if runtimeObject == nil {
 		m.forgetBackoff(gvk, key)
+	} else if annotated, ok := runtimeObject.(kclient.Object); ok && annotated.GetAnnotations()[TraceAnnotation] == "true" {
+		// The annotation is checked before the dequeue is traced so that the first reconcile of an annotated object
+		// is traced in full.
+		m.tracer.trace(gvk, key, m.clock.Now().Add(DefaultTraceDuration))
+	}
+	m.traceDequeue(gvk, key, fromTrigger, fromReplay, info, "")
+
+	if m.metrics != nil {
+		m.metrics.InFlight(gvk, 1)
+		defer m.metrics.InFlight(gvk, -1)
+	}
+	start := m.clock.Now()
+	result, err := m.handle(gvk, key, runtimeObject, fromTrigger, info)
+	m.passes.reconciled(gvk, key, start, runtimeObject == nil, err)
+	m.observeConcurrency(gvk, m.clock.Since(start), err != nil)
+	return result, err
+}
+
+// traced returns true if the key is traced with TraceKey or the TraceAnnotation.
+func (m *HandlerSet) traced(gvk schema.GroupVersionKind, key string) bool {
+	return m.tracer.active(gvk, key, m.clock.Now())
+}
+
+func enqueueSource(fromTrigger, fromReplay bool, info backend.EnqueueInfo) string {
+	switch {
+	case fromTrigger:
+		return "trigger"
+	case fromReplay:
+		return "backoff replay"
+	case info.Requeued:
+		return "requeue"
+	default:
+		return "change"
 	}
+}
 
-	return m.handle(gvk, key, runtimeObject, fromTrigger)
+func formatTraceTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339Nano)
 }
 
 func (m *HandlerSet) handleError(req Request, resp Response, err error) error {
@@ -303,22 +538,62 @@ func (m *HandlerSet) handleError(req Request, resp Response, err error) error {
 	return err
 }
 
-func (m *HandlerSet) handle(gvk schema.GroupVersionKind, key string, unmodifiedObject runtime.Object, trigger bool) (runtime.Object, error) {
+func (m *HandlerSet) handle(gvk schema.GroupVersionKind, key string, unmodifiedObject runtime.Object, trigger bool, info backend.EnqueueInfo) (_ runtime.Object, retErr error) {
 	req, resp, err := m.newRequestResponse(gvk, key, unmodifiedObject, trigger)
 	if err != nil {
 		return nil, err
 	}
+	req.EnqueuedAt = info.EnqueuedAt
+	req.EventObservedAt = info.EventObservedAt
+	req.Requeued = info.Requeued
+	req.oldObject = info.OldObject
+	req.Retries = m.backoff.retriesOf(limiterKey{key: key, gvk: gvk})
+	req.logger = m.baseLogger()
+
+	m.requeues.done(gvk, key, m.clock.Now(), unmodifiedObject == nil)
+	if unmodifiedObject == nil && m.cancelRequeuesOnDelete {
+		m.cancelRequeueLocked(gvk, key)
+	}
 
-	handles := m.handlers.Handles(req)
+	handles := m.handlers.Handles(req) && !m.skipPaused(req)
 	if handles {
-		if req.FromTrigger {
-			log.Debugf("Handling trigger [%s/%s] [%v]", req.Namespace, req.Name, req.GVK)
+		// Deferred first so that it sees the error that is finally returned, for the stall check of Healthz.
+		defer func() {
+			if retErr == nil {
+				m.lastSuccess.Store(m.clock.Now().UnixNano())
+			}
+		}()
+		defer func() {
+			retErr = m.applyQuarantine(req, resp, retErr)
+		}()
+		// The back off runs first, so that the quarantine leaves the keys that it requeued alone.
+		defer func() {
+			retErr = m.applyBackoff(req, resp, retErr)
+		}()
+		if !req.EventObservedAt.IsZero() {
+			reconcileLatency.WithLabelValues(gvk.String()).Observe(m.clock.Since(req.EventObservedAt).Seconds())
+		}
+		if req.traced {
+			traceLogf(gvk, key, "reconcile started, deleted=%v", unmodifiedObject == nil)
+			start := m.clock.Now()
+			defer func() {
+				traceLogf(gvk, key, "reconcile finished in %s, retryAfter=%s, err=%v", m.clock.Since(start), resp.delay, retErr)
+			}()
+		}
+		if req.summary != nil {
+			defer func() {
+				req.summary.log(m.clock.Now(), resp.delay, retErr)
+			}()
 		} else {
-			log.Debugf("Handling [%s/%s] [%v]", req.Namespace, req.Name, req.GVK)
+			req.debug("Handling", "trigger", req.FromTrigger)
 		}
 
-		if err := m.handlers.Handle(req, resp); err != nil {
-			if err := m.handleError(req, resp, err); err != nil {
+		if err := m.handlers.Handle(req, resp, m.clock.Now, m.observe); err != nil {
+			// Even if the error handler swallows the error, the reconcile did not succeed.
+			resp.failed = true
+			err := m.handleError(req, resp, err)
+			m.recordHandlerFailures(req, resp, err != nil)
+			if err != nil {
 				return nil, err
 			}
 		}
@@ -334,16 +609,31 @@ func (m *HandlerSet) handle(gvk schema.GroupVersionKind, key string, unmodifiedO
 	if handles {
 		newObj, err := m.save.save(unmodifiedObject, req)
 		if err != nil {
+			resp.failed = true
 			if err := m.handleError(req, resp, err); err != nil {
 				return nil, err
 			}
 		}
 		req.Object = newObj
 
+		if err := m.applyObjects(req, resp); err != nil {
+			resp.failed = true
+			if err := m.handleError(req, resp, err); err != nil {
+				return nil, err
+			}
+		}
+
+		resp.delay = m.quarantineDelay(req.Namespace, resp.delay)
 		if resp.delay > 0 {
 			if err := m.backend.Trigger(gvk, key, resp.delay); err != nil {
 				return nil, err
 			}
+			m.traceEnqueue(gvk, key, resp.delay, "RetryAfter")
+			m.requeues.track(gvk, key, m.clock.Now().Add(resp.delay))
+		}
+
+		if err := m.runOnCommit(req, resp); err != nil {
+			return nil, err
 		}
 	}
 
@@ -366,14 +656,52 @@ type response struct {
 
 	delay    time.Duration
 	registry TriggerRegistry
+	onCommit []func(ctx context.Context) error
+	failed   bool
+	// retry is true if RetryAfter was called with a zero delay, to retry with the back off of WithBackoff.
+	retry bool
+	// objects are the objects declared with Objects, objectsDeclared is true if Objects was called, even without objects.
+	objects         []kclient.Object
+	objectsDeclared bool
+	// adopt are the objects of objects that were declared by the routes with AdoptExisting.
+	adopt []kclient.Object
+	// failedHandlers are the names of the handlers that returned an error, only tracked with a MetricsRecorder.
+	failedHandlers []string
 }
 
 func (r *response) RetryAfter(delay time.Duration) {
+	if delay <= 0 {
+		r.retry = true
+		return
+	}
 	if r.delay == 0 || delay < r.delay {
 		r.delay = delay
 	}
 }
 
+func (r *response) OnCommit(f func(ctx context.Context) error) {
+	r.onCommit = append(r.onCommit, f)
+}
+
+// runOnCommit runs the OnCommit callbacks of a reconcile that succeeded. The callbacks are dropped if any handler or
+// the save of the object failed, or if the router is stopping, because the reconcile will be retried or abandoned.
+func (m *HandlerSet) runOnCommit(req Request, resp *response) error {
+	if len(resp.onCommit) == 0 || resp.failed || req.Ctx.Err() != nil {
+		return nil
+	}
+	var errs []error
+	for _, f := range resp.onCommit {
+		if err := f(req.Ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := merr.NewErrors(errs...); err != nil {
+		// Returning the error requeues the key, which runs the handlers and so schedules the callbacks again.
+		return fmt.Errorf("on commit callback of [%s] [%v] failed: %w", req.Key, req.GVK, err)
+	}
+	return nil
+}
+
 func (r *response) WatchingGVKs() []schema.GroupVersionKind {
 	return r.registry.WatchingGVKs()
 }
@@ -0,0 +1,178 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultQueueDepthInterval is how often the queue depth of each type is reported to the MetricsRecorder.
+const DefaultQueueDepthInterval = 15 * time.Second
+
+// MetricsRecorder records the metrics of the handlers of a router. Handlers are identified by their route name, which
+// is the file and line that registered the handler unless it is set with RouteBuilder.RouteName.
+type MetricsRecorder interface {
+	// HandlerDone is called after each invocation of a handler with its duration and the error it returned.
+	HandlerDone(gvk schema.GroupVersionKind, handler string, duration time.Duration, err error)
+	// HandlerFailed is called after the ErrorHandler ran, for each handler that failed the reconcile. Requeued is true
+	// if the error was returned and the key will be retried, and false if the ErrorHandler dropped it, such as once
+	// the retries are exhausted.
+	HandlerFailed(gvk schema.GroupVersionKind, handler string, requeued bool)
+	// InFlight is called with 1 when the reconcile of a key of the type starts and with -1 when it ends.
+	InFlight(gvk schema.GroupVersionKind, delta int)
+	// QueueDepth is called periodically with the number of keys of the type that are due and waiting.
+	QueueDepth(gvk schema.GroupVersionKind, depth int)
+}
+
+// WithMetricsRecorder records the metrics of the handlers of the router with the recorder. Without it, no metrics of
+// the handlers are recorded.
+func WithMetricsRecorder(recorder MetricsRecorder) Option {
+	return func(r *Router) {
+		r.handlers.metrics = recorder
+	}
+}
+
+// WithMetrics records the metrics of the handlers of the router with Prometheus collectors registered with the
+// registerer, see NewPrometheusRecorder. If the collectors can't be registered, Start returns the error.
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(r *Router) {
+		recorder, err := NewPrometheusRecorder(r.handlers.name, registerer)
+		if err != nil {
+			r.metricsErr = fmt.Errorf("failed to register the handler metrics of router [%s]: %w", r.handlers.name, err)
+			return
+		}
+		r.handlers.metrics = recorder
+	}
+}
+
+// PrometheusRecorder is a MetricsRecorder of Prometheus metrics, with a series per GVK and handler:
+// nah_handler_invocations_total, nah_handler_errors_total by whether the error was requeued or dropped, and the
+// nah_handler_duration_seconds histogram. The nah_queue_depth and nah_in_flight gauges have a series per GVK. Every
+// series has the name of the router as the router label.
+type PrometheusRecorder struct {
+	invocations *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	queueDepth  *prometheus.GaugeVec
+	inFlight    *prometheus.GaugeVec
+}
+
+// NewPrometheusRecorder registers the collectors of the handler metrics of the router with the registerer. Routers with
+// different names can share a registerer, and the collectors of a router that are already registered are reused.
+func NewPrometheusRecorder(routerName string, registerer prometheus.Registerer) (*PrometheusRecorder, error) {
+	labels := prometheus.Labels{"router": nahname.MetricLabel(routerName)}
+	p := &PrometheusRecorder{
+		invocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "nah_handler_invocations_total",
+			Help:        "Number of invocations of a handler.",
+			ConstLabels: labels,
+		}, []string{"gvk", "handler"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "nah_handler_errors_total",
+			Help:        "Number of reconciles failed by a handler, by whether the key was requeued or the error dropped.",
+			ConstLabels: labels,
+		}, []string{"gvk", "handler", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "nah_handler_duration_seconds",
+			Help:        "Duration of the invocations of a handler.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"gvk", "handler"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "nah_queue_depth",
+			Help:        "Number of keys of a type that are due and waiting for a worker.",
+			ConstLabels: labels,
+		}, []string{"gvk"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "nah_in_flight",
+			Help:        "Number of keys of a type being reconciled.",
+			ConstLabels: labels,
+		}, []string{"gvk"}),
+	}
+
+	var err error
+	if p.invocations, err = register(registerer, p.invocations); err != nil {
+		return nil, err
+	}
+	if p.errors, err = register(registerer, p.errors); err != nil {
+		return nil, err
+	}
+	if p.duration, err = register(registerer, p.duration); err != nil {
+		return nil, err
+	}
+	if p.queueDepth, err = register(registerer, p.queueDepth); err != nil {
+		return nil, err
+	}
+	if p.inFlight, err = register(registerer, p.inFlight); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// register returns the collector that is already registered if there is one.
+func register[T prometheus.Collector](registerer prometheus.Registerer, c T) (T, error) {
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if err := registerer.Register(c); errors.As(err, &alreadyRegistered) {
+		if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+		return c, err
+	} else if err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func (p *PrometheusRecorder) HandlerDone(gvk schema.GroupVersionKind, handler string, duration time.Duration, _ error) {
+	handler = nahname.MetricLabel(handler)
+	p.invocations.WithLabelValues(gvk.String(), handler).Inc()
+	p.duration.WithLabelValues(gvk.String(), handler).Observe(duration.Seconds())
+}
+
+func (p *PrometheusRecorder) HandlerFailed(gvk schema.GroupVersionKind, handler string, requeued bool) {
+	result := "dropped"
+	if requeued {
+		result = "requeued"
+	}
+	p.errors.WithLabelValues(gvk.String(), nahname.MetricLabel(handler), result).Inc()
+}
+
+func (p *PrometheusRecorder) InFlight(gvk schema.GroupVersionKind, delta int) {
+	p.inFlight.WithLabelValues(gvk.String()).Add(float64(delta))
+}
+
+func (p *PrometheusRecorder) QueueDepth(gvk schema.GroupVersionKind, depth int) {
+	p.queueDepth.WithLabelValues(gvk.String()).Set(float64(depth))
+}
+
+// recordHandlerFailures reports the handlers that failed the reconcile, once the ErrorHandler has decided whether the
+// key is requeued.
+func (m *HandlerSet) recordHandlerFailures(req Request, resp *response, requeued bool) {
+	if m.metrics == nil {
+		return
+	}
+	for _, name := range resp.failedHandlers {
+		m.metrics.HandlerFailed(req.GVK, name, requeued)
+	}
+}
+
+// reportQueueDepths reports the queue depth of each handled type to the MetricsRecorder until the context is done.
+func (m *HandlerSet) reportQueueDepths(ctx context.Context) {
+	ticker := m.clock.NewTicker(DefaultQueueDepthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			for _, gvk := range m.handlers.GVKs() {
+				m.metrics.QueueDepth(gvk, m.waitingKeys(gvk))
+			}
+		}
+	}
+}
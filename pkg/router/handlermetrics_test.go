@@ -0,0 +1,31 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWithMetricsRegistrationError(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	// A collector of the same name with other labels can't be registered alongside the handler metrics.
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "nah_queue_depth", Help: "other"}))
+
+	r, _ := newTestRouter(t)
+	WithMetrics(registry)(r)
+	if err := r.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "failed to register the handler metrics") {
+		t.Fatalf("expected Start to return the registration error, got %v", err)
+	}
+
+	// The collectors that are already registered by another router of the same name are reused.
+	registry = prometheus.NewRegistry()
+	first, _ := newTestRouter(t)
+	WithMetrics(registry)(first)
+	second, _ := newTestRouter(t)
+	WithMetrics(registry)(second)
+	if first.metricsErr != nil || second.metricsErr != nil {
+		t.Fatalf("expected the collectors to be shared, got %v and %v", first.metricsErr, second.metricsErr)
+	}
+}
@@ -1,7 +1,10 @@
 package router
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/obot-platform/nah/pkg/merr"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -9,10 +12,46 @@ import (
 
 type handlers struct {
 	lock     sync.RWMutex
-	handlers map[schema.GroupVersionKind][]Handler
+	handlers map[schema.GroupVersionKind][]*registration
+	seq      int
+	started  bool
+}
+
+type registration struct {
+	name     string
+	priority int
+	seq      int
+	handler  Handler
+}
+
+// HandlerInfo describes a handler registered for a GVK.
+type HandlerInfo struct {
+	// Name is the name of the route, which is the file and line that registered the handler unless overridden.
+	Name     string
+	Priority int
+}
+
+// Registration is a handler that has been registered with a router.
+type Registration struct {
+	handlers *handlers
+	gvk      schema.GroupVersionKind
+	reg      *registration
+}
+
+// Priority sets the priority of the handler. Handlers for a GVK run in order of descending priority, and handlers
+// with the same priority run in the order they were registered. The default priority is 0. Priorities cannot be changed
+// after the router has started, because the running reconciles would see the handlers in different orders, so an
+// error is returned and the priority is unchanged.
+func (r *Registration) Priority(priority int) error {
+	if r == nil || r.reg == nil {
+		return nil
+	}
+	return r.handlers.setPriority(r.gvk, r.reg, priority)
 }
 
 func (h *handlers) GVKs() (result []schema.GroupVersionKind) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
 	for gvk := range h.handlers {
 		result = append(result, gvk)
 	}
@@ -20,9 +59,65 @@ func (h *handlers) GVKs() (result []schema.GroupVersionKind) {
 }
 
 func (h *handlers) AddHandler(gvk schema.GroupVersionKind, handler Handler) {
+	_, _ = h.addHandler(gvk, "", handler)
+}
+
+// addHandler returns the registration and whether the handlers were already started when it was added.
+func (h *handlers) addHandler(gvk schema.GroupVersionKind, name string, handler Handler) (*registration, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.seq++
+	reg := &registration{
+		name:    name,
+		seq:     h.seq,
+		handler: handler,
+	}
+	h.handlers[gvk] = append(h.handlers[gvk], reg)
+	h.sort(gvk)
+	return reg, h.started
+}
+
+func (h *handlers) setPriority(gvk schema.GroupVersionKind, reg *registration, priority int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.started {
+		return fmt.Errorf("cannot change the priority of handler %q for %s after the router has started", reg.name, gvk)
+	}
+	reg.priority = priority
+	h.sort(gvk)
+	return nil
+}
+
+// sort must be called with the write lock held. The slice is replaced rather than sorted in place because Handle
+// iterates over it without holding the lock.
+func (h *handlers) sort(gvk schema.GroupVersionKind) {
+	regs := append([]*registration(nil), h.handlers[gvk]...)
+	defer func() { h.handlers[gvk] = regs }()
+	sort.SliceStable(regs, func(i, j int) bool {
+		if regs[i].priority != regs[j].priority {
+			return regs[i].priority > regs[j].priority
+		}
+		return regs[i].seq < regs[j].seq
+	})
+}
+
+func (h *handlers) start() {
 	h.lock.Lock()
 	defer h.lock.Unlock()
-	h.handlers[gvk] = append(h.handlers[gvk], handler)
+	h.started = true
+}
+
+func (h *handlers) Info(gvk schema.GroupVersionKind) []HandlerInfo {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	result := make([]HandlerInfo, 0, len(h.handlers[gvk]))
+	for _, reg := range h.handlers[gvk] {
+		result = append(result, HandlerInfo{
+			Name:     reg.name,
+			Priority: reg.priority,
+		})
+	}
+	return result
 }
 
 func (h *handlers) Handles(req Request) bool {
@@ -31,7 +126,10 @@ func (h *handlers) Handles(req Request) bool {
 	return len(h.handlers[req.GVK]) > 0
 }
 
-func (h *handlers) Handle(req Request, resp *response) error {
+// observer is called after each handler is invoked.
+type observer func(req Request, resp *response, reg *registration, start time.Time, err error)
+
+func (h *handlers) Handle(req Request, resp *response, now func() time.Time, observe observer) error {
 	h.lock.RLock()
 	var (
 		errs     []error
@@ -39,8 +137,13 @@ func (h *handlers) Handle(req Request, resp *response) error {
 	)
 	h.lock.RUnlock()
 
-	for _, h := range handlers {
-		err := h.Handle(req, resp)
+	for _, reg := range handlers {
+		req.summary.handlerRan(reg.name)
+		start := now()
+		err := reg.handler.Handle(req, resp)
+		if observe != nil {
+			observe(req, resp, reg, start, err)
+		}
 		if err != nil {
 			errs = append(errs, err)
 		}
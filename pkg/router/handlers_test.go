@@ -0,0 +1,153 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPriorityAfterStart(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+
+	var order []string
+	low := r.Type(configMap("", "")).RouteName("low").HandlerFunc(func(req Request, resp Response) error {
+		order = append(order, "low")
+		return nil
+	})
+	high := r.Type(configMap("", "")).RouteName("high").HandlerFunc(func(req Request, resp Response) error {
+		order = append(order, "high")
+		return nil
+	})
+	if err := high.Priority(10); err != nil {
+		t.Fatal(err)
+	}
+	startTestRouter(t, r)
+
+	if err := low.Priority(20); err == nil {
+		t.Fatal("expected an error changing the priority after the start")
+	}
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[high low]" {
+		t.Fatalf("expected the handlers to run in the order [high low], got %v", order)
+	}
+}
+
+func TestPosStartAfterStart(t *testing.T) {
+	r, _ := newTestRouter(t)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	var called bool
+	r.PosStart(func(ctx context.Context, c kclient.Client) {
+		called = true
+	})
+	if !called {
+		t.Fatal("expected the function registered after the start to be called")
+	}
+}
+
+// TestRegistrationRace registers routes, priorities and PosStart functions while the router starts and reconciles.
+// It is meant to be run with -race.
+func TestRegistrationRace(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+
+	var (
+		wg         sync.WaitGroup
+		postStarts atomic.Int32
+		stop       = make(chan struct{})
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				reg := r.Type(configMap("", "")).RouteName(fmt.Sprintf("route-%d-%d", i, j)).HandlerFunc(func(req Request, resp Response) error {
+					return nil
+				})
+				// An error is expected once the router has started.
+				_ = reg.Priority(j)
+				r.PosStart(func(context.Context, kclient.Client) {
+					postStarts.Add(1)
+				})
+				_, _ = r.Handlers(&corev1.ConfigMap{})
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if b.watching(configMapGVK) {
+				_ = b.dispatch(configMapGVK, ReplayPrefix+"default/a")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	startTestRouter(t, r)
+	close(stop)
+	wg.Wait()
+
+	if handlers, _ := r.Handlers(&corev1.ConfigMap{}); len(handlers) != 81 {
+		t.Fatalf("expected 81 handlers, got %d", len(handlers))
+	}
+	// Each function is called once, either by the start or when it is registered after it.
+	if n := postStarts.Load(); n != 80 {
+		t.Fatalf("expected the PosStart functions to be called 80 times, got %d", n)
+	}
+}
+
+// TestHandlersOfEachVersion checks that the handlers registered for two versions of a kind each get objects of their
+// own version: each version is watched and read at its GVK, so the objects are served in that version.
+func TestHandlersOfEachVersion(t *testing.T) {
+	objMeta := metav1.ObjectMeta{Namespace: "default", Name: "a"}
+	r, b := newTestRouter(t, &autoscalingv1.HorizontalPodAutoscaler{ObjectMeta: objMeta}, &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: objMeta})
+
+	var v1Called, v2Called atomic.Bool
+	r.Type(&autoscalingv1.HorizontalPodAutoscaler{}).HandlerFunc(func(req Request, resp Response) error {
+		if _, ok := req.Object.(*autoscalingv1.HorizontalPodAutoscaler); !ok {
+			t.Errorf("expected a v1 object, got %T", req.Object)
+		}
+		v1Called.Store(true)
+		return nil
+	})
+	r.Type(&autoscalingv2.HorizontalPodAutoscaler{}).HandlerFunc(func(req Request, resp Response) error {
+		if _, ok := req.Object.(*autoscalingv2.HorizontalPodAutoscaler); !ok {
+			t.Errorf("expected a v2 object, got %T", req.Object)
+		}
+		v2Called.Store(true)
+		return nil
+	})
+	startTestRouter(t, r)
+
+	for _, gv := range []schema.GroupVersion{autoscalingv1.SchemeGroupVersion, autoscalingv2.SchemeGroupVersion} {
+		if err := b.dispatch(gv.WithKind("HorizontalPodAutoscaler"), ReplayPrefix+"default/a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !v1Called.Load() || !v2Called.Load() {
+		t.Fatalf("expected the handlers of both versions to be called, got v1 %v and v2 %v", v1Called.Load(), v2Called.Load())
+	}
+}
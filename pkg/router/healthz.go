@@ -2,17 +2,38 @@ package router
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/obot-platform/nah/pkg/backend"
 	"github.com/obot-platform/nah/pkg/log"
 )
 
+// DefaultStallThreshold is how long a key can wait in the queue of a router without any reconcile succeeding before
+// Healthz reports the router as stalled, unless it is set with WithStallThreshold.
+const DefaultStallThreshold = 10 * time.Minute
+
+// WithStallThreshold sets how long a key can wait in the queue without any reconcile of the router succeeding before
+// Healthz reports the router as stalled. Zero uses DefaultStallThreshold, and a negative threshold disables the check.
+func WithStallThreshold(threshold time.Duration) Option {
+	return func(r *Router) {
+		if threshold == 0 {
+			threshold = DefaultStallThreshold
+		}
+		r.handlers.stallThreshold = threshold
+	}
+}
+
 var healthz struct {
 	healths map[string]bool
+	frozen  map[string]bool
+	routers map[string]*Router
 	started bool
 	lock    *sync.RWMutex
 	port    int
@@ -21,6 +42,8 @@ var healthz struct {
 func init() {
 	healthz.lock = &sync.RWMutex{}
 	healthz.healths = make(map[string]bool)
+	healthz.frozen = make(map[string]bool)
+	healthz.routers = make(map[string]*Router)
 }
 
 func setPort(port int) {
@@ -39,7 +62,87 @@ func setHealthy(name string, healthy bool) {
 	healthz.healths[name] = healthy
 }
 
+func registerHealth(name string, r *Router) {
+	healthz.lock.Lock()
+	defer healthz.lock.Unlock()
+	healthz.routers[name] = r
+}
+
+func registeredRouters() map[string]*Router {
+	healthz.lock.RLock()
+	defer healthz.lock.RUnlock()
+	return maps.Clone(healthz.routers)
+}
+
+func setFrozen(name string, frozen bool) {
+	healthz.lock.Lock()
+	defer healthz.lock.Unlock()
+	healthz.frozen[name] = frozen
+}
+
+// GetReady returns true if all routers are healthy, ready, and none are frozen.
+func GetReady() bool {
+	if !GetHealthy() {
+		return false
+	}
+	for _, r := range registeredRouters() {
+		if r.Readyz() != nil {
+			return false
+		}
+	}
+	healthz.lock.RLock()
+	defer healthz.lock.RUnlock()
+	for _, frozen := range healthz.frozen {
+		if frozen {
+			return false
+		}
+	}
+	return true
+}
+
+type routerStatus struct {
+	Healthy bool   `json:"healthy"`
+	Frozen  bool   `json:"frozen"`
+	Healthz string `json:"healthz,omitempty"`
+	Readyz  string `json:"readyz,omitempty"`
+}
+
+func getStatuses() map[string]routerStatus {
+	routers := registeredRouters()
+
+	healthz.lock.RLock()
+	result := make(map[string]routerStatus, len(healthz.healths))
+	for name, healthy := range healthz.healths {
+		result[name] = routerStatus{Healthy: healthy, Frozen: healthz.frozen[name]}
+	}
+	for name, frozen := range healthz.frozen {
+		if _, ok := result[name]; !ok {
+			result[name] = routerStatus{Frozen: frozen}
+		}
+	}
+	healthz.lock.RUnlock()
+
+	for name, r := range routers {
+		status := result[name]
+		if err := r.Healthz(); err != nil {
+			status.Healthz = err.Error()
+		}
+		if err := r.Readyz(); err != nil {
+			status.Readyz = err.Error()
+		}
+		result[name] = status
+	}
+	return result
+}
+
+// GetHealthy returns true if all routers are healthy: they are not starting their handlers, they are not stalled, and
+// the leases of the leaders are being renewed.
 func GetHealthy() bool {
+	for _, r := range registeredRouters() {
+		if r.Healthz() != nil {
+			return false
+		}
+	}
 	healthz.lock.RLock()
 	defer healthz.lock.RUnlock()
 	for _, healthy := range healthz.healths {
@@ -50,6 +153,100 @@ func GetHealthy() bool {
 	return len(healthz.healths) > 0
 }
 
+// Healthz returns an error if the router should be restarted: it is the leader and has not renewed its lease for
+// longer than the TTL of the election, see leader.ElectionConfig.Healthz, or it is running and stalled, because keys
+// have waited in its queues for longer than the stall threshold without any reconcile succeeding, see
+// WithStallThreshold. Routers that are frozen are never stalled.
+func (r *Router) Healthz() error {
+	if err := r.handlers.election.Healthz(); err != nil {
+		return fmt.Errorf("router [%s]: %w", r.handlers.name, err)
+	}
+	if r.Phase() == PhaseRunning {
+		return r.handlers.stalled()
+	}
+	return nil
+}
+
+// Readyz returns an error if the router should not receive traffic: the caches of its types have not synced, either
+// because it is the leader and is starting its handlers or because it is a follower that hasn't preloaded them, it is
+// frozen, or it is stopping.
+func (r *Router) Readyz() error {
+	switch phase := r.Phase(); {
+	case phase == PhaseDraining || phase == PhaseStopped:
+		return fmt.Errorf("router [%s] is %s", r.handlers.name, phase)
+	case !r.handlers.synced.Load():
+		return fmt.Errorf("caches of router [%s] have not synced", r.handlers.name)
+	case r.Frozen():
+		return fmt.Errorf("router [%s] is frozen", r.handlers.name)
+	}
+	return nil
+}
+
+// HealthHandler returns a handler that serves Healthz on /healthz and Readyz on /readyz, for callers that serve the
+// probes on their own mux rather than on the healthz port of the router. A failed check is served as a 503 with its
+// error.
+func (r *Router) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		writeCheck(w, r.Healthz())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		writeCheck(w, r.Readyz())
+	})
+	return mux
+}
+
+func writeCheck(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintln(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// stalled returns an error if keys have been waiting in the queues for longer than the stall threshold and no
+// reconcile has succeeded for as long. Requeues count from when they are due, so keys delayed by RetryAfter or a back
+// off are not waiting until then.
+func (m *HandlerSet) stalled() error {
+	if m.stallThreshold <= 0 || m.freezer.isFrozen() {
+		return nil
+	}
+	lister, ok := m.backend.(backend.PendingEnqueueLister)
+	if !ok {
+		return nil
+	}
+
+	now := m.clock.Now()
+	sinceSuccess := now.Sub(time.Unix(0, m.lastSuccess.Load()))
+	if sinceSuccess <= m.stallThreshold {
+		return nil
+	}
+
+	var (
+		waiting int
+		oldest  time.Time
+	)
+	for _, gvk := range m.handlers.GVKs() {
+		for _, info := range lister.PendingEnqueues(gvk) {
+			if info.EnqueuedAt.IsZero() || now.Sub(info.EnqueuedAt) <= m.stallThreshold {
+				continue
+			}
+			waiting++
+			if oldest.IsZero() || info.EnqueuedAt.Before(oldest) {
+				oldest = info.EnqueuedAt
+			}
+		}
+	}
+	if waiting == 0 {
+		return nil
+	}
+	return fmt.Errorf("router [%s] is stalled: %d keys have been queued for up to %s and no reconcile has succeeded for %s",
+		m.name, waiting, now.Sub(oldest).Round(time.Second), sinceSuccess.Round(time.Second))
+}
+
 // startHealthz starts a healthz server on the healthzPort. If the server is already running, then this is a no-op.
 // Similarly, if the healthzPort is <= 0, then this is a no-op.
 func startHealthz(ctx context.Context) {
@@ -71,6 +268,27 @@ func startHealthz(ctx context.Context) {
 		}
 		w.WriteHeader(http.StatusServiceUnavailable)
 	})
+	// Frozen routers are alive but should not receive work, so they are only reported on /readyz.
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if GetReady() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/debug/history", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(findHistory(q.Get("kind"), q.Get("namespace"), q.Get("name")))
+	})
+	mux.HandleFunc("/debug/traces", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(getTraces())
+	})
+	mux.HandleFunc("/debug/routers", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(getStatuses())
+	})
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", healthz.port),
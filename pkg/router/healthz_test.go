@@ -0,0 +1,55 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// pendingBackend is a fake backend whose queues hold the pending keys.
+type pendingBackend struct {
+	*fakeBackend
+	pending map[string]backend.EnqueueInfo
+}
+
+func (p *pendingBackend) PendingEnqueues(schema.GroupVersionKind) map[string]backend.EnqueueInfo {
+	return p.pending
+}
+
+func TestStallThreshold(t *testing.T) {
+	for name, test := range map[string]struct {
+		opts     []Option
+		waited   time.Duration
+		expected bool
+	}{
+		"default":            {waited: DefaultStallThreshold + time.Second, expected: true},
+		"within the default": {waited: DefaultStallThreshold - time.Second},
+		// Zero uses the default, like the StallThreshold of the root options.
+		"zero":     {opts: []Option{WithStallThreshold(0)}, waited: DefaultStallThreshold + time.Second, expected: true},
+		"set":      {opts: []Option{WithStallThreshold(time.Minute)}, waited: 2 * time.Minute, expected: true},
+		"within":   {opts: []Option{WithStallThreshold(time.Hour)}, waited: DefaultStallThreshold + time.Second},
+		"disabled": {opts: []Option{WithStallThreshold(-1)}, waited: 24 * time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scheme := testScheme(t)
+			clock := testingclock.NewFakeClock(time.Now())
+			b := &pendingBackend{
+				fakeBackend: newFakeBackend(scheme),
+				pending:     map[string]backend.EnqueueInfo{"default/a": {EnqueuedAt: clock.Now()}},
+			}
+			r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0, append([]Option{WithClock(clock)}, test.opts...)...)
+			r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+				return nil
+			})
+			r.handlers.lastSuccess.Store(clock.Now().UnixNano())
+
+			clock.Step(test.waited)
+			if err := r.handlers.stalled(); (err != nil) != test.expected {
+				t.Fatalf("expected stalled to be %v after %s, got %v", test.expected, test.waited, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultHistorySize is the number of handler invocations kept in the history of a router unless WithHistorySize is
+// given.
+const DefaultHistorySize = 4096
+
+// ReconcileRecord is an entry in the history of a router recording one invocation of a handler.
+type ReconcileRecord struct {
+	GVK        schema.GroupVersionKind `json:"gvk"`
+	Key        string                  `json:"key"`
+	Handler    string                  `json:"handler"`
+	Start      time.Time               `json:"start"`
+	Duration   time.Duration           `json:"duration"`
+	Outcome    string                  `json:"outcome"`
+	Error      string                  `json:"error,omitempty"`
+	RetryAfter time.Duration           `json:"retryAfter,omitempty"`
+}
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// WithHistorySize sets the number of handler invocations kept in the history of the router. The history is a fixed
+// size ring, so the memory used is bounded regardless of the number of objects. A size of zero disables the history.
+func WithHistorySize(size int) Option {
+	return func(r *Router) {
+		r.handlers.history = newHistory(size)
+	}
+}
+
+// History returns the recorded handler invocations for the object, oldest first.
+func (r *Router) History(gvk schema.GroupVersionKind, namespace, name string) []ReconcileRecord {
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	return r.handlers.history.find(func(rec *ReconcileRecord) bool {
+		return rec.GVK == gvk && rec.Key == key
+	})
+}
+
+type history struct {
+	lock    sync.Mutex
+	records []ReconcileRecord
+	next    int
+	full    bool
+}
+
+func newHistory(size int) *history {
+	if size <= 0 {
+		return nil
+	}
+	return &history{
+		records: make([]ReconcileRecord, size),
+	}
+}
+
+func (h *history) record(rec ReconcileRecord) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	h.records[h.next] = rec
+	h.next++
+	if h.next == len(h.records) {
+		h.next = 0
+		h.full = true
+	}
+	h.lock.Unlock()
+}
+
+func (h *history) find(match func(*ReconcileRecord) bool) []ReconcileRecord {
+	if h == nil {
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var result []ReconcileRecord
+	scan := func(records []ReconcileRecord) {
+		for i := range records {
+			if match(&records[i]) {
+				result = append(result, records[i])
+			}
+		}
+	}
+	if h.full {
+		scan(h.records[h.next:])
+	}
+	scan(h.records[:h.next])
+	return result
+}
+
+// observe records the invocation of a handler in the history.
+func (m *HandlerSet) observe(req Request, resp *response, reg *registration, start time.Time, err error) {
+	m.limitAttributes(req, resp, reg)
+	if m.metrics != nil {
+		m.metrics.HandlerDone(req.GVK, reg.name, m.clock.Since(start), err)
+		if err != nil {
+			resp.failedHandlers = append(resp.failedHandlers, reg.name)
+		}
+	}
+	if m.history == nil {
+		return
+	}
+	rec := ReconcileRecord{
+		GVK:        req.GVK,
+		Key:        req.Key,
+		Handler:    reg.name,
+		Start:      start,
+		Duration:   m.clock.Since(start),
+		Outcome:    OutcomeSuccess,
+		RetryAfter: resp.delay,
+	}
+	if err != nil {
+		rec.Outcome = OutcomeError
+		rec.Error = err.Error()
+	}
+	m.history.record(rec)
+}
+
+var histories = struct {
+	lock    sync.RWMutex
+	routers map[string]*history
+}{
+	routers: map[string]*history{},
+}
+
+func registerHistory(name string, h *history) {
+	histories.lock.Lock()
+	defer histories.lock.Unlock()
+	if h == nil {
+		delete(histories.routers, name)
+		return
+	}
+	histories.routers[name] = h
+}
+
+// findHistory returns the records of all routers for the objects with the kind, namespace and name. Empty values
+// match everything and the kind is matched case insensitively.
+func findHistory(kind, namespace, name string) map[string][]ReconcileRecord {
+	histories.lock.RLock()
+	defer histories.lock.RUnlock()
+	result := make(map[string][]ReconcileRecord, len(histories.routers))
+	for router, h := range histories.routers {
+		result[router] = h.find(func(rec *ReconcileRecord) bool {
+			ns, n, ok := strings.Cut(rec.Key, "/")
+			if !ok {
+				ns, n = "", rec.Key
+			}
+			return (kind == "" || strings.EqualFold(kind, rec.GVK.Kind)) &&
+				(namespace == "" || namespace == ns) &&
+				(name == "" || name == n)
+		})
+	}
+	return result
+}
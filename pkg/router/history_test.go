@@ -0,0 +1,66 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	scheme := testScheme(t)
+	b := newFakeBackend(scheme, configMap("default", "a"), configMap("default", "b"))
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0, WithHistorySize(3))
+
+	fail := true
+	r.Type(configMap("", "")).RouteName("test").HandlerFunc(func(req Request, resp Response) error {
+		if req.Name == "b" {
+			return nil
+		}
+		if fail {
+			return errors.New("failed")
+		}
+		resp.RetryAfter(time.Minute)
+		return nil
+	})
+	startTestRouter(t, r)
+
+	_ = b.dispatch(configMapGVK, "default/a")
+	fail = false
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.dispatch(configMapGVK, "default/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	records := r.History(configMapGVK, "default", "a")
+	if len(records) != 2 {
+		t.Fatalf("expected the two reconciles of a, got %+v", records)
+	}
+	if rec := records[0]; rec.Outcome != OutcomeError || rec.Error != "[test] failed" || rec.Key != "default/a" || rec.Handler == "" {
+		t.Fatalf("expected the failure to be recorded first, got %+v", rec)
+	}
+	if rec := records[1]; rec.Outcome != OutcomeSuccess || rec.Error != "" || rec.RetryAfter != time.Minute {
+		t.Fatalf("expected the success to be recorded last, got %+v", rec)
+	}
+	if records := r.History(configMapGVK, "default", "b"); len(records) != 1 {
+		t.Fatalf("expected the reconcile of b, got %+v", records)
+	}
+
+	// The history is bounded, the oldest records are dropped.
+	if err := b.dispatch(configMapGVK, "default/b"); err != nil {
+		t.Fatal(err)
+	}
+	records = r.History(configMapGVK, "default", "a")
+	if len(records) != 1 || records[0].Outcome != OutcomeSuccess {
+		t.Fatalf("expected only the last reconcile of a to be kept, got %+v", records)
+	}
+	if records := r.History(configMapGVK, "default", "b"); len(records) != 2 {
+		t.Fatalf("expected both reconciles of b, got %+v", records)
+	}
+
+	// The history of the started router is found by kind, case insensitively.
+	if records := findHistory("configmap", "default", "")[t.Name()]; len(records) != 3 {
+		t.Fatalf("expected the records of the router to be found, got %+v", records)
+	}
+}
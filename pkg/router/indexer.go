@@ -0,0 +1,127 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	nahfields "github.com/obot-platform/nah/pkg/fields"
+	"github.com/obot-platform/nah/pkg/untriggered"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// FieldNotIndexedError is returned by the cached lists of a request with a field selector on a field that the cache
+// doesn't index, which the cache can't serve.
+type FieldNotIndexedError struct {
+	GVK   schema.GroupVersionKind
+	Field string
+}
+
+func (e *FieldNotIndexedError) Error() string {
+	return fmt.Sprintf("field %s of %v is not indexed, register an index with Router.Indexer before the router starts, or list with untriggered.UncachedList", e.Field, e.GVK)
+}
+
+// Indexer indexes the objects of the type in the cache by the values that extract returns for the field, so that the
+// lists of requests with a field selector on the field are served from the index instead of filtering every object.
+// The triggers registered by such lists use extract too, so that a change of an object only triggers the requests
+// whose selector matches the object. Only exact matches of the field are supported.
+//
+// Indexes must be registered before the router starts, because an index can't be added to a cache that has started.
+// An error is returned if the router has started or the field is already indexed for the type.
+func (r *Router) Indexer(objType kclient.Object, field string, extract kclient.IndexerFunc) error {
+	if phase := r.Phase(); phase != PhaseStarting {
+		return fmt.Errorf("the index of field %s of %T must be registered before router [%s] starts", field, objType, r.handlers.name)
+	}
+
+	gvk, err := r.handlers.backend.GVKForObject(objType, r.handlers.scheme)
+	if err != nil {
+		return err
+	}
+	if err := r.handlers.indexes.add(gvk, field, extract); err != nil {
+		return err
+	}
+	if err := r.handlers.backend.IndexField(context.Background(), objType, field, extract); err != nil {
+		r.handlers.indexes.remove(gvk, field)
+		return fmt.Errorf("failed to index field %s of %v: %w", field, gvk, err)
+	}
+	return nil
+}
+
+type indexes struct {
+	lock     sync.RWMutex
+	indexers map[schema.GroupVersionKind]map[string]kclient.IndexerFunc
+}
+
+func (i *indexes) add(gvk schema.GroupVersionKind, field string, extract kclient.IndexerFunc) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if _, ok := i.indexers[gvk][field]; ok {
+		return fmt.Errorf("field %s of %v is already indexed", field, gvk)
+	}
+	if i.indexers == nil {
+		i.indexers = map[schema.GroupVersionKind]map[string]kclient.IndexerFunc{}
+	}
+	// The indexers of a type are replaced rather than modified, because forGVK returns them without a lock.
+	result := maps.Clone(i.indexers[gvk])
+	if result == nil {
+		result = map[string]kclient.IndexerFunc{}
+	}
+	result[field] = extract
+	i.indexers[gvk] = result
+	return nil
+}
+
+func (i *indexes) remove(gvk schema.GroupVersionKind, field string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	result := maps.Clone(i.indexers[gvk])
+	delete(result, field)
+	i.indexers[gvk] = result
+}
+
+// forGVK returns the indexers of the type, which must not be modified.
+func (i *indexes) forGVK(gvk schema.GroupVersionKind) map[string]kclient.IndexerFunc {
+	if i == nil {
+		return nil
+	}
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.indexers[gvk]
+}
+
+// checkList returns a *FieldNotIndexedError if the cached list has a field selector on a field that is not indexed by
+// an Indexer, by the owner index, or by the field names of the type. Uncached lists are served by the API server.
+func (i *indexes) checkList(scheme *runtime.Scheme, list kclient.ObjectList, sel fields.Selector) error {
+	if i == nil || sel == nil || sel.Empty() || untriggered.IsUncached(list) {
+		return nil
+	}
+	gvk, err := apiutil.GVKForObject(untriggered.UnwrapList(list), scheme)
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+
+	var fieldNames []string
+	if obj, err := scheme.New(gvk); err == nil {
+		if f, ok := obj.(nahfields.Fields); ok {
+			fieldNames = f.FieldNames()
+		}
+	}
+
+	indexers := i.forGVK(gvk)
+	for _, req := range sel.Requirements() {
+		if _, ok := indexers[req.Field]; ok || req.Field == backend.OwnerUIDIndexField || slices.Contains(fieldNames, req.Field) {
+			continue
+		}
+		return &FieldNotIndexedError{GVK: gvk, Field: req.Field}
+	}
+	return nil
+}
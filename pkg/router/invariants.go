@@ -0,0 +1,291 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// DefaultInvariantCheckInterval is how often a router with WithInvariantChecks verifies its invariants.
+	DefaultInvariantCheckInterval = time.Minute
+	// invariantStaleness is how long a key lock can be held, or a requeue can be overdue, before it is a violation.
+	invariantStaleness = 10 * time.Minute
+	// maxViolationKeys bounds the keys listed for a type in one check, so that a broken queue doesn't flood the log.
+	maxViolationKeys = 10
+)
+
+// InvariantViolation is an internal invariant of a router that did not hold, see WithInvariantChecks.
+type InvariantViolation struct {
+	// Check is the name of the invariant, such as "queue" or "trigger".
+	Check   string
+	GVK     schema.GroupVersionKind
+	Key     string
+	Message string
+}
+
+func (v InvariantViolation) String() string {
+	if v.Key == "" {
+		return fmt.Sprintf("[%s] [%v]: %s", v.Check, v.GVK, v.Message)
+	}
+	return fmt.Sprintf("[%s] [%v] [%s]: %s", v.Check, v.GVK, v.Key, v.Message)
+}
+
+// WithInvariantChecks is a development mode that verifies the internal invariants of the router every
+// DefaultInvariantCheckInterval and once more when it stops:
+//   - every queued trigger or back off replay is for a type that has a route, and every queued key is for a type that
+//     the router watches,
+//   - the source type of every trigger is watched by the router, and its target type has a route,
+//   - no lock of a key is held for longer than a reconcile should take, or at all once the router stopped,
+//   - the requeues of the delayed queue have due times that are set and not long overdue.
+//
+// Violations are logged as errors. The checks read every queue and trigger, so this is not meant for production. The
+// queues of the backend are checked as a whole, so routers that share a backend report the types of each other.
+func WithInvariantChecks() Option {
+	return func(r *Router) {
+		if r.handlers.invariants == nil {
+			r.handlers.invariants = &invariants{}
+		}
+	}
+}
+
+// WithStrictInvariantChecks enables WithInvariantChecks and panics when an invariant is violated, for the tests of
+// the router itself.
+func WithStrictInvariantChecks() Option {
+	return func(r *Router) {
+		WithInvariantChecks()(r)
+		r.handlers.invariants.strict = true
+	}
+}
+
+// CheckInvariants verifies the internal invariants of the router now and returns the violations, whether or not
+// WithInvariantChecks is set. Violations of the key locks are only found with WithInvariantChecks, because the locks
+// are only tracked then.
+func (r *Router) CheckInvariants() []InvariantViolation {
+	return r.handlers.checkInvariants(false)
+}
+
+type invariants struct {
+	strict bool
+
+	lock sync.Mutex
+	// held are the key locks that are held, with when they were acquired.
+	held map[string]time.Time
+}
+
+// locked records that the lock of the key was acquired. It is a no-op if the checks are disabled.
+func (i *invariants) locked(lockKey string, now time.Time) {
+	if i == nil {
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.held == nil {
+		i.held = map[string]time.Time{}
+	}
+	i.held[lockKey] = now
+}
+
+func (i *invariants) unlocked(lockKey string) {
+	if i == nil {
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.held, lockKey)
+}
+
+// lockKey acquires the lock of the key and returns the function that releases it.
+func (m *HandlerSet) lockKey(gvk schema.GroupVersionKind, key string) func() {
+	lockKey := gvk.Kind + " " + key
+	m.locker.Lock(lockKey)
+	m.invariants.locked(lockKey, m.clock.Now())
+	return func() {
+		m.invariants.unlocked(lockKey)
+		_ = m.locker.Unlock(lockKey)
+	}
+}
+
+// checkInvariantsPeriodically checks the invariants until the context is done.
+func (m *HandlerSet) checkInvariantsPeriodically(ctx context.Context) {
+	ticker := m.clock.NewTicker(DefaultInvariantCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			m.reportInvariants(m.checkInvariants(false))
+		}
+	}
+}
+
+// reportInvariants logs the violations, and panics if the checks are strict.
+func (m *HandlerSet) reportInvariants(violations []InvariantViolation) {
+	if len(violations) == 0 {
+		return
+	}
+	lines := make([]string, 0, len(violations))
+	for _, v := range violations {
+		log.Errorf("Router [%s] invariant violated: %s", m.name, v)
+		lines = append(lines, v.String())
+	}
+	if m.invariants != nil && m.invariants.strict {
+		panic(fmt.Sprintf("router [%s] violated %d invariants:\n%s", m.name, len(violations), strings.Join(lines, "\n")))
+	}
+}
+
+// checkInvariants returns the violations of the invariants. Stopped is true once the running handlers returned after
+// the router stopped, when no key lock may be held.
+func (m *HandlerSet) checkInvariants(stopped bool) []InvariantViolation {
+	now := m.clock.Now()
+
+	watched := map[schema.GroupVersionKind]bool{}
+	m.watchingLock.Lock()
+	for gvk, ok := range m.watching {
+		watched[gvk] = ok
+	}
+	m.watchingLock.Unlock()
+
+	routed := map[schema.GroupVersionKind]bool{}
+	for _, gvk := range m.handlers.GVKs() {
+		routed[gvk] = true
+	}
+
+	var result []InvariantViolation
+	result = append(result, m.checkQueues(watched, routed)...)
+	result = append(result, m.checkTriggers(watched, routed)...)
+	result = append(result, m.invariants.checkLocks(now, stopped)...)
+	result = append(result, m.checkRequeues(now, routed)...)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Check != result[j].Check {
+			return result[i].Check < result[j].Check
+		}
+		return result[i].GVK.String() < result[j].GVK.String()
+	})
+	return result
+}
+
+func (m *HandlerSet) checkQueues(watched, routed map[schema.GroupVersionKind]bool) []InvariantViolation {
+	lister, ok := m.backend.(backend.PendingEnqueueLister)
+	if !ok {
+		return nil
+	}
+	kinds, ok := m.backend.(backend.QueuedKindLister)
+	if !ok {
+		return nil
+	}
+
+	var result []InvariantViolation
+	for _, gvk := range kinds.QueuedKinds() {
+		var found int
+		for key := range lister.PendingEnqueues(gvk) {
+			var message string
+			switch {
+			case !routed[gvk] && strings.HasPrefix(key, TriggerPrefix):
+				message = "trigger is queued for a type without a route"
+			case !routed[gvk] && strings.HasPrefix(key, ReplayPrefix):
+				message = "back off replay is queued for a type without a route"
+			case !watched[gvk]:
+				message = "key is queued for a type that the router doesn't watch"
+			default:
+				continue
+			}
+			if found++; found > maxViolationKeys {
+				break
+			}
+			result = append(result, InvariantViolation{Check: "queue", GVK: gvk, Key: key, Message: message})
+		}
+	}
+	return result
+}
+
+func (m *HandlerSet) checkTriggers(watched, routed map[schema.GroupVersionKind]bool) []InvariantViolation {
+	m.triggers.lock.RLock()
+	defer m.triggers.lock.RUnlock()
+
+	var result []InvariantViolation
+	for sourceGVK, targets := range m.triggers.matchers {
+		if !watched[sourceGVK] {
+			result = append(result, InvariantViolation{
+				Check:   "trigger",
+				GVK:     sourceGVK,
+				Message: fmt.Sprintf("%d triggers have a source type that the router doesn't watch", len(targets)),
+			})
+		}
+		for target, matchers := range targets {
+			if routed[target.gvk] {
+				continue
+			}
+			for _, mt := range matchers {
+				result = append(result, InvariantViolation{
+					Check:   "trigger",
+					GVK:     target.gvk,
+					Key:     target.key,
+					Message: fmt.Sprintf("trigger from %s [%s] targets a type without a route", sourceGVK, mt.String()),
+				})
+			}
+		}
+	}
+	return result
+}
+
+func (i *invariants) checkLocks(now time.Time, stopped bool) []InvariantViolation {
+	if i == nil {
+		return nil
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var result []InvariantViolation
+	for lockKey, since := range i.held {
+		held := now.Sub(since)
+		var message string
+		switch {
+		case stopped:
+			message = fmt.Sprintf("lock is still held after the router stopped, acquired %s ago", held)
+		case held > invariantStaleness:
+			message = fmt.Sprintf("lock has been held for %s", held)
+		default:
+			continue
+		}
+		kind, key, _ := strings.Cut(lockKey, " ")
+		result = append(result, InvariantViolation{
+			Check:   "lock",
+			GVK:     schema.GroupVersionKind{Kind: kind},
+			Key:     key,
+			Message: message,
+		})
+	}
+	return result
+}
+
+func (m *HandlerSet) checkRequeues(now time.Time, routed map[schema.GroupVersionKind]bool) []InvariantViolation {
+	m.requeues.lock.Lock()
+	defer m.requeues.lock.Unlock()
+
+	var result []InvariantViolation
+	for k, due := range m.requeues.pending {
+		var message string
+		switch {
+		case due.IsZero():
+			message = "requeue has no due time"
+		case now.Sub(due) > invariantStaleness:
+			message = fmt.Sprintf("requeue was due at %s, %s ago, and has not run", due.Format(time.RFC3339), now.Sub(due))
+		case !routed[k.gvk]:
+			message = fmt.Sprintf("requeue due at %s is for a type without a route", due.Format(time.RFC3339))
+		default:
+			continue
+		}
+		result = append(result, InvariantViolation{Check: "requeue", GVK: k.gvk, Key: k.key, Message: message})
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestInvariantViolations(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		logged []string
+	)
+	errorf := log.Errorf
+	log.Errorf = func(message string, obj ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		logged = append(logged, fmt.Sprintf(message, obj...))
+	}
+	t.Cleanup(func() {
+		log.Errorf = errorf
+	})
+
+	clock := testingclock.NewFakeClock(time.Now())
+	scheme := testScheme(t)
+	b := newFakeBackend(scheme, configMap("default", "stuck"), configMap("default", "requeued"))
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0, WithClock(clock), WithInvariantChecks())
+
+	started, release := make(chan struct{}), make(chan struct{})
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		switch req.Name {
+		case "stuck":
+			close(started)
+			<-release
+		case "requeued":
+			resp.RetryAfter(time.Minute)
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if violations := r.CheckInvariants(); len(violations) != 0 {
+		t.Fatalf("expected no violations, got %v", violations)
+	}
+
+	// The reconcile of stuck holds its lock for too long, and the requeue of requeued never runs.
+	done := make(chan error, 1)
+	go func() {
+		done <- b.dispatch(configMapGVK, "default/stuck")
+	}()
+	<-started
+	if err := b.dispatch(configMapGVK, "default/requeued"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Step(invariantStaleness + 2*time.Minute)
+
+	violations := r.CheckInvariants()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 {
+		t.Fatalf("expected the held lock and the overdue requeue, got %v", violations)
+	}
+	if v := violations[0]; v.Check != "lock" || v.GVK.Kind != "ConfigMap" || v.Key != "default/stuck" || !strings.Contains(v.Message, "held for 12m") {
+		t.Fatalf("expected the lock of default/stuck to be reported, got %v", v)
+	}
+	if v := violations[1]; v.Check != "requeue" || v.GVK != configMapGVK || v.Key != "default/requeued" || !strings.Contains(v.Message, "has not run") {
+		t.Fatalf("expected the requeue of default/requeued to be reported, got %v", v)
+	}
+
+	// The violations are logged.
+	r.handlers.reportInvariants(violations)
+	lock.Lock()
+	for _, v := range violations {
+		if indexOf(logged, v.String()) < 0 {
+			t.Errorf("expected %s to be logged, got %v", v, logged)
+		}
+	}
+	lock.Unlock()
+
+	// With strict checks, the violations panic.
+	strict := New(NewHandlerSet(t.Name()+"-strict", scheme, newFakeBackend(scheme)), nil, 0, WithStrictInvariantChecks())
+	func() {
+		defer func() {
+			msg, _ := recover().(string)
+			if !strings.Contains(msg, "violated 2 invariants") || !strings.Contains(msg, violations[0].String()) {
+				t.Fatalf("expected the strict checks to panic with the violations, got %q", msg)
+			}
+		}()
+		strict.handlers.reportInvariants(violations)
+	}()
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"container/list"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/log"
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StateLimits bounds the state that a router keeps for keys, so that a handler bug can't slowly exhaust the memory of
+// the controller. A zero field is not limited.
+type StateLimits struct {
+	// MaxAttributeBytes is the largest size, serialized as JSON, of the Response attributes of a key. When a handler
+	// leaves the attributes above the limit, the largest attributes are removed until they are under it.
+	MaxAttributeBytes int
+	// SoftAttributeBytes is the size of the Response attributes of a key above which a warning is logged, once per
+	// handler.
+	SoftAttributeBytes int
+	// MaxKeys is the number of keys for which the router keeps rate limiting state. The state of the least recently
+	// reconciled key is evicted when the limit is exceeded, which resets its rate limit.
+	MaxKeys int
+}
+
+// WithStateLimits enforces the limits on the state of the router.
+func WithStateLimits(limits StateLimits) Option {
+	return func(r *Router) {
+		r.handlers.limits = &stateLimits{
+			StateLimits: limits,
+			warned:      map[string]bool{},
+		}
+		if limits.MaxKeys > 0 {
+			r.handlers.limiterLRU = newKeyLRU()
+		}
+	}
+}
+
+var stateEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nah_state_evictions_total",
+	Help: "Number of entries evicted from the state of a router because it exceeded its limits.",
+}, []string{"router", "store"})
+
+type stateLimits struct {
+	StateLimits
+
+	lock   sync.Mutex
+	warned map[string]bool
+}
+
+// warn returns true the first time it is called for the handler.
+func (s *stateLimits) warn(handler string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.warned[handler] {
+		return false
+	}
+	s.warned[handler] = true
+	return true
+}
+
+// limitAttributes enforces the attribute limits after a handler has run.
+func (m *HandlerSet) limitAttributes(req Request, resp *response, reg *registration) {
+	if m.limits == nil || len(resp.attr) == 0 || m.limits.MaxAttributeBytes <= 0 && m.limits.SoftAttributeBytes <= 0 {
+		return
+	}
+
+	sizes := make(map[string]int, len(resp.attr))
+	total := 2
+	for k, v := range resp.attr {
+		// Values that can't be serialized, such as functions, are only counted by their key.
+		data, _ := json.Marshal(v)
+		sizes[k] = len(k) + len(data) + 4
+		total += sizes[k]
+	}
+
+	if m.limits.SoftAttributeBytes > 0 && total > m.limits.SoftAttributeBytes && m.limits.warn(reg.name) {
+		log.Warnf("Handler [%s] left %d bytes of attributes for [%s] [%s], above the soft limit of %d bytes", reg.name,
+			total, req.Key, req.GVK, m.limits.SoftAttributeBytes)
+	}
+
+	if m.limits.MaxAttributeBytes <= 0 || total <= m.limits.MaxAttributeBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(sizes))
+	for k := range sizes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return sizes[keys[i]] > sizes[keys[j]]
+	})
+	for _, k := range keys {
+		if total <= m.limits.MaxAttributeBytes {
+			break
+		}
+		log.Warnf("Evicting attribute [%s] of %d bytes for [%s] [%s] after handler [%s], attributes are limited to %d bytes",
+			k, sizes[k], req.Key, req.GVK, reg.name, m.limits.MaxAttributeBytes)
+		delete(resp.attr, k)
+		total -= sizes[k]
+		stateEvictions.WithLabelValues(nahname.MetricLabel(m.name), "attributes").Inc()
+	}
+}
+
+// keyLRU orders keys by when they were last used. It is guarded by the lock of the store that uses it.
+type keyLRU struct {
+	order *list.List
+	elems map[limiterKey]*list.Element
+}
+
+func newKeyLRU() *keyLRU {
+	return &keyLRU{
+		order: list.New(),
+		elems: map[limiterKey]*list.Element{},
+	}
+}
+
+func (l *keyLRU) touch(key limiterKey) {
+	if l == nil {
+		return
+	}
+	if e, ok := l.elems[key]; ok {
+		l.order.MoveToFront(e)
+		return
+	}
+	l.elems[key] = l.order.PushFront(key)
+}
+
+func (l *keyLRU) remove(key limiterKey) {
+	if l == nil {
+		return
+	}
+	if e, ok := l.elems[key]; ok {
+		l.order.Remove(e)
+		delete(l.elems, key)
+	}
+}
+
+// evict removes and returns the least recently used keys above max.
+func (l *keyLRU) evict(max int) (evicted []limiterKey) {
+	if l == nil {
+		return nil
+	}
+	for l.order.Len() > max {
+		e := l.order.Back()
+		key := e.Value.(limiterKey)
+		l.order.Remove(e)
+		delete(l.elems, key)
+		evicted = append(evicted, key)
+	}
+	return evicted
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"log/slog"
+
+	"github.com/obot-platform/nah/pkg/log"
+)
+
+// defaultLogger is the logger of the routers without WithLogger. It is shared because the loggers of log.Slog look up
+// the functions of the log package when they write.
+var defaultLogger = log.Slog()
+
+// WithLogger sets the structured logger of the router. The logs of each reconcile, and Request.Logger, carry the GVK,
+// namespace, name and attempt of the request as fields. Defaults to log.Slog, which writes to the log package.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Router) {
+		r.handlers.logger = logger
+	}
+}
+
+// WithFatalHandler sets the function called when the router can't go on, such as when the caches of a follower fail to
+// preload. It defaults to exiting the process with log.Fatalf, and can be overridden to trigger a controlled shutdown
+// instead.
+func WithFatalHandler(f func(err error)) Option {
+	return func(r *Router) {
+		r.onFatal = f
+	}
+}
+
+func (r *Router) fatal(err error) {
+	if r.onFatal != nil {
+		r.onFatal(err)
+		return
+	}
+	log.Fatalf("%v", err)
+}
+
+// Logger returns the structured logger of the router with the GVK, namespace, name and attempt of the request as
+// fields, or the external route and key for the requests of external routes. The attempt counts from 1 and is only
+// above 1 for retries of routers with WithBackoff. The logger is built on each call, so that the reconciles that don't
+// log don't pay for it; keep it in a variable to log several lines.
+func (r *Request) Logger() *slog.Logger {
+	logger := r.logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+	return requestLogger(logger, *r)
+}
+
+// debug logs the message with Logger, only building the logger if debug logs are enabled.
+func (r *Request) debug(msg string, args ...any) {
+	if r.logger != nil && !r.logger.Enabled(r.Ctx, slog.LevelDebug) {
+		return
+	}
+	r.Logger().Debug(msg, args...)
+}
+
+func (m *HandlerSet) baseLogger() *slog.Logger {
+	if m.logger == nil {
+		return defaultLogger
+	}
+	return m.logger
+}
+
+func requestLogger(logger *slog.Logger, req Request) *slog.Logger {
+	if req.External != "" {
+		return logger.With("external", req.External, "key", req.Key)
+	}
+	return logger.With("gvk", req.GVK.String(), "namespace", req.Namespace, "name", req.Name, "attempt", req.Retries+1)
+}
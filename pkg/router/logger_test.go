@@ -0,0 +1,137 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/log"
+)
+
+// recordingHandler records the records at info level or above, and counts the loggers derived from it.
+type recordingHandler struct {
+	lock    *sync.Mutex
+	records *[]map[string]any
+	derived *int
+	attrs   []slog.Attr
+}
+
+func newRecordingHandler() recordingHandler {
+	return recordingHandler{lock: &sync.Mutex{}, records: &[]map[string]any{}, derived: new(int)}
+}
+
+func (h recordingHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	record := map[string]any{"msg": r.Message}
+	for _, a := range h.attrs {
+		record[a.Key] = a.Value.Any()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		record[a.Key] = a.Value.Any()
+		return true
+	})
+	*h.records = append(*h.records, record)
+	return nil
+}
+
+func (h recordingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	*h.derived++
+	h.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return h
+}
+
+func (h recordingHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h recordingHandler) counts() (records, derived int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(*h.records), *h.derived
+}
+
+func TestRequestLoggerIsLazy(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "quiet"), configMap("default", "loud"))
+	h := newRecordingHandler()
+	WithLogger(slog.New(h))(r)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if req.Name == "loud" {
+			req.Logger().Info("reconciled", "extra", true)
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	// The debug logs of the router don't build the logger of the request when debug is disabled.
+	if err := b.dispatch(configMapGVK, "default/quiet"); err != nil {
+		t.Fatal(err)
+	}
+	if records, derived := h.counts(); records != 0 || derived != 0 {
+		t.Fatalf("expected no logger to be built for a reconcile that doesn't log, got %d records and %d loggers", records, derived)
+	}
+
+	if err := b.dispatch(configMapGVK, "default/loud"); err != nil {
+		t.Fatal(err)
+	}
+	if records, derived := h.counts(); records != 1 || derived != 1 {
+		t.Fatalf("expected one record from one logger, got %d records and %d loggers", records, derived)
+	}
+	record := (*h.records)[0]
+	for key, expected := range map[string]any{
+		"msg":       "reconciled",
+		"gvk":       configMapGVK.String(),
+		"namespace": "default",
+		"name":      "loud",
+		"attempt":   int64(1),
+		"extra":     true,
+	} {
+		if record[key] != expected {
+			t.Errorf("expected %s to be %v, got %v", key, expected, record[key])
+		}
+	}
+}
+
+func TestDefaultLoggerWritesDebugf(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		logged []string
+	)
+	debugf := log.Debugf
+	log.Debugf = func(message string, obj ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		logged = append(logged, fmt.Sprintf(message, obj...))
+	}
+	t.Cleanup(func() {
+		log.Debugf = debugf
+	})
+
+	r, b := newTestRouter(t, configMap("default", "a"))
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	// The debug records of the default logger reach Debugf once it is replaced, as the printf style logs did.
+	if err := b.dispatch(configMapGVK, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, line := range logged {
+		if strings.HasPrefix(line, "Handling") && strings.Contains(line, "namespace=default name=a") {
+			return
+		}
+	}
+	t.Fatalf("expected the request to be logged with Debugf, got %q", logged)
+}
@@ -1,8 +1,12 @@
 package router
 
 import (
+	"slices"
+
+	"github.com/obot-platform/nah/pkg/backend"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -11,6 +15,8 @@ type objectMatcher struct {
 	Name      string
 	Selector  labels.Selector
 	Fields    fields.Selector
+	// indexers extract the values of the indexed fields of the type, see Router.Indexer.
+	indexers map[string]kclient.IndexerFunc
 }
 
 func (o *objectMatcher) String() string {
@@ -66,19 +72,40 @@ func (o *objectMatcher) Match(ns, name string, obj kclient.Object) bool {
 			selectorMatches = o.Selector.Matches(labels.Set(obj.GetLabels()))
 		}
 		if o.Fields != nil {
-			if i, ok := obj.(fields.Fields); ok {
-				fieldMatches = o.Fields.Matches(i)
-			}
+			fieldMatches = o.fieldsMatch(obj)
 		}
 		return selectorMatches && fieldMatches
 	}
-	if o.Fields != nil {
-		if obj == nil {
-			return false
+	return o.Namespace == "" || o.Namespace == ns
+}
+
+// fieldsMatch matches the field selector with the values of the indexed fields, including the owner UID index of the
+// backend, and of the fields of objects that implement fields.Fields. Fields that are neither are assumed to match.
+func (o *objectMatcher) fieldsMatch(obj kclient.Object) bool {
+	f, hasFields := obj.(fields.Fields)
+	for _, req := range o.Fields.Requirements() {
+		var values []string
+		if req.Field == backend.OwnerUIDIndexField {
+			for _, ref := range obj.GetOwnerReferences() {
+				values = append(values, string(ref.UID))
+			}
+		} else if extract, ok := o.indexers[req.Field]; ok {
+			values = extract(obj)
+		} else if hasFields {
+			values = []string{f.Get(req.Field)}
+		} else {
+			continue
 		}
-		if i, ok := obj.(fields.Fields); ok {
-			return o.Fields.Matches(i)
+		switch req.Operator {
+		case selection.Equals, selection.DoubleEquals:
+			if !slices.Contains(values, req.Value) {
+				return false
+			}
+		case selection.NotEquals:
+			if slices.Contains(values, req.Value) {
+				return false
+			}
 		}
 	}
-	return o.Namespace == "" || o.Namespace == ns
+	return true
 }
@@ -0,0 +1,22 @@
+package router
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var parkedKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "nah_retry_budget_parked_keys",
+	Help: "Number of failed keys waiting for the retry budget of a route.",
+}, []string{"route"})
+
+var reconcileLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "nah_reconcile_latency_seconds",
+	Help:    "Time from a watch event being observed to the start of its reconcile.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+}, []string{"gvk"})
+
+func init() {
+	metrics.Registry.MustRegister(parkedKeys, reconcileLatency, delayed, stateEvictions,
+		adaptiveWorkers, adaptiveDuration, adaptiveErrorRate, adaptiveDecisions, pausedObjects, pausedReconciles)
+}
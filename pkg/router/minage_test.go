@@ -0,0 +1,67 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestMinAgeFilter(t *testing.T) {
+	now := time.Now()
+	c := testingclock.NewFakePassiveClock(now)
+
+	for name, test := range map[string]struct {
+		created  time.Duration
+		deleting bool
+		updated  bool
+		deleted  bool
+		called   bool
+		delay    time.Duration
+	}{
+		"new object":      {created: -time.Second, delay: 4 * time.Second},
+		"old enough":      {created: -5 * time.Second, called: true},
+		"update of a new": {created: -time.Second, updated: true, called: true},
+		"deleting":        {created: -time.Second, deleting: true, called: true},
+		"deleted":         {deleted: true, called: true},
+		// The creation timestamp is ahead of the local clock, so the age is treated as zero.
+		"clock skew": {created: time.Minute, delay: 5 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var called bool
+			f := MinAgeFilter{
+				Next: HandlerFunc(func(req Request, resp Response) error {
+					called = true
+					return nil
+				}),
+				MinAge: 5 * time.Second,
+				Clock:  c,
+			}
+
+			var req Request
+			if !test.deleted {
+				cm := configMap("default", "a")
+				cm.CreationTimestamp = metav1.NewTime(now.Add(test.created))
+				if test.deleting {
+					cm.DeletionTimestamp = &metav1.Time{Time: now}
+				}
+				req.Object = cm
+			}
+			if test.updated {
+				req.oldObject = configMap("default", "a")
+			}
+
+			resp := &ResponseWrapper{}
+			if err := f.Handle(req, resp); err != nil {
+				t.Fatal(err)
+			}
+			if called != test.called {
+				t.Fatalf("expected the handler to be called: %v, got %v", test.called, called)
+			}
+			if resp.Delay != test.delay {
+				t.Fatalf("expected a delay of %s, got %s", test.delay, resp.Delay)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WithOldObject sets the OldObject of the requests of this route that were enqueued by an update of the object to a
+// copy of the version of the object before the update, so that the handler can compute what changed, such as the
+// labels or finalizers that were removed. The OldObject is nil for adds, triggers and requeues.
+//
+// Updates that arrive while the key is waiting in the queue are coalesced into a single request, whose OldObject is the
+// version before the earliest of them. If the earliest event was an add, the OldObject is nil even if it was followed
+// by updates. The OldObject can be set for a request of a deleted object if it was updated before it was deleted.
+//
+// Old objects are only kept for the types that have a route with WithOldObject, because keeping them doubles the memory
+// used by the pending keys of the type.
+func (r RouteBuilder) WithOldObject() RouteBuilder {
+	r.oldObject = true
+	return r
+}
+
+// OldObjectHandler sets the OldObject of the request to a copy of the old object of the update that enqueued it.
+type OldObjectHandler struct {
+	Next Handler
+}
+
+func (o OldObjectHandler) Handle(req Request, resp Response) error {
+	if obj, ok := req.oldObject.(kclient.Object); ok {
+		req.OldObject = obj.DeepCopyObject().(kclient.Object)
+	}
+	return o.Next.Handle(req, resp)
+}
+
+// trackOldObjects makes the backend keep the old objects of the updates of the type.
+func (m *HandlerSet) trackOldObjects(gvk schema.GroupVersionKind) {
+	tracker, ok := m.backend.(backend.OldObjectTracker)
+	if !ok {
+		log.Warnf("The backend of router [%s] doesn't track old objects, the requests of [%s] won't have an OldObject", m.name, gvk)
+		return
+	}
+	if err := tracker.TrackOldObjects(gvk); err != nil {
+		log.Errorf("Failed to track the old objects of [%s] in router [%s]: %v", gvk, m.name, err)
+	}
+}
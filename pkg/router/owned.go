@@ -0,0 +1,65 @@
+package router
+
+import (
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/untriggered"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ListOwned populates the list with the objects of its type that have an owner reference to the Request's object. For
+// a namespaced object, only its namespace is listed. The objects are looked up with the owner index of the cache,
+// falling back to listing and filtering every object of the type if the index isn't available. Changes to objects of
+// the list's type also trigger the Request's object.
+func ListOwned(req Request, list kclient.ObjectList) error {
+	if req.Object == nil {
+		return meta.SetList(list, nil)
+	}
+
+	uid := req.Object.GetUID()
+	namespace := req.Object.GetNamespace()
+
+	listed := list
+	if c, ok := req.Client.(*client); ok {
+		// Register the trigger without the field selector, so that objects that are no longer owned also trigger the
+		// Request's object.
+		if err := c.reader.registry.Watch(list, namespace, "", nil, nil); err != nil {
+			return err
+		}
+		listed = untriggered.List(list)
+	}
+
+	err := req.Client.List(req.Ctx, listed, &kclient.ListOptions{
+		Namespace:     namespace,
+		FieldSelector: fields.OneTermEqualSelector(backend.OwnerUIDIndexField, string(uid)),
+	})
+	if err != nil {
+		// The index is not available, such as for an uncached list, so take the slow path.
+		if err := req.Client.List(req.Ctx, listed, &kclient.ListOptions{Namespace: namespace}); err != nil {
+			return err
+		}
+	}
+
+	// Filter even when the index was used, because a cached list can include recently written objects regardless of
+	// the field selector.
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	owned := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		child, ok := obj.(kclient.Object)
+		if !ok {
+			continue
+		}
+		for _, ref := range child.GetOwnerReferences() {
+			if ref.UID == uid {
+				owned = append(owned, obj)
+				break
+			}
+		}
+	}
+	return meta.SetList(list, owned)
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"sync"
+
+	nahname "github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PausedAnnotation pauses the reconcile of an object when it is set to "true", such as while an operator intervenes
+// by hand. The handlers of a paused object are not called and its changes are not saved, but the objects that it
+// triggers are still triggered. Removing the annotation is an update of the object, so it is reconciled right away.
+// Objects being deleted are reconciled even if they are paused, so that their finalizers are removed.
+const PausedAnnotation = "nah.obot.ai/paused"
+
+var (
+	pausedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nah_paused_objects",
+		Help: "Number of objects whose reconcile was skipped because they are paused.",
+	}, []string{"router", "gvk"})
+	pausedReconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nah_paused_reconciles_total",
+		Help: "Number of reconciles skipped because the object is paused.",
+	}, []string{"router", "gvk"})
+)
+
+// WithOnPaused calls f for every reconcile that is skipped because the object is paused with PausedAnnotation. The
+// request is the one the handlers would have been called with, and f must not modify its object.
+func WithOnPaused(f func(req Request)) Option {
+	return func(r *Router) {
+		r.handlers.paused.lock.Lock()
+		defer r.handlers.paused.lock.Unlock()
+		r.handlers.paused.hooks = append(r.handlers.paused.hooks, f)
+	}
+}
+
+type pausedKeys struct {
+	lock  sync.Mutex
+	keys  map[limiterKey]struct{}
+	hooks []func(req Request)
+}
+
+// IsPaused returns true if the object is paused with PausedAnnotation and is not being deleted.
+func IsPaused(obj kclient.Object) bool {
+	return obj != nil && obj.GetDeletionTimestamp().IsZero() && obj.GetAnnotations()[PausedAnnotation] == "true"
+}
+
+// skipPaused returns true if the reconcile of the request is skipped because its object is paused. The retries of a
+// paused key are reset, so that a key paused while waiting for a retry starts over once it is resumed.
+func (m *HandlerSet) skipPaused(req Request) bool {
+	lKey := limiterKey{key: req.Key, gvk: req.GVK}
+	labels := prometheus.Labels{"router": nahname.MetricLabel(m.name), "gvk": req.GVK.String()}
+
+	m.paused.lock.Lock()
+	_, wasPaused := m.paused.keys[lKey]
+	paused := IsPaused(req.Object)
+	switch {
+	case paused && !wasPaused:
+		if m.paused.keys == nil {
+			m.paused.keys = map[limiterKey]struct{}{}
+		}
+		m.paused.keys[lKey] = struct{}{}
+		pausedObjects.With(labels).Inc()
+	case !paused && wasPaused:
+		delete(m.paused.keys, lKey)
+		pausedObjects.With(labels).Dec()
+	}
+	hooks := m.paused.hooks
+	m.paused.lock.Unlock()
+
+	if !paused {
+		if wasPaused {
+			req.Logger().Info("Resuming the reconcile")
+		}
+		return false
+	}
+
+	if wasPaused {
+		req.Logger().Debug("Skipping the reconcile of paused object")
+	} else {
+		req.Logger().Info("Pausing the reconcile", "annotation", PausedAnnotation)
+	}
+	pausedReconciles.With(labels).Inc()
+	m.backoff.reset(lKey)
+	for _, hook := range hooks {
+		hook(req)
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func pausedConfigMap(namespace, name string) *corev1.ConfigMap {
+	cm := configMap(namespace, name)
+	cm.Annotations = map[string]string{PausedAnnotation: "true"}
+	return cm
+}
+
+func TestPausedObjectBeingDeletedIsFinalized(t *testing.T) {
+	obj := pausedConfigMap("default", "a")
+	obj.Finalizers = []string{"nah.io/test"}
+	now := metav1.NewTime(time.Now())
+	obj.DeletionTimestamp = &now
+	r, b := newTestRouter(t, obj)
+
+	var finalized bool
+	r.Type(configMap("", "")).FinalizeFunc("nah.io/test", func(req Request, resp Response) error {
+		finalized = true
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if !finalized {
+		t.Fatal("expected the paused object being deleted to be finalized")
+	}
+	// Removing the last finalizer of the object deletes it.
+	if err := b.Get(context.Background(), kclient.ObjectKeyFromObject(obj), &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the object to be deleted once its finalizer is removed, got %v", err)
+	}
+}
+
+func TestPauseKeyWaitingForRetry(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithBackoff(time.Second, time.Minute)(r)
+	WithBackoffJitter(0)(r)
+
+	var calls int
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		calls++
+		return errors.New("failed")
+	})
+	startTestRouter(t, r)
+
+	lKey := limiterKey{key: "default/a", gvk: configMapGVK}
+	for i := 0; i < 2; i++ {
+		if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); !backend.IsRequeued(err) {
+			t.Fatalf("expected a requeued error, got %v", err)
+		}
+	}
+	b.triggered()
+	if retries := r.handlers.backoff.retriesOf(lKey); retries != 2 {
+		t.Fatalf("expected 2 retries, got %d", retries)
+	}
+
+	setPaused := func(paused bool) {
+		t.Helper()
+		var cm corev1.ConfigMap
+		if err := b.Get(context.Background(), kclient.ObjectKey{Namespace: "default", Name: "a"}, &cm); err != nil {
+			t.Fatal(err)
+		}
+		cm.Annotations = map[string]string{}
+		if paused {
+			cm.Annotations[PausedAnnotation] = "true"
+		}
+		if err := b.Update(context.Background(), &cm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The retry of the paused key is dispatched, skipped, and not requeued.
+	setPaused(true)
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatalf("expected the paused reconcile to be skipped, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the handler not to be called while paused, got %d calls", calls)
+	}
+	if triggers := b.triggered(); len(triggers) != 0 {
+		t.Fatalf("expected the paused key not to be requeued, got %v", triggers)
+	}
+	if retries := r.handlers.backoff.retriesOf(lKey); retries != 0 {
+		t.Fatalf("expected the retries of the paused key to be reset, got %d", retries)
+	}
+
+	// Once resumed, the key starts over from the first retry.
+	setPaused(false)
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); !backend.IsRequeued(err) {
+		t.Fatalf("expected a requeued error, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected the handler to be called once resumed, got %d calls", calls)
+	}
+	if triggers := b.triggered(); len(triggers) != 1 || triggers[0].delay != time.Second {
+		t.Fatalf("expected a requeue after the first back off, got %v", triggers)
+	}
+}
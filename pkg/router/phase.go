@@ -0,0 +1,126 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+)
+
+// Phase is the stage of its lifecycle that a router is in.
+type Phase string
+
+const (
+	// PhaseStarting is the phase of a router that has not started its handlers, including a router waiting to become
+	// the leader.
+	PhaseStarting Phase = "Starting"
+	// PhaseSyncing is the phase of a router that is syncing its caches and running its PosStart functions.
+	PhaseSyncing Phase = "Syncing"
+	// PhaseRunning is the phase of a router that is dispatching keys to its handlers.
+	PhaseRunning Phase = "Running"
+	// PhaseDraining is the phase of a router that is stopping and waiting for its running handlers to return.
+	PhaseDraining Phase = "Draining"
+	// PhaseStopped is the phase of a router whose handlers have all returned after it stopped.
+	PhaseStopped Phase = "Stopped"
+)
+
+type lifecycle struct {
+	lock      sync.Mutex
+	phase     Phase
+	ready     chan struct{}
+	readyOnce sync.Once
+	stopOnce  sync.Once
+
+	stopping      bool
+	stopHooks     []StopHook
+	stopHooksOnce sync.Once
+	stopErr       error
+	// shutdownDeadline is when the shutdown timeout expires, set when the router begins to stop.
+	shutdownDeadline time.Time
+	// cancelRun cancels the context of the handlers of a router started without leader election.
+	cancelRun context.CancelFunc
+}
+
+// Phase returns the current phase of the router.
+func (r *Router) Phase() Phase {
+	r.lifecycle.lock.Lock()
+	defer r.lifecycle.lock.Unlock()
+	if r.lifecycle.phase == "" {
+		return PhaseStarting
+	}
+	return r.lifecycle.phase
+}
+
+// Ready returns a channel that is closed once the router is running: its caches are synced and its PosStart functions
+// have returned. Routes registered after that don't reopen the channel, their types are synced in the background.
+func (r *Router) Ready() <-chan struct{} {
+	return r.lifecycle.ready
+}
+
+// Stopped returns a channel that is closed once the router has stopped and all of its running handlers have returned.
+func (r *Router) Stopped() <-chan struct{} {
+	return r.signalStopped
+}
+
+func (r *Router) setPhase(phase Phase) {
+	r.lifecycle.lock.Lock()
+	defer r.lifecycle.lock.Unlock()
+	if r.lifecycle.phase == PhaseDraining || r.lifecycle.phase == PhaseStopped {
+		// A router that is stopping doesn't go back to running, even if the start finishes late.
+		if phase != PhaseStopped {
+			return
+		}
+	}
+	r.lifecycle.phase = phase
+	log.Infof("Router [%s] is %s", r.handlers.name, phase)
+	if phase == PhaseRunning {
+		r.lifecycle.readyOnce.Do(func() { close(r.lifecycle.ready) })
+	}
+}
+
+// drain waits for the router to stop, either from the context or from a termination signal caught by the leader
+// election, then for its running handlers to return, and then runs the OnStop hooks. The handlers that are still
+// running after the shutdown timeout are aborted, and the router stops without the ones that don't return within the
+// abort grace period.
+func (r *Router) drain(ctx context.Context, terminated <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+	case <-terminated:
+	}
+	r.setPhase(PhaseDraining)
+	r.handlers.freezer.close()
+
+	waitCtx, cancel := r.shutdownContext(context.Background())
+	defer cancel()
+	if err := r.handlers.freezer.wait(waitCtx); err != nil {
+		// The aborted handlers see their context canceled and their writes fail, so they return promptly.
+		r.AbortHandlers(fmt.Errorf("handlers did not return within the shutdown timeout: %w", err))
+		r.waitAborted()
+	}
+	if r.handlers.invariants != nil {
+		r.handlers.reportInvariants(r.handlers.checkInvariants(true))
+	}
+	_ = r.runStopHooks(waitCtx)
+
+	r.lifecycle.lock.Lock()
+	cancelRun := r.lifecycle.cancelRun
+	r.lifecycle.lock.Unlock()
+	if cancelRun != nil {
+		cancelRun()
+	}
+	r.setPhase(PhaseStopped)
+	r.lifecycle.stopOnce.Do(func() { close(r.signalStopped) })
+}
+
+// waitAborted waits for the aborted handlers to return, at most for the abort grace period: a handler that ignores
+// its context would otherwise hold up the shutdown forever. The writes of the handlers that are left running fail.
+func (r *Router) waitAborted() {
+	grace := min(abortGracePeriod, r.shutdownTimeoutOrDefault())
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := r.handlers.freezer.wait(ctx); err != nil {
+		log.Warnf("Aborted handlers of router [%s] did not return within %s, stopping without them", r.handlers.name, grace)
+	}
+}
@@ -0,0 +1,210 @@
+package router
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+)
+
+const quarantineBuckets = 10
+
+// QuarantinePolicy slows down the requeues of a namespace in which most reconciles are failing, so that a broken
+// namespace doesn't drown out the healthy ones. Changes to objects in a quarantined namespace are still reconciled
+// immediately and failed keys are still retried, only later, so the router notices when the namespace recovers.
+type QuarantinePolicy struct {
+	// Window is the sliding window over which the failure ratio is computed. Defaults to 5 minutes.
+	Window time.Duration
+	// FailureRatio is the ratio of failed reconciles in the window above which a namespace is quarantined. Defaults
+	// to 0.8.
+	FailureRatio float64
+	// MinReconciles is the number of reconciles in the window below which a namespace is never quarantined. Defaults
+	// to 20.
+	MinReconciles int
+	// Multiplier is applied to the RetryAfter delays of keys in a quarantined namespace. Defaults to 10.
+	Multiplier float64
+	// FailedDelay is the least a key that failed in a quarantined namespace waits before it is retried. Keys whose
+	// usual back off is longer wait for the back off. Defaults to 1 minute.
+	FailedDelay time.Duration
+}
+
+func (p QuarantinePolicy) complete() QuarantinePolicy {
+	if p.Window <= 0 {
+		p.Window = 5 * time.Minute
+	}
+	if p.FailureRatio <= 0 {
+		p.FailureRatio = 0.8
+	}
+	if p.MinReconciles <= 0 {
+		p.MinReconciles = 20
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 10
+	}
+	if p.FailedDelay <= 0 {
+		p.FailedDelay = time.Minute
+	}
+	return p
+}
+
+// WithNamespaceQuarantine enables the quarantine of namespaces in which most reconciles fail. Cluster scoped objects are
+// never quarantined.
+func WithNamespaceQuarantine(policy QuarantinePolicy) Option {
+	return func(r *Router) {
+		r.handlers.quarantine = &quarantine{
+			policy:     policy.complete(),
+			namespaces: map[string]*namespaceOutcomes{},
+		}
+	}
+}
+
+// QuarantinedNamespaces returns the namespaces that are currently quarantined.
+func (r *Router) QuarantinedNamespaces() []string {
+	return r.handlers.quarantine.list()
+}
+
+type quarantine struct {
+	policy QuarantinePolicy
+
+	lock       sync.Mutex
+	namespaces map[string]*namespaceOutcomes
+	// lastSweep is when the namespaces without reconciles in the window were last removed.
+	lastSweep time.Time
+}
+
+type namespaceOutcomes struct {
+	buckets     [quarantineBuckets]outcomeBucket
+	quarantined bool
+}
+
+type outcomeBucket struct {
+	start         time.Time
+	total, failed int
+}
+
+// record adds the outcome of a reconcile in the namespace and updates the quarantine of the namespace.
+func (q *quarantine) record(name, namespace string, now time.Time, failed bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.sweep(now)
+	ns, ok := q.namespaces[namespace]
+	if !ok {
+		ns = &namespaceOutcomes{}
+		q.namespaces[namespace] = ns
+	}
+
+	width := max(q.policy.Window/quarantineBuckets, 1)
+	start := now.Truncate(width)
+	b := &ns.buckets[(start.UnixNano()/int64(width))%quarantineBuckets]
+	if !b.start.Equal(start) {
+		*b = outcomeBucket{start: start}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+
+	var total, failures int
+	for _, b := range ns.buckets {
+		if now.Sub(b.start) < q.policy.Window {
+			total += b.total
+			failures += b.failed
+		}
+	}
+
+	quarantined := total >= q.policy.MinReconciles && float64(failures)/float64(total) > q.policy.FailureRatio
+	if quarantined && !ns.quarantined {
+		log.Warnf("Quarantining namespace [%s] in router [%s]: %d of %d reconciles failed in the last %s", namespace, name, failures, total, q.policy.Window)
+	} else if !quarantined && ns.quarantined {
+		log.Infof("Lifting quarantine of namespace [%s] in router [%s]: %d of %d reconciles failed in the last %s", namespace, name, failures, total, q.policy.Window)
+	}
+	ns.quarantined = quarantined
+}
+
+// sweep removes the namespaces that had no reconcile in the window, at most once per window, so that the outcomes of
+// deleted or idle namespaces are not kept forever. They are not quarantined, because the window has no failures.
+func (q *quarantine) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < q.policy.Window {
+		return
+	}
+	q.lastSweep = now
+	for name, ns := range q.namespaces {
+		active := false
+		for _, b := range ns.buckets {
+			if now.Sub(b.start) < q.policy.Window {
+				active = true
+				break
+			}
+		}
+		if !active {
+			if ns.quarantined {
+				log.Infof("Lifting quarantine of namespace [%s]: no reconciles in the last %s", name, q.policy.Window)
+			}
+			delete(q.namespaces, name)
+		}
+	}
+}
+
+func (q *quarantine) quarantined(namespace string) bool {
+	if q == nil || namespace == "" {
+		return false
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ns, ok := q.namespaces[namespace]
+	return ok && ns.quarantined
+}
+
+func (q *quarantine) list() []string {
+	if q == nil {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var result []string
+	for name, ns := range q.namespaces {
+		if ns.quarantined {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// applyQuarantine records the outcome of the reconcile of a namespaced key. If the namespace is quarantined and the
+// reconcile failed, the error is returned as a backend.MinDelayError, so that the backend retries the key after the
+// longer of the FailedDelay of the policy and its usual back off, which it keeps counting.
+func (m *HandlerSet) applyQuarantine(req Request, resp *response, err error) error {
+	if m.quarantine == nil || req.Namespace == "" {
+		return err
+	}
+
+	failed := err != nil || resp.failed
+	m.quarantine.record(m.name, req.Namespace, m.clock.Now(), failed)
+	if err == nil || backend.IsRequeued(err) || !m.quarantine.quarantined(req.Namespace) {
+		// The keys requeued by the back off of WithBackoff already waited for the FailedDelay.
+		return err
+	}
+
+	log.Errorf("Namespace [%s] is quarantined, retrying [%s] [%v] in at least %s: %v", req.Namespace, req.Key, req.GVK, m.quarantine.policy.FailedDelay, err)
+	return &backend.MinDelayError{Err: err, Delay: m.quarantine.policy.FailedDelay}
+}
+
+// failedDelay returns the FailedDelay of the policy if the namespace is quarantined, zero otherwise.
+func (q *quarantine) failedDelay(namespace string) time.Duration {
+	if !q.quarantined(namespace) {
+		return 0
+	}
+	return q.policy.FailedDelay
+}
+
+// quarantineDelay multiplies the RetryAfter delay of a key in a quarantined namespace.
+func (m *HandlerSet) quarantineDelay(namespace string, delay time.Duration) time.Duration {
+	if delay <= 0 || !m.quarantine.quarantined(namespace) {
+		return delay
+	}
+	return time.Duration(float64(delay) * m.quarantine.policy.Multiplier)
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestQuarantineKeepsBackoff(t *testing.T) {
+	r, b := newTestRouter(t, configMap("broken", "a"))
+	WithNamespaceQuarantine(QuarantinePolicy{MinReconciles: 1, FailedDelay: time.Minute})(r)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return errors.New("failed")
+	})
+	startTestRouter(t, r)
+
+	// The first failure quarantines the namespace, so it is already retried after the FailedDelay.
+	err := b.dispatch(configMapGVK, ReplayPrefix+"broken/a")
+	if delay := backend.MinDelay(err); delay != time.Minute {
+		t.Fatalf("expected a minimum delay of a minute, got %s for %v", delay, err)
+	}
+	if backend.IsRequeued(err) {
+		t.Fatal("expected the backend to requeue the key with its back off")
+	}
+	if namespaces := r.QuarantinedNamespaces(); len(namespaces) != 1 || namespaces[0] != "broken" {
+		t.Fatalf("expected the namespace to be quarantined, got %v", namespaces)
+	}
+}
+
+func TestQuarantineWithBackoff(t *testing.T) {
+	r, b := newTestRouter(t, configMap("broken", "a"))
+	WithNamespaceQuarantine(QuarantinePolicy{MinReconciles: 1, FailedDelay: 10 * time.Second})(r)
+	WithBackoff(time.Second, time.Hour)(r)
+	WithBackoffJitter(0)(r)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return errors.New("failed")
+	})
+	startTestRouter(t, r)
+
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		if err := b.dispatch(configMapGVK, ReplayPrefix+"broken/a"); !backend.IsRequeued(err) {
+			t.Fatalf("expected a requeued error, got %v", err)
+		}
+		for _, trigger := range b.triggered() {
+			delays = append(delays, trigger.delay)
+		}
+	}
+
+	// The first failure is before the quarantine, then the longer of the back off and the FailedDelay is used.
+	expected := []time.Duration{time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second, 16 * time.Second, 32 * time.Second}
+	if len(delays) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, delays)
+	}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, delays)
+		}
+	}
+}
+
+func TestQuarantineSweep(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	q := &quarantine{
+		policy:     QuarantinePolicy{MinReconciles: 1}.complete(),
+		namespaces: map[string]*namespaceOutcomes{},
+	}
+	q.record("test", "old", clock.Now(), true)
+	if !q.quarantined("old") {
+		t.Fatal("expected the namespace to be quarantined")
+	}
+
+	clock.Step(2 * q.policy.Window)
+	q.record("test", "new", clock.Now(), false)
+	if _, ok := q.namespaces["old"]; ok {
+		t.Fatal("expected the idle namespace to be removed")
+	}
+	if q.quarantined("old") {
+		t.Fatal("expected the idle namespace to not be quarantined")
+	}
+}
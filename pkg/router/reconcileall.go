@@ -0,0 +1,227 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ReconcileAllResult counts the outcomes of the keys of a ReconcileAll.
+type ReconcileAllResult struct {
+	// Total is the number of keys that were enqueued.
+	Total int
+	// Succeeded is the number of keys that were reconciled without error, possibly after retries.
+	Succeeded int
+	// Failed is the number of keys whose reconcile failed with a terminal error, one that implements Terminal() bool
+	// such as a conditions.ErrTerminal. Keys that fail with other errors are retried as usual and stay pending until
+	// they succeed or fail terminally.
+	Failed int
+	// Deleted is the number of keys whose object was deleted before it was reconciled.
+	Deleted int
+	// Pending is the number of keys that had not been reconciled, or were still being retried, when the wait returned
+	// early.
+	Pending int
+}
+
+// reconcileAllBatch is the most keys of the type that may be waiting in the queue when ReconcileAll enqueues more of
+// its keys, so that the keys enqueued by changes in the meantime don't wait behind the whole pass.
+const reconcileAllBatch = 10
+
+// reconcileAllInterval is how often ReconcileAll checks the queue for room for more of its keys.
+const reconcileAllInterval = 100 * time.Millisecond
+
+// WaitFunc blocks until every key of a ReconcileAll has been reconciled without error, failed terminally or was
+// deleted, or until ctx is done, in which case the counts so far are returned with the error of ctx.
+type WaitFunc func(ctx context.Context) (ReconcileAllResult, error)
+
+// ReconcileAll enqueues every key of the GVK that is in the cache at a low priority, and returns a function that waits
+// for each of those keys to be reconciled without error or to fail terminally. Only reconciles that start after
+// ReconcileAll is called are counted, and a key that was already queued is counted once.
+//
+// The keys are enqueued a few at a time, whenever fewer than reconcileAllBatch keys of the type are waiting, so that
+// keys enqueued by changes are not queued behind the whole pass. Backends that can't list their pending keys get all
+// the keys at once. The keys that are not enqueued yet when ctx is done are not enqueued. An error is returned if the
+// GVK is not handled by the router.
+func (r *Router) ReconcileAll(ctx context.Context, gvk schema.GroupVersionKind) (WaitFunc, error) {
+	if !r.handlers.handlers.Handles(Request{GVK: gvk}) {
+		return nil, fmt.Errorf("no handler is registered for %s", gvk)
+	}
+
+	informer, err := r.handlers.backend.GetInformerForKind(ctx, gvk)
+	if err != nil {
+		return nil, err
+	}
+	keys := informer.GetStore().ListKeys()
+
+	p := &reconcilePass{
+		gvk:     gvk,
+		start:   r.handlers.clock.Now(),
+		pending: make(map[string]struct{}, len(keys)),
+		done:    make(chan struct{}),
+	}
+	for _, key := range keys {
+		p.pending[key] = struct{}{}
+	}
+	p.result.Total = len(keys)
+	r.handlers.passes.add(p)
+
+	p.checkDone()
+	go r.handlers.enqueuePass(ctx, p, keys)
+
+	return func(ctx context.Context) (ReconcileAllResult, error) {
+		select {
+		case <-p.done:
+			return p.snapshot(), nil
+		case <-ctx.Done():
+			return p.snapshot(), ctx.Err()
+		}
+	}, nil
+}
+
+// enqueuePass enqueues the keys of the pass whenever there is room in the queue of the type.
+func (m *HandlerSet) enqueuePass(ctx context.Context, p *reconcilePass, keys []string) {
+	for len(keys) > 0 {
+		room := len(keys)
+		if _, ok := m.backend.(backend.PendingEnqueueLister); ok {
+			room = min(room, reconcileAllBatch-m.waitingKeys(p.gvk))
+		}
+		if room <= 0 {
+			select {
+			case <-ctx.Done():
+				// The pass can't complete without the rest of its keys.
+				m.passes.remove(p)
+				return
+			case <-m.clock.After(reconcileAllInterval):
+			}
+			continue
+		}
+		for _, key := range keys[:room] {
+			if err := m.backend.Trigger(p.gvk, key, 0); err != nil {
+				log.Errorf("Failed to enqueue [%s] [%s] for ReconcileAll: %v", key, p.gvk, err)
+				if p.record(key, false, &terminalError{err: err}) {
+					p.doneOnce.Do(func() { close(p.done) })
+				}
+			} else {
+				m.traceEnqueue(p.gvk, key, 0, "ReconcileAll")
+			}
+		}
+		keys = keys[room:]
+	}
+}
+
+// terminalError counts a key whose enqueue failed as failed.
+type terminalError struct {
+	err error
+}
+
+func (e *terminalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *terminalError) Terminal() bool {
+	return true
+}
+
+// isTerminal returns true if the error, or an error it wraps, implements Terminal() bool and returns true.
+func isTerminal(err error) bool {
+	var terminal interface {
+		Terminal() bool
+	}
+	return errors.As(err, &terminal) && terminal.Terminal()
+}
+
+type reconcilePass struct {
+	gvk   schema.GroupVersionKind
+	start time.Time
+
+	lock     sync.Mutex
+	pending  map[string]struct{}
+	result   ReconcileAllResult
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (p *reconcilePass) snapshot() ReconcileAllResult {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := p.result
+	result.Pending = len(p.pending)
+	return result
+}
+
+// record counts the outcome of the key and returns true when every key of the pass has been reconciled. A key that
+// failed with an error that isn't terminal stays pending for its retries.
+func (p *reconcilePass) record(key string, deleted bool, err error) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.pending[key]; !ok || (!deleted && err != nil && !isTerminal(err)) {
+		return len(p.pending) == 0
+	}
+	delete(p.pending, key)
+	switch {
+	case deleted:
+		p.result.Deleted++
+	case err != nil:
+		p.result.Failed++
+	default:
+		p.result.Succeeded++
+	}
+	return len(p.pending) == 0
+}
+
+func (p *reconcilePass) checkDone() {
+	p.lock.Lock()
+	empty := len(p.pending) == 0
+	p.lock.Unlock()
+	if empty {
+		p.doneOnce.Do(func() { close(p.done) })
+	}
+}
+
+type reconcilePasses struct {
+	lock   sync.Mutex
+	passes []*reconcilePass
+}
+
+func (r *reconcilePasses) add(p *reconcilePass) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.passes = append(r.passes, p)
+}
+
+func (r *reconcilePasses) remove(p *reconcilePass) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, existing := range r.passes {
+		if existing == p {
+			r.passes = append(r.passes[:i], r.passes[i+1:]...)
+			return
+		}
+	}
+}
+
+// reconciled records the outcome of a reconcile of the key that started at start in the passes that were created
+// before it. Passes whose keys have all been reconciled are removed.
+func (r *reconcilePasses) reconciled(gvk schema.GroupVersionKind, key string, start time.Time, deleted bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.passes) == 0 {
+		return
+	}
+	remaining := r.passes[:0]
+	for _, p := range r.passes {
+		if p.gvk == gvk && !start.Before(p.start) && p.record(key, deleted, err) {
+			p.doneOnce.Do(func() { close(p.done) })
+			continue
+		}
+		remaining = append(remaining, p)
+	}
+	clear(r.passes[len(remaining):])
+	r.passes = remaining
+}
@@ -0,0 +1,145 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type terminalTestError struct{}
+
+func (terminalTestError) Error() string {
+	return "terminal"
+}
+
+func (terminalTestError) Terminal() bool {
+	return true
+}
+
+func TestReconcileAllWaitsForRetries(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"), configMap("default", "b"), configMap("default", "c"))
+
+	failA := true
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		switch req.Name {
+		case "a":
+			if failA {
+				return errors.New("retriable")
+			}
+		case "b":
+			return terminalTestError{}
+		}
+		return nil
+	})
+	startTestRouter(t, r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	wait, err := r.ReconcileAll(ctx, configMapGVK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys are enqueued by a goroutine.
+	var triggered []string
+	waitFor(t, 10*time.Second, func() bool {
+		for _, trigger := range b.triggered() {
+			triggered = append(triggered, trigger.key)
+		}
+		return len(triggered) == 3
+	})
+	for _, key := range triggered {
+		_ = b.dispatch(configMapGVK, ReplayPrefix+key)
+	}
+
+	early, cancelEarly := context.WithCancel(ctx)
+	cancelEarly()
+	result, err := wait(early)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to end with the context, got %v", err)
+	}
+	if result.Succeeded != 1 || result.Failed != 1 || result.Pending != 1 {
+		t.Fatalf("expected the retriable failure to stay pending, got %+v", result)
+	}
+
+	failA = false
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ReconcileAllResult{Total: 3, Succeeded: 2, Failed: 1}); result != expected {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+// queuedBackend lists a number of other keys waiting in the queue of every type, along with the triggered keys that
+// were not dispatched.
+type queuedBackend struct {
+	*fakeBackend
+	waiting atomic.Int32
+}
+
+func (q *queuedBackend) PendingEnqueues(schema.GroupVersionKind) map[string]backend.EnqueueInfo {
+	result := map[string]backend.EnqueueInfo{}
+	for i := range int(q.waiting.Load()) {
+		result[fmt.Sprintf("other/%d", i)] = backend.EnqueueInfo{}
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, trigger := range q.triggers {
+		result[trigger.key] = backend.EnqueueInfo{}
+	}
+	return result
+}
+
+func (q *queuedBackend) queued() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.triggers)
+}
+
+func TestReconcileAllLowPriority(t *testing.T) {
+	var objs []kclient.Object
+	for i := range 5 {
+		objs = append(objs, configMap("default", fmt.Sprint(i)))
+	}
+	scheme := testScheme(t)
+	b := &queuedBackend{fakeBackend: newFakeBackend(scheme, objs...)}
+	b.waiting.Store(reconcileAllBatch)
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if _, err := r.ReconcileAll(context.Background(), configMapGVK); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * reconcileAllInterval)
+	if triggers := b.triggered(); len(triggers) != 0 {
+		t.Fatalf("expected no keys to be enqueued while the queue is full, got %v", triggers)
+	}
+
+	b.waiting.Store(reconcileAllBatch - 2)
+	waitFor(t, 10*time.Second, func() bool { return b.queued() >= 2 })
+	time.Sleep(2 * reconcileAllInterval)
+	if queued := b.queued(); queued != 2 {
+		t.Fatalf("expected 2 keys to be enqueued in the room of the queue, got %d", queued)
+	}
+
+	// The rest of the keys are enqueued as the queue drains.
+	b.triggered()
+	waitFor(t, 10*time.Second, func() bool { return b.queued() == 2 })
+	b.triggered()
+	waitFor(t, 10*time.Second, func() bool { return b.queued() == 1 })
+}
@@ -0,0 +1,302 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PendingRequeue is a key that a handler asked to be reconciled again later with Response.RetryAfter.
+type PendingRequeue struct {
+	GVK schema.GroupVersionKind `json:"gvk"`
+	Key string                  `json:"key"`
+	Due time.Time               `json:"due"`
+}
+
+// RequeueStore persists the pending requeues of a router so that they survive a restart of the controller.
+type RequeueStore interface {
+	Save(ctx context.Context, requeues []PendingRequeue) error
+	Load(ctx context.Context) ([]PendingRequeue, error)
+}
+
+// WithRequeueStore persists the pending requeues to the store every interval and when the router stops. When the
+// router starts, the stored requeues that are still in the future are scheduled again and the ones for objects that
+// no longer exist are dropped. Requeues are not persisted unless this option is given.
+func WithRequeueStore(store RequeueStore, interval time.Duration) Option {
+	return func(r *Router) {
+		r.handlers.requeues.store = store
+		r.handlers.requeues.interval = interval
+	}
+}
+
+// requeueTracker records the RetryAfter requeues of a router, and the requeues that were canceled but are still in
+// the delayed queue of the backend.
+type requeueTracker struct {
+	store    RequeueStore
+	interval time.Duration
+
+	lock     sync.Mutex
+	pending  map[limiterKey]time.Time
+	canceled map[limiterKey]struct{}
+}
+
+func newRequeueTracker() *requeueTracker {
+	return &requeueTracker{
+		pending:  map[limiterKey]time.Time{},
+		canceled: map[limiterKey]struct{}{},
+	}
+}
+
+// track records that the key will be reconciled at due. The delayed queue only keeps the earliest time for a key, so
+// the earliest is kept here too.
+func (r *requeueTracker) track(gvk schema.GroupVersionKind, key string, due time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	lKey := limiterKey{key: key, gvk: gvk}
+	delete(r.canceled, lKey)
+	if existing, ok := r.pending[lKey]; !ok || due.Before(existing) {
+		r.pending[lKey] = due
+	}
+}
+
+// done forgets the requeue of the key if it is due, or unconditionally if the object is gone.
+func (r *requeueTracker) done(gvk schema.GroupVersionKind, key string, now time.Time, gone bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	lKey := limiterKey{key: key, gvk: gvk}
+	if due, ok := r.pending[lKey]; ok && (gone || !due.After(now)) {
+		delete(r.pending, lKey)
+	}
+}
+
+func (r *requeueTracker) list() []PendingRequeue {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]PendingRequeue, 0, len(r.pending))
+	for k, due := range r.pending {
+		result = append(result, PendingRequeue{GVK: k.gvk, Key: k.key, Due: due})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Due.Before(result[j].Due)
+	})
+	return result
+}
+
+func (m *HandlerSet) restoreRequeues(ctx context.Context) error {
+	stored, err := m.requeues.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load pending requeues: %w", err)
+	}
+
+	handled := map[schema.GroupVersionKind]bool{}
+	for _, gvk := range m.handlers.GVKs() {
+		handled[gvk] = true
+	}
+
+	now := m.clock.Now()
+	for _, requeue := range stored {
+		if !handled[requeue.GVK] || !requeue.Due.After(now) {
+			// Keys that are already due are reconciled by the initial list of the cache.
+			continue
+		}
+
+		obj, err := m.scheme.New(requeue.GVK)
+		if err != nil {
+			continue
+		}
+		ns, name, ok := strings.Cut(requeue.Key, "/")
+		if !ok {
+			name = requeue.Key
+			ns = ""
+		}
+		if err := m.backend.Get(ctx, kclient.ObjectKey{Namespace: ns, Name: name}, obj.(kclient.Object)); apierror.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to restore requeue of [%s] [%s]: %w", requeue.Key, requeue.GVK, err)
+		}
+
+		if err := m.backend.Trigger(requeue.GVK, requeue.Key, requeue.Due.Sub(now)); err != nil {
+			return err
+		}
+		m.traceEnqueue(requeue.GVK, requeue.Key, requeue.Due.Sub(now), "the restore of the requeues")
+		m.requeues.track(requeue.GVK, requeue.Key, requeue.Due)
+	}
+	return nil
+}
+
+func (m *HandlerSet) persistRequeues(ctx context.Context) {
+	save := func(ctx context.Context) {
+		if err := m.requeues.store.Save(ctx, m.requeues.list()); err != nil {
+			log.Errorf("failed to save pending requeues: %v", err)
+		}
+	}
+
+	var tick <-chan time.Time
+	if m.requeues.interval > 0 {
+		ticker := m.clock.NewTicker(m.requeues.interval)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	for {
+		select {
+		case <-tick:
+			save(ctx)
+		case <-ctx.Done():
+			// The context is done, so give the final save its own deadline.
+			saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			save(saveCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// FileRequeueStore stores the pending requeues as JSON in a file, typically on a persistent volume.
+type FileRequeueStore struct {
+	Path string
+}
+
+func (f FileRequeueStore) Save(_ context.Context, requeues []PendingRequeue) error {
+	data, err := json.Marshal(requeues)
+	if err != nil {
+		return err
+	}
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+func (f FileRequeueStore) Load(_ context.Context) ([]PendingRequeue, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var requeues []PendingRequeue
+	return requeues, json.Unmarshal(data, &requeues)
+}
+
+const (
+	requeueStoreLabel      = "nah.obot.ai/requeue-store"
+	requeueChunkAnnotation = "nah.obot.ai/requeue-chunk"
+	requeueDataKey         = "requeues"
+	// defaultRequeueChunkSize keeps each ConfigMap well under the 1MiB limit of an object.
+	defaultRequeueChunkSize = 512 * 1024
+)
+
+// ConfigMapRequeueStore stores the pending requeues in ConfigMaps named <Name>-<n> in the namespace. The requeues are
+// split across as many ConfigMaps as needed to keep each under ChunkSize bytes of data.
+type ConfigMapRequeueStore struct {
+	Client    kclient.Client
+	Namespace string
+	Name      string
+	ChunkSize int
+}
+
+func (c ConfigMapRequeueStore) Save(ctx context.Context, requeues []PendingRequeue) error {
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultRequeueChunkSize
+	}
+
+	var (
+		chunks  [][]PendingRequeue
+		current []PendingRequeue
+		size    int
+	)
+	for _, requeue := range requeues {
+		data, err := json.Marshal(requeue)
+		if err != nil {
+			return err
+		}
+		if size+len(data) > chunkSize && len(current) > 0 {
+			chunks = append(chunks, current)
+			current, size = nil, 0
+		}
+		current = append(current, requeue)
+		size += len(data) + 1
+	}
+	if len(current) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, current)
+	}
+
+	for i, chunk := range chunks {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if err := c.saveChunk(ctx, i, string(data)); err != nil {
+			return err
+		}
+	}
+
+	var existing corev1.ConfigMapList
+	if err := c.Client.List(ctx, &existing, kclient.InNamespace(c.Namespace), kclient.MatchingLabels{requeueStoreLabel: c.Name}); err != nil {
+		return err
+	}
+	for i := range existing.Items {
+		if n, err := strconv.Atoi(existing.Items[i].Annotations[requeueChunkAnnotation]); err == nil && n < len(chunks) {
+			continue
+		}
+		if err := c.Client.Delete(ctx, &existing.Items[i]); err != nil && !apierror.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c ConfigMapRequeueStore) saveChunk(ctx context.Context, i int, data string) error {
+	var cm corev1.ConfigMap
+	err := c.Client.Get(ctx, kclient.ObjectKey{Namespace: c.Namespace, Name: fmt.Sprintf("%s-%d", c.Name, i)}, &cm)
+	if apierror.IsNotFound(err) {
+		return c.Client.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%d", c.Name, i),
+				Namespace:   c.Namespace,
+				Labels:      map[string]string{requeueStoreLabel: c.Name},
+				Annotations: map[string]string{requeueChunkAnnotation: strconv.Itoa(i)},
+			},
+			Data: map[string]string{requeueDataKey: data},
+		})
+	} else if err != nil {
+		return err
+	}
+
+	cm.Data = map[string]string{requeueDataKey: data}
+	return c.Client.Update(ctx, &cm)
+}
+
+func (c ConfigMapRequeueStore) Load(ctx context.Context) ([]PendingRequeue, error) {
+	var existing corev1.ConfigMapList
+	if err := c.Client.List(ctx, &existing, kclient.InNamespace(c.Namespace), kclient.MatchingLabels{requeueStoreLabel: c.Name}); err != nil {
+		return nil, err
+	}
+
+	var result []PendingRequeue
+	for _, cm := range existing.Items {
+		var chunk []PendingRequeue
+		if err := json.Unmarshal([]byte(cm.Data[requeueDataKey]), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid requeue data in ConfigMap %s/%s: %w", cm.Namespace, cm.Name, err)
+		}
+		result = append(result, chunk...)
+	}
+	return result, nil
+}
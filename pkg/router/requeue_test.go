@@ -0,0 +1,124 @@
+package router
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// memRequeueStore is an in memory RequeueStore that sends each save to saves.
+type memRequeueStore struct {
+	lock   sync.Mutex
+	stored []PendingRequeue
+	saves  chan []PendingRequeue
+}
+
+func (m *memRequeueStore) Save(_ context.Context, requeues []PendingRequeue) error {
+	m.lock.Lock()
+	m.stored = requeues
+	m.lock.Unlock()
+	m.saves <- requeues
+	return nil
+}
+
+func (m *memRequeueStore) Load(context.Context) ([]PendingRequeue, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stored, nil
+}
+
+// byKey sorts the requeues by key, the store gets them by due time.
+func byKey(requeues []PendingRequeue) []PendingRequeue {
+	sort.Slice(requeues, func(i, j int) bool {
+		return requeues[i].Key < requeues[j].Key
+	})
+	return requeues
+}
+
+func TestRequeueStore(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now().Truncate(time.Second))
+	start := clock.Now()
+	store := &memRequeueStore{
+		stored: []PendingRequeue{
+			{GVK: configMapGVK, Key: "default/a", Due: start.Add(time.Hour)},
+			// Due requeues are reconciled by the initial list, and requeues of deleted objects and of types
+			// without handlers are dropped.
+			{GVK: configMapGVK, Key: "default/due", Due: start.Add(-time.Second)},
+			{GVK: configMapGVK, Key: "default/gone", Due: start.Add(time.Hour)},
+			{GVK: schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, Key: "default/a", Due: start.Add(time.Hour)},
+		},
+		saves: make(chan []PendingRequeue, 10),
+	}
+
+	scheme := testScheme(t)
+	b := newFakeBackend(scheme, configMap("default", "a"), configMap("default", "b"), configMap("default", "c"), configMap("default", "due"))
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0, WithClock(clock), WithRequeueStore(store, time.Minute))
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		switch req.Name {
+		case "b":
+			resp.RetryAfter(10 * time.Minute)
+		case "c":
+			resp.RetryAfter(time.Minute)
+		}
+		return nil
+	})
+	cancel := startTestRouter(t, r)
+
+	if triggers := b.triggered(); !reflect.DeepEqual(triggers, []fakeTrigger{{gvk: configMapGVK, key: "default/a", delay: time.Hour}}) {
+		t.Fatalf("expected only the pending requeue to be restored, got %v", triggers)
+	}
+
+	if err := b.dispatch(configMapGVK, "default/b"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case saved := <-store.saves:
+		t.Fatalf("expected no save before the interval, got %v", saved)
+	default:
+	}
+
+	expected := []PendingRequeue{
+		{GVK: configMapGVK, Key: "default/a", Due: start.Add(time.Hour)},
+		{GVK: configMapGVK, Key: "default/b", Due: start.Add(10 * time.Minute)},
+	}
+
+	// The ticker of the store is created by the router in the background, so step until it fires.
+	var saved []PendingRequeue
+	for i := 0; saved == nil; i++ {
+		if i == 100 {
+			t.Fatal("expected the requeues to be saved on the interval")
+		}
+		clock.Step(time.Minute)
+		select {
+		case saved = <-store.saves:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if !reflect.DeepEqual(byKey(saved), expected) {
+		t.Fatalf("expected the pending requeues to be saved on the interval, got %v", saved)
+	}
+
+	// The requeues are saved when the router stops, including the ones since the last save.
+	for len(store.saves) > 0 {
+		<-store.saves
+	}
+	if err := b.dispatch(configMapGVK, "default/c"); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, PendingRequeue{GVK: configMapGVK, Key: "default/c", Due: clock.Now().Add(time.Minute)})
+	cancel()
+	select {
+	case saved = <-store.saves:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the requeues to be saved when the router stops")
+	}
+	if !reflect.DeepEqual(byKey(saved), expected) {
+		t.Fatalf("expected the pending requeues to be saved on shutdown, got %v", saved)
+	}
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// ObjectReference is a reference to an object declared in the spec of another object, in the style of
+// corev1.ObjectReference. Only the name is required.
+type ObjectReference struct {
+	// APIVersion is checked against the group of the object the reference is resolved into, if set.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind is checked against the kind of the object the reference is resolved into, if set.
+	Kind string `json:"kind,omitempty"`
+	// Namespace defaults to the namespace of the request. It is ignored for cluster scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (o ObjectReference) key() string {
+	if o.Namespace == "" {
+		return o.Name
+	}
+	return o.Namespace + "/" + o.Name
+}
+
+// ReferenceNotFoundError is returned by Request.Resolve if the referenced object doesn't exist. It unwraps to the
+// NotFound error of the client, so apierrors.IsNotFound is true for it. It is transient: the referenced object may be
+// created later, and its creation enqueues the referrer again.
+type ReferenceNotFoundError struct {
+	GVK schema.GroupVersionKind
+	Ref ObjectReference
+	Err error
+}
+
+func (e *ReferenceNotFoundError) Error() string {
+	return fmt.Sprintf("referenced %s %s not found", e.GVK.Kind, e.Ref.key())
+}
+
+func (e *ReferenceNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// WrongKindError is returned by Request.Resolve if the kind or API group of a reference doesn't match the object it is
+// resolved into. It is terminal because retrying won't fix the reference. The error is recognized as terminal by
+// conditions.ErrorMiddleware.
+type WrongKindError struct {
+	Expected schema.GroupVersionKind
+	Ref      ObjectReference
+}
+
+func (e *WrongKindError) Error() string {
+	return fmt.Sprintf("reference %s to %s %s must be to a %s", e.Ref.Name, e.Ref.APIVersion, e.Ref.Kind, e.Expected.GroupKind())
+}
+
+// Terminal returns true, because the reference must change for the error to go away.
+func (e *WrongKindError) Terminal() bool {
+	return true
+}
+
+// Resolve gets the referenced object into the object. The namespace of the reference defaults to the namespace of the
+// request and the kind and API version of the reference, if set, must match the type of into, although only the group
+// of the API version is compared. Like any read of the request's client, the reference is watched, so changes to the
+// referenced object, including its creation, enqueue the request's object.
+//
+// A *WrongKindError is returned if the type doesn't match and a *ReferenceNotFoundError if the object doesn't exist.
+func (r *Request) Resolve(ref ObjectReference, into kclient.Object) error {
+	if ref.Name == "" {
+		return fmt.Errorf("reference to %s has no name", ref.Kind)
+	}
+
+	gvk, err := apiutil.GVKForObject(into, r.Client.Scheme())
+	if err != nil {
+		return err
+	}
+	if ref.Kind != "" && ref.Kind != gvk.Kind {
+		return &WrongKindError{Expected: gvk, Ref: ref}
+	}
+	if ref.APIVersion != "" {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil || gv.Group != gvk.Group {
+			return &WrongKindError{Expected: gvk, Ref: ref}
+		}
+	}
+
+	namespaced, err := r.Client.IsObjectNamespaced(into)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !namespaced:
+		ref.Namespace = ""
+	case ref.Namespace == "":
+		ref.Namespace = r.Namespace
+	}
+
+	if err := r.Get(into, ref.Namespace, ref.Name); err != nil {
+		if apierrors.IsNotFound(err) {
+			return &ReferenceNotFoundError{GVK: gvk, Ref: ref, Err: err}
+		}
+		return err
+	}
+	return nil
+}
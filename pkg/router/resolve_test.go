@@ -0,0 +1,76 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestResolve(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+
+	ref := ObjectReference{Kind: "ConfigMap", Name: "ref"}
+	var (
+		resolved   *corev1.ConfigMap
+		resolveErr error
+	)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if req.Name != "a" {
+			return nil
+		}
+		resolved = &corev1.ConfigMap{}
+		resolveErr = req.Resolve(ref, resolved)
+		return nil
+	})
+	startTestRouter(t, r)
+
+	// The reference doesn't exist yet.
+	if err := b.dispatch(configMapGVK, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *ReferenceNotFoundError
+	if !errors.As(resolveErr, &notFound) || !apierrors.IsNotFound(resolveErr) {
+		t.Fatalf("expected a ReferenceNotFoundError, got %v", resolveErr)
+	}
+	if notFound.GVK != configMapGVK || notFound.Ref.Namespace != "default" || notFound.Ref.Name != "ref" {
+		t.Fatalf("expected the reference to default to the namespace of the request, got %+v", notFound)
+	}
+	if msg := resolveErr.Error(); msg != "referenced ConfigMap default/ref not found" {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+
+	// The creation of the referenced object enqueues the referrer.
+	ctx := context.Background()
+	referenced := configMap("default", "ref")
+	referenced.Data = map[string]string{"k": "v"}
+	if err := b.Create(ctx, referenced); err != nil {
+		t.Fatal(err)
+	}
+	b.triggered()
+	if err := b.dispatch(configMapGVK, "default/ref"); err != nil {
+		t.Fatal(err)
+	}
+	if triggers := b.triggered(); len(triggers) != 1 || triggers[0].gvk != configMapGVK || triggers[0].key != "default/a" {
+		t.Fatalf("expected the creation of the referenced object to enqueue the referrer, got %v", triggers)
+	}
+
+	if err := b.dispatch(configMapGVK, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if resolveErr != nil || resolved.Name != "ref" || resolved.Data["k"] != "v" {
+		t.Fatalf("expected the reference to be resolved, got %v and %+v", resolveErr, resolved)
+	}
+
+	// A reference to another kind is terminal.
+	ref = ObjectReference{APIVersion: "v1", Kind: "Secret", Name: "ref"}
+	if err := b.dispatch(configMapGVK, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	var wrongKind *WrongKindError
+	if !errors.As(resolveErr, &wrongKind) || !wrongKind.Terminal() || wrongKind.Expected != configMapGVK {
+		t.Fatalf("expected a WrongKindError, got %v", resolveErr)
+	}
+}
@@ -1,12 +1,19 @@
 package router
 
 import (
+	"context"
 	"time"
+
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type ResponseWrapper struct {
 	Delay time.Duration
-	Attr  map[string]any
+	// Retry is true if RetryAfter was called with a zero delay.
+	Retry   bool
+	Attr    map[string]any
+	Commits []func(ctx context.Context) error
+	Objs    []kclient.Object
 }
 
 func (r *ResponseWrapper) Attributes() map[string]any {
@@ -16,6 +23,22 @@ func (r *ResponseWrapper) Attributes() map[string]any {
 	return r.Attr
 }
 
+// RetryAfter records the delay as the response of the router does: the shortest delay is kept, and a zero delay asks
+// for a retry with the back off without clearing a delay.
 func (r *ResponseWrapper) RetryAfter(delay time.Duration) {
-	r.Delay = delay
+	if delay <= 0 {
+		r.Retry = true
+		return
+	}
+	if r.Delay == 0 || delay < r.Delay {
+		r.Delay = delay
+	}
+}
+
+func (r *ResponseWrapper) OnCommit(f func(ctx context.Context) error) {
+	r.Commits = append(r.Commits, f)
+}
+
+func (r *ResponseWrapper) Objects(objs ...kclient.Object) {
+	r.Objs = append(r.Objs, objs...)
 }
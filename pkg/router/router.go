@@ -5,26 +5,67 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/obot-platform/nah/pkg/backend"
 	"github.com/obot-platform/nah/pkg/leader"
-	"github.com/obot-platform/nah/pkg/log"
+	"github.com/obot-platform/nah/pkg/webhook"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/clock"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Router dispatches the changes of objects to the handlers registered for their types.
+//
+// Routes, middleware and triggers can be registered from multiple goroutines, including after the router has started,
+// in which case the new types are watched as they are registered. The scheme is read while routes are registered and
+// is not guarded by the router, so types must be added to the scheme before registering routes for them.
+// Registration.Priority and Indexer fail once the router has started, and functions given to PosStart after the start
+// are called right away.
 type Router struct {
 	RouteBuilder
 
-	OnErrorHandler ErrorHandler
-	handlers       *HandlerSet
-	electionConfig *leader.ElectionConfig
-	startLock      sync.Mutex
-	postStarts     []func(context.Context, kclient.Client)
-	signalStopped  chan struct{}
+	OnErrorHandler  ErrorHandler
+	handlers        *HandlerSet
+	electionConfig  *leader.ElectionConfig
+	startLock       sync.Mutex
+	warmStandby     bool
+	warmTypes       []kclient.Object
+	handlersStarted bool
+	postStartLock   sync.Mutex
+	postStarts      []func(context.Context, kclient.Client)
+	// postStartCtx is the context of the handlers once the PosStart functions have been called.
+	postStartCtx    context.Context
+	signalStopped   chan struct{}
+	lifecycle       lifecycle
+	shutdownTimeout time.Duration
+	onFatal         func(err error)
+	// metricsErr is the error of the registration of the collectors of WithMetrics, returned by Start.
+	metricsErr error
+}
+
+// Option configures optional behavior of a Router.
+type Option func(*Router)
+
+// WithClock sets the clock used by the router for delays and back offs. This is useful for testing with a fake clock.
+// The same clock should be given to the backend so that the delayed queues agree with the router.
+func WithClock(c clock.WithTicker) Option {
+	return func(r *Router) {
+		r.handlers.clock = c
+	}
+}
+
+// WithLeaderElection reports the leader state of the election config to handlers through Request.LeaderIdentity and
+// Request.IsLeader, without the router running the election. This is for callers that run the election themselves.
+func WithLeaderElection(electionConfig *leader.ElectionConfig) Option {
+	return func(r *Router) {
+		r.handlers.election = electionConfig
+	}
 }
 
 // New returns a new *Router with given HandlerSet and ElectionConfig. Passing a nil ElectionConfig is valid and results
@@ -32,29 +73,54 @@ type Router struct {
 // The healthzPort is the port on which the healthz endpoint will be served. If <= 0, the healthz endpoint will not be
 // served. When creating multiple routers, the first router created with a positive healthzPort will be used.
 // The healthz endpoint is served on /healthz, and will not be started until the router is started.
-func New(handlerSet *HandlerSet, electionConfig *leader.ElectionConfig, healthzPort int) *Router {
+func New(handlerSet *HandlerSet, electionConfig *leader.ElectionConfig, healthzPort int, opts ...Option) *Router {
 	r := &Router{
 		handlers:       handlerSet,
 		electionConfig: electionConfig,
 		signalStopped:  make(chan struct{}),
+		lifecycle: lifecycle{
+			ready: make(chan struct{}),
+		},
+	}
+
+	handlerSet.election = electionConfig
+	if electionConfig != nil {
+		// Drain before the lease is released so that the next leader doesn't race with the last writes, and abort
+		// the handlers as soon as the lease is lost. The process exits when the lease is lost, so the OnStop hooks run
+		// here once the aborted handlers have returned.
+		electionConfig.BeforeRelease(r.drainBeforeRelease)
+		electionConfig.OnLeadershipLost(func() {
+			r.AbortHandlers(leader.ErrLeadershipLost)
+			ctx, cancel := r.shutdownContext(context.Background())
+			defer cancel()
+			_ = r.handlers.freezer.wait(ctx)
+			_ = r.runStopHooks(ctx)
+		})
 	}
 
 	if healthzPort > 0 {
 		setPort(healthzPort)
 	}
 
+	for _, opt := range opts {
+		opt(r)
+	}
+
 	r.RouteBuilder.router = r
 	return r
 }
 
-func (r *Router) Stopped() <-chan struct{} {
-	return r.signalStopped
-}
-
 func (r *Router) Backend() backend.Backend {
 	return r.handlers.backend
 }
 
+// Webhook returns an admission webhook router that decodes objects with the scheme of this router and gives its
+// handlers the cached client of this router for lookups. Serving TLS is left to the caller: mount the returned
+// router as an http.Handler.
+func (r *Router) Webhook() *webhook.Router {
+	return webhook.NewRouter(webhook.WithScheme(r.handlers.scheme), webhook.WithClient(r.handlers.backend))
+}
+
 type RouteBuilder struct {
 	includeRemove     bool
 	includeFinalizing bool
@@ -67,10 +133,21 @@ type RouteBuilder struct {
 	middleware        []Middleware
 	sel               labels.Selector
 	fieldSelector     fields.Selector
+	minAge            time.Duration
+	diff              bool
+	oldObject         bool
+	adopt             bool
+	concurrency       *ConcurrencyPolicy
+	workers           int
+	retryBudget       *retryBudget
+	external          string
+	gauges            []routeGauge
+	watches           []routeWatch
 }
 
 func (r RouteBuilder) Middleware(m ...Middleware) RouteBuilder {
-	r.middleware = append(r.middleware, m...)
+	// Clip so that builders derived from the same builder never share the appended elements.
+	r.middleware = append(slices.Clip(r.middleware), m...)
 	return r
 }
 
@@ -89,6 +166,15 @@ func (r RouteBuilder) FieldSelector(sel fields.Selector) RouteBuilder {
 	return r
 }
 
+// MinAge delays the reconcile of newly created objects until they are at least d old, based on their creation
+// timestamp. This lets other actors finish mutating newly created objects before they are reconciled. Updates and
+// deletes, and any object older than d, are processed immediately. Updates are told apart from creations with the
+// old objects of the type, as with WithOldObject, so a backend that doesn't track old objects delays them too.
+func (r RouteBuilder) MinAge(d time.Duration) RouteBuilder {
+	r.minAge = d
+	return r
+}
+
 func (r RouteBuilder) Name(name string) RouteBuilder {
 	r.name = name
 	return r
@@ -104,10 +190,22 @@ func (r RouteBuilder) IncludeFinalizing() RouteBuilder {
 	return r
 }
 
-func (r RouteBuilder) Finalize(finalizerID string, h Handler) {
+// Finalize registers h as the finalizer of the type: the finalizer is added to live objects, and once an object is
+// being deleted, h is called until it succeeds, after which the finalizer is removed. The other routes of the type
+// handle the objects while they are alive. See FinalizerHandler.
+func (r RouteBuilder) Finalize(finalizerID string, h Handler) *Registration {
 	r.finalizeID = finalizerID
-	r.routeName = name()
-	r.Handler(h)
+	if r.routeName == "" {
+		r.routeName = name()
+	}
+	return r.Handler(h)
+}
+
+// RouteName sets the name of the route, which is used in logs, errors and metrics. It defaults to the file and line
+// that registered the handler.
+func (r RouteBuilder) RouteName(name string) RouteBuilder {
+	r.routeName = name
+	return r
 }
 
 func name() string {
@@ -115,10 +213,13 @@ func name() string {
 	return fmt.Sprintf("%s:%d", filepath.Base(filename), line)
 }
 
-func (r RouteBuilder) FinalizeFunc(finalizerID string, h HandlerFunc) {
+// FinalizeFunc is Finalize with a HandlerFunc.
+func (r RouteBuilder) FinalizeFunc(finalizerID string, h HandlerFunc) *Registration {
 	r.finalizeID = finalizerID
-	r.routeName = name()
-	r.Handler(h)
+	if r.routeName == "" {
+		r.routeName = name()
+	}
+	return r.Handler(h)
 }
 
 func (r RouteBuilder) Type(objType kclient.Object) RouteBuilder {
@@ -126,66 +227,184 @@ func (r RouteBuilder) Type(objType kclient.Object) RouteBuilder {
 	return r
 }
 
-func (r RouteBuilder) HandlerFunc(h HandlerFunc) {
-	r.routeName = name()
-	r.Handler(h)
+func (r RouteBuilder) HandlerFunc(h HandlerFunc) *Registration {
+	if r.routeName == "" {
+		r.routeName = name()
+	}
+	return r.Handler(h)
 }
 
-func (r RouteBuilder) Handler(h Handler) {
+func (r RouteBuilder) Handler(h Handler) *Registration {
 	if r.routeName == "" {
 		r.routeName = name()
 	}
-	result := h
-	if r.finalizeID != "" {
-		result = FinalizerHandler{
-			FinalizerID: r.finalizeID,
-			Next:        result,
+	if r.external != "" {
+		if err := r.validateExternal(); err != nil {
+			panic(err.Error())
 		}
+		return r.router.handlers.addExternal(r.external, r.routeName, r.Chain(h), r.workers, r.concurrency)
 	}
-	for i := len(r.middleware) - 1; i >= 0; i-- {
-		result = r.middleware[i](result)
+	reg := r.router.handlers.addHandler(r.objType, r.routeName, r.Chain(h))
+	if r.finalizeID != "" {
+		r.router.handlers.finalizers.add(reg.gvk, r.finalizeID)
 	}
-	if r.name != "" || r.namespace != "" {
-		result = NameNamespaceFilter{
-			Next:      result,
-			Name:      r.name,
-			Namespace: r.namespace,
-		}
+	if len(r.gauges) > 0 {
+		r.addGauges(reg.gvk)
 	}
-	if r.sel != nil {
-		result = SelectorFilter{
-			Next:     result,
-			Selector: r.sel,
-		}
+	if r.oldObject || r.minAge > 0 {
+		r.router.handlers.trackOldObjects(reg.gvk)
 	}
-	if r.fieldSelector != nil {
-		result = FieldSelectorFilter{
-			Next:          result,
-			FieldSelector: r.fieldSelector,
-		}
+	if r.concurrency != nil {
+		r.router.handlers.addConcurrency(reg.gvk, *r.concurrency)
+	} else if r.workers > 0 {
+		r.router.handlers.setWorkers(reg.gvk, r.workers)
 	}
-	if r.includeFinalizing && !r.includeRemove && r.finalizeID == "" {
-		result = IgnoreNilHandler{
-			Next: result,
-		}
+	if len(r.watches) > 0 {
+		r.addWatches(reg.gvk)
 	}
-	if !r.includeRemove && !r.includeFinalizing && r.finalizeID == "" {
-		result = IgnoreRemoveHandler{
-			Next: result,
-		}
+	return reg
+}
+
+// Layer is a named Middleware that a route wraps its handler with.
+type Layer struct {
+	Name       string
+	Middleware Middleware
+}
+
+// Chain returns the handler wrapped in exactly the filters and middleware that this route would use.
+func (r RouteBuilder) Chain(h Handler) Handler {
+	layers := r.Layers()
+	for i := len(layers) - 1; i >= 0; i-- {
+		h = layers[i].Middleware(h)
 	}
+	return h
+}
 
+// Layers returns the filters and middleware of this route, ordered from the outermost to the innermost.
+func (r RouteBuilder) Layers() []Layer {
+	var layers []Layer
 	if r.routeName != "" {
-		result = ErrorPrefix{
-			prefix: "[" + r.routeName + "] ",
-			Next:   result,
+		layers = append(layers, Layer{Name: "ErrorPrefix", Middleware: func(h Handler) Handler {
+			return ErrorPrefix{
+				prefix: "[" + r.routeName + "] ",
+				Next:   h,
+			}
+		}})
+	}
+	if r.adopt {
+		layers = append(layers, Layer{Name: "AdoptHandler", Middleware: func(h Handler) Handler {
+			return AdoptHandler{
+				Next: h,
+			}
+		}})
+	}
+	if r.retryBudget != nil && r.router != nil {
+		layers = append(layers, Layer{Name: "RetryBudgetHandler", Middleware: func(h Handler) Handler {
+			return RetryBudgetHandler{
+				Next:     h,
+				Name:     r.routeName,
+				budget:   r.retryBudget,
+				handlers: r.router.handlers,
+			}
+		}})
+	}
+	if !r.includeRemove && !r.includeFinalizing && r.finalizeID == "" && r.external == "" {
+		layers = append(layers, Layer{Name: "IgnoreRemoveHandler", Middleware: func(h Handler) Handler {
+			return IgnoreRemoveHandler{
+				Next: h,
+			}
+		}})
+	}
+	if r.includeFinalizing && !r.includeRemove && r.finalizeID == "" {
+		layers = append(layers, Layer{Name: "IgnoreNilHandler", Middleware: func(h Handler) Handler {
+			return IgnoreNilHandler{
+				Next: h,
+			}
+		}})
+	}
+	if r.fieldSelector != nil {
+		layers = append(layers, Layer{Name: "FieldSelectorFilter", Middleware: func(h Handler) Handler {
+			return FieldSelectorFilter{
+				Next:          h,
+				FieldSelector: r.fieldSelector,
+			}
+		}})
+	}
+	if r.sel != nil {
+		layers = append(layers, Layer{Name: "SelectorFilter", Middleware: func(h Handler) Handler {
+			return SelectorFilter{
+				Next:     h,
+				Selector: r.sel,
+			}
+		}})
+	}
+	if r.name != "" || r.namespace != "" {
+		layers = append(layers, Layer{Name: "NameNamespaceFilter", Middleware: func(h Handler) Handler {
+			return NameNamespaceFilter{
+				Next:      h,
+				Name:      r.name,
+				Namespace: r.namespace,
+			}
+		}})
+	}
+	if r.minAge > 0 {
+		var c clock.PassiveClock = clock.RealClock{}
+		if r.router != nil {
+			c = r.router.handlers.clock
 		}
+		layers = append(layers, Layer{Name: "MinAgeFilter", Middleware: func(h Handler) Handler {
+			return MinAgeFilter{
+				Next:   h,
+				MinAge: r.minAge,
+				Clock:  c,
+			}
+		}})
+	}
+	if r.oldObject {
+		layers = append(layers, Layer{Name: "OldObjectHandler", Middleware: func(h Handler) Handler {
+			return OldObjectHandler{
+				Next: h,
+			}
+		}})
+	}
+	for _, m := range r.middleware {
+		layers = append(layers, Layer{Name: MiddlewareName(m), Middleware: m})
 	}
+	if r.finalizeID != "" {
+		layers = append(layers, Layer{Name: "FinalizerHandler", Middleware: func(h Handler) Handler {
+			f := FinalizerHandler{
+				FinalizerID: r.finalizeID,
+				Next:        h,
+			}
+			if r.router != nil {
+				f.own = &r.router.handlers.finalizers
+			}
+			return f
+		}})
+	}
+	if r.diff {
+		layers = append(layers, Layer{Name: "DiffRecorder", Middleware: func(h Handler) Handler {
+			return DiffRecorder{
+				Next: h,
+			}
+		}})
+	}
+	return layers
+}
 
-	r.router.handlers.AddHandler(r.objType, result)
+// MiddlewareName returns the name of the function implementing the middleware.
+func MiddlewareName(m Middleware) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(m).Pointer()); f != nil {
+		return f.Name()
+	}
+	return fmt.Sprintf("%T", m)
 }
 
 func (r *Router) Start(ctx context.Context) error {
+	if r.metricsErr != nil {
+		return r.metricsErr
+	}
+
 	id, err := os.Hostname()
 	if err != nil {
 		return err
@@ -194,9 +413,36 @@ func (r *Router) Start(ctx context.Context) error {
 	startHealthz(ctx)
 
 	r.handlers.onError = r.OnErrorHandler
+	registerHistory(r.handlers.name, r.handlers.history)
+	registerTracer(r.handlers.name, r.Traces)
+	registerDelayed(r.handlers.name, r.handlers)
+	registerHealth(r.handlers.name, r)
+
+	if r.electionConfig != nil && r.warmStandby {
+		go func() {
+			if err := r.Standby(ctx); err != nil {
+				r.fatal(fmt.Errorf("failed to preload caches: %w", err))
+			}
+		}()
+	}
+
+	terminated := make(chan struct{})
+	go r.drain(ctx, terminated)
+
+	runCtx := ctx
+	if r.electionConfig == nil {
+		// The election gives the handlers a context that outlives ctx until they are drained and the lease is
+		// released. Without an election, the handlers get a context that is canceled by drain once they are drained,
+		// so that they are not canceled while they are given the shutdown timeout to return.
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		r.lifecycle.lock.Lock()
+		r.lifecycle.cancelRun = cancel
+		r.lifecycle.lock.Unlock()
+	}
 
 	// It's OK to start the electionConfig even if it's nil.
-	return r.electionConfig.Run(ctx, id, r.startHandlers, func(leader string) {
+	return r.electionConfig.Run(runCtx, id, r.startHandlers, func(leader string) {
 		if id == leader {
 			return
 		}
@@ -204,14 +450,14 @@ func (r *Router) Start(ctx context.Context) error {
 		r.startLock.Lock()
 		defer r.startLock.Unlock()
 
-		setHealthy(r.name, false)
-		defer setHealthy(r.name, true)
+		setHealthy(r.handlers.name, false)
+		defer setHealthy(r.handlers.name, true)
 		// I am not the leader, so I am healthy when my cache is ready.
 		if err := r.handlers.Preload(ctx); err != nil {
 			// Failed to preload caches, panic
-			log.Fatalf("failed to preload caches: %v", err)
+			r.fatal(fmt.Errorf("failed to preload caches: %w", err))
 		}
-	}, r.signalStopped)
+	}, terminated)
 }
 
 // startHandlers gets called when we become the leader or if there is no leader election.
@@ -219,33 +465,59 @@ func (r *Router) startHandlers(ctx context.Context) error {
 	r.startLock.Lock()
 	defer r.startLock.Unlock()
 
-	var err error
 	// This is the leader now, so not ready until the controller is started and caches are ready.
-	setHealthy(r.name, false)
-	defer setHealthy(r.name, err == nil)
+	setHealthy(r.handlers.name, false)
 
-	if err = r.handlers.Start(ctx); err != nil {
+	r.setPhase(PhaseSyncing)
+	if err := r.handlers.Start(ctx); err != nil {
 		return err
 	}
-
-	for _, f := range r.postStarts {
+	setHealthy(r.handlers.name, true)
+	r.handlersStarted = true
+
+	r.postStartLock.Lock()
+	postStarts := r.postStarts
+	r.postStartCtx = ctx
+	r.postStartLock.Unlock()
+	for _, f := range postStarts {
 		f(ctx, r.Backend())
 	}
+	r.setPhase(PhaseRunning)
 	return nil
 }
 
-func (r *Router) Handle(objType kclient.Object, h Handler) {
-	r.routeName = name()
-	r.RouteBuilder.Type(objType).Handler(h)
+func (r *Router) Handle(objType kclient.Object, h Handler) *Registration {
+	rb := r.RouteBuilder
+	rb.routeName = name()
+	return rb.Type(objType).Handler(h)
+}
+
+func (r *Router) HandleFunc(objType kclient.Object, h HandlerFunc) *Registration {
+	rb := r.RouteBuilder
+	rb.routeName = name()
+	return rb.Type(objType).Handler(h)
 }
 
-func (r *Router) HandleFunc(objType kclient.Object, h HandlerFunc) {
-	r.routeName = name()
-	r.RouteBuilder.Type(objType).Handler(h)
+// Handlers returns the handlers registered for the type in the order they are run.
+func (r *Router) Handlers(objType kclient.Object) ([]HandlerInfo, error) {
+	gvk, err := r.handlers.backend.GVKForObject(objType, r.handlers.scheme)
+	if err != nil {
+		return nil, err
+	}
+	return r.handlers.handlers.Info(gvk), nil
 }
 
+// PosStart registers a function that is called after the handlers have started, and again each time they are started
+// after a new election. A function registered after the handlers have started is also called right away, before
+// PosStart returns.
 func (r *Router) PosStart(f func(context.Context, kclient.Client)) {
+	r.postStartLock.Lock()
 	r.postStarts = append(r.postStarts, f)
+	ctx := r.postStartCtx
+	r.postStartLock.Unlock()
+	if ctx != nil && ctx.Err() == nil {
+		f(ctx, r.Backend())
+	}
 }
 
 type IgnoreNilHandler struct {
@@ -341,3 +613,23 @@ func (s FieldSelectorFilter) Handle(req Request, resp Response) error {
 	}
 	return nil
 }
+
+type MinAgeFilter struct {
+	Next   Handler
+	MinAge time.Duration
+	Clock  clock.PassiveClock
+}
+
+func (m MinAgeFilter) Handle(req Request, resp Response) error {
+	// Only the creation is delayed: the requests enqueued by an update of the object have its old object.
+	if req.Object == nil || !req.Object.GetDeletionTimestamp().IsZero() || req.oldObject != nil {
+		return m.Next.Handle(req, resp)
+	}
+	// A creation timestamp in the future means the clocks are skewed, so the age is treated as zero.
+	age := max(m.Clock.Since(req.Object.GetCreationTimestamp().Time), 0)
+	if age < m.MinAge {
+		resp.RetryAfter(m.MinAge - age)
+		return nil
+	}
+	return m.Next.Handle(req, resp)
+}
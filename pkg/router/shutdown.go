@@ -0,0 +1,81 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+)
+
+// DefaultShutdownTimeout is how long a router waits for its running handlers when it stops, and before a router with
+// leader election releases the lease.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// abortGracePeriod is how long a router that stops waits for the handlers it aborted after the shutdown timeout, or
+// the shutdown timeout if it is shorter.
+const abortGracePeriod = 5 * time.Second
+
+// WithShutdownTimeout sets how long a router waits for its running handlers to return when it stops, before a router
+// with leader election releases the lease. Keys are no longer dispatched once the router stops, and the handlers
+// still running after the timeout are aborted: their Request context is canceled and their writes fail, and the
+// router stops without the ones that still don't return shortly after. The timeout starts when the router begins to
+// stop and also bounds the OnStop hooks, which get what is left of it. Defaults to DefaultShutdownTimeout.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(r *Router) {
+		r.shutdownTimeout = timeout
+	}
+}
+
+// Drain stops dispatching keys to handlers and waits for the running handlers to return. If ctx is done first, the
+// running handlers are aborted, as with AbortHandlers, and the error of ctx is returned. The router stays frozen.
+//
+// A leader must drain before releasing its lease: otherwise the next leader can start reconciling while the last
+// writes of this process are still being made. Routers that run their leader election drain on shutdown; callers
+// that run the election themselves should call Drain before releasing the lease.
+func (r *Router) Drain(ctx context.Context) error {
+	if err := r.Freeze(ctx); err != nil {
+		r.AbortHandlers(fmt.Errorf("handlers did not return before the drain timeout: %w", err))
+		return err
+	}
+	return nil
+}
+
+// AbortHandlers stops dispatching keys to handlers and cancels the context of the running handlers, so that the writes
+// they attempt from now on fail with ErrRequestAborted. It is for when the lease was lost and another process may
+// already be the leader, so the handlers can't be allowed to finish. Aborting can't be undone, the router must be
+// started again. The caches are not stopped.
+func (r *Router) AbortHandlers(cause error) {
+	log.Warnf("Aborting the handlers of router [%s]: %v", r.handlers.name, cause)
+	r.handlers.freezer.freeze()
+	setFrozen(r.handlers.name, true)
+	r.handlers.abort(cause)
+}
+
+// drainBeforeRelease is called by the leader election of the router before the lease is released on shutdown.
+func (r *Router) drainBeforeRelease() {
+	ctx, cancel := r.shutdownContext(context.Background())
+	defer cancel()
+	if err := r.Drain(ctx); err != nil {
+		log.Warnf("Timed out waiting for handlers of router [%s] before releasing the lease: %v", r.handlers.name, err)
+	}
+}
+
+// shutdownContext returns ctx with the deadline of the shutdown timeout, which starts the first time it is called, so
+// that the drain and the OnStop hooks share one timeout however the router stops.
+func (r *Router) shutdownContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	r.lifecycle.lock.Lock()
+	if r.lifecycle.shutdownDeadline.IsZero() {
+		r.lifecycle.shutdownDeadline = time.Now().Add(r.shutdownTimeoutOrDefault())
+	}
+	deadline := r.lifecycle.shutdownDeadline
+	r.lifecycle.lock.Unlock()
+	return context.WithDeadline(ctx, deadline)
+}
+
+func (r *Router) shutdownTimeoutOrDefault() time.Duration {
+	if r.shutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return r.shutdownTimeout
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// stopWithHandler starts the router with the handler, stops it while the handler is running, and returns the error
+// how long the router took to stop and the error of the reconcile.
+func stopWithHandler(t *testing.T, timeout time.Duration, h HandlerFunc) (time.Duration, error) {
+	t.Helper()
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithShutdownTimeout(timeout)(r)
+
+	running := make(chan struct{})
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		close(running)
+		return h(req, resp)
+	})
+	cancel := startTestRouter(t, r)
+
+	reconciled := make(chan error, 1)
+	go func() {
+		reconciled <- b.dispatch(configMapGVK, ReplayPrefix+"default/a")
+	}()
+	<-running
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-r.Stopped():
+	case <-time.After(10 * time.Second):
+		t.Fatal("router did not stop")
+	}
+	elapsed := time.Since(start)
+
+	select {
+	case err := <-reconciled:
+		return elapsed, err
+	case <-time.After(10 * time.Second):
+		t.Fatal("reconcile did not return")
+		return 0, nil
+	}
+}
+
+func TestShutdownSlowHandlerFinishes(t *testing.T) {
+	elapsed, err := stopWithHandler(t, 5*time.Second, func(req Request, resp Response) error {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		case <-req.Ctx.Done():
+			return req.Ctx.Err()
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected the handler to finish within the shutdown timeout, got %v", err)
+	}
+	if elapsed >= 5*time.Second {
+		t.Fatalf("expected the router to stop once the handler returned, took %s", elapsed)
+	}
+}
+
+func TestShutdownSlowHandlerCutOff(t *testing.T) {
+	var canceled bool
+	elapsed, _ := stopWithHandler(t, 100*time.Millisecond, func(req Request, resp Response) error {
+		select {
+		case <-time.After(10 * time.Second):
+			return nil
+		case <-req.Ctx.Done():
+			canceled = true
+			return req.Ctx.Err()
+		}
+	})
+	if !canceled {
+		t.Fatal("expected the context of the handler to be canceled")
+	}
+	if elapsed >= time.Second {
+		t.Fatalf("expected the router to stop after the shutdown timeout, took %s", elapsed)
+	}
+}
+
+func TestShutdownHandlerIgnoringContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithShutdownTimeout(100 * time.Millisecond)(r)
+	running := make(chan struct{})
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		close(running)
+		<-release
+		return errors.New("released")
+	})
+	cancel := startTestRouter(t, r)
+	go func() {
+		_ = b.dispatch(configMapGVK, ReplayPrefix+"default/a")
+	}()
+	<-running
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-r.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("a handler that ignores its context held up the shutdown")
+	}
+	// The shutdown timeout, and then the grace period, which is the shutdown timeout when it is shorter.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected the router to wait for the aborted handler, stopped after %s", elapsed)
+	}
+}
@@ -0,0 +1,49 @@
+package router
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WithWarmStandby makes a router that uses leader election sync the caches of its types, and of the extra types, as
+// soon as it starts, without waiting to learn who the leader is. Workers are not started and handlers are not called
+// until the router becomes the leader, at which point every cached key is enqueued from the warm cache, which also
+// rebuilds the triggers. The extra types are those that handlers read but don't handle, which would otherwise be
+// listed on the first read after the failover. A standby uses as much memory for its caches as the leader.
+func WithWarmStandby(extra ...kclient.Object) Option {
+	return func(r *Router) {
+		r.warmStandby = true
+		r.warmTypes = extra
+	}
+}
+
+// Standby syncs the caches of a router with WithWarmStandby without starting its workers. It is called by Start for
+// routers with leader election and is for callers that run the leader election themselves. It is a no-op without
+// WithWarmStandby, and after the router has started its handlers.
+func (r *Router) Standby(ctx context.Context) error {
+	if !r.warmStandby {
+		return nil
+	}
+
+	r.startLock.Lock()
+	defer r.startLock.Unlock()
+	if r.handlersStarted {
+		return nil
+	}
+
+	gvks := make([]schema.GroupVersionKind, 0, len(r.warmTypes))
+	for _, obj := range r.warmTypes {
+		gvk, err := r.handlers.backend.GVKForObject(obj, r.handlers.scheme)
+		if err != nil {
+			return fmt.Errorf("failed to find the GVK of warm standby type %T: %w", obj, err)
+		}
+		gvks = append(gvks, gvk)
+	}
+
+	setHealthy(r.handlers.name, false)
+	defer setHealthy(r.handlers.name, true)
+	return r.handlers.Preload(ctx, gvks...)
+}
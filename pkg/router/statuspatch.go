@@ -0,0 +1,97 @@
+package router
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// StatusPatchAttribute is the Response attribute that PatchStatusSafe sets to the way the status was patched,
+	// either StatusPatchSubresource or StatusPatchObject.
+	StatusPatchAttribute = "nah.obot.ai/status-patch"
+	// StatusPatchSubresource means the status was patched through the status subresource.
+	StatusPatchSubresource = "subresource"
+	// StatusPatchObject means the type has no status subresource and the status was patched on the object itself.
+	StatusPatchObject = "object"
+)
+
+// PatchStatusSafe patches the status of obj from original through the status subresource. If the type has no status
+// subresource, as is the case for some legacy CRDs, then the status is patched on the object itself with a JSON patch
+// that only replaces the status, so the spec of the object is left untouched. Neither patch has a resource version, so
+// a concurrent change of the object doesn't conflict with it. The way the status was patched is set in the
+// StatusPatchAttribute of the Response.
+func (r *Request) PatchStatusSafe(obj, original kclient.Object) error {
+	err := r.Client.Status().Patch(r.Ctx, obj, kclient.MergeFrom(original))
+	if !isMissingSubresource(err) {
+		if err == nil {
+			r.setAttribute(StatusPatchAttribute, StatusPatchSubresource)
+		}
+		return err
+	}
+
+	patch, err := statusJSONPatch(obj, original)
+	if err != nil {
+		return err
+	}
+	if patch == nil {
+		r.setAttribute(StatusPatchAttribute, StatusPatchObject)
+		return nil
+	}
+	if err := r.Client.Patch(r.Ctx, obj, kclient.RawPatch(types.JSONPatchType, patch)); err != nil {
+		return err
+	}
+	r.setAttribute(StatusPatchAttribute, StatusPatchObject)
+	return nil
+}
+
+func (r *Request) setAttribute(key string, value any) {
+	if r.attributes != nil {
+		r.attributes.Attributes()[key] = value
+	}
+}
+
+// isMissingSubresource returns true for the NotFound error of a request to a subresource that the type doesn't have.
+// Unlike the NotFound error of a missing object, it doesn't name the object.
+func isMissingSubresource(err error) bool {
+	if !apierrors.IsNotFound(err) {
+		return false
+	}
+	status, ok := err.(apierrors.APIStatus)
+	if !ok {
+		return false
+	}
+	details := status.Status().Details
+	return details == nil || details.Name == ""
+}
+
+// statusJSONPatch returns a JSON patch that replaces the status of original with the status of obj, or nil if the
+// status didn't change.
+func statusJSONPatch(obj, original kclient.Object) ([]byte, error) {
+	newContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(original)
+	if err != nil {
+		return nil, err
+	}
+
+	newStatus, hasNew := newContent["status"]
+	oldStatus, hasOld := oldContent["status"]
+	var ops []map[string]any
+	switch {
+	case hasNew == hasOld && equality.Semantic.DeepEqual(newStatus, oldStatus):
+		return nil, nil
+	case hasNew:
+		// add replaces the status if it is already set.
+		ops = append(ops, map[string]any{"op": "add", "path": "/status", "value": newStatus})
+	default:
+		ops = append(ops, map[string]any{"op": "remove", "path": "/status"})
+	}
+	return json.Marshal(ops)
+}
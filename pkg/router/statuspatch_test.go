@@ -0,0 +1,132 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// legacyStatusClient has no status subresource for any type, like the API server for a CRD without one, and counts
+// the patches of the objects.
+type legacyStatusClient struct {
+	kclient.WithWatch
+	patches int
+}
+
+func (l *legacyStatusClient) Patch(ctx context.Context, obj kclient.Object, patch kclient.Patch, opts ...kclient.PatchOption) error {
+	l.patches++
+	return l.WithWatch.Patch(ctx, obj, patch, opts...)
+}
+
+func (l *legacyStatusClient) Status() kclient.SubResourceWriter {
+	return missingStatus{}
+}
+
+type missingStatus struct {
+	kclient.SubResourceWriter
+}
+
+func (missingStatus) Patch(context.Context, kclient.Object, kclient.Patch, ...kclient.SubResourcePatchOption) error {
+	return apierrors.NewNotFound(schema.GroupResource{Group: "autoscaling", Resource: "horizontalpodautoscalers"}, "")
+}
+
+func TestPatchStatusSafe(t *testing.T) {
+	ctx := context.Background()
+	scheme := testScheme(t)
+
+	t.Run("subresource", func(t *testing.T) {
+		c := newFakeBackend(scheme, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}})
+		req := Request{Client: c, Ctx: ctx, attributes: &ResponseAttributes{}}
+
+		var original appsv1.Deployment
+		if err := c.Get(ctx, Key("default", "a"), &original); err != nil {
+			t.Fatal(err)
+		}
+		// Another writer changes the spec after the object was read, the status patch doesn't conflict with it.
+		concurrent := original.DeepCopy()
+		concurrent.Spec.Paused = true
+		if err := c.Update(ctx, concurrent); err != nil {
+			t.Fatal(err)
+		}
+
+		obj := original.DeepCopy()
+		obj.Status.Replicas = 3
+		if err := req.PatchStatusSafe(obj, &original); err != nil {
+			t.Fatal(err)
+		}
+		if way := req.attributes.Attributes()[StatusPatchAttribute]; way != StatusPatchSubresource {
+			t.Fatalf("expected the status to be patched through the subresource, got %v", way)
+		}
+
+		var live appsv1.Deployment
+		if err := c.Get(ctx, Key("default", "a"), &live); err != nil {
+			t.Fatal(err)
+		}
+		if live.Status.Replicas != 3 || !live.Spec.Paused {
+			t.Fatalf("expected the status to be patched and the concurrent spec change kept, got %+v", live)
+		}
+
+		// A missing object is not mistaken for a type without a status subresource.
+		missing := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "missing"}}
+		if err := req.PatchStatusSafe(missing, missing.DeepCopy()); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected the patch of a missing object to fail, got %v", err)
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		c := &legacyStatusClient{
+			WithWatch: newFakeBackend(scheme, &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+				Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 1},
+			}),
+		}
+		req := Request{Client: c, Ctx: ctx, attributes: &ResponseAttributes{}}
+
+		var original autoscalingv2.HorizontalPodAutoscaler
+		if err := c.Get(ctx, Key("default", "a"), &original); err != nil {
+			t.Fatal(err)
+		}
+
+		// The status didn't change, so nothing is patched.
+		if err := req.PatchStatusSafe(original.DeepCopy(), &original); err != nil {
+			t.Fatal(err)
+		}
+		if c.patches != 0 {
+			t.Fatalf("expected no patch for an unchanged status, got %d", c.patches)
+		}
+		if way := req.attributes.Attributes()[StatusPatchAttribute]; way != StatusPatchObject {
+			t.Fatalf("expected the legacy type to be detected, got %v", way)
+		}
+
+		// Another writer changes the spec after the object was read, the JSON patch only replaces the status.
+		concurrent := original.DeepCopy()
+		concurrent.Spec.MaxReplicas = 5
+		if err := c.Update(ctx, concurrent); err != nil {
+			t.Fatal(err)
+		}
+
+		obj := original.DeepCopy()
+		obj.Spec.MaxReplicas = 2
+		obj.Status.CurrentReplicas = 3
+		if err := req.PatchStatusSafe(obj, &original); err != nil {
+			t.Fatal(err)
+		}
+		if c.patches != 1 {
+			t.Fatalf("expected the status to be patched on the object, got %d patches", c.patches)
+		}
+
+		var live autoscalingv2.HorizontalPodAutoscaler
+		if err := c.Get(ctx, Key("default", "a"), &live); err != nil {
+			t.Fatal(err)
+		}
+		if live.Status.CurrentReplicas != 3 || live.Spec.MaxReplicas != 5 {
+			t.Fatalf("expected only the status to be patched, got %+v and %+v", live.Spec, live.Status)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/obot-platform/nah/pkg/log"
+	"github.com/obot-platform/nah/pkg/merr"
+)
+
+// ErrStopping is returned by OnStop when the router has already begun to stop.
+var ErrStopping = errors.New("router is stopping")
+
+// StopHook is a function run once the router has stopped and its running handlers have returned.
+type StopHook func(ctx context.Context) error
+
+// OnStop registers a function to run when the router stops, after its running handlers have returned, for cleanup
+// such as flushing batches or closing connection pools. The hooks run exactly once, in reverse order of registration,
+// whether the router stops because the context of Start is done, the lease is lost, or the leader election caught a
+// termination signal. The hooks get what is left of the shutdown timeout after the drain, see WithShutdownTimeout: ctx
+// is canceled once it expires, and the hooks that haven't run by then are skipped. Their errors are returned by Stop.
+//
+// ErrStopping is returned, and the hook is not registered, if the router has already begun to stop.
+func (r *Router) OnStop(hook StopHook) error {
+	r.lifecycle.lock.Lock()
+	defer r.lifecycle.lock.Unlock()
+	if r.lifecycle.stopping || r.lifecycle.phase == PhaseDraining || r.lifecycle.phase == PhaseStopped {
+		return ErrStopping
+	}
+	r.lifecycle.stopHooks = append(r.lifecycle.stopHooks, hook)
+	return nil
+}
+
+// Stop stops dispatching keys, waits for the running handlers to return, and runs the OnStop hooks, all within the
+// shutdown timeout. If ctx is done or the timeout expires before the handlers return, they are aborted, as with Drain.
+// The errors of the hooks are returned, also if they already ran because the router stopped on its own. The caches are
+// stopped by the context of Start, not by Stop.
+func (r *Router) Stop(ctx context.Context) error {
+	r.setPhase(PhaseDraining)
+	ctx, cancel := r.shutdownContext(ctx)
+	defer cancel()
+	drainErr := r.Drain(ctx)
+	if drainErr != nil {
+		r.waitAborted()
+	}
+	err := merr.NewErrors(drainErr, r.runStopHooks(ctx))
+	r.setPhase(PhaseStopped)
+	r.lifecycle.stopOnce.Do(func() { close(r.signalStopped) })
+	return err
+}
+
+// runStopHooks runs the OnStop hooks the first time it is called, until ctx is done, and returns their errors on every
+// call.
+func (r *Router) runStopHooks(ctx context.Context) error {
+	r.lifecycle.stopHooksOnce.Do(func() {
+		r.lifecycle.lock.Lock()
+		r.lifecycle.stopping = true
+		hooks := r.lifecycle.stopHooks
+		r.lifecycle.stopHooks = nil
+		r.lifecycle.lock.Unlock()
+
+		r.lifecycle.stopErr = r.callStopHooks(ctx, hooks)
+	})
+	return r.lifecycle.stopErr
+}
+
+func (r *Router) callStopHooks(ctx context.Context, hooks []StopHook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("skipped %d stop hooks of router [%s]: %w", i+1, r.handlers.name, ctx.Err()))
+			break
+		}
+
+		// The hook runs in its own goroutine so that a hook that ignores ctx can't hold up the shutdown.
+		done := make(chan error, 1)
+		go func(hook StopHook) {
+			done <- hook(ctx)
+		}(hooks[i])
+
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("stop hook of router [%s] did not return: %w", r.handlers.name, ctx.Err()))
+		}
+	}
+
+	err := merr.NewErrors(errs...)
+	if err != nil {
+		log.Errorf("Stop hooks of router [%s] failed: %v", r.handlers.name, err)
+	}
+	return err
+}
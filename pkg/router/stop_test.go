@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStopHooksShareShutdownTimeout(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	WithShutdownTimeout(400 * time.Millisecond)(r)
+
+	running := make(chan struct{})
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		close(running)
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	})
+	remaining := make(chan time.Duration, 1)
+	if err := r.OnStop(func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		remaining <- time.Until(deadline)
+		<-ctx.Done()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	startTestRouter(t, r)
+	go func() {
+		_ = b.dispatch(configMapGVK, ReplayPrefix+"default/a")
+	}()
+	<-running
+
+	start := time.Now()
+	err := r.Stop(context.Background())
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the hook to run out of time, got %v", err)
+	}
+	if left := <-remaining; left > 200*time.Millisecond {
+		t.Fatalf("expected the hook to get what is left of the shutdown timeout, got %s", left)
+	}
+	if elapsed > 600*time.Millisecond {
+		t.Fatalf("expected the stop to take one shutdown timeout, took %s", elapsed)
+	}
+}
+
+func TestStopHooksRegisteredLate(t *testing.T) {
+	r, _ := newTestRouter(t)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+
+	var order []int
+	for i := 0; i < 2; i++ {
+		if err := r.OnStop(func(ctx context.Context) error {
+			order = append(order, i)
+			// A hook registered while the hooks run is rejected rather than skipped silently.
+			if err := r.OnStop(func(context.Context) error { return nil }); !errors.Is(err, ErrStopping) {
+				t.Errorf("expected ErrStopping while the hooks run, got %v", err)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	startTestRouter(t, r)
+
+	if err := r.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[1 0]" {
+		t.Fatalf("expected the hooks to run in reverse order, got %v", order)
+	}
+	if err := r.OnStop(func(context.Context) error { return nil }); !errors.Is(err, ErrStopping) {
+		t.Fatalf("expected ErrStopping once the router stopped, got %v", err)
+	}
+	// The hooks ran exactly once.
+	if err := r.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 {
+		t.Fatalf("expected the hooks to run once, got %v", order)
+	}
+}
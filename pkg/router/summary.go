@@ -0,0 +1,94 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// WithSummaryLogging makes the router log exactly one line at the end of each reconcile summarizing what it did:
+// the source of the reconcile, the handlers run, the number of writes, the requeue delay, the error, the duration, and
+// anything the handlers added with Request.LogSummary. The per-step debug logs of the router are not emitted in
+// this mode.
+func WithSummaryLogging() Option {
+	return func(r *Router) {
+		r.handlers.summaryLogging = true
+	}
+}
+
+// LogSummary adds a key and value to the summary line logged at the end of the reconcile. This is a no-op unless
+// the router was created with WithSummaryLogging.
+func (r *Request) LogSummary(key string, value any) {
+	if r.summary == nil {
+		return
+	}
+	r.summary.lock.Lock()
+	defer r.summary.lock.Unlock()
+	r.summary.fields = append(r.summary.fields, summaryField{key: key, value: value})
+}
+
+type summaryField struct {
+	key   string
+	value any
+}
+
+type reconcileSummary struct {
+	gvk    schema.GroupVersionKind
+	key    string
+	source string
+	start  time.Time
+	writes atomic.Int64
+
+	lock     sync.Mutex
+	handlers []string
+	fields   []summaryField
+}
+
+func (s *reconcileSummary) handlerRan(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers = append(s.handlers, name)
+}
+
+func (s *reconcileSummary) log(end time.Time, delay time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	line := &strings.Builder{}
+	write := func(key string, value any) {
+		v := fmt.Sprint(value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(line, " %s=%s", key, v)
+	}
+
+	line.WriteString("reconcile")
+	write("gvk", s.gvk)
+	write("key", s.key)
+	write("source", s.source)
+	write("handlers", strings.Join(s.handlers, ","))
+	write("writes", s.writes.Load())
+	if delay > 0 {
+		write("requeue", delay)
+	}
+	write("duration", end.Sub(s.start))
+	for _, f := range s.fields {
+		write(f.key, f.value)
+	}
+
+	if err != nil {
+		write("error", err)
+		log.Errorf("%s", line.String())
+	} else {
+		log.Infof("%s", line.String())
+	}
+}
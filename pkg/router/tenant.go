@@ -0,0 +1,146 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrClusterScopedTenant is returned for writes made while handling a cluster scoped object when tenant impersonation
+// is enabled without ClusterScopedBaseClient.
+var ErrClusterScopedTenant = errors.New("writes are not allowed while handling cluster scoped objects with tenant impersonation")
+
+// TenantImpersonation configures the writes of each request to go through a client that impersonates the tenant of
+// the request's namespace, so that the API server enforces the tenant's permissions. Reads are still served from the
+// shared cache.
+type TenantImpersonation struct {
+	// Config is the base config that is copied for each tenant.
+	Config *rest.Config
+	// Impersonate returns the impersonation config for the namespace.
+	Impersonate func(namespace string) rest.ImpersonationConfig
+	// ClusterScopedBaseClient uses the router's own client for cluster scoped objects. Otherwise, writes while
+	// handling cluster scoped objects return ErrClusterScopedTenant.
+	ClusterScopedBaseClient bool
+}
+
+// WithTenantImpersonation makes the writes of each request use a client impersonating the request's namespace.
+func WithTenantImpersonation(t TenantImpersonation) Option {
+	return func(r *Router) {
+		r.handlers.tenants = &tenantClients{
+			config:  t,
+			clients: map[string]kclient.Client{},
+		}
+	}
+}
+
+type tenantClients struct {
+	config  TenantImpersonation
+	lock    sync.Mutex
+	clients map[string]kclient.Client
+}
+
+// clientFor returns the client to write with for the namespace, creating and caching it if needed.
+func (t *tenantClients) clientFor(base kclient.Client, namespace string) (kclient.Client, error) {
+	if namespace == "" {
+		if t.config.ClusterScopedBaseClient {
+			return base, nil
+		}
+		return &errorWriter{Client: base, err: ErrClusterScopedTenant}, nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if c, ok := t.clients[namespace]; ok {
+		return c, nil
+	}
+
+	cfg := rest.CopyConfig(t.config.Config)
+	cfg.Impersonate = t.config.Impersonate(namespace)
+
+	c, err := kclient.New(cfg, kclient.Options{
+		Scheme: base.Scheme(),
+		Mapper: base.RESTMapper(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonated client for namespace %s: %w", namespace, err)
+	}
+
+	t.clients[namespace] = c
+	return c, nil
+}
+
+func (t *tenantClients) evict(namespace string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.clients, namespace)
+}
+
+// watchNamespaces evicts the cached client of a namespace when the namespace is deleted.
+func (m *HandlerSet) watchNamespaces(ctx context.Context) error {
+	gvk := corev1.SchemeGroupVersion.WithKind("Namespace")
+	if !m.scheme.Recognizes(gvk) {
+		log.Warnf("scheme does not include namespaces, impersonated tenant clients will not be evicted")
+		return nil
+	}
+	return m.backend.Watcher(ctx, gvk, m.name+" tenant clients", func(_ schema.GroupVersionKind, key string, obj runtime.Object) (runtime.Object, error) {
+		if obj == nil {
+			m.tenants.evict(key)
+		}
+		return obj, nil
+	})
+}
+
+// errorWriter allows reads, but returns an error for every write.
+type errorWriter struct {
+	kclient.Client
+	err error
+}
+
+func (e *errorWriter) Create(context.Context, kclient.Object, ...kclient.CreateOption) error {
+	return e.err
+}
+
+func (e *errorWriter) Delete(context.Context, kclient.Object, ...kclient.DeleteOption) error {
+	return e.err
+}
+
+func (e *errorWriter) Update(context.Context, kclient.Object, ...kclient.UpdateOption) error {
+	return e.err
+}
+
+func (e *errorWriter) Patch(context.Context, kclient.Object, kclient.Patch, ...kclient.PatchOption) error {
+	return e.err
+}
+
+func (e *errorWriter) DeleteAllOf(context.Context, kclient.Object, ...kclient.DeleteAllOfOption) error {
+	return e.err
+}
+
+func (e *errorWriter) Status() kclient.SubResourceWriter {
+	return &errorSubResourceWriter{err: e.err}
+}
+
+type errorSubResourceWriter struct {
+	err error
+}
+
+func (e *errorSubResourceWriter) Create(context.Context, kclient.Object, kclient.Object, ...kclient.SubResourceCreateOption) error {
+	return e.err
+}
+
+func (e *errorSubResourceWriter) Update(context.Context, kclient.Object, ...kclient.SubResourceUpdateOption) error {
+	return e.err
+}
+
+func (e *errorSubResourceWriter) Patch(context.Context, kclient.Object, kclient.Patch, ...kclient.SubResourcePatchOption) error {
+	return e.err
+}
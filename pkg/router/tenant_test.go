@@ -0,0 +1,108 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestTenantImpersonation(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		writes []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		writes = append(writes, req.Method+" "+req.URL.Path+" as "+req.Header.Get("Impersonate-User"))
+		lock.Unlock()
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	scheme := testScheme(t)
+	b := newFakeBackend(scheme, configMap("a", "owner"), configMap("b", "owner"))
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0, WithTenantImpersonation(TenantImpersonation{
+		Config: &rest.Config{Host: server.URL},
+		Impersonate: func(namespace string) rest.ImpersonationConfig {
+			return rest.ImpersonationConfig{UserName: "tenant-" + namespace}
+		},
+	}))
+
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if req.Name != "owner" {
+			return nil
+		}
+		// The read is served by the backend, only the write goes through the impersonated client.
+		var owner corev1.ConfigMap
+		if err := req.Get(&owner, req.Namespace, req.Name); err != nil {
+			return err
+		}
+		return req.Client.Create(req.Ctx, configMap(req.Namespace, "child"))
+	})
+	startTestRouter(t, r)
+
+	for _, key := range []string{"a/owner", "b/owner", "a/owner"} {
+		if err := b.dispatch(configMapGVK, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lock.Lock()
+	got := writes
+	lock.Unlock()
+	expected := []string{
+		"POST /api/v1/namespaces/a/configmaps as tenant-a",
+		"POST /api/v1/namespaces/b/configmaps as tenant-b",
+		"POST /api/v1/namespaces/a/configmaps as tenant-a",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected the writes %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected the writes %v, got %v", expected, got)
+		}
+	}
+
+	// The clients are cached per namespace, and evicted when the namespace is deleted.
+	tenants := r.handlers.tenants
+	cached, err := tenants.clientFor(b, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := tenants.clientFor(b, "a"); again != cached || len(tenants.clients) != 2 {
+		t.Fatalf("expected a cached client per namespace, got %d clients", len(tenants.clients))
+	}
+	if err := b.dispatch(corev1.SchemeGroupVersion.WithKind("Namespace"), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tenants.clients["a"]; ok || len(tenants.clients) != 1 {
+		t.Fatalf("expected the client of the deleted namespace to be evicted, got %d clients", len(tenants.clients))
+	}
+
+	// Writes while handling cluster scoped objects fail, unless the base client is used for them.
+	ctx := context.Background()
+	c, err := tenants.clientFor(b, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, configMap("a", "cluster")); !errors.Is(err, ErrClusterScopedTenant) {
+		t.Fatalf("expected ErrClusterScopedTenant, got %v", err)
+	}
+	if err := c.Status().Update(ctx, configMap("a", "cluster")); !errors.Is(err, ErrClusterScopedTenant) {
+		t.Fatalf("expected ErrClusterScopedTenant for status writes, got %v", err)
+	}
+	tenants.config.ClusterScopedBaseClient = true
+	if c, _ := tenants.clientFor(b, ""); c != b {
+		t.Fatal("expected the base client to be used for cluster scoped objects")
+	}
+}
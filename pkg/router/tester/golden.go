@@ -0,0 +1,79 @@
+package tester
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hexops/autogold/v2"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	yaml2 "sigs.k8s.io/yaml"
+)
+
+// MatchGolden compares the objects against the expected.golden file in the given directory. The objects are
+// serialized in a deterministic order (GVK, namespace, name) with server populated fields normalized. Run the
+// tests with -update to regenerate the golden files.
+func MatchGolden(t *testing.T, scheme *runtime.Scheme, objs []kclient.Object, dir string) {
+	t.Helper()
+	autogold.ExpectFile(t, GoldenYAML(t, scheme, objs), autogold.Dir(dir), autogold.Name("expected"))
+}
+
+// MatchGolden compares all the objects collected and created during the handler invocation against the
+// expected.golden file in the given directory.
+func (r *Response) MatchGolden(t *testing.T, dir string) {
+	t.Helper()
+	objs := append(append([]kclient.Object{}, r.Collected...), r.Client.Created...)
+	MatchGolden(t, r.Client.Scheme(), objs, dir)
+}
+
+// GoldenYAML returns the deterministic, normalized YAML form of the objects used for golden file comparisons.
+func GoldenYAML(t *testing.T, scheme *runtime.Scheme, objs []kclient.Object) string {
+	t.Helper()
+
+	type entry struct {
+		sortKey string
+		data    string
+	}
+
+	entries := make([]entry, 0, len(objs))
+	for _, o := range objs {
+		gvk, err := apiutil.GVKForObject(o, scheme)
+		require.NoError(t, err)
+
+		o = normalize(o)
+		o.GetObjectKind().SetGroupVersionKind(gvk)
+
+		data, err := yaml2.Marshal(o)
+		require.NoError(t, err)
+
+		entries = append(entries, entry{
+			sortKey: strings.Join([]string{gvk.Group, gvk.Version, gvk.Kind, o.GetNamespace(), o.GetName()}, "/"),
+			data:    string(stripLastTransition(data)),
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].sortKey < entries[j].sortKey
+	})
+
+	yamls := make([]string, 0, len(entries))
+	for _, e := range entries {
+		yamls = append(yamls, e.data)
+	}
+	return strings.Join(yamls, "\n---\n")
+}
+
+// normalize returns a copy of the object with the fields that the API server or the test client assign cleared
+// so that they don't cause spurious golden file differences.
+func normalize(obj kclient.Object) kclient.Object {
+	obj = obj.DeepCopyObject().(kclient.Object)
+	obj.SetManagedFields(nil)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	return obj
+}
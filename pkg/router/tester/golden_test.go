@@ -0,0 +1,56 @@
+package tester
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestGoldenYAML(t *testing.T) {
+	scheme := queueScheme(t)
+	b := configMap("b", map[string]string{"k": "v"})
+	b.UID = "uid"
+	b.ResourceVersion = "3"
+	b.CreationTimestamp = metav1.Now()
+	objs := []kclient.Object{
+		b,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}},
+		configMap("a", nil),
+	}
+
+	yaml := GoldenYAML(t, scheme, objs)
+	docs := strings.Split(yaml, "\n---\n")
+	if len(docs) != 3 {
+		t.Fatalf("expected a document per object, got:\n%s", yaml)
+	}
+	for i, want := range []string{"kind: ConfigMap\nmetadata:\n  creationTimestamp: null\n  name: a\n", "kind: ConfigMap\nmetadata:\n  creationTimestamp: null\n  name: b\n", "kind: Secret"} {
+		if !strings.Contains(docs[i], want) {
+			t.Errorf("expected document %d to contain %q, got:\n%s", i, want, docs[i])
+		}
+	}
+	for _, field := range []string{"uid", "resourceVersion", "creationTimestamp: \"2"} {
+		if strings.Contains(yaml, field) {
+			t.Errorf("expected %s to be normalized, got:\n%s", field, yaml)
+		}
+	}
+	if b.UID != "uid" || b.ResourceVersion != "3" {
+		t.Errorf("expected the objects of the test to be left unchanged, got %s and %s", b.UID, b.ResourceVersion)
+	}
+
+	// The order of the objects doesn't change the result.
+	if reversed := GoldenYAML(t, scheme, []kclient.Object{objs[2], objs[1], objs[0]}); reversed != yaml {
+		t.Errorf("expected the same YAML for the objects in any order, got:\n%s", reversed)
+	}
+}
+
+func TestResponseMatchGolden(t *testing.T) {
+	resp := &Response{
+		Client:    &Client{SchemeObj: queueScheme(t)},
+		Collected: []kclient.Object{configMap("collected", nil)},
+	}
+	resp.Client.Created = []kclient.Object{configMap("created", nil)}
+	resp.MatchGolden(t, "testdata/golden")
+}
@@ -0,0 +1,67 @@
+package tester
+
+import (
+	"fmt"
+
+	"github.com/obot-platform/nah/pkg/router"
+)
+
+// ErrShortCircuited is returned when a layer of the chain did not call the next handler, so the handler under test
+// never ran. Err is the error returned by the chain, if any.
+type ErrShortCircuited struct {
+	Layer string
+	Err   error
+}
+
+func (e *ErrShortCircuited) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("handler not invoked, short-circuited by [%s]: %v", e.Layer, e.Err)
+	}
+	return fmt.Sprintf("handler not invoked, short-circuited by [%s]", e.Layer)
+}
+
+func (e *ErrShortCircuited) Unwrap() error {
+	return e.Err
+}
+
+// InvokeWithMiddleware invokes the handler wrapped in the middleware, the first middleware being the outermost.
+// If the handler is never reached, then an *ErrShortCircuited naming the middleware that returned early is returned.
+func InvokeWithMiddleware(h router.Handler, mws []router.Middleware, req router.Request, resp router.Response) error {
+	layers := make([]router.Layer, 0, len(mws))
+	for _, m := range mws {
+		layers = append(layers, router.Layer{Name: router.MiddlewareName(m), Middleware: m})
+	}
+	return invokeLayers(layers, h, req, resp)
+}
+
+// InvokeRoute invokes the handler through exactly the filters and middleware that the route would use when
+// registered on a router. If the handler is never reached, then an *ErrShortCircuited is returned.
+func InvokeRoute(route router.RouteBuilder, h router.Handler, req router.Request, resp router.Response) error {
+	return invokeLayers(route.Layers(), h, req, resp)
+}
+
+func invokeLayers(layers []router.Layer, h router.Handler, req router.Request, resp router.Response) error {
+	deepest := -1
+	probe := func(i int, next router.Handler) router.Handler {
+		return router.HandlerFunc(func(req router.Request, resp router.Response) error {
+			if i > deepest {
+				deepest = i
+			}
+			return next.Handle(req, resp)
+		})
+	}
+
+	chain := probe(len(layers), h)
+	for i := len(layers) - 1; i >= 0; i-- {
+		chain = probe(i, layers[i].Middleware(chain))
+	}
+
+	err := chain.Handle(req, resp)
+	if deepest < len(layers) {
+		return &ErrShortCircuited{
+			Layer: layers[deepest].Name,
+			Err:   err,
+		}
+	}
+	return err
+}
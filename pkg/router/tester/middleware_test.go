@@ -0,0 +1,68 @@
+package tester
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func passThrough(next router.Handler) router.Handler {
+	return next
+}
+
+func shortCircuit(router.Handler) router.Handler {
+	return router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		return errors.New("stopped")
+	})
+}
+
+func TestInvokeWithMiddleware(t *testing.T) {
+	var invoked bool
+	h := router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		invoked = true
+		return nil
+	})
+	req := router.Request{Object: configMap("a", nil)}
+
+	if err := InvokeWithMiddleware(h, []router.Middleware{passThrough, passThrough}, req, &Response{}); err != nil || !invoked {
+		t.Fatalf("expected the handler to be invoked through the middleware, got invoked %v and %v", invoked, err)
+	}
+
+	invoked = false
+	err := InvokeWithMiddleware(h, []router.Middleware{passThrough, shortCircuit}, req, &Response{})
+	var short *ErrShortCircuited
+	if !errors.As(err, &short) || invoked {
+		t.Fatalf("expected the handler to be short-circuited, got invoked %v and %v", invoked, err)
+	}
+	if !strings.HasSuffix(short.Layer, ".shortCircuit") || short.Err == nil || short.Err.Error() != "stopped" {
+		t.Fatalf("expected the short-circuiting middleware and its error, got %s and %v", short.Layer, short.Err)
+	}
+}
+
+func TestInvokeRoute(t *testing.T) {
+	var invoked bool
+	h := router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		invoked = true
+		return nil
+	})
+
+	// The route ignores removed objects by default.
+	var route router.RouteBuilder
+	err := InvokeRoute(route, h, router.Request{}, &Response{})
+	var short *ErrShortCircuited
+	if !errors.As(err, &short) || short.Layer != "IgnoreRemoveHandler" || short.Err != nil || invoked {
+		t.Fatalf("expected the removed object to be ignored, got invoked %v and %v", invoked, err)
+	}
+
+	if err := InvokeRoute(route.IncludeRemoved(), h, router.Request{}, &Response{}); err != nil || !invoked {
+		t.Fatalf("expected the handler to be invoked for the removed object, got invoked %v and %v", invoked, err)
+	}
+
+	invoked = false
+	if err := InvokeRoute(route, h, router.Request{Object: &corev1.ConfigMap{}}, &Response{}); err != nil || !invoked {
+		t.Fatalf("expected the handler to be invoked for the object, got invoked %v and %v", invoked, err)
+	}
+}
@@ -0,0 +1,161 @@
+package tester
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	"k8s.io/apimachinery/pkg/runtime"
+	testingclock "k8s.io/utils/clock/testing"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultErrorDelay is the delay before a failed reconcile is retried, the first delay of the router's queues.
+const defaultErrorDelay = 500 * time.Millisecond
+
+// Queue reconciles the keys of a type against in memory objects the way the queue of a router does, but on a fake
+// clock. A key whose reconcile requested a delay with Response.RetryAfter, or failed, is only due again once the clock
+// is stepped past the delay, so RetryAfter delays, back offs and debounce windows are tested without sleeping:
+//
+//	q := tester.NewQueue(scheme, &v1.Widget{}, handler, seed...)
+//	q.Add("default/foo")
+//	q.ProcessAll(t)
+//	q.Clock.Step(time.Minute)
+//	q.ProcessAll(t) // reconciles the keys that became due
+//
+// Give the same clock to the router with router.WithClock so that the handlers read the time from it.
+//
+// Queue doesn't run a router, it simulates the requeues of a router without WithBackoff over the runtime backend:
+//   - a key that is already due earlier keeps its earlier time, as in the delaying queue of the backend;
+//   - a successful reconcile is requeued after the shortest delay given to Response.RetryAfter, and a RetryAfter with
+//     a zero delay is ignored;
+//   - a failed reconcile is requeued after ErrorDelay, even if RetryAfter was called. The rate limiter of the backend
+//     starts at the same 500 milliseconds but doubles the delay of each consecutive failure, which Queue doesn't.
+//
+// The objects returned with Response.Objects are created or updated, without the pruning of the apply of a router.
+// Triggers, watches, quarantine and the other middleware of a router are not simulated.
+type Queue struct {
+	Scheme *runtime.Scheme
+	// Type is an object of the type that is reconciled, only its type is used.
+	Type    kclient.Object
+	Handler router.Handler
+	// Client holds the in memory objects that the reconciles read and write.
+	Client *Client
+	Clock  *testingclock.FakeClock
+	// ErrorDelay is the delay before a failed reconcile is retried, defaults to 500 milliseconds.
+	ErrorDelay time.Duration
+
+	due map[string]time.Time
+}
+
+// NewQueue returns a queue whose clock starts at the current time and whose reconciles read and write the objects.
+func NewQueue(scheme *runtime.Scheme, objType kclient.Object, handler router.Handler, objs ...kclient.Object) *Queue {
+	client := &Client{
+		SchemeObj: scheme,
+	}
+	for _, obj := range objs {
+		client.Objects = append(client.Objects, obj.DeepCopyObject().(kclient.Object))
+	}
+	return &Queue{
+		Scheme:  scheme,
+		Type:    objType,
+		Handler: handler,
+		Client:  client,
+		Clock:   testingclock.NewFakeClock(time.Now()),
+	}
+}
+
+// Add makes the key due now.
+func (q *Queue) Add(key string) {
+	q.AddAfter(key, 0)
+}
+
+// AddAfter makes the key due after the delay. Like the router's queue, a key that is already due earlier keeps its
+// earlier time.
+func (q *Queue) AddAfter(key string, delay time.Duration) {
+	if q.due == nil {
+		q.due = map[string]time.Time{}
+	}
+	at := q.Clock.Now().Add(delay)
+	if existing, ok := q.due[key]; ok && !at.Before(existing) {
+		return
+	}
+	q.due[key] = at
+}
+
+// Pending returns the keys that are waiting in the queue and when they are due.
+func (q *Queue) Pending() map[string]time.Time {
+	result := make(map[string]time.Time, len(q.due))
+	for key, at := range q.due {
+		result[key] = at
+	}
+	return result
+}
+
+// ProcessAll reconciles the keys that are due at the current time of the clock, in the order they became due, until
+// no key is due. The keys requeued with a delay stay in the queue until the clock is stepped. The clock is not
+// stepped by ProcessAll. It returns the result of each reconcile, in order.
+func (q *Queue) ProcessAll(t *testing.T) []*QueueResult {
+	t.Helper()
+
+	var results []*QueueResult
+	for {
+		key, ok := q.next()
+		if !ok {
+			return results
+		}
+		delete(q.due, key)
+
+		resp, err := reconcileKey(t, q.Scheme, q.Client, q.Type, key, q.Handler, false)
+		results = append(results, &QueueResult{
+			Key:        key,
+			RetryAfter: resp.Delay,
+			Err:        err,
+		})
+
+		switch {
+		case err != nil:
+			q.AddAfter(key, q.errorDelay())
+		case resp.Delay > 0:
+			q.AddAfter(key, resp.Delay)
+		}
+	}
+}
+
+// QueueResult is the outcome of a reconcile run by Queue.ProcessAll.
+type QueueResult struct {
+	Key string
+	// RetryAfter is the delay requested with Response.RetryAfter, zero if none was.
+	RetryAfter time.Duration
+	// Err is the error returned by the handler, after which the key is retried after the ErrorDelay.
+	Err error
+}
+
+// next returns the key that became due first of the keys due at the current time.
+func (q *Queue) next() (string, bool) {
+	now := q.Clock.Now()
+	var keys []string
+	for key, at := range q.due {
+		if !at.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ati, atj := q.due[keys[i]], q.due[keys[j]]; !ati.Equal(atj) {
+			return ati.Before(atj)
+		}
+		return keys[i] < keys[j]
+	})
+	return keys[0], true
+}
+
+func (q *Queue) errorDelay() time.Duration {
+	if q.ErrorDelay > 0 {
+		return q.ErrorDelay
+	}
+	return defaultErrorDelay
+}
@@ -0,0 +1,194 @@
+package tester
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// routerBackend is a backend over the in memory objects of a Client that runs the reconciles of a real router when
+// dispatch is called, and records the delays of the requeues that the router asks the backend for.
+type routerBackend struct {
+	*Client
+
+	lock     sync.Mutex
+	delays   map[string]time.Duration
+	watchers map[schema.GroupVersionKind]backend.Callback
+}
+
+func (b *routerBackend) Trigger(_ schema.GroupVersionKind, key string, delay time.Duration) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.delays[key] = delay
+	return nil
+}
+
+func (b *routerBackend) Watcher(_ context.Context, gvk schema.GroupVersionKind, _ string, cb backend.Callback) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.watchers[gvk] = cb
+	return nil
+}
+
+func (b *routerBackend) dispatch(gvk schema.GroupVersionKind, key string) error {
+	b.lock.Lock()
+	cb, ok := b.watchers[gvk]
+	b.lock.Unlock()
+	if !ok {
+		return errors.New("type is not watched")
+	}
+	_, err := cb(gvk, key, nil)
+	return err
+}
+
+func (b *routerBackend) GetInformerForKind(_ context.Context, gvk schema.GroupVersionKind) (cache.SharedIndexInformer, error) {
+	obj, err := b.SchemeObj.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return cache.NewSharedIndexInformer(&cache.ListWatch{}, obj, 0, cache.Indexers{}), nil
+}
+
+func (b *routerBackend) IndexField(context.Context, kclient.Object, string, kclient.IndexerFunc) error {
+	return nil
+}
+
+func (b *routerBackend) Preload(context.Context) error {
+	return nil
+}
+
+func (b *routerBackend) Start(context.Context) error {
+	return nil
+}
+
+func (b *routerBackend) GVKForObject(obj runtime.Object, scheme *runtime.Scheme) (schema.GroupVersionKind, error) {
+	return apiutil.GVKForObject(obj, scheme)
+}
+
+// routerRequeue reconciles the key once with a started router and returns the delay after which the key is due again,
+// and whether it is requeued at all. A failed reconcile is retried by the rate limiter of the backend, whose first
+// delay is the default ErrorDelay of Queue.
+func routerRequeue(t *testing.T, scheme *runtime.Scheme, handler router.Handler, key string, objs ...kclient.Object) (time.Duration, bool) {
+	t.Helper()
+	b := &routerBackend{
+		Client:   &Client{SchemeObj: scheme, Objects: objs},
+		delays:   map[string]time.Duration{},
+		watchers: map[schema.GroupVersionKind]backend.Callback{},
+	}
+	r := router.New(router.NewHandlerSet(t.Name(), scheme, b), nil, 0)
+	r.Type(&corev1.ConfigMap{}).Handler(handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-r.Stopped()
+	})
+	select {
+	case <-r.Ready():
+	case err := <-errs:
+		t.Fatalf("router failed to start: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("router did not start")
+	}
+
+	if err := b.dispatch(corev1.SchemeGroupVersion.WithKind("ConfigMap"), key); err != nil {
+		return defaultErrorDelay, true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delay, ok := b.delays[key]
+	return delay, ok
+}
+
+// TestQueueMatchesRouter checks that Queue requeues a key after the same delay as a router without WithBackoff does
+// for the outcomes of a reconcile that it simulates.
+func TestQueueMatchesRouter(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler router.HandlerFunc
+	}{
+		{
+			name: "done",
+			handler: func(req router.Request, resp router.Response) error {
+				return nil
+			},
+		},
+		{
+			name: "retry after",
+			handler: func(req router.Request, resp router.Response) error {
+				resp.RetryAfter(time.Minute)
+				return nil
+			},
+		},
+		{
+			name: "shortest retry after",
+			handler: func(req router.Request, resp router.Response) error {
+				resp.RetryAfter(time.Minute)
+				resp.RetryAfter(10 * time.Second)
+				return nil
+			},
+		},
+		{
+			name: "retry without delay",
+			handler: func(req router.Request, resp router.Response) error {
+				resp.RetryAfter(0)
+				return nil
+			},
+		},
+		{
+			name: "error",
+			handler: func(req router.Request, resp router.Response) error {
+				return errors.New("failed")
+			},
+		},
+		{
+			name: "error with retry after",
+			handler: func(req router.Request, resp router.Response) error {
+				resp.RetryAfter(time.Minute)
+				return errors.New("failed")
+			},
+		},
+		{
+			name: "update and retry after",
+			handler: func(req router.Request, resp router.Response) error {
+				cm := req.Object.(*corev1.ConfigMap)
+				cm.Data = map[string]string{"k": "v"}
+				resp.RetryAfter(time.Minute)
+				return req.Client.Update(req.Ctx, cm)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := queueScheme(t)
+			wantDelay, wantRequeued := routerRequeue(t, scheme, tt.handler, "default/a", configMap("a", nil))
+
+			q := NewQueue(scheme, &corev1.ConfigMap{}, tt.handler, configMap("a", nil))
+			q.Add("default/a")
+			q.ProcessAll(t)
+			at, requeued := q.Pending()["default/a"]
+			if requeued != wantRequeued {
+				t.Fatalf("expected the key to be requeued %v as by the router, got %v", wantRequeued, requeued)
+			}
+			if delay := at.Sub(q.Clock.Now()); requeued && delay != wantDelay {
+				t.Fatalf("expected the key to be requeued after %s as by the router, got %s", wantDelay, delay)
+			}
+		})
+	}
+}
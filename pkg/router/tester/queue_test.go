@@ -0,0 +1,131 @@
+package tester
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func queueScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func resultKeys(results []*QueueResult) []string {
+	var result []string
+	for _, r := range results {
+		result = append(result, r.Key)
+	}
+	return result
+}
+
+func TestQueueRetryAfter(t *testing.T) {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+	var q *Queue
+	q = NewQueue(queueScheme(t), &corev1.ConfigMap{}, router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		// The handler waits for a minute after the object was first seen before marking it ready.
+		cm := req.Object.(*corev1.ConfigMap)
+		if cm.Annotations["seen"] == "" {
+			cm.Annotations = map[string]string{"seen": q.Clock.Now().Format(time.RFC3339Nano)}
+			resp.RetryAfter(time.Minute)
+			return req.Client.Update(req.Ctx, cm)
+		}
+		seen, err := time.Parse(time.RFC3339Nano, cm.Annotations["seen"])
+		if err != nil {
+			return err
+		}
+		if wait := time.Minute - q.Clock.Since(seen); wait > 0 {
+			resp.RetryAfter(wait)
+			return nil
+		}
+		cm.Annotations["ready"] = "true"
+		return req.Client.Update(req.Ctx, cm)
+	}), cm)
+
+	q.Add("default/a")
+	results := q.ProcessAll(t)
+	if len(results) != 1 || results[0].RetryAfter != time.Minute || results[0].Err != nil {
+		t.Fatalf("expected one reconcile requeued after a minute, got %+v", results)
+	}
+	if results := q.ProcessAll(t); len(results) != 0 {
+		t.Fatalf("expected no key to be due before the clock is stepped, got %v", resultKeys(results))
+	}
+
+	q.Clock.Step(30 * time.Second)
+	if results := q.ProcessAll(t); len(results) != 0 {
+		t.Fatalf("expected no key to be due after half the delay, got %v", resultKeys(results))
+	}
+
+	q.Clock.Step(30 * time.Second)
+	results = q.ProcessAll(t)
+	if len(results) != 1 || results[0].RetryAfter != 0 {
+		t.Fatalf("expected the key to be reconciled once it was due, got %+v", results)
+	}
+	if len(q.Pending()) != 0 {
+		t.Fatalf("expected no pending keys, got %v", q.Pending())
+	}
+
+	var result corev1.ConfigMap
+	if err := q.Client.Get(context.Background(), router.Key("default", "a"), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Annotations["ready"] != "true" {
+		t.Fatalf("expected the object to be ready, got %v", result.Annotations)
+	}
+}
+
+func TestQueueOrderAndDebounce(t *testing.T) {
+	q := NewQueue(queueScheme(t), &corev1.ConfigMap{}, router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		return nil
+	}))
+
+	q.AddAfter("default/b", 2*time.Second)
+	q.AddAfter("default/a", 3*time.Second)
+	q.AddAfter("default/c", time.Second)
+	// A later enqueue of a key that is already due earlier keeps the earlier time.
+	q.AddAfter("default/c", 10*time.Second)
+
+	q.Clock.Step(3 * time.Second)
+	if got := resultKeys(q.ProcessAll(t)); len(got) != 3 || got[0] != "default/c" || got[1] != "default/b" || got[2] != "default/a" {
+		t.Fatalf("expected the keys in the order they became due, got %v", got)
+	}
+}
+
+func TestQueueRetriesErrors(t *testing.T) {
+	fail := true
+	q := NewQueue(queueScheme(t), &corev1.ConfigMap{}, router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+	q.ErrorDelay = time.Second
+
+	q.Add("default/a")
+	if results := q.ProcessAll(t); len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected a failed reconcile, got %+v", results)
+	}
+	if at, ok := q.Pending()["default/a"]; !ok || !at.Equal(q.Clock.Now().Add(time.Second)) {
+		t.Fatalf("expected the key to be retried after a second, got %v", q.Pending())
+	}
+
+	fail = false
+	q.Clock.Step(time.Second)
+	if results := q.ProcessAll(t); len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("expected the retry to succeed, got %+v", results)
+	}
+	if len(q.Pending()) != 0 {
+		t.Fatalf("expected no pending keys, got %v", q.Pending())
+	}
+}
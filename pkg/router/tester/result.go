@@ -0,0 +1,141 @@
+package tester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Result is the outcome of a reconcile run with Harness.Reconcile.
+type Result struct {
+	// Objects are the objects declared by the handler with router.Objects.
+	Objects []kclient.Object
+	// Created, Updated and Deleted are the objects written through the request's client, in order. Writes of the
+	// status are in Updated.
+	Created []kclient.Object
+	Updated []kclient.Object
+	Deleted []kclient.Object
+	// Writes are the mutating calls made through the request's client, in order.
+	Writes []Write
+	// RetryAfter is the shortest delay requested with Response.RetryAfter, zero if none was.
+	RetryAfter time.Duration
+	// Err is the error returned by the handler.
+	Err error
+	// Response is the response the handler was invoked with.
+	Response *Response
+
+	scheme *runtime.Scheme
+}
+
+// NewHarness returns a harness whose reconciles read and write the existing objects in a controller-runtime fake
+// client, see Client.
+func NewHarness(scheme *runtime.Scheme, existing ...kclient.Object) *Harness {
+	return &Harness{
+		Scheme:   scheme,
+		Existing: existing,
+	}
+}
+
+// Reconcile invokes the handler with a request built as the router builds it for the input object, and returns what
+// the handler did. Like the router, the status of the input object is saved if the handler changed it and returned no
+// error. Unlike Invoke, the expectations of the harness are not asserted, so that reconciles that are expected to fail
+// or requeue can be inspected.
+func (b *Harness) Reconcile(t *testing.T, input kclient.Object, handler router.Handler) *Result {
+	t.Helper()
+	return b.ReconcileWithContext(t, context.TODO(), input, handler)
+}
+
+// ReconcileWithContext is Reconcile with the context of the request.
+func (b *Harness) ReconcileWithContext(t *testing.T, ctx context.Context, input kclient.Object, handler router.Handler) *Result {
+	t.Helper()
+
+	req := NewRequestWithContext(t, ctx, b.Scheme, input, b.Existing...)
+	req.FromTrigger = b.FromTrigger
+	resp := &Response{
+		Client: req.Client.(*Client),
+	}
+
+	unmodified := input.DeepCopyObject().(kclient.Object)
+	err := handler.Handle(req, resp)
+	if err == nil && router.StatusChanged(unmodified, req.Object) {
+		// Mimic the router saving the status after the handlers run.
+		require.NoError(t, resp.Client.Status().Update(req.Ctx, req.Object))
+	}
+
+	if b.triggers == nil {
+		b.triggers = map[string][]Trigger{}
+	}
+	b.triggers[req.Key] = resp.Client.Triggers
+	b.writes = resp.Client.Writes
+
+	return &Result{
+		Objects:    resp.Collected,
+		Created:    resp.Client.Created,
+		Updated:    resp.Client.Updated,
+		Deleted:    resp.Client.Deleted,
+		Writes:     resp.Client.Writes,
+		RetryAfter: resp.Delay,
+		Err:        err,
+		Response:   resp,
+		scheme:     b.Scheme,
+	}
+}
+
+// MatchGolden compares the objects declared, created and updated by the reconcile against the expected.golden file in
+// the given directory. Only the last version of an object that was written more than once is compared, and deleted
+// objects are left out.
+func (r *Result) MatchGolden(t *testing.T, dir string) {
+	t.Helper()
+	MatchGolden(t, r.scheme, r.Final(t), dir)
+}
+
+// Final returns the last version of each object declared, created or updated by the reconcile that was not deleted,
+// in the order they were first written.
+func (r *Result) Final(t *testing.T) []kclient.Object {
+	t.Helper()
+
+	var (
+		keys   []ObjectKey
+		latest = map[ObjectKey]kclient.Object{}
+	)
+	add := func(objs []kclient.Object) {
+		for _, o := range objs {
+			key := r.key(t, o)
+			if _, ok := latest[key]; !ok {
+				keys = append(keys, key)
+			}
+			latest[key] = o
+		}
+	}
+	add(r.Objects)
+	add(r.Created)
+	add(r.Updated)
+	for _, o := range r.Deleted {
+		delete(latest, r.key(t, o))
+	}
+
+	result := make([]kclient.Object, 0, len(latest))
+	for _, key := range keys {
+		if o, ok := latest[key]; ok {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+func (r *Result) key(t *testing.T, o kclient.Object) ObjectKey {
+	t.Helper()
+	gvk, err := apiutil.GVKForObject(o, r.scheme)
+	require.NoError(t, err)
+	return ObjectKey{
+		GVK:       gvk,
+		Namespace: o.GetNamespace(),
+		Name:      o.GetName(),
+	}
+}
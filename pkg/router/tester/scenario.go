@@ -0,0 +1,305 @@
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	yaml2 "sigs.k8s.io/yaml"
+)
+
+const defaultMaxReconciles = 10
+
+// ErrorMatcher returns true if the error returned by a reconcile is the expected one.
+type ErrorMatcher func(err error) bool
+
+func ErrorContains(s string) ErrorMatcher {
+	return func(err error) bool {
+		return err != nil && strings.Contains(err.Error(), s)
+	}
+}
+
+func ErrorIs(target error) ErrorMatcher {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+func AnyError() ErrorMatcher {
+	return func(err error) bool {
+		return err != nil
+	}
+}
+
+// Scenario describes a reconcile of a single key against an in memory set of objects and the expected state
+// afterward.
+type Scenario struct {
+	Name   string
+	Scheme *runtime.Scheme
+	// Handler is invoked for ReconcileKey.
+	Handler router.Handler
+	// SeedObjects are the objects that exist before the first reconcile.
+	SeedObjects []kclient.Object
+	// ReconcileType is an object of the type that is reconciled, only its type is used.
+	ReconcileType kclient.Object
+	// ReconcileKey is the namespace/name, or name for cluster scoped objects, to reconcile. The object is read from
+	// the in memory objects before each reconcile so that the changes of the previous reconcile are observed.
+	ReconcileKey string
+	// Reconciles is the number of times to reconcile the key, defaults to 1. It is ignored if UntilStable is set.
+	Reconciles int
+	// UntilStable reconciles the key until a reconcile performs no writes, up to MaxReconciles times.
+	UntilStable bool
+	// MaxReconciles bounds UntilStable, defaults to 10.
+	MaxReconciles int
+	// FromTrigger sets Request.FromTrigger of the reconciles, as if the key was enqueued by a trigger.
+	FromTrigger bool
+
+	// ExpectedObjects must exist after the reconciles, they include the objects passed to Response.Objects.
+	ExpectedObjects []kclient.Object
+	// SubsetMatch only compares the fields set in the ExpectedObjects instead of the entire object.
+	SubsetMatch bool
+	// ExpectedMissing must not exist after the reconciles.
+	ExpectedMissing []kclient.Object
+	// ExpectedRetryAfter is the delay requested by the last reconcile.
+	ExpectedRetryAfter time.Duration
+	// ExpectedError matches the error of the last reconcile. If nil, the last reconcile must not return an error.
+	ExpectedError ErrorMatcher
+}
+
+// RunScenarios runs each scenario as a subtest.
+func RunScenarios(t *testing.T, scenarios []Scenario) {
+	t.Helper()
+	for _, s := range scenarios {
+		t.Run(s.Name, s.Run)
+	}
+}
+
+// Run executes the scenario and asserts the expected results.
+func (s Scenario) Run(t *testing.T) {
+	t.Helper()
+	require.NotNil(t, s.Scheme, "scenario scheme is required")
+	require.NotNil(t, s.ReconcileType, "scenario reconcile type is required")
+
+	client := &Client{
+		SchemeObj: s.Scheme,
+	}
+	for _, obj := range s.SeedObjects {
+		client.Objects = append(client.Objects, obj.DeepCopyObject().(kclient.Object))
+	}
+
+	resp, err := s.reconcile(t, client)
+
+	if s.ExpectedError == nil {
+		assert.NoError(t, err, "last reconcile of %s returned an error", s.ReconcileKey)
+	} else {
+		assert.True(t, s.ExpectedError(err), "last reconcile of %s returned unexpected error: %v", s.ReconcileKey, err)
+	}
+	if resp != nil {
+		assert.Equal(t, s.ExpectedRetryAfter, resp.Delay, "unexpected retry after for %s", s.ReconcileKey)
+	}
+
+	for _, expected := range s.ExpectedObjects {
+		s.assertObject(t, client, expected)
+	}
+
+	for _, missing := range s.ExpectedMissing {
+		actual := missing.DeepCopyObject().(kclient.Object)
+		err := client.Get(context.Background(), router.Key(missing.GetNamespace(), missing.GetName()), actual)
+		assert.Truef(t, apierrors.IsNotFound(err), "expected %T %s/%s to not exist", missing, missing.GetNamespace(), missing.GetName())
+	}
+}
+
+func (s Scenario) reconcile(t *testing.T, client *Client) (*Response, error) {
+	t.Helper()
+
+	count, maxCount := s.Reconciles, s.Reconciles
+	if count <= 0 {
+		count, maxCount = 1, 1
+	}
+	if s.UntilStable {
+		maxCount = s.MaxReconciles
+		if maxCount <= 0 {
+			maxCount = defaultMaxReconciles
+		}
+		count = maxCount
+	}
+
+	var (
+		resp *Response
+		err  error
+	)
+	for i := 0; i < count; i++ {
+		writes := client.writes
+		resp, err = s.reconcileOnce(t, client)
+		if s.UntilStable && client.writes == writes {
+			return resp, err
+		}
+	}
+
+	if s.UntilStable {
+		t.Fatalf("reconciling %s did not converge after %d reconciles, the last reconcile still wrote objects, last error: %v", s.ReconcileKey, maxCount, err)
+	}
+	return resp, err
+}
+
+func (s Scenario) reconcileOnce(t *testing.T, client *Client) (*Response, error) {
+	t.Helper()
+	return reconcileKey(t, s.Scheme, client, s.ReconcileType, s.ReconcileKey, s.Handler, s.FromTrigger)
+}
+
+// reconcileKey reads the object of the key from the client, invokes the handler with it and applies the objects of the
+// response, as the router does.
+func reconcileKey(t *testing.T, scheme *runtime.Scheme, client *Client, objType kclient.Object, key string, handler router.Handler, fromTrigger bool) (*Response, error) {
+	t.Helper()
+
+	ns, name, ok := strings.Cut(key, "/")
+	if !ok {
+		ns, name = "", key
+	}
+
+	gvk, err := apiutil.GVKForObject(objType, scheme)
+	require.NoError(t, err)
+
+	obj := objType.DeepCopyObject().(kclient.Object)
+	if err := client.Get(context.Background(), router.Key(ns, name), obj); apierrors.IsNotFound(err) {
+		obj = nil
+	} else {
+		require.NoError(t, err)
+	}
+
+	req := router.Request{
+		Client:      client,
+		Object:      obj,
+		Ctx:         context.Background(),
+		GVK:         gvk,
+		Namespace:   ns,
+		Name:        name,
+		Key:         key,
+		FromTrigger: fromTrigger,
+	}
+	resp := &Response{
+		Client: client,
+	}
+
+	var unmodified kclient.Object
+	if obj != nil {
+		unmodified = obj.DeepCopyObject().(kclient.Object)
+	}
+
+	err = handler.Handle(req, resp)
+	if err == nil && obj != nil && router.StatusChanged(unmodified, obj) {
+		// Mimic the router saving the status after the handlers run.
+		require.NoError(t, client.Status().Update(req.Ctx, obj))
+	}
+	if err == nil {
+		applyObjects(t, client, resp.Collected)
+	}
+	return resp, err
+}
+
+// applyObjects mimics the router applying the objects passed to Response.Objects: missing objects are created and
+// existing objects are updated if they differ from the desired object. Unlike apply, nothing is pruned and the
+// status is ignored.
+func applyObjects(t *testing.T, client *Client, objs []kclient.Object) {
+	t.Helper()
+
+	for _, desired := range objs {
+		desired = desired.DeepCopyObject().(kclient.Object)
+		existing := desired.DeepCopyObject().(kclient.Object)
+		err := client.Get(context.Background(), router.Key(desired.GetNamespace(), desired.GetName()), existing)
+		if apierrors.IsNotFound(err) {
+			require.NoError(t, client.Create(context.Background(), desired))
+			continue
+		}
+		require.NoError(t, err)
+
+		desired.SetResourceVersion(existing.GetResourceVersion())
+		desired.SetUID(existing.GetUID())
+		desired.SetCreationTimestamp(existing.GetCreationTimestamp())
+		if !assert.ObjectsAreEqual(specMap(t, desired), specMap(t, existing)) {
+			require.NoError(t, client.Update(context.Background(), desired))
+		}
+	}
+}
+
+// specMap returns the fields of the object without its kind and status, which the client may not have populated.
+func specMap(t *testing.T, obj kclient.Object) map[string]any {
+	t.Helper()
+	result := toMap(t, normalize(obj))
+	delete(result, "apiVersion")
+	delete(result, "kind")
+	delete(result, "status")
+	return result
+}
+
+func (s Scenario) assertObject(t *testing.T, client *Client, expected kclient.Object) {
+	t.Helper()
+
+	actual := expected.DeepCopyObject().(kclient.Object)
+	if err := client.Get(context.Background(), router.Key(expected.GetNamespace(), expected.GetName()), actual); err != nil {
+		assert.Failf(t, "missing expected object", "%T %s/%s: %v", expected, expected.GetNamespace(), expected.GetName(), err)
+		return
+	}
+
+	gvk, err := apiutil.GVKForObject(expected, s.Scheme)
+	require.NoError(t, err)
+
+	expected, actual = normalize(expected), normalize(actual)
+	expected.GetObjectKind().SetGroupVersionKind(gvk)
+	actual.GetObjectKind().SetGroupVersionKind(gvk)
+
+	if s.SubsetMatch {
+		if diff := subsetDiff(t, expected, actual); len(diff) > 0 {
+			assert.Failf(t, "object does not match", "%v %s/%s:\n%s", gvk, expected.GetNamespace(), expected.GetName(), strings.Join(diff, "\n"))
+		}
+		return
+	}
+
+	left, _ := yaml2.Marshal(expected)
+	right, _ := yaml2.Marshal(actual)
+	assert.Equal(t, string(stripLastTransition(left)), string(stripLastTransition(right)), "object %s/%s (%v) does not match", expected.GetNamespace(), expected.GetName(), gvk)
+}
+
+// subsetDiff returns a description of every field set in expected that has a different value in actual.
+func subsetDiff(t *testing.T, expected, actual kclient.Object) []string {
+	t.Helper()
+	return diffValues("", toMap(t, expected), toMap(t, actual))
+}
+
+func toMap(t *testing.T, obj kclient.Object) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(obj)
+	require.NoError(t, err)
+	result := map[string]any{}
+	require.NoError(t, json.Unmarshal(data, &result))
+	return result
+}
+
+func diffValues(path string, expected, actual any) (result []string) {
+	expectedMap, ok := expected.(map[string]any)
+	if !ok {
+		if !assert.ObjectsAreEqual(expected, actual) {
+			e, _ := json.Marshal(expected)
+			a, _ := json.Marshal(actual)
+			result = append(result, fmt.Sprintf("%s: expected %s, got %s", path, e, a))
+		}
+		return result
+	}
+
+	actualMap, _ := actual.(map[string]any)
+	for k, v := range expectedMap {
+		result = append(result, diffValues(path+"."+k, v, actualMap[k])...)
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package tester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// copyHandler copies the data of the reconciled config map to the config map named by its "copy" key through
+// Response.Objects, and waits for the copy before marking the source done.
+var copyHandler = router.HandlerFunc(func(req router.Request, resp router.Response) error {
+	cm := req.Object.(*corev1.ConfigMap)
+	target := cm.Data["copy"]
+	if target == "" {
+		return nil
+	}
+
+	if err := router.Objects(resp, configMap(target, map[string]string{"from": cm.Name})); err != nil {
+		return err
+	}
+
+	var existing corev1.ConfigMap
+	if err := req.Get(&existing, cm.Namespace, target); err != nil {
+		resp.RetryAfter(time.Second)
+		return nil
+	}
+	if cm.Annotations["done"] == "" {
+		cm.Annotations = map[string]string{"done": "true"}
+		return req.Client.Update(req.Ctx, cm)
+	}
+	return nil
+})
+
+func TestScenarios(t *testing.T) {
+	scheme := queueScheme(t)
+
+	RunScenarios(t, []Scenario{
+		{
+			Name:          "applies the objects of the handler",
+			Scheme:        scheme,
+			Handler:       copyHandler,
+			SeedObjects:   []kclient.Object{configMap("a", map[string]string{"copy": "b"})},
+			ReconcileType: &corev1.ConfigMap{},
+			ReconcileKey:  "default/a",
+			SubsetMatch:   true,
+			ExpectedObjects: []kclient.Object{
+				configMap("b", map[string]string{"from": "a"}),
+			},
+			ExpectedRetryAfter: time.Second,
+		},
+		{
+			Name:          "until stable",
+			Scheme:        scheme,
+			Handler:       copyHandler,
+			SeedObjects:   []kclient.Object{configMap("a", map[string]string{"copy": "b"})},
+			ReconcileType: &corev1.ConfigMap{},
+			ReconcileKey:  "default/a",
+			UntilStable:   true,
+			ExpectedObjects: []kclient.Object{
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", Annotations: map[string]string{"done": "true"}},
+					Data:       map[string]string{"copy": "b"},
+				},
+				configMap("b", map[string]string{"from": "a"}),
+			},
+		},
+		{
+			Name:          "reconcile count",
+			Scheme:        scheme,
+			Handler:       copyHandler,
+			SeedObjects:   []kclient.Object{configMap("a", map[string]string{"copy": "b"})},
+			ReconcileType: &corev1.ConfigMap{},
+			ReconcileKey:  "default/a",
+			Reconciles:    2,
+			SubsetMatch:   true,
+			ExpectedObjects: []kclient.Object{
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", Annotations: map[string]string{"done": "true"}}},
+			},
+		},
+		{
+			Name:            "missing",
+			Scheme:          scheme,
+			Handler:         copyHandler,
+			SeedObjects:     []kclient.Object{configMap("a", nil)},
+			ReconcileType:   &corev1.ConfigMap{},
+			ReconcileKey:    "default/a",
+			ExpectedMissing: []kclient.Object{configMap("b", nil)},
+		},
+		{
+			Name:   "expected error",
+			Scheme: scheme,
+			Handler: router.HandlerFunc(func(req router.Request, resp router.Response) error {
+				_ = router.Objects(resp, configMap("b", nil))
+				return &ErrShortCircuited{Layer: "test"}
+			}),
+			SeedObjects:   []kclient.Object{configMap("a", nil)},
+			ReconcileType: &corev1.ConfigMap{},
+			ReconcileKey:  "default/a",
+			ExpectedError: ErrorContains("short-circuited by [test]"),
+			// The objects of a failed reconcile are not applied.
+			ExpectedMissing: []kclient.Object{configMap("b", nil)},
+		},
+	})
+}
+
+func TestSubsetDiff(t *testing.T) {
+	expected := configMap("a", map[string]string{"k": "1"})
+	actual := configMap("a", map[string]string{"k": "2", "other": "3"})
+
+	diff := subsetDiff(t, expected, actual)
+	if len(diff) != 1 || diff[0] != `.data.k: expected "1", got "2"` {
+		t.Fatalf("expected only the differing field of expected, got %v", diff)
+	}
+
+	actual.Data["k"] = "1"
+	if diff := subsetDiff(t, expected, actual); len(diff) != 0 {
+		t.Fatalf("expected the fields missing from expected to be ignored, got %v", diff)
+	}
+}
@@ -30,6 +30,12 @@ type Harness struct {
 	ExpectedOutput     []kclient.Object
 	ExpectedGoldenPath string
 	ExpectedDelay      time.Duration
+	// FromTrigger sets Request.FromTrigger of the invocations, as if the keys were enqueued by a trigger.
+	FromTrigger bool
+
+	triggers map[string][]Trigger
+	// writes are the writes of the last invocation.
+	writes []Write
 }
 
 func genericToTyped(scheme *runtime.Scheme, objs []runtime.Object) ([]kclient.Object, error) {
@@ -196,8 +202,14 @@ func (b *Harness) InvokeWithContext(t *testing.T, ctx context.Context, input kcl
 			Client: req.Client.(*Client),
 		}
 	)
+	req.FromTrigger = b.FromTrigger
 
 	err := handler.Handle(req, &resp)
+	if b.triggers == nil {
+		b.triggers = map[string][]Trigger{}
+	}
+	b.triggers[req.Key] = resp.Client.Triggers
+	b.writes = resp.Client.Writes
 	if err != nil {
 		return &resp, err
 	}
@@ -0,0 +1,141 @@
+package tester
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/untriggered"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Trigger is a trigger registration that the router would have performed for a read made through the Request's
+// client. An empty Name means the trigger matches every object selected by Namespace, Selector and Fields.
+type Trigger struct {
+	SourceGVK schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Selector  labels.Selector
+	Fields    fields.Selector
+}
+
+// SourceKey returns the namespace/name of the source object for triggers registered with a Get.
+func (t Trigger) SourceKey() string {
+	return toKey(t.Namespace, t.Name)
+}
+
+// Matches returns true if a change to the object would enqueue the key that registered this trigger.
+//
+// The field selector of the trigger is matched with metadata.name, metadata.namespace, the owner UID index of the
+// backend, and the fields of objects that implement fields.Fields. Other fields, such as the ones indexed with
+// IndexField in a router, are unknown to the harness and are assumed to match, like the router does for fields it
+// can't read.
+func (t Trigger) Matches(gvk schema.GroupVersionKind, obj kclient.Object) bool {
+	if gvk != t.SourceGVK {
+		return false
+	}
+	if t.Name != "" {
+		return t.Name == obj.GetName() && t.Namespace == obj.GetNamespace()
+	}
+	if t.Namespace != "" && t.Namespace != obj.GetNamespace() {
+		return false
+	}
+	if t.Selector != nil && !t.Selector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	if t.Fields != nil && !fieldsMatch(t.Fields, obj) {
+		return false
+	}
+	return true
+}
+
+func fieldsMatch(sel fields.Selector, obj kclient.Object) bool {
+	for _, req := range sel.Requirements() {
+		values, ok := fieldValues(obj, req.Field)
+		if !ok {
+			continue
+		}
+		switch req.Operator {
+		case selection.Equals, selection.DoubleEquals:
+			if !slices.Contains(values, req.Value) {
+				return false
+			}
+		case selection.NotEquals:
+			if slices.Contains(values, req.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fieldValues returns the values of the field of the object, and false if the field is unknown.
+func fieldValues(obj kclient.Object, field string) ([]string, bool) {
+	switch field {
+	case "metadata.name":
+		return []string{obj.GetName()}, true
+	case "metadata.namespace":
+		return []string{obj.GetNamespace()}, true
+	case backend.OwnerUIDIndexField:
+		var uids []string
+		for _, ref := range obj.GetOwnerReferences() {
+			uids = append(uids, string(ref.UID))
+		}
+		return uids, true
+	}
+	if f, ok := obj.(fields.Fields); ok && f.Has(field) {
+		return []string{f.Get(field)}, true
+	}
+	return nil, false
+}
+
+func (c *Client) recordTrigger(obj runtime.Object, namespace, name string, sel labels.Selector, fields fields.Selector) {
+	if untriggered.IsWrapped(obj) {
+		return
+	}
+	gvk, err := apiutil.GVKForObject(obj, c.SchemeObj)
+	if err != nil {
+		return
+	}
+	if _, ok := obj.(kclient.ObjectList); ok {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	c.Triggers = append(c.Triggers, Trigger{
+		SourceGVK: gvk,
+		Namespace: namespace,
+		Name:      name,
+		Selector:  sel,
+		Fields:    fields,
+	})
+}
+
+// TriggersRegistered returns the triggers registered by the last invocation of the harness for the key.
+func (b *Harness) TriggersRegistered(forKey string) []Trigger {
+	return b.triggers[forKey]
+}
+
+// Enqueues returns the keys, of the keys invoked through the harness, that would be enqueued by a change to the
+// given source object.
+func (b *Harness) Enqueues(source kclient.Object) ([]string, error) {
+	gvk, err := apiutil.GVKForObject(source, b.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key, triggers := range b.triggers {
+		for _, t := range triggers {
+			if t.Matches(gvk, source) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,105 @@
+package tester
+
+import (
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var secretGVK = corev1.SchemeGroupVersion.WithKind("Secret")
+
+func secret(name string, labels map[string]string, owners ...string) *corev1.Secret {
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels}}
+	for _, uid := range owners {
+		s.OwnerReferences = append(s.OwnerReferences, metav1.OwnerReference{UID: k8stypes.UID(uid)})
+	}
+	return s
+}
+
+func TestTriggerMatches(t *testing.T) {
+	for name, test := range map[string]struct {
+		trigger  Trigger
+		obj      kclient.Object
+		expected bool
+	}{
+		"name":              {trigger: Trigger{SourceGVK: secretGVK, Namespace: "default", Name: "a"}, obj: secret("a", nil), expected: true},
+		"other name":        {trigger: Trigger{SourceGVK: secretGVK, Namespace: "default", Name: "a"}, obj: secret("b", nil)},
+		"other kind":        {trigger: Trigger{SourceGVK: corev1.SchemeGroupVersion.WithKind("ConfigMap"), Namespace: "default", Name: "a"}, obj: secret("a", nil)},
+		"label selector":    {trigger: Trigger{SourceGVK: secretGVK, Selector: labels.SelectorFromSet(labels.Set{"app": "web"})}, obj: secret("a", map[string]string{"app": "web"}), expected: true},
+		"other labels":      {trigger: Trigger{SourceGVK: secretGVK, Selector: labels.SelectorFromSet(labels.Set{"app": "web"})}, obj: secret("a", map[string]string{"app": "db"})},
+		"other namespace":   {trigger: Trigger{SourceGVK: secretGVK, Namespace: "other"}, obj: secret("a", nil)},
+		"name field":        {trigger: Trigger{SourceGVK: secretGVK, Fields: fields.OneTermEqualSelector("metadata.name", "a")}, obj: secret("a", nil), expected: true},
+		"other name field":  {trigger: Trigger{SourceGVK: secretGVK, Fields: fields.OneTermEqualSelector("metadata.name", "a")}, obj: secret("b", nil)},
+		"not equal field":   {trigger: Trigger{SourceGVK: secretGVK, Fields: fields.OneTermNotEqualSelector("metadata.name", "a")}, obj: secret("a", nil)},
+		"owner field":       {trigger: Trigger{SourceGVK: secretGVK, Fields: fields.OneTermEqualSelector(backend.OwnerUIDIndexField, "owner")}, obj: secret("a", nil, "other", "owner"), expected: true},
+		"other owner field": {trigger: Trigger{SourceGVK: secretGVK, Fields: fields.OneTermEqualSelector(backend.OwnerUIDIndexField, "owner")}, obj: secret("a", nil, "other")},
+		// The harness can't read the fields indexed in a router, so they are assumed to match.
+		"unknown field": {trigger: Trigger{SourceGVK: secretGVK, Fields: fields.OneTermEqualSelector("spec.indexed", "x")}, obj: secret("a", nil), expected: true},
+		"unknown and known fields": {
+			trigger: Trigger{SourceGVK: secretGVK, Fields: fields.AndSelectors(fields.OneTermEqualSelector("spec.indexed", "x"), fields.OneTermEqualSelector("metadata.name", "a"))},
+			obj:     secret("b", nil),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if matches := test.trigger.Matches(secretGVK, test.obj); matches != test.expected {
+				t.Fatalf("expected a match: %v, got %v", test.expected, matches)
+			}
+		})
+	}
+}
+
+func TestTriggersRegisteredAndEnqueues(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	h := &Harness{Scheme: scheme, Existing: []kclient.Object{secret("bar", nil)}}
+
+	input := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo", UID: "foo-uid"}}
+	_, err := h.InvokeFunc(t, input, func(req router.Request, resp router.Response) error {
+		if err := req.Get(&corev1.Secret{}, "default", "bar"); err != nil {
+			return err
+		}
+		return req.List(&corev1.SecretList{}, &kclient.ListOptions{
+			Namespace:     "default",
+			FieldSelector: fields.OneTermEqualSelector(backend.OwnerUIDIndexField, "foo-uid"),
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggers := h.TriggersRegistered("default/foo")
+	if len(triggers) != 2 || triggers[0].SourceGVK != secretGVK || triggers[0].SourceKey() != "default/bar" {
+		t.Fatalf("expected a trigger on the secret and its owned secrets, got %+v", triggers)
+	}
+
+	for name, test := range map[string]struct {
+		source   kclient.Object
+		expected bool
+	}{
+		"read secret":  {source: secret("bar", nil), expected: true},
+		"owned secret": {source: secret("owned", nil, "foo-uid"), expected: true},
+		"other secret": {source: secret("other", nil, "other-uid")},
+		"other kind":   {source: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "bar"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			keys, err := h.Enqueues(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enqueued := len(keys) == 1 && keys[0] == "default/foo"; enqueued != test.expected {
+				t.Fatalf("expected the key to be enqueued: %v, got %v", test.expected, keys)
+			}
+		})
+	}
+}
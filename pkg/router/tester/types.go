@@ -10,69 +10,115 @@ import (
 	"github.com/google/uuid"
 	"github.com/obot-platform/nah/pkg/router"
 	"github.com/obot-platform/nah/pkg/untriggered"
-	"golang.org/x/exp/maps"
-	"k8s.io/apimachinery/pkg/api/errors"
 	meta2 "k8s.io/apimachinery/pkg/api/meta"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
+// Client is an in memory client backed by the controller-runtime fake client, which records the writes and the trigger
+// registrations of the calls made through it. The fake client is seeded with the Objects on the first call, so changes
+// to Objects after that are not seen. Like the types of most CRDs, the types with a Status field have a status
+// subresource: Update leaves their status unchanged and Status().Update only changes the status.
 type Client struct {
 	Objects   []kclient.Object
 	SchemeObj *runtime.Scheme
 	Created   []kclient.Object
 	Updated   []kclient.Object
-}
+	Deleted   []kclient.Object
+	// Triggers are the trigger registrations the router would perform for the reads through this client.
+	Triggers []Trigger
+	// Writes are the mutating calls made through this client, in order.
+	Writes []Write
 
-func (c Client) Watch(ctx context.Context, obj kclient.ObjectList, opts ...kclient.ListOption) (watch.Interface, error) {
-	panic("unsupported")
+	writes int
+	store  kclient.WithWatch
 }
 
-func (c Client) objects() []kclient.Object {
-	return append(append(c.Objects, c.Created...), c.Updated...)
-}
+// backing returns the fake client, seeded with the Objects on the first call. When several Objects have the same type,
+// namespace and name, the last one is kept.
+func (c *Client) backing() kclient.WithWatch {
+	if c.store != nil {
+		return c.store
+	}
 
-func (c *Client) Get(ctx context.Context, key kclient.ObjectKey, out kclient.Object, opts ...kclient.GetOption) error {
-	if u, ok := out.(*untriggered.Holder); ok {
-		out = u.Object
+	var (
+		keys   []ObjectKey
+		latest = map[ObjectKey]kclient.Object{}
+	)
+	for _, obj := range c.Objects {
+		gvk, err := apiutil.GVKForObject(obj, c.SchemeObj)
+		if err != nil {
+			panic(fmt.Sprintf("invalid object %T %s/%s of the test: %v", obj, obj.GetNamespace(), obj.GetName(), err))
+		}
+		key := ObjectKey{GVK: gvk, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		if _, ok := latest[key]; !ok {
+			keys = append(keys, key)
+		}
+		latest[key] = obj.DeepCopyObject().(kclient.Object)
 	}
-	t := reflect.TypeOf(out)
-	var ns string
-	if key.Namespace != "" {
-		ns = key.Namespace
+	objs := make([]kclient.Object, 0, len(keys))
+	for _, key := range keys {
+		objs = append(objs, latest[key])
 	}
 
-	// iterate over the slice in reverse because updated objects are at the end. created objects are before them.
-	// in the scenario where an object has been created and then updated, or updated twice, we want the last object in
-	objs := c.objects()
-	for i := len(objs) - 1; i >= 0; i-- {
-		obj := objs[i]
-		if reflect.TypeOf(obj) != t {
+	c.store = fake.NewClientBuilder().
+		WithScheme(c.SchemeObj).
+		WithObjects(objs...).
+		WithStatusSubresource(withStatus(c.SchemeObj)...).
+		Build()
+	return c.store
+}
+
+// withStatus returns an object of each type of the scheme that has a Status field.
+func withStatus(scheme *runtime.Scheme) []kclient.Object {
+	var result []kclient.Object
+	for gvk, t := range scheme.AllKnownTypes() {
+		if gvk.Version == runtime.APIVersionInternal || strings.HasSuffix(gvk.Kind, "List") || t.Kind() != reflect.Struct {
 			continue
 		}
-		if obj.GetName() == key.Name &&
-			obj.GetNamespace() == ns {
-			copy(out, obj)
-			return nil
+		if _, ok := t.FieldByName("Status"); !ok {
+			continue
 		}
+		if obj, ok := reflect.New(t).Interface().(kclient.Object); ok {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+// defaultResourceVersion sets the resource version of an object that has none to the version that is stored, so that
+// the objects of a test can be written without reading them first.
+func (c *Client) defaultResourceVersion(ctx context.Context, obj kclient.Object) {
+	if obj.GetResourceVersion() != "" {
+		return
+	}
+	existing := obj.DeepCopyObject().(kclient.Object)
+	if err := c.backing().Get(ctx, kclient.ObjectKeyFromObject(obj), existing); err == nil {
+		obj.SetResourceVersion(existing.GetResourceVersion())
 	}
-	return errors.NewNotFound(schema.GroupResource{
-		Group:    fmt.Sprintf("Unknown group from test: %T", out),
-		Resource: fmt.Sprintf("Unknown resource from test: %T", out),
-	}, key.Name)
 }
 
-func copy(dest, src kclient.Object) {
-	srcCopy := src.DeepCopyObject()
-	reflect.Indirect(reflect.ValueOf(dest)).Set(reflect.Indirect(reflect.ValueOf(srcCopy)))
+func (c *Client) Watch(ctx context.Context, obj kclient.ObjectList, opts ...kclient.ListOption) (watch.Interface, error) {
+	return c.backing().Watch(ctx, obj, opts...)
+}
+
+func (c *Client) Get(ctx context.Context, key kclient.ObjectKey, out kclient.Object, opts ...kclient.GetOption) error {
+	c.recordTrigger(out, key.Namespace, key.Name, nil, nil)
+	if u, ok := out.(*untriggered.Holder); ok {
+		out = u.Object
+	}
+	return c.backing().Get(ctx, key, out, opts...)
 }
 
+// List lists the objects of the fake client. The fake client only selects by the fields it has an index for, so the
+// field selector is matched here instead, with the fields that Trigger.Matches knows.
 func (c *Client) List(ctx context.Context, objList kclient.ObjectList, opts ...kclient.ListOption) error {
+	triggerList := objList
 	if u, ok := objList.(*untriggered.HolderList); ok {
 		objList = u.ObjectList
 	}
@@ -81,48 +127,29 @@ func (c *Client) List(ctx context.Context, objList kclient.ObjectList, opts ...k
 	for _, opt := range opts {
 		opt.ApplyToList(listOpts)
 	}
+	c.recordTrigger(triggerList, listOpts.Namespace, "", listOpts.LabelSelector, listOpts.FieldSelector)
 
-	gvk, err := apiutil.GVKForObject(objList, c.SchemeObj)
-	if err != nil {
+	if err := c.backing().List(ctx, objList, &kclient.ListOptions{
+		Namespace:     listOpts.Namespace,
+		LabelSelector: listOpts.LabelSelector,
+	}); err != nil {
 		return err
 	}
-	if !strings.HasSuffix(gvk.Kind, "List") {
-		return fmt.Errorf("invalid list object %v, Kind must end with List", gvk)
+	if listOpts.FieldSelector == nil || listOpts.FieldSelector.Empty() {
+		return nil
 	}
-	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
-	genericObj, err := c.SchemeObj.New(gvk)
+
+	items, err := meta2.ExtractList(objList)
 	if err != nil {
 		return err
 	}
-	obj := genericObj.(kclient.Object)
-	t := reflect.TypeOf(obj)
-	var ns string
-	if listOpts.Namespace != "" {
-		ns = listOpts.Namespace
-	}
-
-	// put objects into a map because c.objects() returns both created and updated objects, with updates coming after
-	// created. this will ensure the last object in is what is returned
-	resultObjs := make(map[string]runtime.Object)
-	for _, testObj := range c.objects() {
-		if testObj.GetNamespace() != ns {
-			continue
-		}
-		if reflect.TypeOf(testObj) != t {
-			continue
+	var matched []runtime.Object
+	for _, item := range items {
+		if obj, ok := item.(kclient.Object); ok && fieldsMatch(listOpts.FieldSelector, obj) {
+			matched = append(matched, item)
 		}
-		if opts != nil && listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(testObj.GetLabels())) {
-			continue
-		}
-		copy(obj, testObj)
-		resultObjs[testObj.GetNamespace()+"/"+testObj.GetName()] = testObj
-		newObj, err := c.SchemeObj.New(gvk)
-		if err != nil {
-			return err
-		}
-		obj = newObj.(kclient.Object)
 	}
-	return meta2.SetList(objList, maps.Values(resultObjs))
+	return meta2.SetList(objList, matched)
 }
 
 func (c *Client) Create(ctx context.Context, obj kclient.Object, opts ...kclient.CreateOption) error {
@@ -134,36 +161,35 @@ func (c *Client) Create(ctx context.Context, obj kclient.Object, opts ...kclient
 		}
 		obj.SetName(obj.GetGenerateName() + r[:5])
 	}
+	if err := c.backing().Create(ctx, obj, opts...); err != nil {
+		return err
+	}
 	c.Created = append(c.Created, obj)
+	c.recordWrite(VerbCreate, obj, "", "")
 	return nil
 }
 
 func (c *Client) Update(ctx context.Context, o kclient.Object, opts ...kclient.UpdateOption) error {
-	t := reflect.TypeOf(o)
-
-	for _, obj := range c.objects() {
-		if reflect.TypeOf(obj) != t {
-			continue
-		}
-		if obj.GetName() == o.GetName() && obj.GetNamespace() == o.GetNamespace() {
-			c.Updated = append(c.Updated, o)
-			return nil
-		}
+	c.defaultResourceVersion(ctx, o)
+	if err := c.backing().Update(ctx, o, opts...); err != nil {
+		return err
 	}
-
-	return errors.NewNotFound(schema.GroupResource{
-		Group:    fmt.Sprintf("Unknown group from test: %T", o),
-		Resource: fmt.Sprintf("Unknown resource from test: %T", o),
-	}, o.GetName())
+	c.Updated = append(c.Updated, o)
+	c.recordWrite(VerbUpdate, o, "", "")
+	return nil
 }
 
 type Response struct {
 	router.ResponseAttributes
 
-	Delay     time.Duration
+	Delay time.Duration
+	// Retry is true if RetryAfter was called with a zero delay, to retry with the back off of the router.
+	Retry     bool
 	Collected []kclient.Object
 	Client    *Client
 	NoPrune   bool
+	// Commits are the functions registered with OnCommit. The tester does not call them.
+	Commits []func(ctx context.Context) error
 }
 
 func (r *Response) DisablePrune() {
@@ -171,38 +197,42 @@ func (r *Response) DisablePrune() {
 }
 
 func (r *Response) RetryAfter(delay time.Duration) {
+	if delay <= 0 {
+		r.Retry = true
+		return
+	}
 	if r.Delay == 0 || delay < r.Delay {
 		r.Delay = delay
 	}
 }
 
+func (r *Response) OnCommit(f func(ctx context.Context) error) {
+	r.Commits = append(r.Commits, f)
+}
+
 func (r *Response) Objects(obj ...kclient.Object) {
 	r.Collected = append(r.Collected, obj...)
 }
 
 func (c *Client) Delete(ctx context.Context, obj kclient.Object, opts ...kclient.DeleteOption) error {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (c *Client) Patch(ctx context.Context, obj kclient.Object, patch kclient.Patch, opts ...kclient.PatchOption) error {
-	//TODO implement me
-	panic("implement me")
+	if u, ok := obj.(*untriggered.Holder); ok {
+		obj = u.Object
+	}
+	if err := c.backing().Delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.Deleted = append(c.Deleted, obj)
+	c.recordWrite(VerbDelete, obj, "", "")
+	return nil
 }
 
+// DeleteAllOf deletes the objects of the type, it is recorded as a single delete without a name.
 func (c *Client) DeleteAllOf(ctx context.Context, obj kclient.Object, opts ...kclient.DeleteAllOfOption) error {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (c *Client) Status() kclient.StatusWriter {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (c *Client) SubResource(subResource string) kclient.SubResourceClient {
-	//TODO implement me
-	panic("implement me")
+	if err := c.backing().DeleteAllOf(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.recordWrite(VerbDelete, obj, "", "")
+	return nil
 }
 
 func (c *Client) Scheme() *runtime.Scheme {
@@ -210,8 +240,7 @@ func (c *Client) Scheme() *runtime.Scheme {
 }
 
 func (c *Client) RESTMapper() meta2.RESTMapper {
-	//TODO implement me
-	panic("implement me")
+	return c.backing().RESTMapper()
 }
 
 func (c *Client) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
@@ -219,6 +248,5 @@ func (c *Client) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKin
 }
 
 func (c *Client) IsObjectNamespaced(obj runtime.Object) (bool, error) {
-	//TODO implement me
-	panic("implement me")
+	return c.backing().IsObjectNamespaced(obj)
 }
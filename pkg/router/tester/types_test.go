@@ -0,0 +1,203 @@
+package tester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/router"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configMap returns a config map of the default namespace.
+func configMap(name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}, Data: data}
+}
+
+func TestClientStatusSubresource(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{
+		SchemeObj: queueScheme(t),
+		Objects:   []kclient.Object{&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}},
+	}
+	get := func() *appsv1.Deployment {
+		t.Helper()
+		var d appsv1.Deployment
+		if err := c.Get(ctx, router.Key("default", "a"), &d); err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+
+	// Update leaves the status of a type with a status subresource unchanged.
+	d := get()
+	d.Spec.Paused = true
+	d.Status.Replicas = 3
+	if err := c.Update(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if d := get(); !d.Spec.Paused || d.Status.Replicas != 0 {
+		t.Fatalf("expected only the spec to be updated, got %+v and %+v", d.Spec, d.Status)
+	}
+
+	// Status().Update only changes the status.
+	d = get()
+	d.Spec.Paused = false
+	d.Status.Replicas = 3
+	if err := c.Status().Update(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if d := get(); !d.Spec.Paused || d.Status.Replicas != 3 {
+		t.Fatalf("expected only the status to be updated, got %+v and %+v", d.Spec, d.Status)
+	}
+
+	AssertWrites(t, c.Writes, []WriteExpectation{
+		{Verb: VerbUpdate, Kind: "Deployment", Name: "a"},
+		{Verb: VerbUpdate, Kind: "Deployment", Name: "a", Subresource: "status"},
+	})
+}
+
+func TestClientResourceVersions(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{
+		SchemeObj: queueScheme(t),
+		Objects:   []kclient.Object{configMap("a", nil)},
+	}
+
+	// An object of the test without a resource version is written over the stored version.
+	if err := c.Update(ctx, configMap("a", map[string]string{"k": "1"})); err != nil {
+		t.Fatal(err)
+	}
+
+	var stale corev1.ConfigMap
+	if err := c.Get(ctx, router.Key("default", "a"), &stale); err != nil {
+		t.Fatal(err)
+	}
+	fresh := stale.DeepCopy()
+	fresh.Data = map[string]string{"k": "2"}
+	if err := c.Update(ctx, fresh); err != nil {
+		t.Fatal(err)
+	}
+	stale.Data = map[string]string{"k": "3"}
+	if err := c.Update(ctx, &stale); !apierrors.IsConflict(err) {
+		t.Fatalf("expected the update of a stale version to conflict, got %v", err)
+	}
+	if len(c.Updated) != 2 || len(c.Writes) != 2 {
+		t.Fatalf("expected only the successful updates to be recorded, got %d updates and %d writes", len(c.Updated), len(c.Writes))
+	}
+
+	if err := c.Update(ctx, configMap("missing", nil)); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the update of a missing object to fail, got %v", err)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{
+		SchemeObj: queueScheme(t),
+		Objects: []kclient.Object{
+			configMap("a", nil),
+			configMap("b", nil),
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "a"}},
+		},
+	}
+
+	var list corev1.ConfigMapList
+	if err := c.List(ctx, &list, kclient.InNamespace("default"), kclient.MatchingFieldsSelector{
+		Selector: fields.OneTermEqualSelector("metadata.name", "b"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "b" {
+		t.Fatalf("expected the field selector to select default/b, got %v", list.Items)
+	}
+
+	if err := c.List(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 3 {
+		t.Fatalf("expected the objects of every namespace, got %v", list.Items)
+	}
+	if len(c.Triggers) != 2 {
+		t.Fatalf("expected a trigger for each list, got %v", c.Triggers)
+	}
+}
+
+func TestClientApplyAndDelete(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{
+		SchemeObj: queueScheme(t),
+		Objects: []kclient.Object{
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "finalized", Finalizers: []string{"test"}}},
+		},
+	}
+
+	// An apply patch creates the object, then replaces it.
+	for _, value := range []string{"1", "2"} {
+		if err := c.Patch(ctx, configMap("a", map[string]string{"k": value}), kclient.Apply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cm corev1.ConfigMap
+	if err := c.Get(ctx, router.Key("default", "a"), &cm); err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data["k"] != "2" || cm.UID == "" {
+		t.Fatalf("expected the applied object with a UID, got %+v", cm)
+	}
+	if len(c.Created) != 1 || len(c.Updated) != 1 {
+		t.Fatalf("expected one create and one update, got %d and %d", len(c.Created), len(c.Updated))
+	}
+
+	// An object with a finalizer is only marked as deleted.
+	if err := c.Delete(ctx, configMap("finalized", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, router.Key("default", "finalized"), &cm); err != nil || cm.DeletionTimestamp == nil {
+		t.Fatalf("expected the finalized object to be deleting, got %v and %v", cm.DeletionTimestamp, err)
+	}
+	if err := c.Delete(ctx, configMap("a", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, router.Key("default", "a"), &cm); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the object to be deleted, got %v", err)
+	}
+
+	AssertWrites(t, c.Writes, []WriteExpectation{
+		{Verb: VerbPatch, Name: "a"},
+		{Verb: VerbPatch, Name: "a"},
+		{Verb: VerbDelete, Name: "finalized"},
+		{Verb: VerbDelete, Name: "a"},
+	})
+}
+
+func TestHarnessReconcileUsesFakeClient(t *testing.T) {
+	input := configMap("a", nil)
+	existing := configMap("b", map[string]string{"k": "1"})
+	h := NewHarness(queueScheme(t), existing)
+
+	result := h.Reconcile(t, input, router.HandlerFunc(func(req router.Request, resp router.Response) error {
+		var b corev1.ConfigMap
+		if err := req.Get(&b, "default", "b"); err != nil {
+			return err
+		}
+		b.Data["k"] = "2"
+		if err := req.Client.Update(req.Ctx, &b); err != nil {
+			return err
+		}
+		// A version other than the stored one conflicts.
+		existing.Data = map[string]string{"k": "3"}
+		existing.ResourceVersion = "1"
+		return req.Client.Update(req.Ctx, existing)
+	}))
+	if !apierrors.IsConflict(result.Err) {
+		t.Fatalf("expected the update of the stale version to conflict, got %v", result.Err)
+	}
+	if len(result.Updated) != 1 || result.Updated[0].(*corev1.ConfigMap).Data["k"] != "2" {
+		t.Fatalf("expected the first update to be recorded, got %v", result.Updated)
+	}
+}
@@ -0,0 +1,93 @@
+package tester
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// waitHandler records whether the children of the config map are ready, and the reasons if they are not, in its
+// annotations.
+var waitHandler = router.HandlerFunc(func(req router.Request, resp router.Response) error {
+	var children corev1.ConfigMapList
+	ready, reasons, err := router.WaitForOwned(req, resp, &children, func(obj kclient.Object) (bool, string) {
+		if obj.(*corev1.ConfigMap).Data["ready"] == "true" {
+			return true, ""
+		}
+		return false, "waiting"
+	}, time.Minute)
+	if err != nil {
+		return err
+	}
+
+	cm := req.Object.(*corev1.ConfigMap)
+	cm.Annotations = map[string]string{"reasons": strings.Join(reasons, ", ")}
+	if ready {
+		cm.Annotations["ready"] = "true"
+	}
+	return req.Client.Update(req.Ctx, cm)
+})
+
+func ownedConfigMap(name string, ready bool) *corev1.ConfigMap {
+	cm := configMap(name, nil)
+	cm.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "owner-uid"}}
+	if ready {
+		cm.Data = map[string]string{"ready": "true"}
+	}
+	return cm
+}
+
+func waitResult(reasons string, ready bool) *corev1.ConfigMap {
+	cm := configMap("owner", nil)
+	cm.Annotations = map[string]string{"reasons": reasons}
+	if ready {
+		cm.Annotations["ready"] = "true"
+	}
+	return cm
+}
+
+func TestWaitForOwned(t *testing.T) {
+	owner := configMap("owner", nil)
+	owner.UID = "owner-uid"
+	scheme := queueScheme(t)
+
+	RunScenarios(t, []Scenario{
+		{
+			Name:               "not ready",
+			Scheme:             scheme,
+			Handler:            waitHandler,
+			SeedObjects:        []kclient.Object{owner, ownedConfigMap("a", true), ownedConfigMap("b", false), configMap("c", nil)},
+			ReconcileType:      &corev1.ConfigMap{},
+			ReconcileKey:       "default/owner",
+			SubsetMatch:        true,
+			ExpectedObjects:    []kclient.Object{waitResult("b: waiting", false)},
+			ExpectedRetryAfter: time.Minute,
+		},
+		{
+			Name:            "ready",
+			Scheme:          scheme,
+			Handler:         waitHandler,
+			SeedObjects:     []kclient.Object{owner, ownedConfigMap("a", true), ownedConfigMap("b", true), configMap("c", nil)},
+			ReconcileType:   &corev1.ConfigMap{},
+			ReconcileKey:    "default/owner",
+			SubsetMatch:     true,
+			ExpectedObjects: []kclient.Object{waitResult("", true)},
+		},
+		{
+			Name:               "none owned",
+			Scheme:             scheme,
+			Handler:            waitHandler,
+			SeedObjects:        []kclient.Object{owner, configMap("c", nil)},
+			ReconcileType:      &corev1.ConfigMap{},
+			ReconcileKey:       "default/owner",
+			SubsetMatch:        true,
+			ExpectedObjects:    []kclient.Object{waitResult("no owned objects found", false)},
+			ExpectedRetryAfter: time.Minute,
+		},
+	})
+}
@@ -0,0 +1,215 @@
+package tester
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/obot-platform/nah/pkg/untriggered"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbPatch  = "patch"
+	VerbDelete = "delete"
+)
+
+// Write is a mutating call made through the Client.
+type Write struct {
+	Verb      string
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	// Subresource is the subresource that was written, such as "status", or empty for the object itself.
+	Subresource string
+	// PatchType is the type of the patch of a patch, such as types.ApplyPatchType for server-side apply.
+	PatchType types.PatchType
+}
+
+func (w Write) String() string {
+	var b strings.Builder
+	b.WriteString(w.Verb)
+	if w.PatchType != "" {
+		fmt.Fprintf(&b, " (%s)", w.PatchType)
+	}
+	fmt.Fprintf(&b, " %s %s", w.GVK.Kind, toKey(w.Namespace, w.Name))
+	if w.Subresource != "" {
+		b.WriteString(" /" + w.Subresource)
+	}
+	return b.String()
+}
+
+// WriteExpectation matches a Write. Empty fields match any value, so an expectation with only a Verb matches every
+// write with that verb.
+type WriteExpectation struct {
+	Verb        string
+	Kind        string
+	Namespace   string
+	Name        string
+	Subresource string
+	PatchType   types.PatchType
+}
+
+func (e WriteExpectation) matches(w Write) bool {
+	return (e.Verb == "" || e.Verb == w.Verb) &&
+		(e.Kind == "" || e.Kind == w.GVK.Kind) &&
+		(e.Namespace == "" || e.Namespace == w.Namespace) &&
+		(e.Name == "" || e.Name == w.Name) &&
+		(e.Subresource == "" || e.Subresource == w.Subresource) &&
+		(e.PatchType == "" || e.PatchType == w.PatchType)
+}
+
+// AssertWrites asserts that the last invocation of the harness made exactly the expected writes, in order.
+func (b *Harness) AssertWrites(t *testing.T, expected []WriteExpectation) {
+	t.Helper()
+	AssertWrites(t, b.writes, expected)
+}
+
+// AssertNoWrites asserts that the last invocation of the harness made no writes, such as when reconciling an object
+// that has already converged.
+func (b *Harness) AssertNoWrites(t *testing.T) {
+	t.Helper()
+	AssertWrites(t, b.writes, nil)
+}
+
+// AssertWrites asserts that the writes are exactly the expected writes, in order.
+func AssertWrites(t *testing.T, writes []Write, expected []WriteExpectation) {
+	t.Helper()
+	if len(writes) != len(expected) {
+		assert.Failf(t, "unexpected writes", "expected %d writes, got %d:\n%s", len(expected), len(writes), formatWrites(writes))
+		return
+	}
+	for i, e := range expected {
+		if !e.matches(writes[i]) {
+			assert.Failf(t, "unexpected write", "write %d does not match %+v:\n%s", i, e, formatWrites(writes))
+		}
+	}
+}
+
+func formatWrites(writes []Write) string {
+	if len(writes) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, 0, len(writes))
+	for i, w := range writes {
+		lines = append(lines, fmt.Sprintf("  %d: %s", i, w))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *Client) recordWrite(verb string, obj runtime.Object, subresource string, patchType types.PatchType) {
+	if u, ok := obj.(*untriggered.Holder); ok {
+		obj = u.Object
+	}
+	w := Write{
+		Verb:        verb,
+		Subresource: subresource,
+		PatchType:   patchType,
+	}
+	w.GVK, _ = apiutil.GVKForObject(obj, c.SchemeObj)
+	if o, ok := obj.(kclient.Object); ok {
+		w.Namespace, w.Name = o.GetNamespace(), o.GetName()
+	}
+	c.Writes = append(c.Writes, w)
+	c.writes++
+}
+
+// Patch applies the patch with the fake client. The fake client doesn't support server-side apply, so an apply patch
+// creates the applied object, or replaces the stored object with it.
+func (c *Client) Patch(ctx context.Context, obj kclient.Object, patch kclient.Patch, opts ...kclient.PatchOption) error {
+	if patch.Type() == types.ApplyPatchType {
+		return c.apply(ctx, obj)
+	}
+	if err := c.backing().Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	c.Updated = append(c.Updated, obj)
+	c.recordWrite(VerbPatch, obj, "", patch.Type())
+	return nil
+}
+
+func (c *Client) apply(ctx context.Context, obj kclient.Object) error {
+	existing := obj.DeepCopyObject().(kclient.Object)
+	err := c.backing().Get(ctx, kclient.ObjectKeyFromObject(obj), existing)
+	switch {
+	case errors.IsNotFound(err):
+		obj.SetUID(types.UID(uuid.New().String()))
+		obj.SetResourceVersion("")
+		if err := c.backing().Create(ctx, obj); err != nil {
+			return err
+		}
+		c.Created = append(c.Created, obj)
+	case err != nil:
+		return err
+	default:
+		obj.SetUID(existing.GetUID())
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		if err := c.backing().Update(ctx, obj); err != nil {
+			return err
+		}
+		c.Updated = append(c.Updated, obj)
+	}
+	c.recordWrite(VerbPatch, obj, "", types.ApplyPatchType)
+	return nil
+}
+
+func (c *Client) Status() kclient.StatusWriter {
+	return &subResourceClient{
+		client:      c,
+		subresource: "status",
+	}
+}
+
+func (c *Client) SubResource(subResource string) kclient.SubResourceClient {
+	return &subResourceClient{
+		client:      c,
+		subresource: subResource,
+	}
+}
+
+// subResourceClient writes a subresource with the fake client, and records the written objects in Updated.
+type subResourceClient struct {
+	client      *Client
+	subresource string
+}
+
+func (s *subResourceClient) Get(ctx context.Context, obj kclient.Object, subResource kclient.Object, opts ...kclient.SubResourceGetOption) error {
+	return s.client.Get(ctx, kclient.ObjectKeyFromObject(obj), subResource)
+}
+
+func (s *subResourceClient) Create(ctx context.Context, obj kclient.Object, subResource kclient.Object, opts ...kclient.SubResourceCreateOption) error {
+	if err := s.client.backing().SubResource(s.subresource).Create(ctx, obj, subResource, opts...); err != nil {
+		return err
+	}
+	s.client.recordWrite(VerbCreate, obj, s.subresource, "")
+	return nil
+}
+
+func (s *subResourceClient) Update(ctx context.Context, obj kclient.Object, opts ...kclient.SubResourceUpdateOption) error {
+	s.client.defaultResourceVersion(ctx, obj)
+	if err := s.client.backing().SubResource(s.subresource).Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	s.client.Updated = append(s.client.Updated, obj)
+	s.client.recordWrite(VerbUpdate, obj, s.subresource, "")
+	return nil
+}
+
+func (s *subResourceClient) Patch(ctx context.Context, obj kclient.Object, patch kclient.Patch, opts ...kclient.SubResourcePatchOption) error {
+	if err := s.client.backing().SubResource(s.subresource).Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	s.client.Updated = append(s.client.Updated, obj)
+	s.client.recordWrite(VerbPatch, obj, s.subresource, patch.Type())
+	return nil
+}
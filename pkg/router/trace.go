@@ -0,0 +1,184 @@
+package router
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TraceAnnotation, when set to "true" on an object, traces the key of the object for DefaultTraceDuration after
+	// each reconcile of it.
+	TraceAnnotation = "nah.obot.ai/trace"
+	// TraceTag prefixes every line logged for a traced key so that the trace can be grepped out of the logs.
+	TraceTag = "[nah-trace]"
+	// DefaultTraceDuration is how long a key stays traced after its last reconcile with the TraceAnnotation.
+	DefaultTraceDuration = 10 * time.Minute
+)
+
+// ActiveTrace is a key that is currently being traced.
+type ActiveTrace struct {
+	GVK     schema.GroupVersionKind `json:"gvk"`
+	Key     string                  `json:"key"`
+	Expires time.Time               `json:"expires"`
+}
+
+// TraceKey logs every enqueue, dequeue, reconcile, trigger and API write of the key for the duration. The lines are
+// logged at info level, regardless of the debug level of the logger, and are prefixed with TraceTag. Tracing a key that
+// is already traced extends the trace if the new duration ends later.
+func (r *Router) TraceKey(gvk schema.GroupVersionKind, key string, duration time.Duration) {
+	r.handlers.tracer.trace(gvk, key, r.handlers.clock.Now().Add(duration))
+}
+
+// Traces returns the keys that are currently traced.
+func (r *Router) Traces() []ActiveTrace {
+	return r.handlers.tracer.list(r.handlers.clock.Now())
+}
+
+type tracer struct {
+	lock    sync.Mutex
+	expires map[limiterKey]time.Time
+}
+
+func (t *tracer) trace(gvk schema.GroupVersionKind, key string, expires time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.expires == nil {
+		t.expires = map[limiterKey]time.Time{}
+	}
+	lKey := limiterKey{key: key, gvk: gvk}
+	if existing, ok := t.expires[lKey]; !ok || expires.After(existing) {
+		t.expires[lKey] = expires
+	}
+}
+
+// active returns true if the key is traced. Expired traces are removed as they are looked up.
+func (t *tracer) active(gvk schema.GroupVersionKind, key string, now time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.expires) == 0 {
+		return false
+	}
+	lKey := limiterKey{key: key, gvk: gvk}
+	expires, ok := t.expires[lKey]
+	if !ok {
+		return false
+	}
+	if !now.Before(expires) {
+		delete(t.expires, lKey)
+		return false
+	}
+	return true
+}
+
+func (t *tracer) list(now time.Time) []ActiveTrace {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	result := make([]ActiveTrace, 0, len(t.expires))
+	for k, expires := range t.expires {
+		if !now.Before(expires) {
+			delete(t.expires, k)
+			continue
+		}
+		result = append(result, ActiveTrace{GVK: k.gvk, Key: k.key, Expires: expires})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Expires.Before(result[j].Expires)
+	})
+	return result
+}
+
+// traceDequeue logs the dequeue of a traced key, and why it was skipped if it was.
+func (m *HandlerSet) traceDequeue(gvk schema.GroupVersionKind, key string, fromTrigger, fromReplay bool, info backend.EnqueueInfo, skipped string) {
+	if !m.traced(gvk, key) {
+		return
+	}
+	if skipped != "" {
+		skipped = ", " + skipped
+	}
+	traceLogf(gvk, key, "dequeued from %s, enqueued at %s, event observed at %s%s", enqueueSource(fromTrigger, fromReplay, info),
+		formatTraceTime(info.EnqueuedAt), formatTraceTime(info.EventObservedAt), skipped)
+}
+
+// traceEnqueue logs an enqueue of a traced key by the router. The source is only formatted if the key is traced.
+func (m *HandlerSet) traceEnqueue(gvk schema.GroupVersionKind, key string, delay time.Duration, source string, args ...any) {
+	if !m.traced(gvk, key) {
+		return
+	}
+	if delay > 0 {
+		traceLogf(gvk, key, "enqueued by "+source+" after %s", append(args, delay)...)
+		return
+	}
+	traceLogf(gvk, key, "enqueued by "+source, args...)
+}
+
+// observeEnqueues logs the enqueues of the traced keys of the type by the events of their objects, for the backends
+// that report them. An object with the TraceAnnotation is traced from its event on.
+func (m *HandlerSet) observeEnqueues(gvk schema.GroupVersionKind) {
+	observer, ok := m.backend.(backend.EnqueueObserver)
+	if !ok {
+		return
+	}
+	err := observer.ObserveEnqueues(gvk, func(key string, obj runtime.Object) {
+		if annotated, ok := obj.(kclient.Object); ok && annotated.GetAnnotations()[TraceAnnotation] == "true" {
+			m.tracer.trace(gvk, key, m.clock.Now().Add(DefaultTraceDuration))
+		}
+		if m.traced(gvk, key) {
+			traceLogf(gvk, key, "enqueued by a change")
+		}
+	})
+	if err != nil {
+		log.Errorf("Failed to observe the enqueues of [%s] in router [%s], they won't be traced: %v", gvk, m.name, err)
+	}
+}
+
+func traceLogf(gvk schema.GroupVersionKind, key, message string, obj ...any) {
+	log.Infof(TraceTag+" [%s] [%s] "+message, append([]any{key, gvk}, obj...)...)
+}
+
+// writeTracer logs the writes of a request for a traced key.
+type writeTracer struct {
+	gvk schema.GroupVersionKind
+	key string
+}
+
+// write logs a write once it ran, so that the name given to a created object and the error of the write are known.
+func (w *writeTracer) write(verb string, obj kclient.Object, err error) {
+	if w == nil {
+		return
+	}
+	if err != nil {
+		traceLogf(w.gvk, w.key, "%s %T %s/%s failed: %v", verb, obj, obj.GetNamespace(), obj.GetName(), err)
+		return
+	}
+	traceLogf(w.gvk, w.key, "%s %T %s/%s succeeded", verb, obj, obj.GetNamespace(), obj.GetName())
+}
+
+var tracers = struct {
+	lock    sync.RWMutex
+	routers map[string]func() []ActiveTrace
+}{
+	routers: map[string]func() []ActiveTrace{},
+}
+
+func registerTracer(name string, traces func() []ActiveTrace) {
+	tracers.lock.Lock()
+	defer tracers.lock.Unlock()
+	tracers.routers[name] = traces
+}
+
+func getTraces() map[string][]ActiveTrace {
+	tracers.lock.RLock()
+	defer tracers.lock.RUnlock()
+	result := make(map[string][]ActiveTrace, len(tracers.routers))
+	for name, traces := range tracers.routers {
+		result[name] = traces()
+	}
+	return result
+}
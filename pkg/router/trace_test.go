@@ -0,0 +1,181 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// captureTraces records the trace lines that are logged until the test ends. It must be called before the router is
+// started, so that the logger is restored once the router has stopped.
+func captureTraces(t *testing.T) func() []string {
+	t.Helper()
+	var (
+		lock  sync.Mutex
+		lines []string
+	)
+	infof := log.Infof
+	log.Infof = func(message string, obj ...interface{}) {
+		if line := fmt.Sprintf(message, obj...); strings.HasPrefix(line, TraceTag) {
+			lock.Lock()
+			defer lock.Unlock()
+			lines = append(lines, line)
+		}
+	}
+	t.Cleanup(func() {
+		log.Infof = infof
+	})
+	return func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), lines...)
+	}
+}
+
+// indexOf returns the index of the first line that contains the text, or -1.
+func indexOf(lines []string, text string) int {
+	for i, line := range lines {
+		if strings.Contains(line, text) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestTraceAnnotatedObject(t *testing.T) {
+	annotated := configMap("default", "a")
+	annotated.Annotations = map[string]string{TraceAnnotation: "true"}
+	r, b := newTestRouter(t, annotated, configMap("default", "untraced"))
+	traces := captureTraces(t)
+
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		if req.Name != "a" {
+			return nil
+		}
+		if err := req.Client.Create(req.Ctx, configMap("default", "b")); err != nil {
+			return err
+		}
+		// The update of an object that doesn't exist fails, and is traced with its error.
+		_ = req.Client.Update(req.Ctx, configMap("default", "missing"))
+		resp.RetryAfter(time.Minute)
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, "default/untraced"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := traces(); len(lines) != 0 {
+		t.Fatalf("expected no trace for an object without the annotation, got %v", lines)
+	}
+
+	// The first reconcile of the annotated object is traced from its dequeue on.
+	if err := b.dispatch(configMapGVK, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	lines := traces()
+	last := -1
+	for _, expected := range []string{
+		"[default/a] [/v1, Kind=ConfigMap] dequeued from change",
+		"reconcile started",
+		"create *v1.ConfigMap default/b succeeded",
+		"update *v1.ConfigMap default/missing failed: ",
+	} {
+		i := indexOf(lines, expected)
+		if i <= last {
+			t.Fatalf("expected %q to be traced after the line %d, got %v", expected, last, lines)
+		}
+		last = i
+	}
+	for _, expected := range []string{"enqueued by RetryAfter after 1m0s", "reconcile finished"} {
+		if indexOf(lines, expected) < 0 {
+			t.Fatalf("expected %q to be traced, got %v", expected, lines)
+		}
+	}
+}
+
+func TestTraceEnqueues(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+	traces := captureTraces(t)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	r.TraceKey(configMapGVK, "default/a", time.Hour)
+	r.handlers.traceEnqueue(configMapGVK, "default/a", 0, "the release from the retry budget of [%s]", "budget")
+	r.handlers.traceEnqueue(configMapGVK, "default/b", 0, "ReconcileAll")
+	// A replayed key is traced without its ReplayPrefix.
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := traces()
+	for _, expected := range []string{
+		"[default/a] [/v1, Kind=ConfigMap] enqueued by the release from the retry budget of [budget]",
+		"[default/a] [/v1, Kind=ConfigMap] dequeued from backoff replay",
+	} {
+		if indexOf(lines, expected) < 0 {
+			t.Fatalf("expected %q to be traced, got %v", expected, lines)
+		}
+	}
+	if i := indexOf(lines, "default/b"); i >= 0 {
+		t.Fatalf("expected no trace of a key that is not traced, got %q", lines[i])
+	}
+}
+
+// observingBackend is a fake backend that reports the enqueues of the events of the objects.
+type observingBackend struct {
+	*fakeBackend
+	observers map[schema.GroupVersionKind]func(string, runtime.Object)
+}
+
+func (o *observingBackend) ObserveEnqueues(gvk schema.GroupVersionKind, observe func(string, runtime.Object)) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.observers[gvk] = observe
+	return nil
+}
+
+func (o *observingBackend) observe(gvk schema.GroupVersionKind, key string, obj runtime.Object) {
+	o.lock.Lock()
+	observe := o.observers[gvk]
+	o.lock.Unlock()
+	observe(key, obj)
+}
+
+func TestTraceEnqueuesOfEvents(t *testing.T) {
+	scheme := testScheme(t)
+	b := &observingBackend{
+		fakeBackend: newFakeBackend(scheme),
+		observers:   map[schema.GroupVersionKind]func(string, runtime.Object){},
+	}
+	r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0)
+	traces := captureTraces(t)
+	r.Type(configMap("", "")).HandlerFunc(func(req Request, resp Response) error {
+		return nil
+	})
+	startTestRouter(t, r)
+
+	b.observe(configMapGVK, "default/a", configMap("default", "a"))
+	if lines := traces(); len(lines) != 0 {
+		t.Fatalf("expected no trace of the event of an object without the annotation, got %v", lines)
+	}
+
+	// The annotation is checked on the object of the event, so the enqueue is traced too.
+	annotated := configMap("default", "a")
+	annotated.Annotations = map[string]string{TraceAnnotation: "true"}
+	b.observe(configMapGVK, "default/a", annotated)
+	if lines := traces(); len(lines) != 1 || !strings.HasSuffix(lines[0], "[default/a] [/v1, Kind=ConfigMap] enqueued by a change") {
+		t.Fatalf("expected the enqueue of the annotated object to be traced, got %v", lines)
+	}
+	if active := r.Traces(); len(active) != 1 || active[0].Key != "default/a" {
+		t.Fatalf("expected the annotated object to be traced, got %v", active)
+	}
+}
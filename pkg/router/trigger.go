@@ -21,6 +21,8 @@ type triggers struct {
 	gvkLookup backend.Backend
 	scheme    *runtime.Scheme
 	watcher   watcher
+	traced    func(gvk schema.GroupVersionKind, key string) bool
+	indexes   *indexes
 }
 
 type watcher interface {
@@ -44,6 +46,7 @@ func (m *triggers) invokeTriggers(req Request) {
 		for _, matcher := range matchers {
 			if matcher.Match(req.Namespace, req.Name, req.Object) {
 				log.Debugf("Triggering [%s] [%v] from [%s] [%v]", et.key, et.gvk, req.Key, req.GVK)
+				m.traceTrigger(et, req, "")
 				_ = m.trigger.Trigger(et.gvk, et.key, 0)
 				break
 			}
@@ -51,6 +54,19 @@ func (m *triggers) invokeTriggers(req Request) {
 	}
 }
 
+// traceTrigger logs the trigger edge if either end of it is traced.
+func (m *triggers) traceTrigger(target enqueueTarget, req Request, suffix string) {
+	if m.traced == nil {
+		return
+	}
+	if req.traced {
+		traceLogf(req.GVK, req.Key, "triggered [%s] [%v]%s", target.key, target.gvk, suffix)
+	}
+	if m.traced(target.gvk, target.key) {
+		traceLogf(target.gvk, target.key, "triggered by [%s] [%v]%s", req.Key, req.GVK, suffix)
+	}
+}
+
 func (m *triggers) register(gvk schema.GroupVersionKind, key string, targetGVK schema.GroupVersionKind, mr objectMatcher) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -101,6 +117,7 @@ func (m *triggers) Register(sourceGVK schema.GroupVersionKind, key string, obj r
 		Name:      name,
 		Selector:  selector,
 		Fields:    fields,
+		indexers:  m.indexes.forGVK(gvk),
 	})
 
 	return gvk, true, m.watcher.WatchGVK(gvk)
@@ -133,6 +150,7 @@ func (m *triggers) UnregisterAndTrigger(req Request) {
 				}
 				if targetGVK == req.GVK && mt.Match(req.Namespace, req.Name, req.Object) {
 					log.Debugf("Triggering [%s] [%v] from [%s] [%v] on delete", target.key, target.gvk, req.Key, req.GVK)
+					m.traceTrigger(target, req, " on delete")
 					_ = m.trigger.Trigger(target.gvk, target.key, 0)
 				}
 			}
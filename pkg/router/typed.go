@@ -0,0 +1,67 @@
+package router
+
+import (
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// TypedHandler returns a Handler that passes the object of the request to the function as a T, such as
+// *corev1.ConfigMap, so that handlers don't have to cast req.Object. The object is the zero T (a nil pointer) when the
+// request has no object, such as for a deleted object on a route with IncludeRemoved. If the object is not a T,
+// because the handler was registered for another type, an error naming the expected and actual GVK is returned
+// instead of calling the function.
+func TypedHandler[T kclient.Object](f func(req Request, resp Response, obj T) error) Handler {
+	return HandlerFunc(func(req Request, resp Response) error {
+		obj, err := typedObject[T](req)
+		if err != nil {
+			return err
+		}
+		return f(req, resp, obj)
+	})
+}
+
+// TypedMiddleware returns a Middleware that passes the object of the request to the function as a T, along with the
+// next handler of the chain, which the function calls to continue. The object is the zero T when the request has no
+// object, and an error is returned without calling the function if the object is not a T, like TypedHandler.
+func TypedMiddleware[T kclient.Object](f func(req Request, resp Response, obj T, next Handler) error) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req Request, resp Response) error {
+			obj, err := typedObject[T](req)
+			if err != nil {
+				return err
+			}
+			return f(req, resp, obj, next)
+		})
+	}
+}
+
+func typedObject[T kclient.Object](req Request) (T, error) {
+	var zero T
+	if req.Object == nil {
+		return zero, nil
+	}
+	if obj, ok := req.Object.(T); ok {
+		return obj, nil
+	}
+	return zero, fmt.Errorf("handler of %s %s expects %s but was registered for %s", req.GVK.Kind, req.Key,
+		expectedKind(req, zero), req.GVK)
+}
+
+// expectedKind returns the GVK of the type for the error of a mismatched object, or the Go type if the scheme of the
+// request can't name it.
+func expectedKind(req Request, obj runtime.Object) string {
+	t := reflect.TypeOf(obj)
+	if req.Client != nil && t != nil && t.Kind() == reflect.Pointer {
+		// The scheme can't name a nil pointer, so look up a new object of the type.
+		if newObj, ok := reflect.New(t.Elem()).Interface().(runtime.Object); ok {
+			if gvk, err := apiutil.GVKForObject(newObj, req.Client.Scheme()); err == nil {
+				return gvk.String()
+			}
+		}
+	}
+	return fmt.Sprintf("%T", obj)
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTypedHandlerMismatchedType(t *testing.T) {
+	var called bool
+	h := TypedHandler(func(req Request, resp Response, obj *corev1.Secret) error {
+		called = true
+		return nil
+	})
+
+	req := Request{
+		Client: fake.NewClientBuilder().WithScheme(testScheme(t)).Build(),
+		Object: configMap("default", "a"),
+		GVK:    configMapGVK,
+		Key:    "default/a",
+	}
+	err := h.Handle(req, &ResponseWrapper{})
+	if err == nil || called {
+		t.Fatal("expected an error without calling the function for an object of another type")
+	}
+	if !strings.Contains(err.Error(), "/v1, Kind=Secret") || !strings.Contains(err.Error(), "/v1, Kind=ConfigMap") {
+		t.Fatalf("expected the error to name the expected and actual GVK, got %v", err)
+	}
+
+	// Without a client, the expected type is named by its Go type.
+	req.Client = nil
+	if err := h.Handle(req, &ResponseWrapper{}); err == nil || !strings.Contains(err.Error(), "*v1.Secret") {
+		t.Fatalf("expected the error to name the Go type, got %v", err)
+	}
+}
+
+func TestTypedHandlerNilObject(t *testing.T) {
+	var called bool
+	h := TypedHandler(func(req Request, resp Response, obj *corev1.ConfigMap) error {
+		called = true
+		if obj != nil {
+			return errors.New("expected a nil object")
+		}
+		return nil
+	})
+	if err := h.Handle(Request{GVK: configMapGVK, Key: "default/a"}, &ResponseWrapper{}); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("expected the function to be called with a nil object")
+	}
+}
+
+func TestTypedMiddlewareChain(t *testing.T) {
+	r, b := newTestRouter(t, configMap("default", "a"))
+
+	var order []string
+	middleware := func(name string) Middleware {
+		return TypedMiddleware(func(req Request, resp Response, obj *corev1.ConfigMap, next Handler) error {
+			order = append(order, name+":"+obj.Name)
+			return next.Handle(req, resp)
+		})
+	}
+	stop := TypedMiddleware(func(req Request, resp Response, obj *corev1.ConfigMap, next Handler) error {
+		order = append(order, "stop")
+		return nil
+	})
+
+	r.Type(configMap("", "")).Middleware(middleware("first"), middleware("second")).HandlerFunc(
+		TypedHandler(func(req Request, resp Response, obj *corev1.ConfigMap) error {
+			order = append(order, "handler:"+obj.Name)
+			return nil
+		}).Handle)
+	r.Type(configMap("", "")).Middleware(middleware("third"), stop).HandlerFunc(func(req Request, resp Response) error {
+		order = append(order, "not called")
+		return nil
+	})
+	startTestRouter(t, r)
+
+	if err := b.dispatch(configMapGVK, ReplayPrefix+"default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[first:a second:a handler:a third:a stop]" {
+		t.Fatalf("expected the middleware to run in order and stop the chain, got %v", order)
+	}
+}
@@ -2,8 +2,12 @@ package router
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/obot-platform/nah/pkg/leader"
+	"github.com/obot-platform/nah/pkg/untriggered"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -27,6 +31,30 @@ type HandlerFunc func(req Request, resp Response) error
 // That the ErrorHandler can possibly clear a previous error state.
 type ErrorHandler func(req Request, resp Response, err error) error
 
+// ErrorHandlers returns an ErrorHandler that runs the handlers in order. A failed reconcile's error is given to the
+// first handler, and the error returned by each handler is given to the next, until one returns nil, which marks the
+// error as handled. For a nil error, every handler is called so that each can clear its previous error state, and the
+// first error they return is returned.
+func ErrorHandlers(handlers ...ErrorHandler) ErrorHandler {
+	return func(req Request, resp Response, err error) error {
+		if err == nil {
+			var result error
+			for _, h := range handlers {
+				if hErr := h(req, resp, nil); hErr != nil && result == nil {
+					result = hErr
+				}
+			}
+			return result
+		}
+		for _, h := range handlers {
+			if err = h(req, resp, err); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
 func (h HandlerFunc) Handle(req Request, resp Response) error {
 	return h(req, resp)
 }
@@ -40,6 +68,30 @@ type Request struct {
 	Name        string
 	Key         string
 	FromTrigger bool
+	// EnqueuedAt is when the key was added to the queue, or when the requeue was due if Requeued is true. This is
+	// best effort and zero if unknown.
+	EnqueuedAt time.Time
+	// EventObservedAt is when the watch event of the object that enqueued this request was observed. It is zero for
+	// requests that are not from a watch event of the object, such as triggers and requeues.
+	EventObservedAt time.Time
+	// Requeued is true if the request is only from a requeue, either after an error or from Response.RetryAfter.
+	Requeued bool
+	// Retries is the number of consecutive retries of the key that led to this request, zero if the last reconcile
+	// succeeded. It is only counted for routers with WithBackoff.
+	Retries int
+	// External is the name of the external route of the request, or empty if the key is a Kubernetes object.
+	External string
+	// OldObject is the version of the object before the update that enqueued this request, for routes with
+	// WithOldObject. It is nil for other routes and for requests that are not from an update.
+	OldObject kclient.Object
+
+	oldObject  runtime.Object
+	summary    *reconcileSummary
+	traced     bool
+	election   *leader.ElectionConfig
+	attributes *ResponseAttributes
+	// logger is the logger of the router, without the fields of the request that Logger adds.
+	logger *slog.Logger
 }
 
 func (r *Request) WithContext(ctx context.Context) Request {
@@ -48,6 +100,52 @@ func (r *Request) WithContext(ctx context.Context) Request {
 	return newRequest
 }
 
+// ElidedAnnotation is set by the cache on objects stored with some fields elided because they were too large. The
+// value is the comma separated list of the elided fields. The client of the Request refuses to update or patch an object
+// with the annotation, other than with a JSON patch, because the elided fields would be dropped on the server.
+const ElidedAnnotation = "nah.obot.ai/elided"
+
+// IsElided returns true if the Request's Object is missing fields that were elided by the cache.
+func (r *Request) IsElided() bool {
+	if r.Object == nil {
+		return false
+	}
+	return isElided(r.Object)
+}
+
+func isElided(obj kclient.Object) bool {
+	_, ok := obj.GetAnnotations()[ElidedAnnotation]
+	return ok
+}
+
+// getLive fetches the object from the API server into a copy of obj, bypassing the cache, for the writes of objects
+// that may have elided fields.
+func getLive(req Request, obj kclient.Object) (kclient.Object, error) {
+	live := obj.DeepCopyObject().(kclient.Object)
+	// The annotations are read again, so that the ElidedAnnotation of the cached object is not kept.
+	live.SetAnnotations(nil)
+	return live, req.Client.Get(req.Ctx, kclient.ObjectKeyFromObject(obj), untriggered.UncachedGet(live))
+}
+
+// GetFull fetches the complete Request's object from the API server into obj, bypassing the cache. Use this when the
+// Request's Object has elided fields that the handler needs.
+func (r *Request) GetFull(obj kclient.Object) error {
+	return r.Client.Get(r.Ctx, Key(r.Namespace, r.Name), untriggered.UncachedGet(obj))
+}
+
+// LeaderIdentity returns the identity of the current leader of the router, or an empty string if the router does not
+// use leader election.
+func (r *Request) LeaderIdentity() string {
+	identity, _ := r.election.Leader()
+	return identity
+}
+
+// IsLeader returns true if this process holds the lease of the router, or if the router does not use leader election.
+func (r *Request) IsLeader() bool {
+	_, isLeader := r.election.Leader()
+	return isLeader
+}
+
 func (r *Request) List(object kclient.ObjectList, opts *kclient.ListOptions) error {
 	return r.Client.List(r.Ctx, object, opts)
 }
@@ -66,9 +164,55 @@ func (r *Request) Delete(object kclient.Object) error {
 
 type Response interface {
 	Attributes() map[string]any
+	// RetryAfter requeues the key after the delay, the shortest if it is called more than once. A zero delay retries
+	// with the back off of WithBackoff, and is ignored without it.
 	RetryAfter(delay time.Duration)
 }
 
+// CommitResponse is implemented by the responses that support OnCommit, such as the response of the router,
+// ResponseWrapper and the response of the tester. Use OnCommit to register a function with any Response.
+type CommitResponse interface {
+	// OnCommit registers a function that is called after all handlers for the object have run and its changes are
+	// saved without error. The function is not called if the reconcile fails or the router stops first. If the
+	// function returns an error, the key is requeued and the handlers run again, which registers all of the functions
+	// again, including the ones that succeeded. The functions are called at least once, so they must be idempotent.
+	OnCommit(f func(ctx context.Context) error)
+}
+
+// OnCommit registers the function with CommitResponse.OnCommit of the response, or returns an error if the response
+// doesn't support it.
+func OnCommit(resp Response, f func(ctx context.Context) error) error {
+	commit, ok := resp.(CommitResponse)
+	if !ok {
+		return fmt.Errorf("response %T does not support OnCommit", resp)
+	}
+	commit.OnCommit(f)
+	return nil
+}
+
+// ObjectsResponse is implemented by the responses that support Objects, such as the response of the router,
+// ResponseWrapper and the response of the tester. Use Objects to declare objects with any Response.
+type ObjectsResponse interface {
+	// Objects declares objects that the request's object owns. Once all handlers for the object have run without
+	// error and its status is saved, the objects declared by every handler are applied together with an owner
+	// reference to the object, and the objects applied for it before that were not declared again are pruned. Calling
+	// Objects without objects declares that the object owns none. The types of the applied objects are recorded in
+	// the ChildrenAnnotation of the object. Objects of a namespaced object must be in its namespace, which is the
+	// default.
+	Objects(objs ...kclient.Object)
+}
+
+// Objects declares the objects with ObjectsResponse.Objects of the response, or returns an error if the response
+// doesn't support it.
+func Objects(resp Response, objs ...kclient.Object) error {
+	objects, ok := resp.(ObjectsResponse)
+	if !ok {
+		return fmt.Errorf("response %T does not support Objects", resp)
+	}
+	objects.Objects(objs...)
+	return nil
+}
+
 func Key(namespace, name string) kclient.ObjectKey {
 	return kclient.ObjectKey{
 		Name:      name,
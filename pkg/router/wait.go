@@ -0,0 +1,78 @@
+package router
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WaitOption configures WaitForOwned.
+type WaitOption func(*waitOptions)
+
+type waitOptions struct {
+	readyIfNone bool
+}
+
+// ReadyIfNoneOwned makes WaitForOwned report ready when the object owns no objects of the list's type. By default,
+// owning no objects is reported as not ready.
+func ReadyIfNoneOwned() WaitOption {
+	return func(o *waitOptions) {
+		o.readyIfNone = true
+	}
+}
+
+// WaitForOwned lists the objects of the childList's type that are owned by the Request's object with ListOwned and
+// checks each with isReady. If any are not ready, then resp.RetryAfter(delay) is called and the reasons are returned,
+// one per object that is not ready, in a form suitable for a condition message. Changes to the listed objects also
+// trigger the Request's object. The childList is populated with the owned objects.
+func WaitForOwned(req Request, resp Response, childList kclient.ObjectList, isReady func(kclient.Object) (bool, string), delay time.Duration, opts ...WaitOption) (allReady bool, notReadyReasons []string, err error) {
+	var o waitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if req.Object == nil {
+		return false, nil, nil
+	}
+
+	if err := ListOwned(req, childList); err != nil {
+		return false, nil, err
+	}
+
+	objs, err := meta.ExtractList(childList)
+	if err != nil {
+		return false, nil, err
+	}
+
+	owned := make([]kclient.Object, 0, len(objs))
+	for _, obj := range objs {
+		if child, ok := obj.(kclient.Object); ok {
+			owned = append(owned, child)
+		}
+	}
+
+	if len(owned) == 0 {
+		if o.readyIfNone {
+			return true, nil, nil
+		}
+		resp.RetryAfter(delay)
+		return false, []string{"no owned objects found"}, nil
+	}
+
+	for _, child := range owned {
+		if ready, reason := isReady(child); !ready {
+			if reason == "" {
+				reason = "not ready"
+			}
+			notReadyReasons = append(notReadyReasons, fmt.Sprintf("%s: %s", child.GetName(), reason))
+		}
+	}
+
+	if len(notReadyReasons) > 0 {
+		resp.RetryAfter(delay)
+		return false, notReadyReasons, nil
+	}
+	return true, nil, nil
+}
@@ -0,0 +1,212 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/log"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WatchOption configures a watch of RouteBuilder.Watches.
+type WatchOption func(*routeWatch)
+
+// TriggerFromLabels only triggers for the watched objects whose labels match the selector. An update triggers if the
+// object matches before or after it, so that removing the label triggers the objects it was mapped to.
+func TriggerFromLabels(sel labels.Selector) WatchOption {
+	return func(w *routeWatch) {
+		w.sel = sel
+	}
+}
+
+// MapToRequests sets the function that maps a changed watched object to the keys of the route's type to enqueue. It
+// can return no keys. The object is shared with the cache and must not be modified. Without it, a watched object is
+// mapped to its owners of the route's type.
+func MapToRequests(fn func(obj kclient.Object) []kclient.ObjectKey) WatchOption {
+	return func(w *routeWatch) {
+		w.mapFn = fn
+	}
+}
+
+// Watches triggers the route's type when objects of the watched type are created, updated, or deleted, without the
+// handler reading them first. The changed object is mapped to the keys to enqueue with MapToRequests, and can be
+// filtered with TriggerFromLabels. The watches of a type share its informer, and its initial list doesn't trigger,
+// because every object of the route's type is reconciled when the router starts.
+func (r RouteBuilder) Watches(objType kclient.Object, opts ...WatchOption) RouteBuilder {
+	w := routeWatch{objType: objType}
+	for _, opt := range opts {
+		opt(&w)
+	}
+	r.watches = append(slices.Clip(r.watches), w)
+	return r
+}
+
+type routeWatch struct {
+	objType kclient.Object
+	sel     labels.Selector
+	mapFn   func(obj kclient.Object) []kclient.ObjectKey
+}
+
+// matches returns true if obj is a kclient.Object that matches the selector of the watch.
+func (w *routeWatch) matches(obj any) (kclient.Object, bool) {
+	kobj, ok := obj.(kclient.Object)
+	if !ok {
+		return nil, false
+	}
+	return kobj, w.sel == nil || w.sel.Matches(labels.Set(kobj.GetLabels()))
+}
+
+// secondaryWatch is the event handler of a watched type, shared by the routes that watch it.
+type secondaryWatch struct {
+	gvk     schema.GroupVersionKind
+	lock    sync.RWMutex
+	targets []watchTarget
+	started bool
+}
+
+type watchTarget struct {
+	gvk   schema.GroupVersionKind
+	watch routeWatch
+}
+
+func (r RouteBuilder) addWatches(gvk schema.GroupVersionKind) {
+	for _, w := range r.watches {
+		watchedGVK, err := r.router.handlers.backend.GVKForObject(w.objType, r.router.handlers.scheme)
+		if err != nil {
+			panic(fmt.Sprintf("scheme does not know gvk for %T", w.objType))
+		}
+		if w.mapFn == nil {
+			namespaced, err := r.router.handlers.backend.IsObjectNamespaced(r.objType)
+			if err != nil {
+				panic(fmt.Sprintf("failed to find the scope of %v: %v", gvk, err))
+			}
+			w.mapFn = ownersOfKind(gvk, namespaced)
+		}
+		r.router.handlers.addWatch(watchedGVK, watchTarget{gvk: gvk, watch: w})
+	}
+}
+
+func (m *HandlerSet) addWatch(gvk schema.GroupVersionKind, target watchTarget) {
+	m.watchesLock.Lock()
+	defer m.watchesLock.Unlock()
+
+	sw, ok := m.watches[gvk]
+	if !ok {
+		sw = &secondaryWatch{gvk: gvk}
+		if m.watches == nil {
+			m.watches = map[schema.GroupVersionKind]*secondaryWatch{}
+		}
+		m.watches[gvk] = sw
+	}
+
+	sw.lock.Lock()
+	sw.targets = append(sw.targets, target)
+	sw.lock.Unlock()
+
+	if m.watchesStarted && !sw.started {
+		if err := m.startWatch(m.ctx, sw); err != nil {
+			log.Errorf("failed to watch %v for a route of %v added after start: %v", gvk, target.gvk, err)
+		}
+	}
+}
+
+// startWatches adds the event handlers of the watched types to their informers.
+func (m *HandlerSet) startWatches(ctx context.Context) error {
+	m.watchesLock.Lock()
+	defer m.watchesLock.Unlock()
+	m.watchesStarted = true
+	for _, sw := range m.watches {
+		if err := m.startWatch(ctx, sw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *HandlerSet) startWatch(ctx context.Context, sw *secondaryWatch) error {
+	informer, err := m.backend.GetInformerForKind(ctx, sw.gvk)
+	if err != nil {
+		return err
+	}
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj any, isInInitialList bool) {
+			if !isInInitialList {
+				m.triggerWatch(sw, obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			m.triggerWatch(sw, oldObj, newObj)
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			m.triggerWatch(sw, obj)
+		},
+	})
+	if err == nil {
+		sw.started = true
+	}
+	return err
+}
+
+// triggerWatch enqueues the keys that the versions of a changed object are mapped to by each route watching its type.
+func (m *HandlerSet) triggerWatch(sw *secondaryWatch, versions ...any) {
+	sw.lock.RLock()
+	targets := sw.targets
+	sw.lock.RUnlock()
+
+	for _, target := range targets {
+		seen := map[kclient.ObjectKey]bool{}
+		for _, version := range versions {
+			obj, ok := target.watch.matches(version)
+			if !ok {
+				continue
+			}
+			for _, key := range target.watch.mapFn(obj) {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				log.Debugf("Triggering [%s] [%v] from watched [%s/%s] [%v]", key, target.gvk, obj.GetNamespace(), obj.GetName(), sw.gvk)
+				if err := m.backend.Trigger(target.gvk, objectKeyString(key), 0); err != nil {
+					log.Errorf("failed to trigger [%s] [%v] from watched [%v]: %v", key, target.gvk, sw.gvk, err)
+				} else {
+					m.traceEnqueue(target.gvk, objectKeyString(key), 0, "the watch of [%s/%s] [%v]", obj.GetNamespace(), obj.GetName(), sw.gvk)
+				}
+			}
+		}
+	}
+}
+
+func objectKeyString(key kclient.ObjectKey) string {
+	if key.Namespace == "" {
+		return key.Name
+	}
+	return key.Namespace + "/" + key.Name
+}
+
+// ownersOfKind maps an object to its owners of the kind, which are in the namespace of the object if they are
+// namespaced.
+func ownersOfKind(gvk schema.GroupVersionKind, namespaced bool) func(obj kclient.Object) []kclient.ObjectKey {
+	return func(obj kclient.Object) []kclient.ObjectKey {
+		namespace := ""
+		if namespaced {
+			namespace = obj.GetNamespace()
+		}
+		var result []kclient.ObjectKey
+		for _, ref := range obj.GetOwnerReferences() {
+			gv, err := schema.ParseGroupVersion(ref.APIVersion)
+			if err != nil || ref.Kind != gvk.Kind || gv.Group != gvk.Group {
+				continue
+			}
+			result = append(result, Key(namespace, ref.Name))
+		}
+		return result
+	}
+}
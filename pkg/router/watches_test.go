@@ -0,0 +1,142 @@
+package router
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var secretGVK = corev1.SchemeGroupVersion.WithKind("Secret")
+
+// watchBackend records the event handlers added to the informers of the watched types, so that the tests can deliver
+// the events of the watched objects.
+type watchBackend struct {
+	*fakeBackend
+
+	lock     sync.Mutex
+	handlers map[schema.GroupVersionKind][]cache.ResourceEventHandler
+}
+
+type handlerInformer struct {
+	cache.SharedIndexInformer
+	add func(cache.ResourceEventHandler)
+}
+
+func (h handlerInformer) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	h.add(handler)
+	return nil, nil
+}
+
+func (w *watchBackend) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.SharedIndexInformer, error) {
+	if gvk != secretGVK {
+		return w.fakeBackend.GetInformerForKind(ctx, gvk)
+	}
+	return handlerInformer{
+		SharedIndexInformer: cache.NewSharedIndexInformer(&cache.ListWatch{}, &corev1.Secret{}, 0, cache.Indexers{}),
+		add: func(handler cache.ResourceEventHandler) {
+			w.lock.Lock()
+			defer w.lock.Unlock()
+			w.handlers[gvk] = append(w.handlers[gvk], handler)
+		},
+	}, nil
+}
+
+// secretHandler returns the event handler of the watch of secrets, there must be exactly one.
+func (w *watchBackend) secretHandler(t *testing.T) cache.ResourceEventHandler {
+	t.Helper()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.handlers[secretGVK]) != 1 {
+		t.Fatalf("expected one watch of secrets, got %d", len(w.handlers[secretGVK]))
+	}
+	return w.handlers[secretGVK][0]
+}
+
+func secret(name string, lbls map[string]string, owners ...string) *corev1.Secret {
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: lbls}}
+	for _, owner := range owners {
+		s.OwnerReferences = append(s.OwnerReferences, metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: owner})
+	}
+	return s
+}
+
+func TestWatches(t *testing.T) {
+	for name, test := range map[string]struct {
+		opts []WatchOption
+		// events are delivered to the watch in order, with the keys of the config maps each one must trigger.
+		events []func(h cache.ResourceEventHandler)
+		keys   [][]string
+	}{
+		"mapped keys": {
+			opts: []WatchOption{MapToRequests(func(obj kclient.Object) []kclient.ObjectKey {
+				return []kclient.ObjectKey{Key(obj.GetNamespace(), "mapped-"+obj.GetName())}
+			})},
+			events: []func(h cache.ResourceEventHandler){
+				func(h cache.ResourceEventHandler) { h.OnAdd(secret("a", nil), true) },
+				func(h cache.ResourceEventHandler) { h.OnAdd(secret("a", nil), false) },
+				func(h cache.ResourceEventHandler) { h.OnUpdate(secret("a", nil), secret("a", nil)) },
+				func(h cache.ResourceEventHandler) {
+					h.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/a", Obj: secret("a", nil)})
+				},
+			},
+			// The initial list doesn't trigger, and both versions of an update map to the same key.
+			keys: [][]string{nil, {"default/mapped-a"}, {"default/mapped-a"}, {"default/mapped-a"}},
+		},
+		"owners": {
+			events: []func(h cache.ResourceEventHandler){
+				func(h cache.ResourceEventHandler) { h.OnAdd(secret("a", nil, "x", "y"), false) },
+				func(h cache.ResourceEventHandler) { h.OnAdd(secret("b", nil), false) },
+			},
+			keys: [][]string{{"default/x", "default/y"}, nil},
+		},
+		"labels": {
+			opts: []WatchOption{TriggerFromLabels(labels.SelectorFromSet(labels.Set{"app": "test"}))},
+			events: []func(h cache.ResourceEventHandler){
+				func(h cache.ResourceEventHandler) { h.OnAdd(secret("a", nil, "x"), false) },
+				func(h cache.ResourceEventHandler) { h.OnAdd(secret("a", map[string]string{"app": "test"}, "x"), false) },
+				// Removing the label triggers the old owner.
+				func(h cache.ResourceEventHandler) {
+					h.OnUpdate(secret("a", map[string]string{"app": "test"}, "x"), secret("a", nil, "y"))
+				},
+			},
+			keys: [][]string{nil, {"default/x"}, {"default/x"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scheme := testScheme(t)
+			b := &watchBackend{
+				fakeBackend: newFakeBackend(scheme),
+				handlers:    map[schema.GroupVersionKind][]cache.ResourceEventHandler{},
+			}
+			r := New(NewHandlerSet(t.Name(), scheme, b), nil, 0)
+			r.Type(configMap("", "")).Watches(&corev1.Secret{}, test.opts...).HandlerFunc(func(req Request, resp Response) error {
+				return nil
+			})
+			startTestRouter(t, r)
+			h := b.secretHandler(t)
+			b.triggered()
+
+			for i, event := range test.events {
+				event(h)
+				var keys []string
+				for _, trigger := range b.triggered() {
+					if trigger.gvk != configMapGVK {
+						t.Fatalf("expected event %d to trigger config maps, got %v", i, trigger.gvk)
+					}
+					keys = append(keys, trigger.key)
+				}
+				if !reflect.DeepEqual(keys, test.keys[i]) {
+					t.Fatalf("expected event %d to trigger %v, got %v", i, test.keys[i], keys)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/webhook"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestWebhookUsesRouterSchemeAndClient(t *testing.T) {
+	existing := configMap("default", "existing")
+	r, _ := newTestRouter(t, existing)
+
+	wh := r.Webhook()
+	wh.Kind("ConfigMap").HandleFunc(func(resp *webhook.Response, req *webhook.Request) error {
+		obj, err := req.GetObject()
+		if err != nil {
+			return err
+		}
+		if _, ok := obj.(*corev1.ConfigMap); !ok {
+			t.Fatalf("expected a typed config map, got %T", obj)
+		}
+		// Only allow the config map if the existing one is found with the router's client.
+		if err := req.Client.Get(req.Context, kclient.ObjectKeyFromObject(existing), &corev1.ConfigMap{}); err != nil {
+			return err
+		}
+		resp.Allow()
+		return nil
+	})
+
+	data, err := json.Marshal(&corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: data},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	wh.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	var result admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Response == nil || !result.Response.Allowed {
+		t.Fatalf("expected the request to be allowed, got %+v", result.Response)
+	}
+}
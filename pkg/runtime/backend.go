@@ -37,14 +37,19 @@ type Backend struct {
 	cache        cache.Cache
 	startedLock  *sync.RWMutex
 	started      bool
+	workers      int
 }
 
-func newBackend(cacheFactory SharedControllerFactory, client *cacheClient, cache cache.Cache) *Backend {
+func newBackend(cacheFactory SharedControllerFactory, client *cacheClient, cache cache.Cache, workers int) *Backend {
+	if workers <= 0 {
+		workers = DefaultThreadiness
+	}
 	return &Backend{
 		cacheClient:  client,
 		cacheFactory: cacheFactory,
 		cache:        cache,
 		startedLock:  new(sync.RWMutex),
+		workers:      workers,
 	}
 }
 
@@ -67,7 +72,7 @@ func (b *Backend) start(ctx context.Context, preloadOnly bool) (err error) {
 	if preloadOnly {
 		err = b.cacheFactory.Preload(ctx)
 	} else {
-		err = b.cacheFactory.Start(ctx, DefaultThreadiness)
+		err = b.cacheFactory.Start(ctx, b.workers)
 	}
 	if err != nil {
 		return err
@@ -100,43 +105,152 @@ func (b *Backend) Trigger(gvk schema.GroupVersionKind, key string, delay time.Du
 	return nil
 }
 
+func (b *Backend) EnqueueInfo(gvk schema.GroupVersionKind, key string) (backend.EnqueueInfo, bool) {
+	c, err := b.cacheFactory.ForKind(gvk)
+	if err != nil {
+		return backend.EnqueueInfo{}, false
+	}
+	if getter, ok := c.(interface {
+		EnqueueInfo(string) (backend.EnqueueInfo, bool)
+	}); ok {
+		return getter.EnqueueInfo(key)
+	}
+	return backend.EnqueueInfo{}, false
+}
+
+func (b *Backend) PendingEnqueues(gvk schema.GroupVersionKind) map[string]backend.EnqueueInfo {
+	c, err := b.cacheFactory.ForKind(gvk)
+	if err != nil {
+		return nil
+	}
+	if lister, ok := c.(interface {
+		PendingEnqueues() map[string]backend.EnqueueInfo
+	}); ok {
+		return lister.PendingEnqueues()
+	}
+	return nil
+}
+
+func (b *Backend) CachedNamespaces(gvk schema.GroupVersionKind) []string {
+	return b.namespaces.of(gvk.Group)
+}
+
+func (b *Backend) QueuedKinds() []schema.GroupVersionKind {
+	if lister, ok := b.cacheFactory.(interface {
+		Kinds() []schema.GroupVersionKind
+	}); ok {
+		return lister.Kinds()
+	}
+	return nil
+}
+
+func (b *Backend) TrackOldObjects(gvk schema.GroupVersionKind) error {
+	c, err := b.cacheFactory.ForKind(gvk)
+	if err != nil {
+		return err
+	}
+	if tracker, ok := c.(interface {
+		TrackOldObjects()
+	}); ok {
+		tracker.TrackOldObjects()
+	}
+	return nil
+}
+
+func (b *Backend) ObserveEnqueues(gvk schema.GroupVersionKind, observe func(string, runtime.Object)) error {
+	c, err := b.cacheFactory.ForKind(gvk)
+	if err != nil {
+		return err
+	}
+	if observer, ok := c.(interface {
+		ObserveEnqueues(func(string, runtime.Object))
+	}); ok {
+		observer.ObserveEnqueues(observe)
+	}
+	return nil
+}
+
+func (b *Backend) SetWorkers(gvk schema.GroupVersionKind, workers int) error {
+	c, err := b.cacheFactory.ForKind(gvk)
+	if err != nil {
+		return err
+	}
+	if scaler, ok := c.(interface {
+		SetWorkers(int)
+	}); ok {
+		scaler.SetWorkers(workers)
+	}
+	return nil
+}
+
 func (b *Backend) addIndexer(ctx context.Context, gvk schema.GroupVersionKind) error {
 	obj, err := b.Scheme().New(gvk)
 	if err != nil {
 		return err
 	}
-	f, ok := obj.(fields.Fields)
-	if !ok {
-		return nil
-	}
 
 	cache, err := b.cache.GetInformerForKind(ctx, gvk)
 	if err != nil {
 		return err
 	}
 
-	indexers := map[string]kcache.IndexFunc{}
-	for _, field := range f.FieldNames() {
-		field := field
-		indexers["field:"+field] = func(obj interface{}) ([]string, error) {
-			f, ok := obj.(fields.Fields)
-			if !ok {
-				return nil, nil
-			}
-			v := f.Get(field)
-			if v == "" {
-				return nil, nil
-			}
-			vals := []string{keyFunc("", v)}
-			if ko, ok := obj.(kclient.Object); ok && ko.GetNamespace() != "" {
-				vals = append(vals, keyFunc(ko.GetNamespace(), v))
+	indexers := map[string]kcache.IndexFunc{
+		"field:" + backend.OwnerUIDIndexField: indexOwnerUIDs,
+	}
+	if f, ok := obj.(fields.Fields); ok {
+		for _, field := range f.FieldNames() {
+			field := field
+			indexers["field:"+field] = func(obj interface{}) ([]string, error) {
+				f, ok := obj.(fields.Fields)
+				if !ok {
+					return nil, nil
+				}
+				v := f.Get(field)
+				if v == "" {
+					return nil, nil
+				}
+				vals := []string{keyFunc("", v)}
+				if ko, ok := obj.(kclient.Object); ok && ko.GetNamespace() != "" {
+					vals = append(vals, keyFunc(ko.GetNamespace(), v))
+				}
+				return vals, nil
 			}
-			return vals, nil
 		}
 	}
+
+	if sii, ok := cache.(kcache.SharedIndexInformer); ok {
+		// Every router watching the GVK adds the indexers, and adding an existing indexer is an error.
+		for name := range sii.GetIndexer().GetIndexers() {
+			delete(indexers, name)
+		}
+	}
+	if len(indexers) == 0 {
+		return nil
+	}
 	return cache.AddIndexers(indexers)
 }
 
+// indexOwnerUIDs indexes objects by the UIDs of their owners, in the form expected by the field selectors of the
+// cache.
+func indexOwnerUIDs(obj interface{}) ([]string, error) {
+	ko, ok := obj.(kclient.Object)
+	if !ok {
+		return nil, nil
+	}
+	refs := ko.GetOwnerReferences()
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	vals := make([]string, 0, 2*len(refs))
+	for _, ref := range refs {
+		vals = append(vals, "__all_namespaces/"+string(ref.UID))
+		if ko.GetNamespace() != "" {
+			vals = append(vals, keyFunc(ko.GetNamespace(), string(ref.UID)))
+		}
+	}
+	return vals, nil
+}
+
 func (b *Backend) Watcher(ctx context.Context, gvk schema.GroupVersionKind, name string, cb backend.Callback) error {
 	c, err := b.cacheFactory.ForKind(gvk)
 	if err != nil {
@@ -153,7 +267,7 @@ func (b *Backend) Watcher(ctx context.Context, gvk schema.GroupVersionKind, name
 	}
 
 	if b.hasStarted() {
-		return c.Start(ctx, DefaultThreadiness)
+		return c.Start(ctx, b.workers)
 	}
 	return nil
 }
@@ -3,16 +3,20 @@ package runtime
 import (
 	"context"
 	"errors"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/obot-platform/nah/pkg/backend"
 	"github.com/obot-platform/nah/pkg/untriggered"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/utils/clock"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
@@ -37,6 +41,25 @@ type cacheClient struct {
 
 	recent     map[objectKey]objectValue
 	recentLock sync.Mutex
+	clock      clock.Clock
+	// namespaces are the namespaces that the caches of each API group are restricted to.
+	namespaces cachedNamespaces
+}
+
+// cachedNamespaces are the namespaces that the caches are restricted to, by API group.
+type cachedNamespaces struct {
+	// defaults are the namespaces of the API groups without a config of their own, nil if they are cluster wide.
+	defaults []string
+	// groups are the namespaces of the API groups with a config of their own, nil if they are cluster wide.
+	groups map[string][]string
+}
+
+// of returns the namespaces that the caches of the API group are restricted to, or nil if they are cluster wide.
+func (n cachedNamespaces) of(group string) []string {
+	if namespaces, ok := n.groups[group]; ok {
+		return namespaces
+	}
+	return n.defaults
 }
 
 func newer(oldRV, newRV string) bool {
@@ -54,11 +77,13 @@ func newer(oldRV, newRV string) bool {
 	return oldI < newI
 }
 
-func newCacheClient(uncached kclient.WithWatch, cached kclient.Client) *cacheClient {
+func newCacheClient(uncached kclient.WithWatch, cached kclient.Client, clock clock.Clock, namespaces cachedNamespaces) *cacheClient {
 	return &cacheClient{
-		uncached: uncached,
-		cached:   cached,
-		recent:   map[objectKey]objectValue{},
+		uncached:   uncached,
+		cached:     cached,
+		recent:     map[objectKey]objectValue{},
+		clock:      clock,
+		namespaces: namespaces,
 	}
 }
 
@@ -68,10 +93,10 @@ func (c *cacheClient) startPurge(ctx context.Context) {
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(cacheDuration):
+			case <-c.clock.After(cacheDuration):
 			}
 
-			now := time.Now()
+			now := c.clock.Now()
 			c.recentLock.Lock()
 			for k, v := range c.recent {
 				if v.Inserted.Add(cacheDuration).Before(now) {
@@ -109,12 +134,28 @@ func (c *cacheClient) store(obj kclient.Object) {
 		name:      obj.GetName(),
 	}] = objectValue{
 		Object:   obj.DeepCopyObject().(kclient.Object),
-		Inserted: time.Now(),
+		Inserted: c.clock.Now(),
 	}
 	c.recentLock.Unlock()
 }
 
+// namespacesOf returns the namespaces that the caches of the type of the object or list are restricted to.
+func (c *cacheClient) namespacesOf(obj runtime.Object) ([]string, error) {
+	gvk, err := apiutil.GVKForObject(untriggered.Unwrap(obj), c.Scheme())
+	if err != nil {
+		return nil, err
+	}
+	return c.namespaces.of(gvk.Group), nil
+}
+
 func (c *cacheClient) Get(ctx context.Context, key kclient.ObjectKey, obj kclient.Object, opts ...kclient.GetOption) error {
+	namespaces, err := c.namespacesOf(obj)
+	if err != nil {
+		return err
+	}
+	if err := backend.CheckNamespace(key.Namespace, namespaces); err != nil {
+		return err
+	}
 	if u, ok := obj.(*untriggered.Holder); ok {
 		obj = u.Object
 		if u.IsUncached() {
@@ -158,13 +199,69 @@ func (c *cacheClient) Get(ctx context.Context, key kclient.ObjectKey, obj kclien
 }
 
 func (c *cacheClient) List(ctx context.Context, list kclient.ObjectList, opts ...kclient.ListOption) error {
+	listOpts := &kclient.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	namespaces, err := c.namespacesOf(list)
+	if err != nil {
+		return err
+	}
+	if err := backend.CheckNamespace(listOpts.Namespace, namespaces); err != nil {
+		return err
+	}
 	if u, ok := list.(*untriggered.HolderList); ok {
 		list = u.ObjectList
 		if u.IsUncached() {
+			if listOpts.Namespace == "" && len(namespaces) > 0 {
+				return listNamespaces(ctx, c.uncached, namespaces, list, opts)
+			}
 			return c.uncached.List(ctx, u, opts...)
 		}
 	}
-	return c.cached.List(ctx, list, opts...)
+	if err := c.cached.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	if listOpts.Namespace == "" && len(namespaces) > 1 {
+		// The cache merges the lists of its namespaces in no particular order.
+		return sortByNamespace(list)
+	}
+	return nil
+}
+
+// listNamespaces lists each of the sorted namespaces and merges the results, ordered by namespace, because listing
+// all namespaces may not be allowed.
+func listNamespaces(ctx context.Context, reader kclient.Reader, namespaces []string, list kclient.ObjectList, opts []kclient.ListOption) error {
+	var items []runtime.Object
+	for _, namespace := range namespaces {
+		nsList := list.DeepCopyObject().(kclient.ObjectList)
+		if err := reader.List(ctx, nsList, append(slices.Clip(opts), kclient.InNamespace(namespace))...); err != nil {
+			return err
+		}
+		nsItems, err := meta.ExtractList(nsList)
+		if err != nil {
+			return err
+		}
+		items = append(items, nsItems...)
+	}
+	return meta.SetList(list, items)
+}
+
+// sortByNamespace orders the items of the list by namespace, keeping the order of the items within a namespace.
+func sortByNamespace(list kclient.ObjectList) error {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(items, func(a, b runtime.Object) int {
+		return strings.Compare(namespaceOf(a), namespaceOf(b))
+	})
+	return meta.SetList(list, items)
+}
+
+func namespaceOf(obj runtime.Object) string {
+	if o, ok := obj.(kclient.Object); ok {
+		return o.GetNamespace()
+	}
+	return ""
 }
 
 func (c *cacheClient) Create(ctx context.Context, obj kclient.Object, opts ...kclient.CreateOption) error {
@@ -0,0 +1,80 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	"github.com/obot-platform/nah/pkg/untriggered"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestCacheClient(t *testing.T, namespaces cachedNamespaces, objs ...kclient.Object) *cacheClient {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return newCacheClient(c, c, clocktesting.NewFakeClock(metav1.Now().Time), namespaces)
+}
+
+func TestUncachedListFansOutByNamespace(t *testing.T) {
+	var objs []kclient.Object
+	for _, namespace := range []string{"c", "b", "a"} {
+		for _, name := range []string{"y", "x"} {
+			objs = append(objs, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})
+		}
+	}
+	c := newTestCacheClient(t, cachedNamespaces{defaults: []string{"a", "b"}}, objs...)
+
+	var list corev1.ConfigMapList
+	if err := c.List(context.Background(), untriggered.UncachedList(&list)); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, cm := range list.Items {
+		keys = append(keys, cm.Namespace+"/"+cm.Name)
+	}
+	if expected := []string{"a/x", "a/y", "b/x", "b/y"}; !slices.Equal(keys, expected) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+
+	err := c.List(context.Background(), untriggered.UncachedList(&list), kclient.InNamespace("c"))
+	if nsErr := (*backend.NamespaceNotCachedError)(nil); !errors.As(err, &nsErr) || nsErr.Namespace != "c" {
+		t.Fatalf("expected a NamespaceNotCachedError for namespace c, got %v", err)
+	}
+}
+
+func TestCachedNamespacesOfAPIGroup(t *testing.T) {
+	c := newTestCacheClient(t, cachedNamespaces{
+		defaults: []string{"a"},
+		groups:   map[string][]string{"apps": nil},
+	},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "cm"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "deploy"}},
+	)
+
+	// The core group uses the namespaces of the default config.
+	err := c.Get(context.Background(), kclient.ObjectKey{Namespace: "b", Name: "cm"}, &corev1.ConfigMap{})
+	if !errors.As(err, new(*backend.NamespaceNotCachedError)) {
+		t.Fatalf("expected a NamespaceNotCachedError, got %v", err)
+	}
+
+	// The apps group has a cluster wide config of its own.
+	if err := c.Get(context.Background(), kclient.ObjectKey{Namespace: "b", Name: "deploy"}, &appsv1.Deployment{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.List(context.Background(), &appsv1.DeploymentList{}, kclient.InNamespace("b")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"slices"
 	"time"
 
 	"github.com/obot-platform/nah/pkg/mapper"
@@ -8,6 +9,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -19,6 +21,30 @@ type Runtime struct {
 type Config struct {
 	Rest      *rest.Config
 	Namespace string
+	// Namespaces restricts the caches to these namespaces, along with Namespace if it is set, with informers per
+	// namespace. Reads of other namespaces fail with a *backend.NamespaceNotCachedError, and lists of all namespaces
+	// are merged from the namespaces, ordered by namespace. The caches are cluster wide if both are empty.
+	Namespaces []string
+	// Clock is used for the delayed queues and the recently written object cache. This is only read from the
+	// default config and defaults to the real clock.
+	Clock clock.WithTicker
+	// Workers is the number of workers started per GVK. This is only read from the default config and defaults to
+	// DefaultThreadiness.
+	Workers int
+	// Fairness, if set, dispatches keys round-robin across fairness buckets. This is only read from the default config.
+	Fairness *Fairness
+	// Elide configures the types whose large objects are cached with some fields elided.
+	Elide []ElidePolicy
+}
+
+// cachedNamespaces returns the sorted namespaces that the caches are restricted to, or nil if they are cluster wide.
+func (c Config) cachedNamespaces() []string {
+	namespaces := slices.Clone(c.Namespaces)
+	if c.Namespace != "" {
+		namespaces = append(namespaces, c.Namespace)
+	}
+	slices.Sort(namespaces)
+	return slices.Compact(namespaces)
 }
 
 func NewRuntime(cfg *rest.Config, scheme *runtime.Scheme) (*Runtime, error) {
@@ -38,7 +64,13 @@ func NewRuntimeWithConfigs(defaultConfig Config, apiGroupConfigs map[string]Conf
 	cachedClients := make(map[string]client.Client, len(apiGroupConfigs))
 	caches := make(map[string]cache.Cache, len(apiGroupConfigs))
 
+	namespaces := cachedNamespaces{
+		defaults: defaultConfig.cachedNamespaces(),
+		groups:   make(map[string][]string, len(apiGroupConfigs)),
+	}
+
 	for key, cfg := range apiGroupConfigs {
+		namespaces.groups[key] = cfg.cachedNamespaces()
 		uncachedClient, cachedClient, theCache, err := getClients(cfg, scheme)
 		if err != nil {
 			return nil, err
@@ -58,7 +90,13 @@ func NewRuntimeWithConfigs(defaultConfig Config, apiGroupConfigs map[string]Conf
 	aggCachedClient := multi.NewClient(cachedClient, cachedClients)
 	aggCache := multi.NewCache(scheme, theCache, caches)
 
+	if defaultConfig.Clock == nil {
+		defaultConfig.Clock = clock.RealClock{}
+	}
+
 	factory := NewSharedControllerFactory(aggUncachedClient, aggCache, &SharedControllerFactoryOptions{
+		Clock:    defaultConfig.Clock,
+		Fairness: defaultConfig.Fairness,
 		// In baaah this is only invoked when a key fails to process
 		DefaultRateLimiter: workqueue.NewTypedMaxOfRateLimiter(
 			// This will go .5, 1, 2, 4, 8 seconds, etc up until 15 minutes
@@ -67,7 +105,7 @@ func NewRuntimeWithConfigs(defaultConfig Config, apiGroupConfigs map[string]Conf
 	})
 
 	return &Runtime{
-		Backend: newBackend(factory, newCacheClient(aggUncachedClient, aggCachedClient), aggCache),
+		Backend: newBackend(factory, newCacheClient(aggUncachedClient, aggCachedClient, defaultConfig.Clock, namespaces), aggCache, defaultConfig.Workers),
 	}, nil
 }
 
@@ -86,15 +124,23 @@ func getClients(cfg Config, scheme *runtime.Scheme) (uncachedClient client.WithW
 	}
 
 	var namespaces map[string]cache.Config
-	if cfg.Namespace != "" {
-		namespaces = map[string]cache.Config{}
-		namespaces[cfg.Namespace] = cache.Config{}
+	for _, namespace := range cfg.cachedNamespaces() {
+		if namespaces == nil {
+			namespaces = map[string]cache.Config{}
+		}
+		namespaces[namespace] = cache.Config{}
+	}
+
+	byObject, err := elideByObject(cfg.Elide, scheme)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	theCache, err = cache.New(cfg.Rest, cache.Options{
 		Mapper:            mapper,
 		Scheme:            scheme,
 		DefaultNamespaces: namespaces,
+		ByObject:          byObject,
 	})
 	if err != nil {
 		return nil, nil, nil, err
@@ -3,10 +3,14 @@ package runtime
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/obot-platform/nah/pkg/backend"
 	"github.com/obot-platform/nah/pkg/log"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -17,6 +21,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	clientgocache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -46,11 +51,15 @@ type Controller interface {
 }
 
 type controller struct {
-	startLock sync.Mutex
+	// startingLock serializes Start, which doesn't hold startLock while it waits for the caches, because the event
+	// handlers take it to add the start keys.
+	startingLock sync.Mutex
+	startLock    sync.Mutex
 
 	name         string
 	workqueue    workqueue.TypedRateLimitingInterface[any]
 	rateLimiter  workqueue.TypedRateLimiter[any]
+	clock        clock.WithTicker
 	informer     cache.Informer
 	handler      Handler
 	gvk          schema.GroupVersionKind
@@ -59,6 +68,27 @@ type controller struct {
 	registration clientgocache.ResourceEventHandlerRegistration
 	obj          runtime.Object
 	cache        cache.Cache
+	fairness     *Fairness
+	fairQueue    *fairQueue
+
+	infoLock   sync.Mutex
+	pending    map[string]backend.EnqueueInfo
+	processing map[string]backend.EnqueueInfo
+	// startVersions are the resource versions that the first reconciles of the objects of the initial list read from
+	// the cache, or empty until they have read it, so that the updates that race with them are not reconciled twice.
+	// The entry of an object is removed on its next update or delete.
+	startVersions map[string]string
+	// trackOld is set once a route asks for the old objects of updates, which are only kept from then on.
+	trackOld atomic.Bool
+	// observers are called with the key and object of every event, they are replaced as a whole when one is added.
+	observers atomic.Pointer[[]func(string, runtime.Object)]
+
+	workerLock sync.Mutex
+	// workerTarget is the number of workers set with SetWorkers, or zero to use the number given to Start.
+	workerTarget int
+	// workerStops stops each of the running workers, the last ones are stopped first when the workers are reduced.
+	workerStops []context.CancelFunc
+	workerCtx   context.Context
 }
 
 type startKey struct {
@@ -68,6 +98,10 @@ type startKey struct {
 
 type Options struct {
 	RateLimiter workqueue.TypedRateLimiter[any]
+	// Clock is used by the delayed queue. Defaults to the real clock.
+	Clock clock.WithTicker
+	// Fairness, if set, dispatches the keys round-robin across the fairness buckets instead of in FIFO order.
+	Fairness *Fairness
 }
 
 func New(gvk schema.GroupVersionKind, scheme *runtime.Scheme, cache cache.Cache, handler Handler, opts *Options) (Controller, error) {
@@ -90,7 +124,11 @@ func New(gvk schema.GroupVersionKind, scheme *runtime.Scheme, cache cache.Cache,
 		cache:       cache,
 		obj:         obj,
 		rateLimiter: opts.RateLimiter,
+		clock:       opts.Clock,
 		informer:    informer,
+		fairness:    opts.Fairness,
+		pending:     map[string]backend.EnqueueInfo{},
+		processing:  map[string]backend.EnqueueInfo{},
 	}
 
 	return controller, nil
@@ -115,6 +153,9 @@ func applyDefaultOptions(opts *Options) *Options {
 			workqueue.NewTypedItemExponentialFailureRateLimiter[any](5*time.Millisecond, 30*time.Second),
 		)
 	}
+	if newOpts.Clock == nil {
+		newOpts.Clock = clock.RealClock{}
+	}
 	return &newOpts
 }
 
@@ -136,8 +177,16 @@ func (c *controller) run(ctx context.Context, workers int) {
 	// will create a goroutine under the hood.  It we instantiate a workqueue we must have
 	// a mechanism to Shutdown it down.  Without the stopCh we don't know when to shutdown
 	// the queue and release the goroutine
-	c.workqueue = workqueue.NewTypedRateLimitingQueueWithConfig(c.rateLimiter, workqueue.TypedRateLimitingQueueConfig[any]{Name: c.name})
+	c.workqueue = c.newWorkqueue()
+	c.infoLock.Lock()
+	c.startVersions = map[string]string{}
 	for _, start := range c.startKeys {
+		if start.after == 0 && !isSpecialKey(start.key) {
+			c.startVersions[start.key] = ""
+		}
+	}
+	c.infoLock.Unlock()
+	for _, start := range sortStartKeys(c.startKeys) {
 		if start.after == 0 {
 			c.workqueue.Add(start.key)
 		} else {
@@ -155,13 +204,19 @@ func (c *controller) run(ctx context.Context, workers int) {
 	// Start the informer factories to begin populating the informer caches
 	log.Infof("Starting %s controller", c.name)
 
-	for i := 0; i < workers; i++ {
-		go wait.Until(func() {
-			c.runWorker(ctx)
-		}, time.Second, ctx.Done())
+	c.workerLock.Lock()
+	c.workerCtx = ctx
+	if c.workerTarget > 0 {
+		workers = c.workerTarget
 	}
+	c.resizeWorkersLocked(workers)
+	c.workerLock.Unlock()
 
 	<-ctx.Done()
+	c.workerLock.Lock()
+	c.workerCtx = nil
+	c.workerStops = nil
+	c.workerLock.Unlock()
 	c.startLock.Lock()
 	defer c.startLock.Unlock()
 	c.started = false
@@ -169,10 +224,13 @@ func (c *controller) run(ctx context.Context, workers int) {
 }
 
 func (c *controller) Start(ctx context.Context, workers int) error {
-	c.startLock.Lock()
-	defer c.startLock.Unlock()
+	c.startingLock.Lock()
+	defer c.startingLock.Unlock()
 
-	if c.started {
+	c.startLock.Lock()
+	started := c.started
+	c.startLock.Unlock()
+	if started {
 		return nil
 	}
 
@@ -192,9 +250,11 @@ func (c *controller) Start(ctx context.Context, workers int) error {
 		registration, err := c.informer.AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
 			AddFunc: c.handleObject,
 			UpdateFunc: func(old, new interface{}) {
-				c.handleObject(new)
+				c.handleUpdate(old, new)
+			},
+			DeleteFunc: func(obj interface{}) {
+				c.handleDelete(obj)
 			},
-			DeleteFunc: c.handleObject,
 		})
 		if err != nil {
 			return err
@@ -208,17 +268,91 @@ func (c *controller) Start(ctx context.Context, workers int) error {
 		}()
 	}
 
-	if ok := clientgocache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced); !ok {
+	// Wait for the registration, not just the informer, so that every object of the initial list has been delivered
+	// to the start keys before the queue is created.
+	if ok := clientgocache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced, c.registration.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
-	go c.run(ctx, workers)
+	c.startLock.Lock()
 	c.started = true
+	c.startLock.Unlock()
+	go c.run(ctx, workers)
 	return nil
 }
 
-func (c *controller) runWorker(ctx context.Context) {
-	for c.processNextWorkItem(ctx) {
+// sortStartKeys sorts the keys enqueued before the queue was created by namespace and name, so that the initial list is
+// processed in the same order on every start. Duplicate keys are coalesced by the queue.
+func sortStartKeys(keys []startKey) []startKey {
+	result := slices.Clone(keys)
+	sort.SliceStable(result, func(i, j int) bool {
+		nsI, nameI := keyParse(result[i].key)
+		nsJ, nameJ := keyParse(result[j].key)
+		if nsI != nsJ {
+			return nsI < nsJ
+		}
+		return nameI < nameJ
+	})
+	return result
+}
+
+func (c *controller) newWorkqueue() workqueue.TypedRateLimitingInterface[any] {
+	config := workqueue.TypedRateLimitingQueueConfig[any]{Name: c.name, Clock: c.clock}
+	if c.fairness != nil {
+		c.fairQueue = newFairQueue(c.name, *c.fairness)
+		config.DelayingQueue = workqueue.NewTypedDelayingQueueWithConfig(workqueue.TypedDelayingQueueConfig[any]{
+			Name:  c.name,
+			Clock: c.clock,
+			Queue: workqueue.NewTypedWithConfig(workqueue.TypedQueueConfig[any]{
+				Name:  c.name,
+				Clock: c.clock,
+				Queue: c.fairQueue,
+			}),
+		})
+	}
+	return workqueue.NewTypedRateLimitingQueueWithConfig(c.rateLimiter, config)
+}
+
+// SetWorkers changes the number of workers. If the controller is running, workers are started or stopped right away.
+// A stopped worker finishes the key it is processing first.
+func (c *controller) SetWorkers(workers int) {
+	if workers <= 0 {
+		return
+	}
+	c.workerLock.Lock()
+	defer c.workerLock.Unlock()
+	c.workerTarget = workers
+	if c.workerCtx != nil {
+		c.resizeWorkersLocked(workers)
+	}
+}
+
+func (c *controller) resizeWorkersLocked(workers int) {
+	for len(c.workerStops) < workers {
+		stopCtx, stop := context.WithCancel(c.workerCtx)
+		ctx := c.workerCtx
+		go wait.Until(func() {
+			c.runWorker(ctx, stopCtx.Done())
+		}, time.Second, stopCtx.Done())
+		c.workerStops = append(c.workerStops, stop)
+	}
+	for len(c.workerStops) > workers {
+		last := len(c.workerStops) - 1
+		c.workerStops[last]()
+		c.workerStops = c.workerStops[:last]
+	}
+}
+
+func (c *controller) runWorker(ctx context.Context, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if !c.processNextWorkItem(ctx) {
+			return
+		}
 	}
 }
 
@@ -246,14 +380,27 @@ func (c *controller) processSingleItem(ctx context.Context, obj interface{}) err
 	)
 
 	defer c.workqueue.Done(obj)
+	if c.fairQueue != nil {
+		defer c.fairQueue.done(obj)
+	}
 
 	if key, ok = obj.(string); !ok {
 		c.workqueue.Forget(obj)
 		log.Errorf("expected string in workqueue but got %#v", obj)
 		return nil
 	}
+	c.startProcessing(key)
+	defer c.doneProcessing(key)
+
 	if err := c.syncHandler(ctx, key); err != nil {
-		c.workqueue.AddRateLimited(key)
+		if backend.IsRequeued(err) {
+			// The handlers requeued the key and logged the error themselves.
+			return nil
+		}
+		// This is AddRateLimited, split so that the time the requeue is due is known.
+		delay := max(c.rateLimiter.When(key), backend.MinDelay(err))
+		c.recordEnqueue(key, backend.EnqueueInfo{EnqueuedAt: c.clock.Now().Add(delay), Requeued: true})
+		c.workqueue.AddAfter(key, delay)
 		return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 	}
 
@@ -278,15 +425,19 @@ func (c *controller) syncHandler(ctx context.Context, key string) error {
 		Namespace: ns,
 	}, obj)
 	if apierror.IsNotFound(err) {
+		c.readStartVersion(key, "")
 		return c.handler.OnChange(key, nil)
 	} else if err != nil {
 		return err
 	}
+	c.readStartVersion(key, obj.GetResourceVersion())
 
 	return c.handler.OnChange(key, obj.(runtime.Object))
 }
 
 func (c *controller) EnqueueKey(key string) {
+	c.recordEnqueue(key, backend.EnqueueInfo{EnqueuedAt: c.clock.Now()})
+
 	c.startLock.Lock()
 	defer c.startLock.Unlock()
 
@@ -299,6 +450,7 @@ func (c *controller) EnqueueKey(key string) {
 
 func (c *controller) Enqueue(namespace, name string) {
 	key := keyFunc(namespace, name)
+	c.recordEnqueue(key, backend.EnqueueInfo{EnqueuedAt: c.clock.Now(), Requeued: true})
 
 	c.startLock.Lock()
 	defer c.startLock.Unlock()
@@ -312,6 +464,7 @@ func (c *controller) Enqueue(namespace, name string) {
 
 func (c *controller) EnqueueAfter(namespace, name string, duration time.Duration) {
 	key := keyFunc(namespace, name)
+	c.recordEnqueue(key, backend.EnqueueInfo{EnqueuedAt: c.clock.Now().Add(duration), Requeued: true})
 
 	c.startLock.Lock()
 	defer c.startLock.Unlock()
@@ -323,6 +476,64 @@ func (c *controller) EnqueueAfter(namespace, name string, duration time.Duration
 	}
 }
 
+// recordEnqueue merges the info into the info of the pending key, keeping the earliest times.
+func (c *controller) recordEnqueue(key string, info backend.EnqueueInfo) {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	existing, ok := c.pending[key]
+	if !ok {
+		c.pending[key] = info
+		return
+	}
+	if info.EnqueuedAt.Before(existing.EnqueuedAt) {
+		existing.EnqueuedAt = info.EnqueuedAt
+	}
+	// The old object is only taken from an update if no earlier watch event is pending, so that it is the version
+	// before the earliest coalesced event, and nil if that event was an add.
+	if existing.OldObject == nil && existing.EventObservedAt.IsZero() {
+		existing.OldObject = info.OldObject
+	}
+	if !info.EventObservedAt.IsZero() && (existing.EventObservedAt.IsZero() || info.EventObservedAt.Before(existing.EventObservedAt)) {
+		existing.EventObservedAt = info.EventObservedAt
+	}
+	existing.Requeued = existing.Requeued && info.Requeued
+	c.pending[key] = existing
+}
+
+func (c *controller) startProcessing(key string) {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	if info, ok := c.pending[key]; ok {
+		delete(c.pending, key)
+		c.processing[key] = info
+	}
+}
+
+func (c *controller) doneProcessing(key string) {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	delete(c.processing, key)
+}
+
+// PendingEnqueues returns the enqueue info of the keys that are waiting in the queue, including the delayed ones.
+func (c *controller) PendingEnqueues() map[string]backend.EnqueueInfo {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	result := make(map[string]backend.EnqueueInfo, len(c.pending))
+	for key, info := range c.pending {
+		result[key] = info
+	}
+	return result
+}
+
+// EnqueueInfo returns when the key being processed was enqueued.
+func (c *controller) EnqueueInfo(key string) (backend.EnqueueInfo, bool) {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	info, ok := c.processing[key]
+	return info, ok
+}
+
 func keyParse(key string) (namespace string, name string) {
 	var ok bool
 	namespace, name, ok = strings.Cut(key, "/")
@@ -340,13 +551,40 @@ func keyFunc(namespace, name string) string {
 	return namespace + "/" + name
 }
 
-func (c *controller) enqueue(obj interface{}) {
+// TrackOldObjects makes the controller keep a copy of the previous version of the objects of update events, which is
+// returned as the OldObject of the EnqueueInfo.
+func (c *controller) TrackOldObjects() {
+	c.trackOld.Store(true)
+}
+
+// ObserveEnqueues adds an observer that is called with the key and the object of every event that enqueues a key.
+func (c *controller) ObserveEnqueues(observe func(string, runtime.Object)) {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	var observers []func(string, runtime.Object)
+	if existing := c.observers.Load(); existing != nil {
+		observers = slices.Clone(*existing)
+	}
+	observers = append(observers, observe)
+	c.observers.Store(&observers)
+}
+
+func (c *controller) enqueue(obj interface{}, old runtime.Object) {
 	var key string
 	var err error
 	if key, err = clientgocache.MetaNamespaceKeyFunc(obj); err != nil {
 		log.Errorf("%v", err)
 		return
 	}
+	now := c.clock.Now()
+	c.recordEnqueue(key, backend.EnqueueInfo{EnqueuedAt: now, EventObservedAt: now, OldObject: old})
+	if observers := c.observers.Load(); observers != nil {
+		runtimeObj, _ := obj.(runtime.Object)
+		for _, observe := range *observers {
+			observe(key, runtimeObj)
+		}
+	}
+
 	c.startLock.Lock()
 	if c.workqueue == nil {
 		c.startKeys = append(c.startKeys, startKey{key: key})
@@ -370,5 +608,75 @@ func (c *controller) handleObject(obj interface{}) {
 		}
 		obj = newObj
 	}
-	c.enqueue(obj)
+	c.enqueue(obj, nil)
+}
+
+// readStartVersion records the version that the first reconcile of an object of the initial list read, or forgets
+// the object if it was not found.
+func (c *controller) readStartVersion(key, resourceVersion string) {
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	if version, ok := c.startVersions[key]; !ok || version != "" {
+		return
+	}
+	if resourceVersion == "" {
+		delete(c.startVersions, key)
+		return
+	}
+	c.startVersions[key] = resourceVersion
+}
+
+// raced returns true if the update of an object of the initial list is already seen by its first reconcile, because
+// the reconcile hasn't read the object from the cache yet, which has the update, or read this version. A watch event
+// that races with the initial list is then coalesced into the first reconcile.
+func (c *controller) raced(obj interface{}) bool {
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return false
+	}
+	key := keyFunc(meta.GetNamespace(), meta.GetName())
+
+	c.infoLock.Lock()
+	defer c.infoLock.Unlock()
+	version, ok := c.startVersions[key]
+	if !ok {
+		return false
+	}
+	if version == "" {
+		return true
+	}
+	delete(c.startVersions, key)
+	return version == meta.GetResourceVersion()
+}
+
+func (c *controller) handleDelete(obj interface{}) {
+	if meta, ok := obj.(metav1.Object); ok {
+		c.infoLock.Lock()
+		delete(c.startVersions, keyFunc(meta.GetNamespace(), meta.GetName()))
+		c.infoLock.Unlock()
+	} else if tombstone, ok := obj.(clientgocache.DeletedFinalStateUnknown); ok {
+		c.infoLock.Lock()
+		delete(c.startVersions, tombstone.Key)
+		c.infoLock.Unlock()
+	}
+	c.handleObject(obj)
+}
+
+func (c *controller) handleUpdate(old, new interface{}) {
+	if c.raced(new) {
+		return
+	}
+	if !c.trackOld.Load() {
+		c.handleObject(new)
+		return
+	}
+	if _, ok := new.(metav1.Object); !ok {
+		c.handleObject(new)
+		return
+	}
+	var oldObj runtime.Object
+	if o, ok := old.(runtime.Object); ok {
+		oldObj = o.DeepCopyObject()
+	}
+	c.enqueue(new, oldObj)
 }
@@ -0,0 +1,390 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/obot-platform/nah/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	configMapGVK = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	secretGVK    = corev1.SchemeGroupVersion.WithKind("Secret")
+)
+
+// newTestController returns a controller over an informer that is never started. Its keys should be trigger keys,
+// which are not read from the cache.
+func newTestController(t *testing.T, handler HandlerFunc) *controller {
+	t.Helper()
+	return &controller{
+		gvk:         configMapGVK,
+		name:        configMapGVK.String(),
+		handler:     handler,
+		obj:         &corev1.ConfigMap{},
+		rateLimiter: applyDefaultOptions(nil).RateLimiter,
+		clock:       clock.RealClock{},
+		informer:    clientgocache.NewSharedIndexInformer(&clientgocache.ListWatch{}, &corev1.ConfigMap{}, 0, clientgocache.Indexers{}),
+		pending:     map[string]backend.EnqueueInfo{},
+		processing:  map[string]backend.EnqueueInfo{},
+	}
+}
+
+// runTestController runs the controller until the test ends.
+func runTestController(t *testing.T, c *controller, workers int) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.run(ctx, workers)
+}
+
+func waitForController(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the controller")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWorkersNeverOverlapAKey(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		active   = map[string]int{}
+		overlaps atomic.Int32
+		calls    atomic.Int32
+	)
+	c := newTestController(t, func(key string, obj runtime.Object) error {
+		lock.Lock()
+		active[key]++
+		if active[key] > 1 {
+			overlaps.Add(1)
+		}
+		lock.Unlock()
+
+		calls.Add(1)
+		time.Sleep(100 * time.Microsecond)
+
+		lock.Lock()
+		active[key]--
+		lock.Unlock()
+		return nil
+	})
+	c.SetWorkers(8)
+	runTestController(t, c, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				c.EnqueueKey(fmt.Sprintf("_t default/key-%d", (i+j)%4))
+				if j%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	waitForController(t, func() bool {
+		c.infoLock.Lock()
+		defer c.infoLock.Unlock()
+		return len(c.pending) == 0 && len(c.processing) == 0
+	})
+
+	if n := overlaps.Load(); n != 0 {
+		t.Fatalf("expected the reconciles of a key never to overlap, got %d overlaps", n)
+	}
+	if n := calls.Load(); n < 4 {
+		t.Fatalf("expected every key to be reconciled, got %d reconciles", n)
+	}
+}
+
+func TestWorkersOfTypesAreIndependent(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var slowRunning atomic.Int32
+	slow := newTestController(t, func(key string, obj runtime.Object) error {
+		slowRunning.Add(1)
+		<-release
+		return nil
+	})
+	slow.SetWorkers(1)
+	runTestController(t, slow, 1)
+
+	var (
+		fastRunning atomic.Int32
+		allRunning  = make(chan struct{})
+		once        sync.Once
+	)
+	fast := newTestController(t, func(key string, obj runtime.Object) error {
+		if fastRunning.Add(1) == 4 {
+			once.Do(func() { close(allRunning) })
+		}
+		<-allRunning
+		return nil
+	})
+	fast.gvk, fast.name = secretGVK, secretGVK.String()
+	fast.SetWorkers(4)
+	runTestController(t, fast, 1)
+
+	slow.EnqueueKey("_t default/a")
+	slow.EnqueueKey("_t default/b")
+	waitForController(t, func() bool { return slowRunning.Load() == 1 })
+
+	for i := 0; i < 4; i++ {
+		fast.EnqueueKey(fmt.Sprintf("_t default/%d", i))
+	}
+	select {
+	case <-allRunning:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the 4 workers of the fast type to run while the slow type is blocked, got %d", fastRunning.Load())
+	}
+	if n := slowRunning.Load(); n != 1 {
+		t.Fatalf("expected the single worker of the slow type to run one key, got %d", n)
+	}
+}
+
+func TestOldObjectOfCoalescedUpdates(t *testing.T) {
+	version := func(resourceVersion string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", ResourceVersion: resourceVersion}}
+	}
+	oldObject := func(c *controller) runtime.Object {
+		t.Helper()
+		info, ok := c.PendingEnqueues()["default/a"]
+		if !ok {
+			t.Fatal("expected the key to be pending")
+		}
+		return info.OldObject
+	}
+
+	c := newTestController(t, nil)
+	c.TrackOldObjects()
+	c.handleUpdate(version("1"), version("2"))
+	c.handleUpdate(version("2"), version("3"))
+	c.handleUpdate(version("3"), version("4"))
+	if old, ok := oldObject(c).(*corev1.ConfigMap); !ok || old.ResourceVersion != "1" {
+		t.Fatalf("expected the old object to be the version before the earliest update, got %v", oldObject(c))
+	}
+
+	// The version of the request is the one before the updates coalesced while it was processed.
+	c.startProcessing("default/a")
+	c.handleUpdate(version("4"), version("5"))
+	if info, _ := c.EnqueueInfo("default/a"); info.OldObject.(*corev1.ConfigMap).ResourceVersion != "1" {
+		t.Fatalf("expected the request being processed to keep its old object, got %v", info.OldObject)
+	}
+	if old, ok := oldObject(c).(*corev1.ConfigMap); !ok || old.ResourceVersion != "4" {
+		t.Fatalf("expected the next request to have the version before the update during the processing, got %v", oldObject(c))
+	}
+	c.doneProcessing("default/a")
+
+	// An add followed by updates has no old object.
+	c = newTestController(t, nil)
+	c.TrackOldObjects()
+	c.handleObject(version("1"))
+	c.handleUpdate(version("1"), version("2"))
+	if old := oldObject(c); old != nil {
+		t.Fatalf("expected no old object after an add, got %v", old)
+	}
+
+	// A trigger or requeue before the update doesn't hide it.
+	c = newTestController(t, nil)
+	c.TrackOldObjects()
+	c.Enqueue("default", "a")
+	c.handleUpdate(version("1"), version("2"))
+	if old, ok := oldObject(c).(*corev1.ConfigMap); !ok || old.ResourceVersion != "1" {
+		t.Fatalf("expected the old object of the update after the requeue, got %v", oldObject(c))
+	}
+
+	// Without a route asking for them, old objects are not kept.
+	c = newTestController(t, nil)
+	c.handleUpdate(version("1"), version("2"))
+	if old := oldObject(c); old != nil {
+		t.Fatalf("expected no old object when they are not tracked, got %v", old)
+	}
+}
+
+func TestObserveEnqueues(t *testing.T) {
+	var observed []string
+	c := newTestController(t, nil)
+	c.handleObject(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}})
+	c.ObserveEnqueues(func(key string, obj runtime.Object) {
+		observed = append(observed, fmt.Sprintf("%s %T", key, obj))
+	})
+
+	c.handleObject(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"}})
+	c.handleObject(clientgocache.DeletedFinalStateUnknown{
+		Key: "default/c",
+		Obj: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "c"}},
+	})
+	// Triggers and requeues are not events.
+	c.Enqueue("default", "d")
+	c.EnqueueAfter("default", "e", time.Minute)
+
+	expected := []string{"default/b *v1.ConfigMap", "default/c *v1.ConfigMap"}
+	if !slices.Equal(observed, expected) {
+		t.Fatalf("expected the events %v to be observed, got %v", expected, observed)
+	}
+}
+
+// storeCache reads the objects from the store of an informer.
+type storeCache struct {
+	cache.Cache
+	store clientgocache.Store
+	// beforeGet, if set, is called before each read.
+	beforeGet func(store clientgocache.Store, key string)
+}
+
+func (s storeCache) Get(_ context.Context, key kclient.ObjectKey, obj kclient.Object, _ ...kclient.GetOption) error {
+	k := keyFunc(key.Namespace, key.Name)
+	if s.beforeGet != nil {
+		s.beforeGet(s.store, k)
+	}
+	item, ok, err := s.store.GetByKey(k)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apierror.NewNotFound(corev1.Resource("configmaps"), key.Name)
+	}
+	item.(*corev1.ConfigMap).DeepCopyInto(obj.(*corev1.ConfigMap))
+	return nil
+}
+
+// startListController starts a controller whose informer lists the objects and then watches the watcher.
+func startListController(t *testing.T, handler HandlerFunc, watcher *watch.FakeWatcher, beforeGet func(store clientgocache.Store, key string), objs ...corev1.ConfigMap) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	informer := clientgocache.NewSharedIndexInformer(&clientgocache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			return &corev1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}, Items: objs}, nil
+		},
+		WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+			return watcher, nil
+		},
+	}, &corev1.ConfigMap{}, 0, clientgocache.Indexers{})
+	go informer.Run(ctx.Done())
+	if !clientgocache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+
+	c := newTestController(t, handler)
+	c.informer = informer
+	c.cache = storeCache{store: informer.GetStore(), beforeGet: beforeGet}
+	if err := c.Start(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testConfigMap(namespace, name, resourceVersion string) corev1.ConfigMap {
+	return corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: resourceVersion}}
+}
+
+// reconcileRecorder records the keys and versions of the reconciles.
+type reconcileRecorder struct {
+	lock       sync.Mutex
+	reconciles []string
+}
+
+func (r *reconcileRecorder) OnChange(key string, obj runtime.Object) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if cm, ok := obj.(*corev1.ConfigMap); ok {
+		key += "@" + cm.ResourceVersion
+	}
+	r.reconciles = append(r.reconciles, key)
+	return nil
+}
+
+func (r *reconcileRecorder) recorded() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.reconciles)
+}
+
+func TestInitialListSortedOnce(t *testing.T) {
+	var (
+		objs     []corev1.ConfigMap
+		expected []string
+	)
+	for _, namespace := range []string{"c", "a", "b"} {
+		for _, name := range []string{"z", "x", "y"} {
+			objs = append(objs, testConfigMap(namespace, name, "1"))
+		}
+	}
+	for _, namespace := range []string{"a", "b", "c"} {
+		for _, name := range []string{"x", "y", "z"} {
+			expected = append(expected, namespace+"/"+name+"@1")
+		}
+	}
+
+	recorder := &reconcileRecorder{}
+	startListController(t, recorder.OnChange, watch.NewFake(), nil, objs...)
+	waitForController(t, func() bool { return len(recorder.recorded()) >= len(objs) })
+	time.Sleep(100 * time.Millisecond)
+
+	if got := recorder.recorded(); !slices.Equal(got, expected) {
+		t.Fatalf("expected exactly one reconcile of each object in sorted order, got %v", got)
+	}
+}
+
+func TestInitialListRacingUpdate(t *testing.T) {
+	var (
+		watcher = watch.NewFake()
+		once    sync.Once
+	)
+	// The update arrives before the first reconcile of the object reads it from the cache.
+	beforeGet := func(store clientgocache.Store, key string) {
+		if key != "default/a" {
+			return
+		}
+		once.Do(func() {
+			updated := testConfigMap("default", "a", "2")
+			watcher.Modify(&updated)
+			for {
+				item, _, _ := store.GetByKey("default/a")
+				if item.(*corev1.ConfigMap).ResourceVersion == "2" {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+
+	recorder := &reconcileRecorder{}
+	startListController(t, recorder.OnChange, watcher, beforeGet,
+		testConfigMap("default", "a", "1"), testConfigMap("default", "b", "1"))
+
+	waitForController(t, func() bool { return len(recorder.recorded()) >= 2 })
+	time.Sleep(100 * time.Millisecond)
+	if got := recorder.recorded(); !slices.Equal(got, []string{"default/a@2", "default/b@1"}) {
+		t.Fatalf("expected the racing update to be coalesced into the first reconcile, got %v", got)
+	}
+
+	// The updates after the first reconcile are reconciled.
+	updated := testConfigMap("default", "a", "3")
+	watcher.Modify(&updated)
+	waitForController(t, func() bool { return len(recorder.recorded()) >= 3 })
+	if got := recorder.recorded(); got[2] != "default/a@3" {
+		t.Fatalf("expected the next update to be reconciled, got %v", got)
+	}
+}
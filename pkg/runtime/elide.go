@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/obot-platform/nah/pkg/log"
+	"github.com/obot-platform/nah/pkg/router"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kcache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var elidedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nah_cache_elided_objects_total",
+	Help: "Number of objects stored in the cache with their large fields elided.",
+}, []string{"type"})
+
+func init() {
+	metrics.Registry.MustRegister(elidedObjects)
+}
+
+// ElidePolicy makes the cache store objects of a type without the given fields when the serialized object is larger
+// than MaxSize bytes. Elided objects carry the router.ElidedAnnotation, and handlers can fetch the full object with
+// Request.GetFull.
+type ElidePolicy struct {
+	// Object is an instance of the type the policy applies to.
+	Object kclient.Object
+	// MaxSize is the largest serialized size, in bytes, of an object that is cached in full.
+	MaxSize int
+	// Paths are the JSONPath expressions of the fields to elide, such as .spec.data. Only simple field paths are
+	// supported.
+	Paths []string
+}
+
+func elideByObject(policies []ElidePolicy, scheme *runtime.Scheme) (map[kclient.Object]cache.ByObject, error) {
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	result := make(map[kclient.Object]cache.ByObject, len(policies))
+	for _, p := range policies {
+		if p.MaxSize <= 0 {
+			return nil, fmt.Errorf("elide policy for %T requires a positive MaxSize", p.Object)
+		}
+		gvk, err := apiutil.GVKForObject(p.Object, scheme)
+		if err != nil {
+			return nil, err
+		}
+		result[p.Object] = cache.ByObject{
+			Transform: elideTransform(p, gvk.GroupKind().String()),
+		}
+	}
+	return result, nil
+}
+
+func elideTransform(p ElidePolicy, typeName string) kcache.TransformFunc {
+	paths := make([][]string, 0, len(p.Paths))
+	for _, path := range p.Paths {
+		path = strings.Trim(strings.TrimSpace(path), "{}")
+		paths = append(paths, strings.Split(strings.TrimPrefix(path, "."), "."))
+	}
+
+	return func(in any) (any, error) {
+		obj, ok := in.(kclient.Object)
+		if !ok {
+			return in, nil
+		}
+		data, err := json.Marshal(obj)
+		if err != nil || len(data) <= p.MaxSize {
+			return in, nil
+		}
+
+		u := map[string]any{}
+		if err := json.Unmarshal(data, &u); err != nil {
+			return in, nil
+		}
+		for _, path := range paths {
+			unstructured.RemoveNestedField(u, path...)
+		}
+
+		out := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(kclient.Object)
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, out); err != nil {
+			log.Errorf("failed to elide fields of %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+			return in, nil
+		}
+		annotations := out.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[router.ElidedAnnotation] = strings.Join(p.Paths, ",")
+		out.SetAnnotations(annotations)
+
+		elidedObjects.WithLabelValues(typeName).Inc()
+		return out, nil
+	}
+}
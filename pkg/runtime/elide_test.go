@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/router"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestElideTransform(t *testing.T) {
+	transform := elideTransform(ElidePolicy{Object: &corev1.ConfigMap{}, MaxSize: 200, Paths: []string{"{.data}", ".binaryData"}}, "ConfigMap")
+
+	small := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "small"}, Data: map[string]string{"k": "v"}}
+	out, err := transform(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != small {
+		t.Fatalf("expected an object within MaxSize to be cached in full, got %v", out)
+	}
+
+	large := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "large", Labels: map[string]string{"app": "a"}},
+		Data:       map[string]string{"k": strings.Repeat("v", 200)},
+		BinaryData: map[string][]byte{"b": []byte("data")},
+	}
+	out, err = transform(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elided := out.(*corev1.ConfigMap)
+	if elided.Data != nil || elided.BinaryData != nil {
+		t.Fatalf("expected the fields to be elided, got %v and %v", elided.Data, elided.BinaryData)
+	}
+	if elided.Labels["app"] != "a" || elided.Annotations[router.ElidedAnnotation] != "{.data},.binaryData" {
+		t.Fatalf("expected the other fields to be kept and the annotation to list the paths, got %v", elided.ObjectMeta)
+	}
+	if large.Data == nil || large.Annotations != nil {
+		t.Fatal("expected the object of the informer not to be changed")
+	}
+}
+
+func TestElideByObjectRequiresMaxSize(t *testing.T) {
+	if _, err := elideByObject([]ElidePolicy{{Object: &corev1.ConfigMap{}, Paths: []string{".data"}}}, nil); err == nil {
+		t.Fatal("expected an error for a policy without MaxSize")
+	}
+}
@@ -0,0 +1,160 @@
+package runtime
+
+import (
+	"sync"
+
+	"github.com/obot-platform/nah/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var fairQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "nah_fair_queue_depth",
+	Help: "Number of keys waiting in each fairness bucket of a controller's queue.",
+}, []string{"name", "bucket"})
+
+func init() {
+	metrics.Registry.MustRegister(fairQueueDepth)
+}
+
+// Fairness dispatches keys round-robin across buckets, so that a burst of keys in one bucket does not delay the keys
+// of the other buckets.
+type Fairness struct {
+	// Key returns the bucket of a queue key. Defaults to NamespaceBucket.
+	Key func(key string) string
+	// MaxPerBucket is the most keys of one bucket that are processed concurrently while keys of other buckets are
+	// waiting. When only one bucket has keys waiting, it may use all the workers. Zero means no limit.
+	MaxPerBucket int
+}
+
+// NamespaceBucket returns the namespace of the queue key, ignoring the prefixes of trigger and replay keys.
+func NamespaceBucket(key string) string {
+	if isSpecialKey(key) {
+		key = key[3:]
+	}
+	ns, _ := keyParse(key)
+	return ns
+}
+
+// fairQueue implements the workqueue.Queue storage with a FIFO per bucket. Push, Pop, Touch, and Len are called with
+// the workqueue's lock held, but done is called by the workers, so the queue has its own lock.
+type fairQueue struct {
+	name         string
+	key          func(string) string
+	maxPerBucket int
+
+	lock     sync.Mutex
+	buckets  map[string][]any
+	order    []string
+	next     int
+	inflight map[string]int
+	popped   map[any]string
+	length   int
+}
+
+func newFairQueue(name string, f Fairness) *fairQueue {
+	key := f.Key
+	if key == nil {
+		key = NamespaceBucket
+	}
+	return &fairQueue{
+		name:         name,
+		key:          key,
+		maxPerBucket: f.MaxPerBucket,
+		buckets:      map[string][]any{},
+		inflight:     map[string]int{},
+		popped:       map[any]string{},
+	}
+}
+
+var _ workqueue.Queue[any] = (*fairQueue)(nil)
+
+func (f *fairQueue) bucketOf(item any) string {
+	if s, ok := item.(string); ok {
+		return f.key(s)
+	}
+	return ""
+}
+
+func (f *fairQueue) Touch(any) {}
+
+func (f *fairQueue) Push(item any) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	bucket := f.bucketOf(item)
+	if _, ok := f.buckets[bucket]; !ok {
+		f.order = append(f.order, bucket)
+	}
+	f.buckets[bucket] = append(f.buckets[bucket], item)
+	f.length++
+	fairQueueDepth.WithLabelValues(name.MetricLabel(f.name), name.MetricLabel(bucket)).Set(float64(len(f.buckets[bucket])))
+}
+
+func (f *fairQueue) Len() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.length
+}
+
+// Pop returns the next key round-robin across the buckets, skipping buckets at their concurrency limit unless every
+// bucket with keys waiting is at its limit.
+func (f *fairQueue) Pop() any {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	i := f.pick()
+	bucket := f.order[i]
+	items := f.buckets[bucket]
+	item := items[0]
+	items[0] = nil
+
+	if len(items) == 1 {
+		delete(f.buckets, bucket)
+		f.order = append(f.order[:i], f.order[i+1:]...)
+		fairQueueDepth.DeleteLabelValues(name.MetricLabel(f.name), name.MetricLabel(bucket))
+		f.next = i
+	} else {
+		f.buckets[bucket] = items[1:]
+		fairQueueDepth.WithLabelValues(name.MetricLabel(f.name), name.MetricLabel(bucket)).Set(float64(len(items) - 1))
+		f.next = i + 1
+	}
+	if f.next >= len(f.order) {
+		f.next = 0
+	}
+
+	f.length--
+	f.inflight[bucket]++
+	f.popped[item] = bucket
+	return item
+}
+
+func (f *fairQueue) pick() int {
+	if f.next >= len(f.order) {
+		f.next = 0
+	}
+	if f.maxPerBucket <= 0 {
+		return f.next
+	}
+	for n := 0; n < len(f.order); n++ {
+		i := (f.next + n) % len(f.order)
+		if f.inflight[f.order[i]] < f.maxPerBucket {
+			return i
+		}
+	}
+	return f.next
+}
+
+// done records that the worker has finished processing an item returned by Pop.
+func (f *fairQueue) done(item any) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	bucket, ok := f.popped[item]
+	if !ok {
+		return
+	}
+	delete(f.popped, item)
+	if f.inflight[bucket]--; f.inflight[bucket] <= 0 {
+		delete(f.inflight, bucket)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/obot-platform/nah/pkg/backend"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -147,3 +148,45 @@ func (s *sharedController) RegisterHandler(ctx context.Context, name string, han
 
 	return nil
 }
+
+func (s *sharedController) PendingEnqueues() map[string]backend.EnqueueInfo {
+	if c, ok := s.initController().(interface {
+		PendingEnqueues() map[string]backend.EnqueueInfo
+	}); ok {
+		return c.PendingEnqueues()
+	}
+	return nil
+}
+
+func (s *sharedController) EnqueueInfo(key string) (backend.EnqueueInfo, bool) {
+	if c, ok := s.initController().(interface {
+		EnqueueInfo(string) (backend.EnqueueInfo, bool)
+	}); ok {
+		return c.EnqueueInfo(key)
+	}
+	return backend.EnqueueInfo{}, false
+}
+
+func (s *sharedController) TrackOldObjects() {
+	if c, ok := s.initController().(interface {
+		TrackOldObjects()
+	}); ok {
+		c.TrackOldObjects()
+	}
+}
+
+func (s *sharedController) ObserveEnqueues(observe func(string, runtime.Object)) {
+	if c, ok := s.initController().(interface {
+		ObserveEnqueues(func(string, runtime.Object))
+	}); ok {
+		c.ObserveEnqueues(observe)
+	}
+}
+
+func (s *sharedController) SetWorkers(workers int) {
+	if c, ok := s.initController().(interface {
+		SetWorkers(int)
+	}); ok {
+		c.SetWorkers(workers)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -19,6 +20,10 @@ type SharedControllerFactory interface {
 type SharedControllerFactoryOptions struct {
 	DefaultRateLimiter workqueue.TypedRateLimiter[any]
 	DefaultWorkers     int
+	// Clock is used by the delayed queue of every controller. Defaults to the real clock.
+	Clock clock.WithTicker
+	// Fairness, if set, is used by the queue of every controller.
+	Fairness *Fairness
 
 	KindRateLimiter map[schema.GroupVersionKind]workqueue.TypedRateLimiter[any]
 	KindWorkers     map[schema.GroupVersionKind]int
@@ -37,6 +42,8 @@ type sharedControllerFactory struct {
 	workers         int
 	kindRateLimiter map[schema.GroupVersionKind]workqueue.TypedRateLimiter[any]
 	kindWorkers     map[schema.GroupVersionKind]int
+	clock           clock.WithTicker
+	fairness        *Fairness
 }
 
 func NewSharedControllerFactory(c kclient.Client, cache cache.Cache, opts *SharedControllerFactoryOptions) SharedControllerFactory {
@@ -49,6 +56,8 @@ func NewSharedControllerFactory(c kclient.Client, cache cache.Cache, opts *Share
 		kindWorkers:     opts.KindWorkers,
 		rateLimiter:     opts.DefaultRateLimiter,
 		kindRateLimiter: opts.KindRateLimiter,
+		clock:           opts.Clock,
+		fairness:        opts.Fairness,
 	}
 }
 
@@ -139,6 +148,8 @@ func (s *sharedControllerFactory) ForKind(gvk schema.GroupVersionKind) (SharedCo
 
 			return New(gvk, s.client.Scheme(), s.cache, handler, &Options{
 				RateLimiter: rateLimiter,
+				Clock:       s.clock,
+				Fairness:    s.fairness,
 			})
 		},
 		handler: handler,
@@ -161,6 +172,17 @@ func (s *sharedControllerFactory) getWorkers(gvk schema.GroupVersionKind, worker
 	return s.workers, nil
 }
 
+// Kinds returns the types that have a controller.
+func (s *sharedControllerFactory) Kinds() []schema.GroupVersionKind {
+	s.controllerLock.RLock()
+	defer s.controllerLock.RUnlock()
+	result := make([]schema.GroupVersionKind, 0, len(s.controllers))
+	for gvk := range s.controllers {
+		result = append(result, gvk)
+	}
+	return result
+}
+
 func (s *sharedControllerFactory) byGVK(gvk schema.GroupVersionKind) *sharedController {
 	s.controllerLock.RLock()
 	defer s.controllerLock.RUnlock()
@@ -111,6 +111,10 @@ func (e errorList) ToErr() error {
 	}
 }
 
+func (e errorList) Unwrap() []error {
+	return e
+}
+
 func (e errorList) Cause() error {
 	if len(e) > 0 {
 		return e[0]
@@ -130,3 +134,7 @@ func (h handlerError) Error() string {
 func (h handlerError) Cause() error {
 	return h.Err
 }
+
+func (h handlerError) Unwrap() error {
+	return h.Err
+}
@@ -26,7 +26,8 @@ func (r *RouteMatch) admit(response *Response, request *Request) error {
 
 func (r *RouteMatch) matches(req *v1.AdmissionRequest) bool {
 	var (
-		group, version, kind, resource string
+		group, version, kind = req.Kind.Group, req.Kind.Version, req.Kind.Kind
+		resource             = req.Resource.Resource
 	)
 	if req.RequestKind != nil {
 		group, version, kind = req.RequestKind.Group, req.RequestKind.Version, req.RequestKind.Kind
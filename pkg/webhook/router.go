@@ -10,6 +10,11 @@ import (
 	"gomodules.xyz/jsonpatch/v2"
 	v1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -17,12 +22,38 @@ var (
 	jsonPatchType = v1.PatchTypeJSONPatch
 )
 
-func NewRouter() *Router {
-	return &Router{}
+// Option configures optional behavior of a webhook Router.
+type Option func(*Router)
+
+// WithScheme sets the scheme used to decode the objects in the admission requests into typed objects. Objects of
+// types not in the scheme are decoded as unstructured.
+func WithScheme(scheme *runtime.Scheme) Option {
+	return func(r *Router) {
+		r.scheme = scheme
+		r.decoder = serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	}
+}
+
+// WithClient sets the client handlers can use for lookups, usually the cached client of a controller router.
+func WithClient(client kclient.Reader) Option {
+	return func(r *Router) {
+		r.client = client
+	}
+}
+
+func NewRouter(opts ...Option) *Router {
+	r := &Router{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 type Router struct {
 	matches []*RouteMatch
+	scheme  *runtime.Scheme
+	decoder runtime.Decoder
+	client  kclient.Reader
 }
 
 func (r *Router) sendError(rw http.ResponseWriter, review *v1.AdmissionReview, err error) {
@@ -32,7 +63,14 @@ func (r *Router) sendError(rw http.ResponseWriter, review *v1.AdmissionReview, e
 		return
 	}
 	review.Response.Allowed = false
-	review.Response.Result = &errors.NewInternalError(err).ErrStatus
+	review.Response.Patch = nil
+	review.Response.PatchType = nil
+	if status, ok := err.(errors.APIStatus); ok {
+		s := status.Status()
+		review.Response.Result = &s
+	} else {
+		review.Response.Result = &errors.NewInternalError(err).ErrStatus
+	}
 	writeResponse(rw, review)
 }
 
@@ -76,6 +114,8 @@ func (r *Router) admit(response *Response, request *v1.AdmissionRequest, req *ht
 			err := m.admit(response, &Request{
 				AdmissionRequest: *request,
 				Context:          req.Context(),
+				Client:           r.client,
+				decoder:          r.decoder,
 			})
 			log.Debugf("admit result: %s %s %s user=%s allowed=%v err=%v", request.Operation, request.Kind.String(), resourceString(request.Namespace, request.Name), request.UserInfo.Username, response.Allowed, err)
 			return err
@@ -94,6 +134,54 @@ type Request struct {
 	v1.AdmissionRequest
 
 	Context context.Context
+	// Client is the client given to the webhook router with WithClient, nil if none was given.
+	Client kclient.Reader
+
+	decoder   runtime.Decoder
+	obj       runtime.Object
+	oldObj    runtime.Object
+	objErr    error
+	oldObjErr error
+	decoded   bool
+}
+
+// GetObject returns the object in the request decoded into its typed form using the scheme of the webhook router.
+// The object is nil for delete requests.
+func (r *Request) GetObject() (runtime.Object, error) {
+	r.decode()
+	return r.obj, r.objErr
+}
+
+// GetOldObject returns the existing object in the request decoded into its typed form using the scheme of the webhook
+// router. The object is nil for create requests.
+func (r *Request) GetOldObject() (runtime.Object, error) {
+	r.decode()
+	return r.oldObj, r.oldObjErr
+}
+
+func (r *Request) decode() {
+	if r.decoded {
+		return
+	}
+	r.decoded = true
+	r.obj, r.objErr = r.decodeRaw(r.Object)
+	r.oldObj, r.oldObjErr = r.decodeRaw(r.OldObject)
+}
+
+func (r *Request) decodeRaw(raw runtime.RawExtension) (runtime.Object, error) {
+	if len(raw.Raw) == 0 {
+		return raw.Object, nil
+	}
+	if r.decoder != nil {
+		obj, _, err := r.decoder.Decode(raw.Raw, nil, nil)
+		if err == nil {
+			return obj, nil
+		} else if !runtime.IsNotRegisteredError(err) {
+			return nil, err
+		}
+	}
+	obj := &unstructured.Unstructured{}
+	return obj, obj.UnmarshalJSON(raw.Raw)
 }
 
 func (r *Request) DecodeOldObject(obj kclient.Object) error {
@@ -108,11 +196,39 @@ type Response struct {
 	v1.AdmissionResponse
 }
 
+// Allow allows the request.
+func (r *Response) Allow() {
+	r.Allowed = true
+	r.Result = nil
+}
+
+// Deny denies the request with the given reason and a 403 status code.
+func (r *Response) Deny(reason string) {
+	r.DenyWithCode(http.StatusForbidden, reason)
+}
+
+// DenyWithCode denies the request with the given status code and reason.
+func (r *Response) DenyWithCode(code int32, reason string) {
+	r.Allowed = false
+	r.Patch = nil
+	r.PatchType = nil
+	r.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    code,
+		Reason:  metav1.StatusReason(http.StatusText(int(code))),
+		Message: reason,
+	}
+}
+
 func (r *Response) CreatePatch(request *Request, newObj kclient.Object) error {
 	if len(r.Patch) > 0 {
 		return fmt.Errorf("response patch has already been already been assigned")
 	}
 
+	if newObj.GetObjectKind().GroupVersionKind().Empty() && request.Kind.Kind != "" {
+		newObj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind(request.Kind))
+	}
+
 	newBytes, err := json.Marshal(newObj)
 	if err != nil {
 		return err
@@ -0,0 +1,299 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gomodules.xyz/jsonpatch/v2"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+var configMapKind = metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+func testRouter(t *testing.T) *Router {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(WithScheme(scheme))
+}
+
+func raw(t *testing.T, obj runtime.Object) runtime.RawExtension {
+	t.Helper()
+	if obj == nil {
+		return runtime.RawExtension{}
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime.RawExtension{Raw: data}
+}
+
+func testConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+		Data:       data,
+	}
+}
+
+// review sends an admission review for the objects to the router and returns the response.
+func review(t *testing.T, r *Router, operation v1.Operation, kind metav1.GroupVersionKind, obj, oldObj runtime.Object) *v1.AdmissionResponse {
+	t.Helper()
+	body, err := json.Marshal(&v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      kind,
+			Namespace: "default",
+			Name:      "a",
+			Operation: operation,
+			Object:    raw(t, obj),
+			OldObject: raw(t, oldObj),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var result v1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Response == nil || result.Response.UID != "uid" {
+		t.Fatalf("expected a response to the request, got %+v", result.Response)
+	}
+	return result.Response
+}
+
+func TestCreateWithPatch(t *testing.T) {
+	r := testRouter(t)
+	r.Kind("ConfigMap").Operation(v1.Create).HandleFunc(func(resp *Response, req *Request) error {
+		obj, err := req.GetObject()
+		if err != nil {
+			return err
+		}
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			t.Fatalf("expected a typed config map, got %T", obj)
+		}
+		if old, err := req.GetOldObject(); err != nil || old != nil {
+			t.Fatalf("expected no old object on create, got %v, %v", old, err)
+		}
+
+		cm = cm.DeepCopy()
+		cm.Data["added"] = "true"
+		resp.Allow()
+		return resp.CreatePatch(req, cm)
+	})
+
+	resp := review(t, r, v1.Create, configMapKind, testConfigMap(map[string]string{"key": "value"}), nil)
+	if !resp.Allowed {
+		t.Fatalf("expected the request to be allowed, got %+v", resp.Result)
+	}
+	if resp.PatchType == nil || *resp.PatchType != v1.PatchTypeJSONPatch {
+		t.Fatalf("expected a JSON patch, got %v", resp.PatchType)
+	}
+	var patch []jsonpatch.Operation
+	if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 1 || patch[0].Operation != "add" || patch[0].Path != "/data/added" || patch[0].Value != "true" {
+		t.Fatalf("expected the added key to be patched, got %+v", patch)
+	}
+}
+
+func TestUpdateDecodesBothObjects(t *testing.T) {
+	r := testRouter(t)
+	r.Kind("ConfigMap").HandleFunc(func(resp *Response, req *Request) error {
+		obj, err := req.GetObject()
+		if err != nil {
+			return err
+		}
+		old, err := req.GetOldObject()
+		if err != nil {
+			return err
+		}
+		if obj.(*corev1.ConfigMap).Data["key"] == old.(*corev1.ConfigMap).Data["key"] {
+			resp.Allow()
+			return nil
+		}
+		resp.Deny("key is immutable")
+		return nil
+	})
+
+	resp := review(t, r, v1.Update, configMapKind, testConfigMap(map[string]string{"key": "new"}), testConfigMap(map[string]string{"key": "old"}))
+	if resp.Allowed {
+		t.Fatal("expected the update to be denied")
+	}
+	if resp.Result == nil || resp.Result.Code != http.StatusForbidden || resp.Result.Message != "key is immutable" {
+		t.Fatalf("expected a forbidden result with the reason, got %+v", resp.Result)
+	}
+	if resp.Patch != nil {
+		t.Fatalf("expected no patch on a denied request, got %s", resp.Patch)
+	}
+
+	resp = review(t, r, v1.Update, configMapKind, testConfigMap(map[string]string{"key": "old", "other": "new"}), testConfigMap(map[string]string{"key": "old"}))
+	if !resp.Allowed || resp.Result != nil {
+		t.Fatalf("expected the update to be allowed, got %+v", resp.Result)
+	}
+}
+
+func TestDeleteHasOnlyOldObject(t *testing.T) {
+	r := testRouter(t)
+	r.Operation(v1.Delete).HandleFunc(func(resp *Response, req *Request) error {
+		if obj, err := req.GetObject(); err != nil || obj != nil {
+			t.Fatalf("expected no object on delete, got %v, %v", obj, err)
+		}
+		old, err := req.GetOldObject()
+		if err != nil {
+			return err
+		}
+		if old.(*corev1.ConfigMap).Data["protected"] == "true" {
+			resp.DenyWithCode(http.StatusConflict, "protected")
+			return nil
+		}
+		resp.Allow()
+		return nil
+	})
+
+	resp := review(t, r, v1.Delete, configMapKind, nil, testConfigMap(map[string]string{"protected": "true"}))
+	if resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusConflict {
+		t.Fatalf("expected the delete to be denied with a conflict, got %+v", resp.Result)
+	}
+
+	resp = review(t, r, v1.Delete, configMapKind, nil, testConfigMap(nil))
+	if !resp.Allowed {
+		t.Fatalf("expected the delete to be allowed, got %+v", resp.Result)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		err  error
+		code int32
+	}{
+		"status":   {err: apierrors.NewBadRequest("invalid"), code: http.StatusBadRequest},
+		"internal": {err: errors.New("failed"), code: http.StatusInternalServerError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := testRouter(t)
+			r.HandleFunc(func(resp *Response, req *Request) error {
+				// The patch is dropped when the handler fails.
+				resp.Allow()
+				if err := resp.CreatePatch(req, testConfigMap(map[string]string{"key": "patched"})); err != nil {
+					return err
+				}
+				return test.err
+			})
+
+			resp := review(t, r, v1.Create, configMapKind, testConfigMap(nil), nil)
+			if resp.Allowed || resp.Patch != nil || resp.PatchType != nil {
+				t.Fatalf("expected the request to be denied without a patch, got %+v", resp)
+			}
+			if resp.Result == nil || resp.Result.Code != test.code {
+				t.Fatalf("expected a result with code %d, got %+v", test.code, resp.Result)
+			}
+		})
+	}
+}
+
+func TestNoRouteMatch(t *testing.T) {
+	r := testRouter(t)
+	r.Kind("Secret").HandleFunc(func(resp *Response, req *Request) error {
+		t.Fatal("the handler of another kind was called")
+		return nil
+	})
+
+	resp := review(t, r, v1.Create, configMapKind, testConfigMap(nil), nil)
+	if resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the request to be denied, got %+v", resp.Result)
+	}
+}
+
+func TestRequestKindTakesPrecedence(t *testing.T) {
+	r := testRouter(t)
+	r.Version("v1beta1").HandleFunc(func(resp *Response, req *Request) error {
+		resp.Allow()
+		return nil
+	})
+
+	body, err := json.Marshal(&v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			UID:         "uid",
+			Kind:        configMapKind,
+			RequestKind: &metav1.GroupVersionKind{Version: "v1beta1", Kind: "ConfigMap"},
+			Operation:   v1.Create,
+			Object:      raw(t, testConfigMap(nil)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	var result v1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Response.Allowed {
+		t.Fatalf("expected the request kind to be matched, got %+v", result.Response.Result)
+	}
+}
+
+func TestUnknownTypeIsUnstructured(t *testing.T) {
+	r := testRouter(t)
+	var obj runtime.Object
+	r.HandleFunc(func(resp *Response, req *Request) error {
+		var err error
+		obj, err = req.GetObject()
+		resp.Allow()
+		return err
+	})
+
+	widget := &unstructured.Unstructured{}
+	widget.SetGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	widget.SetName("a")
+	resp := review(t, r, v1.Create, metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}, widget, nil)
+	if !resp.Allowed {
+		t.Fatalf("expected the request to be allowed, got %+v", resp.Result)
+	}
+	if u, ok := obj.(*unstructured.Unstructured); !ok || u.GetName() != "a" {
+		t.Fatalf("expected an unstructured object, got %T", obj)
+	}
+}
+
+func TestInvalidReview(t *testing.T) {
+	r := testRouter(t)
+	for name, body := range map[string]string{
+		"malformed":  "{",
+		"no request": "{}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("expected status 500, got %d", rec.Code)
+			}
+		})
+	}
+}
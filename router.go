@@ -1,15 +1,24 @@
 package baaah
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"maps"
+	"time"
 
+	"github.com/obot-platform/nah/pkg/apply"
 	"github.com/obot-platform/nah/pkg/backend"
 	"github.com/obot-platform/nah/pkg/leader"
 	"github.com/obot-platform/nah/pkg/restconfig"
 	"github.com/obot-platform/nah/pkg/router"
 	bruntime "github.com/obot-platform/nah/pkg/runtime"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const defaultHealthzPort = 8888
@@ -21,6 +30,10 @@ type Options struct {
 	DefaultRESTConfig *rest.Config
 	// If a Backend is provided, then this is ignored.
 	DefaultNamespace string
+	// Namespaces restricts the caches to these namespaces, along with DefaultNamespace if it is set. Reads of other
+	// namespaces fail, and lists of all namespaces are merged from the namespaces. The caches are cluster wide if
+	// both are empty. If a Backend is provided, then this is ignored.
+	Namespaces []string
 	// If a Backend is provided, then this is ignored.
 	Scheme *runtime.Scheme
 	// APIGroupConfigs are keyed by an API group. This indicates to the router that all actions on this group should use the
@@ -28,8 +41,55 @@ type Options struct {
 	APIGroupConfigs map[string]bruntime.Config
 	// ElectionConfig being nil represents no leader election for the router.
 	ElectionConfig *leader.ElectionConfig
-	// Defaults to 8888
+	// HealthzPort is the port for the healthz endpoint. Defaults to 8888 if it is 0, a negative port disables the
+	// endpoint.
 	HealthzPort int
+	// Clock is used for all delays and back offs in the router and the created backend. Defaults to the real clock.
+	Clock clock.WithTicker
+	// Workers is the default number of workers per GVK, for the types whose routes don't set their own with
+	// RouteBuilder.Workers. If a Backend is provided, then this is ignored.
+	Workers int
+	// TenantImpersonation makes the writes of each request impersonate the tenant of the request's namespace. If the
+	// Config is not set, then DefaultRESTConfig is used.
+	TenantImpersonation *router.TenantImpersonation
+	// RequeueStore persists the pending requeues across restarts every RequeueStoreInterval and on shutdown. Pending
+	// requeues are not persisted if this is nil.
+	RequeueStore         router.RequeueStore
+	RequeueStoreInterval time.Duration
+	// Elide configures the types whose large objects are cached with some fields elided. If a Backend is provided,
+	// then this is ignored.
+	Elide []bruntime.ElidePolicy
+	// Fairness, if set, dispatches keys round-robin across fairness buckets. If a Backend is provided, then this is
+	// ignored.
+	Fairness *bruntime.Fairness
+	// WarmStandby makes replicas that are not the leader sync the caches of the router's types and of the
+	// WarmStandbyTypes, so they can reconcile as soon as they become the leader.
+	WarmStandby      bool
+	WarmStandbyTypes []kclient.Object
+	// MetricsRegisterer, if set, registers the Prometheus metrics of the router's handlers, and NewRouter fails if they
+	// can't be registered. No metrics of the handlers are recorded if this is nil.
+	MetricsRegisterer prometheus.Registerer
+	// StallThreshold is how long a key can wait in the queue without any reconcile succeeding before the router is
+	// reported as unhealthy. Zero uses router.DefaultStallThreshold, and a negative threshold disables the check.
+	StallThreshold time.Duration
+	// ShutdownTimeout is how long the router waits for its running handlers to return when it stops, before the lease
+	// is released. The handlers still running after it are aborted. Defaults to router.DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// Logger is the structured logger of the router and of its leader election, unless the election config has its
+	// own. Defaults to log.Slog, which writes to the log package.
+	Logger *slog.Logger
+	// FatalHandler is called when the router or its leader election can't go on, such as when the lease is lost.
+	// Defaults to exiting the process with log.Fatalf.
+	FatalHandler func(err error)
+	// RefreshToken, if set, is the bearer token of DefaultRESTConfig, of the configs of APIGroupConfigs and of the
+	// TenantImpersonation config, reloaded when it expires or is rejected, as with restconfig.WithRefreshingToken.
+	// RefreshTokenTTL is how long the tokens without an expiry are used. The ElectionConfig is not changed, it should
+	// be created from a config returned by restconfig.WithRefreshingToken.
+	RefreshToken    restconfig.TokenFunc
+	RefreshTokenTTL time.Duration
+	// EventRecorder, if set, records an "Adopted" event on each existing object adopted for the routes with
+	// router.RouteBuilder.AdoptExisting. A record.EventRecorder of client-go can be used.
+	EventRecorder apply.EventRecorder
 }
 
 func (o *Options) complete() (*Options, error) {
@@ -46,11 +106,7 @@ func (o *Options) complete() (*Options, error) {
 		result.HealthzPort = defaultHealthzPort
 	}
 
-	if result.Backend != nil {
-		return &result, nil
-	}
-
-	if result.DefaultRESTConfig == nil {
+	if result.Backend == nil && result.DefaultRESTConfig == nil {
 		var err error
 		result.DefaultRESTConfig, err = restconfig.New(result.Scheme)
 		if err != nil {
@@ -58,7 +114,22 @@ func (o *Options) complete() (*Options, error) {
 		}
 	}
 
-	defaultConfig := bruntime.Config{Rest: result.DefaultRESTConfig, Namespace: result.DefaultNamespace}
+	// The credentials are refreshed once the default config exists, so that the config of the backend is wrapped too.
+	result.refreshCredentials()
+
+	if result.Backend != nil {
+		return &result, nil
+	}
+
+	defaultConfig := bruntime.Config{
+		Rest:       result.DefaultRESTConfig,
+		Namespace:  result.DefaultNamespace,
+		Namespaces: result.Namespaces,
+		Clock:      result.Clock,
+		Workers:    result.Workers,
+		Elide:      result.Elide,
+		Fairness:   result.Fairness,
+	}
 	backend, err := bruntime.NewRuntimeWithConfigs(defaultConfig, result.APIGroupConfigs, result.Scheme)
 	if err != nil {
 		return nil, err
@@ -100,10 +171,116 @@ func DefaultRouter(routerName string, scheme *runtime.Scheme) (*router.Router, e
 	return NewRouter(routerName, opts)
 }
 
+// refreshCredentials makes the configs of the options authenticate with RefreshToken, and then clears it so that the
+// configs are only wrapped once. The clients, caches and tenant clients of the router are built from copies of these
+// configs, which keep the refreshing transport.
+func (o *Options) refreshCredentials() {
+	if o.RefreshToken == nil {
+		return
+	}
+	refresh := func(cfg *rest.Config) *rest.Config {
+		if cfg == nil {
+			return nil
+		}
+		return restconfig.WithRefreshingToken(cfg, o.RefreshToken, o.RefreshTokenTTL)
+	}
+
+	o.DefaultRESTConfig = refresh(o.DefaultRESTConfig)
+	if len(o.APIGroupConfigs) > 0 {
+		groups := make(map[string]bruntime.Config, len(o.APIGroupConfigs))
+		for group, cfg := range o.APIGroupConfigs {
+			cfg.Rest = refresh(cfg.Rest)
+			groups[group] = cfg
+		}
+		o.APIGroupConfigs = groups
+	}
+	if o.TenantImpersonation != nil && o.TenantImpersonation.Config != nil {
+		tenants := *o.TenantImpersonation
+		tenants.Config = refresh(tenants.Config)
+		o.TenantImpersonation = &tenants
+	}
+	o.RefreshToken = nil
+}
+
+// configureElection gives the election config the logger and fatal handler of the options, unless it has its own.
+func (o *Options) configureElection(ec *leader.ElectionConfig) {
+	if ec == nil {
+		return
+	}
+	if ec.Logger == nil {
+		ec.Logger = o.Logger
+	}
+	if ec.OnFatal == nil {
+		ec.OnFatal = o.FatalHandler
+	}
+}
+
+// ObjectsSubContext is the owner sub context of the objects declared with router.Objects, so that they are a set of
+// their own and are not pruned by, or prune, the objects that handlers apply for the same owner with apply.New.
+const ObjectsSubContext = "nah.obot.ai/objects"
+
+// objectsApplier returns the router.Applier of the objects declared with router.Objects. The objects to adopt are
+// marked with apply.AnnotationAdopt, and their adoption is recorded with the recorder if it is not nil.
+func objectsApplier(recorder apply.EventRecorder) router.Applier {
+	return func(ctx context.Context, c kclient.Client, owner kclient.Object, pruneGVKs []schema.GroupVersionKind, adopt []kclient.Object, objs ...kclient.Object) error {
+		for _, obj := range adopt {
+			annotations := maps.Clone(obj.GetAnnotations())
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[apply.AnnotationAdopt] = "true"
+			obj.SetAnnotations(annotations)
+		}
+		// The objects of a namespaced owner are in its namespace, so the set is only listed in that namespace.
+		return apply.New(c).WithOwnerSubContext(ObjectsSubContext).WithNamespace(owner.GetNamespace()).WithPruneGVKs(pruneGVKs...).
+			WithEventRecorder(recorder).Apply(ctx, owner, objs...)
+	}
+}
+
 func NewRouter(handlerName string, opts *Options) (*router.Router, error) {
 	opts, err := opts.complete()
 	if err != nil {
 		return nil, err
 	}
-	return router.New(router.NewHandlerSet(handlerName, opts.Backend.Scheme(), opts.Backend), opts.ElectionConfig, opts.HealthzPort), nil
+	routerOpts := []router.Option{router.WithApplier(objectsApplier(opts.EventRecorder))}
+	if opts.Clock != nil {
+		routerOpts = append(routerOpts, router.WithClock(opts.Clock))
+	}
+	if opts.TenantImpersonation != nil {
+		tenants := *opts.TenantImpersonation
+		if tenants.Config == nil {
+			tenants.Config = opts.DefaultRESTConfig
+		}
+		if tenants.Config == nil {
+			return nil, fmt.Errorf("tenant impersonation requires a REST config")
+		}
+		routerOpts = append(routerOpts, router.WithTenantImpersonation(tenants))
+	}
+	if opts.RequeueStore != nil {
+		routerOpts = append(routerOpts, router.WithRequeueStore(opts.RequeueStore, opts.RequeueStoreInterval))
+	}
+	if opts.WarmStandby {
+		routerOpts = append(routerOpts, router.WithWarmStandby(opts.WarmStandbyTypes...))
+	}
+	if opts.MetricsRegisterer != nil {
+		recorder, err := router.NewPrometheusRecorder(handlerName, opts.MetricsRegisterer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register the handler metrics: %w", err)
+		}
+		routerOpts = append(routerOpts, router.WithMetricsRecorder(recorder))
+	}
+	if opts.ShutdownTimeout > 0 {
+		routerOpts = append(routerOpts, router.WithShutdownTimeout(opts.ShutdownTimeout))
+	}
+	if opts.Logger != nil {
+		routerOpts = append(routerOpts, router.WithLogger(opts.Logger))
+	}
+	if opts.FatalHandler != nil {
+		routerOpts = append(routerOpts, router.WithFatalHandler(opts.FatalHandler))
+	}
+	opts.configureElection(opts.ElectionConfig)
+	if opts.StallThreshold != 0 {
+		routerOpts = append(routerOpts, router.WithStallThreshold(opts.StallThreshold))
+	}
+	return router.New(router.NewHandlerSet(handlerName, opts.Backend.Scheme(), opts.Backend), opts.ElectionConfig, opts.HealthzPort, routerOpts...), nil
 }
@@ -0,0 +1,191 @@
+package baaah
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/obot-platform/nah/pkg/apply"
+	"github.com/obot-platform/nah/pkg/conditions"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApplyObjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	configMapGVK := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(owner).Build()
+	ctx := context.Background()
+	pruneGVKs := []schema.GroupVersionKind{configMapGVK}
+	applyObjects := func(ctx context.Context, c kclient.Client, owner kclient.Object, pruneGVKs []schema.GroupVersionKind, objs ...kclient.Object) error {
+		return objectsApplier(nil)(ctx, c, owner, pruneGVKs, nil, objs...)
+	}
+
+	child := func(name, value string) kclient.Object {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Data:       map[string]string{"value": value},
+		}
+	}
+	get := func(name string) (*corev1.ConfigMap, error) {
+		var cm corev1.ConfigMap
+		return &cm, c.Get(ctx, kclient.ObjectKey{Namespace: "default", Name: name}, &cm)
+	}
+
+	// The declared objects are created.
+	if err := applyObjects(ctx, c, owner, pruneGVKs, child("a", "1"), child("b", "1")); err != nil {
+		t.Fatal(err)
+	}
+	a, err := get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Data["value"] != "1" {
+		t.Fatalf("expected the created object to have value 1, got %q", a.Data["value"])
+	}
+	if refs := a.GetOwnerReferences(); len(refs) != 1 || refs[0].UID != owner.UID {
+		t.Fatalf("expected the created object to be owned by the owner, got %v", refs)
+	}
+
+	// Changed objects are updated in place.
+	if err := applyObjects(ctx, c, owner, pruneGVKs, child("a", "2"), child("b", "1")); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Data["value"] != "2" || updated.UID != a.UID {
+		t.Fatalf("expected the object to be updated in place to value 2, got %q", updated.Data["value"])
+	}
+
+	// Objects that are no longer declared are pruned.
+	if err := applyObjects(ctx, c, owner, pruneGVKs, child("a", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get("b"); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the object that is no longer declared to be pruned, got %v", err)
+	}
+	if _, err := get("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The objects that handlers apply for the same owner with apply.New are a set of their own.
+	if err := apply.New(c).WithPruneGVKs(pruneGVKs...).Apply(ctx, owner, child("c", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyObjects(ctx, c, owner, pruneGVKs, child("a", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get("c"); err != nil {
+		t.Fatalf("expected the object applied with apply.New not to be pruned, got %v", err)
+	}
+	if _, err := get("a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventRecorder records the reasons and messages of the events of each object, by name.
+type eventRecorder map[string][]string
+
+func (e eventRecorder) Event(object runtime.Object, _, reason, message string) {
+	name := object.(kclient.Object).GetName()
+	e[name] = append(e[name], reason+": "+message)
+}
+
+func (e eventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	e.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func TestApplyObjectsAdoption(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	configMapGVK := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"}}
+	controller := true
+	controlled := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "controlled",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Secret", Name: "other", UID: "other-uid", Controller: &controller}}}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(
+		owner,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "adopted"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "plain"}},
+		controlled,
+	).Build()
+	ctx := context.Background()
+	pruneGVKs := []schema.GroupVersionKind{configMapGVK}
+	events := eventRecorder{}
+	applyObjects := objectsApplier(events)
+
+	child := func(name string) kclient.Object {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Data:       map[string]string{"value": "1"},
+		}
+	}
+
+	// Only the existing objects of the objects to adopt are adopted.
+	adopted := child("adopted")
+	if err := applyObjects(ctx, c, owner, pruneGVKs, []kclient.Object{adopted}, adopted, child("plain")); err != nil {
+		t.Fatal(err)
+	}
+	var cm corev1.ConfigMap
+	if err := c.Get(ctx, kclient.ObjectKey{Namespace: "default", Name: "adopted"}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	if refs := cm.GetOwnerReferences(); cm.Data["value"] != "1" || len(refs) != 1 || refs[0].UID != owner.UID {
+		t.Fatalf("expected the adopted object to be updated and owned by the owner, got %v and %v", cm.Data, refs)
+	}
+	if len(events) != 1 || len(events["adopted"]) != 1 || events["adopted"][0] != "Adopted: Adopted by ConfigMap default/owner" {
+		t.Fatalf("expected one event on the adopted object, got %v", events)
+	}
+
+	// An existing object that is controlled by another object is not adopted.
+	toAdopt := child("controlled")
+	err := applyObjects(ctx, c, owner, pruneGVKs, []kclient.Object{toAdopt}, toAdopt)
+	var terminal *conditions.ErrTerminal
+	if !errors.As(err, &terminal) || !strings.Contains(err.Error(), "it is controlled by Secret other") {
+		t.Fatalf("expected a terminal error naming the controller, got %v", err)
+	}
+	if len(events["controlled"]) != 0 {
+		t.Fatalf("expected no event on the object that was not adopted, got %v", events["controlled"])
+	}
+}
+
+func TestNewRouterMetricsRegistrationError(t *testing.T) {
+	b := newAppBackend(t)
+	registry := prometheus.NewRegistry()
+	// A collector of the same name with other labels can't be registered alongside the handler metrics.
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "nah_handler_invocations_total", Help: "other"}))
+
+	_, err := NewRouter("test", &Options{Scheme: b.Scheme(), Backend: b, HealthzPort: -1, MetricsRegisterer: registry})
+	if err == nil || !strings.Contains(err.Error(), "failed to register the handler metrics") {
+		t.Fatalf("expected the registration error to be returned, got %v", err)
+	}
+
+	r, err := NewRouter("test", &Options{Scheme: b.Scheme(), Backend: b, HealthzPort: -1, MetricsRegisterer: prometheus.NewRegistry()})
+	if err != nil || r == nil {
+		t.Fatalf("expected a router, got %v", err)
+	}
+}